@@ -0,0 +1,44 @@
+package kaniko
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ciAnnotations maps the OCI annotation keys written with ci-annotations to
+// the Drone metadata they record.
+func (b Build) ciAnnotations() map[string]string {
+	return map[string]string{
+		"org.opencontainers.image.revision": b.DroneCommitSha,
+		"org.opencontainers.image.source":   b.DroneRepoLink,
+		"io.drone.build.link":               b.DroneBuildLink,
+		"io.drone.build.number":             b.DroneBuildNumber,
+		"io.drone.stage.name":               b.DroneStageName,
+		"io.drone.step.name":                b.DroneStepName,
+		"io.drone.stage.machine":            b.DroneStageMachine,
+		"io.drone.runner.hostname":          b.DroneRunnerHostname,
+	}
+}
+
+// manifestAnnotations returns the annotations of the pushed manifests: the
+// Drone metadata when ci-annotations is set, overridden by the annotations
+// given as key=value.
+func (b Build) manifestAnnotations() (map[string]string, error) {
+	annotations := map[string]string{}
+	if b.CIAnnotations {
+		for key, value := range b.ciAnnotations() {
+			// Metadata Drone did not provide is omitted
+			if value != "" {
+				annotations[key] = value
+			}
+		}
+	}
+	for _, annotation := range b.Annotations {
+		parts := strings.SplitN(annotation, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid annotation %s, expected key=value", annotation)
+		}
+		annotations[parts[0]] = parts[1]
+	}
+	return annotations, nil
+}
@@ -0,0 +1,114 @@
+package kaniko
+
+import "fmt"
+
+// ExecutorArgs returns the arguments of the kaniko executor building b and
+// pushing it to the destination tags of b.Repo, or writing it to an OCI
+// layout without pushing it when layout is set. It only translates the
+// build settings, so that other tools can generate kaniko commands
+// equivalent to the plugin's: labels and cache directories must be
+// resolved beforehand, and the platform and OCI layout path arguments are
+// added for each kaniko run.
+func ExecutorArgs(b Build, destinations []string, layout bool) []string {
+	args := []string{
+		fmt.Sprintf("--dockerfile=%s", b.Dockerfile),
+		fmt.Sprintf("--context=%s", b.contextArg()),
+	}
+	if b.ContextSubPath != "" {
+		args = append(args, fmt.Sprintf("--context-sub-path=%s", b.ContextSubPath))
+	}
+
+	// Set the destination repository
+	if !b.NoPush && !layout {
+		for _, tag := range destinations {
+			args = append(args, fmt.Sprintf("--destination=%s:%s", b.Repo, tag))
+		}
+	}
+	// Set the build arguments
+	for _, arg := range b.Args {
+		args = append(args, fmt.Sprintf("--build-arg=%s", arg))
+	}
+	// Set the labels
+	for _, label := range b.Labels {
+		args = append(args, fmt.Sprintf("--label=%s", label))
+	}
+	// Set repository mirrors
+	for _, mirror := range b.Mirrors {
+		args = append(args, fmt.Sprintf("--registry-mirror=%s", mirror))
+	}
+	if b.StrictMirrors && len(b.Mirrors) != 0 {
+		args = append(args, "--skip-default-registry-fallback")
+	}
+	if b.Target != "" {
+		args = append(args, fmt.Sprintf("--target=%s", b.Target))
+	}
+
+	if b.SkipTlsVerify {
+		args = append(args, "--skip-tls-verify=true")
+	}
+
+	if b.SnapshotMode != "" {
+		args = append(args, fmt.Sprintf("--snapshotMode=%s", b.SnapshotMode))
+	}
+
+	if b.SingleSnapshot {
+		args = append(args, "--single-snapshot")
+	}
+
+	for _, path := range b.IgnorePaths {
+		args = append(args, fmt.Sprintf("--ignore-path=%s", path))
+	}
+
+	if b.IncludeVarRun {
+		args = append(args, "--ignore-var-run=false")
+	}
+
+	if b.EnableCache {
+		args = append(args, "--cache=true")
+
+		if b.CacheRepo != "" {
+			args = append(args, fmt.Sprintf("--cache-repo=%s", b.CacheRepo))
+		}
+
+		if b.CacheDir != "" {
+			args = append(args, fmt.Sprintf("--cache-dir=%s", b.CacheDir))
+		}
+
+		if b.CacheCopyLayers {
+			args = append(args, "--cache-copy-layers")
+		}
+
+		if b.CacheNoCompress {
+			args = append(args, "--compressed-caching=false")
+		}
+	}
+
+	if b.CacheTTL != 0 {
+		args = append(args, fmt.Sprintf("--cache-ttl=%dh", b.CacheTTL))
+	}
+
+	if b.DigestFile != "" {
+		args = append(args, fmt.Sprintf("--digest-file=%s", b.DigestFile))
+	}
+
+	if b.NoPush || layout {
+		args = append(args, "--no-push")
+	}
+
+	if b.Verbosity != "" {
+		args = append(args, fmt.Sprintf("--verbosity=%s", b.Verbosity))
+	}
+
+	if b.UseNewRun {
+		args = append(args, "--use-new-run")
+	}
+
+	if b.Cleanup {
+		args = append(args, "--cleanup")
+	}
+
+	if b.PullRetry > 0 {
+		args = append(args, fmt.Sprintf("--image-download-retry=%d", b.PullRetry))
+	}
+	return args
+}
@@ -0,0 +1,87 @@
+package kaniko
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestExecutorArgs(t *testing.T) {
+	tests := []struct {
+		name         string
+		build        Build
+		destinations []string
+		layout       bool
+	}{
+		{
+			name:         "minimal",
+			build:        Build{Dockerfile: "Dockerfile", Context: ".", Repo: "foo/bar"},
+			destinations: []string{"latest"},
+		},
+		{
+			name: "full",
+			build: Build{
+				Dockerfile:      "docker/Dockerfile",
+				Context:         "app",
+				ContextSubPath:  "src",
+				Repo:            "registry.example.com/foo/bar",
+				Args:            []string{"VERSION=1.2.3", "DEBUG"},
+				Labels:          []string{"org.opencontainers.image.source=https://example.com"},
+				Mirrors:         []string{"mirror.gcr.io"},
+				StrictMirrors:   true,
+				Target:          "release",
+				SkipTlsVerify:   true,
+				SnapshotMode:    "redo",
+				SingleSnapshot:  true,
+				IgnorePaths:     []string{"/var/cache"},
+				IncludeVarRun:   true,
+				EnableCache:     true,
+				CacheRepo:       "registry.example.com/foo/bar/cache",
+				CacheDir:        "/cache",
+				CacheCopyLayers: true,
+				CacheNoCompress: true,
+				CacheTTL:        24,
+				DigestFile:      "/kaniko/digest-file",
+				Verbosity:       "debug",
+				UseNewRun:       true,
+				Cleanup:         true,
+				PullRetry:       3,
+			},
+			destinations: []string{"1", "1.2", "1.2.3"},
+		},
+		{
+			name:         "layout",
+			build:        Build{Dockerfile: "Dockerfile", Context: ".", Repo: "foo/bar", CacheDir: "/cache"},
+			destinations: []string{"latest"},
+			layout:       true,
+		},
+		{
+			name:  "no-push",
+			build: Build{Dockerfile: "Dockerfile", Context: ".", NoPush: true, Mirrors: []string{"mirror.gcr.io"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := strings.Join(ExecutorArgs(test.build, test.destinations, test.layout), "\n") + "\n"
+			golden := filepath.Join("testdata", "args", test.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(want), got); diff != "" {
+				t.Errorf("ExecutorArgs() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
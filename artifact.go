@@ -0,0 +1,107 @@
+package kaniko
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/artifact"
+)
+
+// artifactPublishers returns the publishers of the artifact file and the
+// artifact publisher destinations: files, http(s) URLs, which the artifact is
+// POSTed to, and URLs with a scheme supported by the command, e.g. s3://.
+func (p Plugin) artifactPublishers() ([]artifact.Publisher, error) {
+	var publishers []artifact.Publisher
+	if p.Artifact.ArtifactFile != "" {
+		publishers = append(publishers, artifact.File(p.Artifact.ArtifactFile))
+	}
+	header, err := artifact.ParseHeaders(p.Artifact.Headers)
+	if err != nil {
+		return nil, err
+	}
+	for _, destination := range p.Artifact.Publishers {
+		parts := strings.SplitN(destination, "://", 2)
+		switch {
+		case len(parts) == 1:
+			publishers = append(publishers, artifact.File(destination))
+		case parts[0] == "http" || parts[0] == "https":
+			publishers = append(publishers, artifact.HTTP{URL: destination, Header: header})
+		case p.Artifact.Openers[parts[0]] != nil:
+			publisher, err := p.Artifact.Openers[parts[0]](destination)
+			if err != nil {
+				return nil, err
+			}
+			publishers = append(publishers, publisher)
+		default:
+			return nil, fmt.Errorf("artifact publisher %s is not supported by this plugin", destination)
+		}
+	}
+	return publishers, nil
+}
+
+// publishArtifact publishes the artifact using the digest recorded in the
+// digest file. Failures are only logged, like those of the artifact file.
+func (p Plugin) publishArtifact() {
+	if p.Build.DigestFile == "" {
+		return
+	}
+	publishers, err := p.artifactPublishers()
+	if err != nil || len(publishers) == 0 {
+		return // Already reported by exec
+	}
+	content, err := ioutil.ReadFile(p.Build.DigestFile)
+	if err != nil {
+		p.warnf("failed to read digest file contents at path: %s with error: %s\n", p.Build.DigestFile, err)
+	}
+	format, _ := artifact.ParseFormat(p.Artifact.Format)
+	b, err := artifact.Marshal(p.Artifact.RegistryType, format, p.Artifact.Registry, p.Artifact.Repo, string(content), p.Artifact.Tags)
+	if err != nil {
+		p.warnf("failed to marshal plugin artifact: %s\n", err)
+		return
+	}
+	p.publishArtifactContent(p.stepContext(), b, format)
+}
+
+// publishArtifactContent publishes the artifact to the artifact file and the
+// artifact publishers.
+func (p Plugin) publishArtifactContent(ctx context.Context, b []byte, format artifact.FormatEnum) {
+	publishers, err := p.artifactPublishers()
+	if err != nil {
+		return // Already reported by exec
+	}
+	for _, publisher := range publishers {
+		if err := publisher.Publish(ctx, b, format); err != nil {
+			p.warnf("failed to publish plugin artifact to %s with error: %s\n", publisher, err)
+			continue
+		}
+		if _, isFile := publisher.(artifact.File); !isFile {
+			fmt.Fprintf(os.Stdout, "Published artifact to %s\n", publisher)
+		}
+	}
+}
+
+// withSuffix returns the artifact with the suffix appended to the file
+// names of the artifact file and the publishers, except http(s) URLs, so
+// that builds of several images don't overwrite each other's artifacts.
+func (a Artifact) withSuffix(suffix string) Artifact {
+	appendSuffix := func(path string) string {
+		ext := filepath.Ext(path)
+		return strings.TrimSuffix(path, ext) + "-" + suffix + ext
+	}
+	if a.ArtifactFile != "" {
+		a.ArtifactFile = appendSuffix(a.ArtifactFile)
+	}
+	var publishers []string
+	for _, destination := range a.Publishers {
+		if !strings.HasPrefix(destination, "http://") && !strings.HasPrefix(destination, "https://") {
+			destination = appendSuffix(destination)
+		}
+		publishers = append(publishers, destination)
+	}
+	a.Publishers = publishers
+	return a
+}
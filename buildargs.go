@@ -0,0 +1,55 @@
+package kaniko
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// secretArgPattern matches the names of build args holding secrets, whose
+// values are masked in the executor output.
+var secretArgPattern = regexp.MustCompile(`(?i)(PASSWORD|PASSWD|SECRET|TOKEN|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIAL)`)
+
+// argFiles returns the build args with the values given as NAME=@path read
+// from the file at path, so that values may contain commas and newlines.
+// A single trailing newline of the file is removed. Values starting with a
+// literal @ are escaped as NAME=@@value.
+func (b Build) argFiles() ([]string, error) {
+	var args []string
+	for _, arg := range b.Args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[1], "@") {
+			args = append(args, arg)
+			continue
+		}
+		name, path := parts[0], strings.TrimPrefix(parts[1], "@")
+		if strings.HasPrefix(path, "@") {
+			args = append(args, name+"="+path)
+			continue
+		}
+		if path == "" {
+			return nil, fmt.Errorf("invalid build arg %s, expected NAME=@path", arg)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read build arg %s: %s", name, err)
+		}
+		value := strings.TrimSuffix(strings.TrimSuffix(string(content), "\n"), "\r")
+		args = append(args, name+"="+value)
+	}
+	return args, nil
+}
+
+// secretArgValues returns the values of the build args whose names suggest
+// secrets, e.g. NPM_TOKEN or DB_PASSWORD.
+func (b Build) secretArgValues() []string {
+	var values []string
+	for _, arg := range b.Args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) == 2 && secretArgPattern.MatchString(parts[0]) {
+			values = append(values, parts[1])
+		}
+	}
+	return values
+}
@@ -0,0 +1,124 @@
+package kaniko
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gexops/drone-kaniko/pkg/cachestats"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// cacheKey matches the tags kaniko stores cached layers under.
+var cacheKey = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// seedCache copies the cached layers of the CacheFrom repositories, in
+// order, into the cache repository. Kaniko reads a single cache repository,
+// so seeding it lets builds hit layers cached by other branches. Failures
+// only reduce cache hits and are reported without failing the build.
+func (p Plugin) seedCache() {
+	dst, err := registry.ParseRepository(p.Build.CacheRepo)
+	if err != nil {
+		p.warnf("invalid cache repo %s: %s\n", p.Build.CacheRepo, err)
+		return
+	}
+	var sources []registry.Repository
+	for _, name := range p.Build.CacheFrom {
+		src, err := registry.ParseRepository(name)
+		if err != nil {
+			p.warnf("invalid cache source %s: %s\n", name, err)
+			continue
+		}
+		sources = append(sources, src)
+	}
+	seedCache(p.stepContext(), p.registryClient(), dst, sources, p.warnf)
+}
+
+func seedCache(ctx context.Context, client *registry.Client, dst registry.Repository, sources []registry.Repository, warnf func(string, ...interface{})) {
+	cached := map[string]bool{}
+	tags, err := client.Tags(ctx, dst)
+	if e, ok := err.(*registry.Error); err != nil && !(ok && e.StatusCode == http.StatusNotFound) {
+		warnf("failed to list cache repo %s, skipping cache seeding: %s\n", dst, err)
+		return
+	}
+	for _, tag := range tags {
+		cached[tag] = true
+	}
+
+	for _, src := range sources {
+		tags, err := client.Tags(ctx, src)
+		if err != nil {
+			warnf("failed to list cache source %s: %s\n", src, err)
+			continue
+		}
+		seeded := 0
+		for _, tag := range tags {
+			if cached[tag] || !cacheKey.MatchString(tag) {
+				continue
+			}
+			if _, err := client.Copy(ctx, src, tag, dst, tag); err != nil {
+				warnf("failed to copy cached layer %s from %s: %s\n", tag, src, err)
+				continue
+			}
+			cached[tag] = true
+			seeded++
+		}
+		fmt.Fprintf(os.Stdout, "Seeded %d cached layers of %s from %s\n", seeded, dst, src)
+	}
+}
+
+// defaultCacheRepo derives the cache repository of repo: <repo>/cache, or
+// <repo>-cache for registries without nested repositories.
+func defaultCacheRepo(repo string) string {
+	if r, err := registry.ParseRepository(repo); err == nil {
+		switch r.Registry {
+		case "registry-1.docker.io", "quay.io":
+			return strings.TrimSuffix(repo, "/") + "-cache"
+		}
+	}
+	return strings.TrimSuffix(repo, "/") + "/cache"
+}
+
+// usesCacheFrom reports whether the cache repository is seeded from other
+// cache repositories before the build.
+func (b Build) usesCacheFrom() bool {
+	return b.EnableCache && len(b.CacheFrom) != 0 && b.CacheRepo != ""
+}
+
+// usesCacheStats reports whether the cache lookups of the build are recorded
+// in the cache repository.
+func (b Build) usesCacheStats() bool {
+	return b.CacheStats && b.EnableCache && b.CacheRepo != ""
+}
+
+// recordCacheStats adds the cache lookups of the build to the statistics
+// stored in the cache repository. Concurrent builds may overwrite each
+// other's updates, and failures are reported without failing the build.
+func (p Plugin) recordCacheStats() {
+	run := p.cacheCounter.Run()
+	if run.Empty() {
+		return
+	}
+	repo, err := registry.ParseRepository(p.Build.CacheRepo)
+	if err != nil {
+		p.warnf("invalid cache repo %s: %s\n", p.Build.CacheRepo, err)
+		return
+	}
+	ctx := p.stepContext()
+	client := p.registryClient()
+	stats, err := cachestats.Fetch(ctx, client, repo)
+	if err != nil {
+		p.warnf("failed to read cache statistics of %s: %s\n", repo, err)
+		return
+	}
+	stats.Record(run, time.Now().UTC())
+	if err := cachestats.Push(ctx, client, repo, stats); err != nil {
+		p.warnf("failed to write cache statistics of %s: %s\n", repo, err)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "Recorded %d cache hits and %d misses in %s:%s\n", len(run.Hits), len(run.Misses), repo, cachestats.Tag)
+}
@@ -0,0 +1,58 @@
+package kaniko
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/gexops/drone-kaniko/pkg/registry/registrytest"
+)
+
+func TestSeedCache(t *testing.T) {
+	reg := registrytest.New(t)
+	client := registry.NewClient(registry.KeychainFunc(func(string) (registry.Credential, error) {
+		return registry.Credential{Username: registrytest.Username, Password: registrytest.Password}, nil
+	}), true)
+
+	keyA, keyB := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	reg.PushImage("app/cache/main", keyA, []byte(`{"layer":"a"}`), []byte("a"))
+	reg.PushImage("app/cache/main", "latest", []byte(`{"layer":"latest"}`), []byte("latest"))
+	reg.PushImage("app/cache/shared", keyB, []byte(`{"layer":"b"}`), []byte("b"))
+	bDigest := reg.PushImage("app/cache/branch", keyB, []byte(`{"layer":"b2"}`), []byte("b2"))
+
+	dst := registry.Repository{Registry: reg.Host(), Name: "app/cache/branch"}
+	seedCache(context.Background(), client, dst, []registry.Repository{
+		{Registry: reg.Host(), Name: "app/cache/main"},
+		{Registry: reg.Host(), Name: "app/cache/missing"},
+		{Registry: reg.Host(), Name: "app/cache/shared"},
+	}, t.Logf)
+
+	if _, _, ok := reg.Manifest("app/cache/branch", keyA); !ok {
+		t.Error("expected cached layer of main to be seeded")
+	}
+	if _, _, ok := reg.Manifest("app/cache/branch", "latest"); ok {
+		t.Error("expected tags other than cache keys to be skipped")
+	}
+	if _, content, _ := reg.Manifest("app/cache/branch", keyB); registry.Digest(content) != bDigest {
+		t.Error("expected existing cache entries to be kept")
+	}
+}
+
+func TestDefaultCacheRepo(t *testing.T) {
+	tests := []struct {
+		repo string
+		want string
+	}{
+		{"gcr.io/project/app", "gcr.io/project/app/cache"},
+		{"123456789012.dkr.ecr.us-east-1.amazonaws.com/team/app", "123456789012.dkr.ecr.us-east-1.amazonaws.com/team/app/cache"},
+		{"octocat/app", "octocat/app-cache"},
+		{"https://index.docker.io/v1/octocat/app", "https://index.docker.io/v1/octocat/app-cache"},
+		{"quay.io/team/app", "quay.io/team/app-cache"},
+	}
+	for _, test := range tests {
+		if got := defaultCacheRepo(test.repo); got != test.want {
+			t.Errorf("defaultCacheRepo(%q) = %q, want %q", test.repo, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	kaniko "github.com/gexops/drone-kaniko"
+	"github.com/gexops/drone-kaniko/pkg/acr"
+	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/command"
+	"github.com/gexops/drone-kaniko/pkg/docker"
+)
+
+var (
+	version = "unknown"
+)
+
+func main() {
+	// Load env-file if it exists first
+	if env := os.Getenv("PLUGIN_ENV_FILE"); env != "" {
+		if err := godotenv.Load(env); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	app := cli.NewApp()
+	app.Name = "kaniko acr plugin"
+	app.Usage = "kaniko acr plugin"
+	app.Action = func(c *cli.Context) error {
+		defer docker.RemoveCredentials()
+		ctx, stop := command.Context()
+		defer stop()
+		return kaniko.WriteResult(c.String("output"), func() error {
+			return kaniko.ReportError(c.String("error-file"), run(ctx, c))
+		})
+	}
+	app.Version = version
+	app.Flags = append([]cli.Flag{
+		cli.StringFlag{
+			Name:   "repo",
+			Usage:  "acr repository",
+			EnvVar: "PLUGIN_REPO",
+		},
+		cli.StringFlag{
+			Name:   "registry",
+			Usage:  "acr registry, e.g. myregistry.azurecr.io",
+			EnvVar: "PLUGIN_REGISTRY",
+		},
+		cli.StringFlag{
+			Name:   "username",
+			Usage:  "acr username, service principal client id or repository scoped token name",
+			EnvVar: "PLUGIN_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "password",
+			Usage:  "acr password",
+			EnvVar: "PLUGIN_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:   "tenant-id",
+			Usage:  "Azure AD tenant id for workload identity authentication",
+			EnvVar: "PLUGIN_TENANT_ID,AZURE_TENANT_ID",
+		},
+		cli.StringFlag{
+			Name:   "client-id",
+			Usage:  "Azure AD application or managed identity client id for workload identity authentication",
+			EnvVar: "PLUGIN_CLIENT_ID,AZURE_CLIENT_ID",
+		},
+		cli.StringFlag{
+			Name:   "federated-token-file",
+			Usage:  "File containing the federated token, e.g. the projected Kubernetes service account token, exchanged for an Azure AD token",
+			EnvVar: "PLUGIN_FEDERATED_TOKEN_FILE,AZURE_FEDERATED_TOKEN_FILE",
+		},
+		cli.StringFlag{
+			Name:   "authority-host",
+			Usage:  "Azure AD authority host",
+			Value:  acr.DefaultAuthorityHost,
+			EnvVar: "PLUGIN_AUTHORITY_HOST,AZURE_AUTHORITY_HOST",
+		},
+		cli.StringFlag{
+			Name:   "snapshot-mode",
+			Usage:  "Specify one of full, redo or time as snapshot mode",
+			EnvVar: "PLUGIN_SNAPSHOT_MODE",
+		},
+		cli.StringFlag{
+			Name:   "cache-repo",
+			Usage:  "Remote repository that will be used to store cached layers. Cache repo should be present in specified registry. enable-cache needs to be set to use this flag",
+			EnvVar: "PLUGIN_CACHE_REPO",
+		},
+		cli.StringFlag{
+			Name:   "registry-auths",
+			Usage:  "JSON or YAML list of registry, username and password objects of additional registries, e.g. of base images",
+			EnvVar: "PLUGIN_REGISTRY_AUTHS",
+		},
+		cli.StringSliceFlag{
+			Name:   "artifact-publishers",
+			Usage:  "Destinations the artifact is additionally published to: files, http(s) URLs the artifact is POSTed to",
+			EnvVar: "PLUGIN_ARTIFACT_PUBLISHERS",
+		},
+		cli.StringFlag{
+			Name:   "ledger",
+			Usage:  "JSON Lines ledger each build is recorded in, a file on a mounted volume",
+			EnvVar: "PLUGIN_LEDGER",
+		},
+		cli.StringFlag{
+			Name:   "vault-path",
+			Usage:  "Vault secret whose keys set the plugin settings of the same names, e.g. secret/data/drone/registry with username and password",
+			EnvVar: "PLUGIN_VAULT_PATH",
+		},
+	}, command.Flags()...)
+
+	if err := app.Run(os.Args); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, c *cli.Context) error {
+	if err := command.Setup(ctx, c, userAgent(c)); err != nil {
+		return err
+	}
+	noPush := c.Bool("no-push")
+	registry := c.String("registry")
+	if registry == "" {
+		return fmt.Errorf("registry must be specified")
+	}
+
+	// only setup auth when pushing or credentials are defined
+	if !noPush || c.String("username") != "" || c.String("federated-token-file") != "" {
+		if err := setupACRAuth(ctx, c, registry); err != nil {
+			return err
+		}
+	}
+
+	if err := command.AddAuths(c); err != nil {
+		return err
+	}
+
+	build := command.Build(c)
+	build.Repo = fmt.Sprintf("%s/%s", registry, c.String("repo"))
+	build.CacheRepo = registryRepo(registry, c.String("cache-repo"))
+	build.CacheFrom = registryRepos(registry, c.StringSlice("cache-from"))
+	plugin := kaniko.Plugin{
+		Build: build,
+		Artifact: kaniko.Artifact{
+			Tags:         c.StringSlice("tags"),
+			Repo:         c.String("repo"),
+			Registry:     registry,
+			ArtifactFile: c.String("artifact-file"),
+			Format:       c.String("artifact-format"),
+			Publishers:   c.StringSlice("artifact-publishers"),
+			Headers:      c.StringSlice("artifact-publish-headers"),
+			RegistryType: artifact.ACR,
+		},
+		Promotion:  command.Promotion(c),
+		UserAgent:  userAgent(c),
+		MaskValues: command.MaskValues(c, "password"),
+		Context:    ctx,
+	}
+	return plugin.Exec()
+}
+
+// setupACRAuth writes the docker config for the registry, using either the
+// given username and password or a refresh token obtained with workload
+// identity federation, so that no client secret has to be stored in Drone.
+func setupACRAuth(ctx context.Context, c *cli.Context, registry string) error {
+	username, password := c.String("username"), c.String("password")
+	if username == "" {
+		tenantID, clientID, tokenFile := c.String("tenant-id"), c.String("client-id"), c.String("federated-token-file")
+		if tenantID == "" || clientID == "" || tokenFile == "" {
+			return fmt.Errorf("either username and password or tenant-id, client-id and federated-token-file must be specified")
+		}
+		federatedToken, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read federated token")
+		}
+
+		client := &acr.Client{AuthorityHost: c.String("authority-host"), UserAgent: userAgent(c)}
+		aadToken, err := client.AADToken(ctx, tenantID, clientID, string(federatedToken))
+		if err != nil {
+			return err
+		}
+		refreshToken, err := client.RefreshToken(ctx, registry, tenantID, aadToken)
+		if err != nil {
+			return err
+		}
+		username, password = acr.TokenUsername, refreshToken
+	} else if password == "" {
+		return fmt.Errorf("password must be specified")
+	}
+	return docker.AddAuth(docker.ConfigPath, registry, username, password)
+}
+
+// userAgent identifies the plugin and the Drone build in registry requests.
+func userAgent(c *cli.Context) string {
+	return command.UserAgent(c, "drone-kaniko-acr", version)
+}
+
+// registryRepo prefixes the repo, if any, with the registry.
+func registryRepo(registry, repo string) string {
+	if repo == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", registry, repo)
+}
+
+// registryRepos prefixes each repo with the registry.
+func registryRepos(registry string, repos []string) []string {
+	var out []string
+	for _, repo := range repos {
+		out = append(out, registryRepo(registry, repo))
+	}
+	return out
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_registryRepo(t *testing.T) {
+	if got := registryRepo("myregistry.azurecr.io", "team/app"); got != "myregistry.azurecr.io/team/app" {
+		t.Errorf("registryRepo() = %v, want myregistry.azurecr.io/team/app", got)
+	}
+	if got := registryRepo("myregistry.azurecr.io", ""); got != "" {
+		t.Errorf("registryRepo() without repo = %v, want empty", got)
+	}
+}
+
+func Test_registryRepos(t *testing.T) {
+	got := registryRepos("myregistry.azurecr.io", []string{"team/app", "team/cache"})
+	want := []string{"myregistry.azurecr.io/team/app", "myregistry.azurecr.io/team/cache"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("registryRepos() = %v, want %v", got, want)
+	}
+	if got := registryRepos("myregistry.azurecr.io", nil); got != nil {
+		t.Errorf("registryRepos() without repos = %v, want nil", got)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	kaniko "github.com/gexops/drone-kaniko"
+	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/artifactory"
+	"github.com/gexops/drone-kaniko/pkg/command"
+	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+var (
+	version = "unknown"
+)
+
+func main() {
+	// Load env-file if it exists first
+	if env := os.Getenv("PLUGIN_ENV_FILE"); env != "" {
+		if err := godotenv.Load(env); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	app := cli.NewApp()
+	app.Name = "kaniko artifactory plugin"
+	app.Usage = "kaniko artifactory plugin"
+	app.Action = func(c *cli.Context) error {
+		defer docker.RemoveCredentials()
+		ctx, stop := command.Context()
+		defer stop()
+		return kaniko.WriteResult(c.String("output"), func() error {
+			return kaniko.ReportError(c.String("error-file"), run(ctx, c))
+		})
+	}
+	app.Version = version
+	app.Flags = append([]cli.Flag{
+		cli.StringFlag{
+			Name:   "repo",
+			Usage:  "docker repository",
+			EnvVar: "PLUGIN_REPO",
+		},
+		cli.StringFlag{
+			Name:   "registry",
+			Usage:  "artifactory docker registry, e.g. example.jfrog.io",
+			EnvVar: "PLUGIN_REGISTRY",
+		},
+		cli.StringFlag{
+			Name:   "username",
+			Usage:  "artifactory username",
+			EnvVar: "PLUGIN_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "password",
+			Usage:  "artifactory password",
+			EnvVar: "PLUGIN_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:   "api-key",
+			Usage:  "artifactory API key, used instead of the password",
+			EnvVar: "PLUGIN_API_KEY",
+		},
+		cli.StringFlag{
+			Name:   "access-token",
+			Usage:  "artifactory access token, used instead of the password",
+			EnvVar: "PLUGIN_ACCESS_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "url",
+			Usage:  "artifactory REST API base URL, defaults to https://<registry>/artifactory",
+			EnvVar: "PLUGIN_URL",
+		},
+		cli.StringFlag{
+			Name:   "repo-key",
+			Usage:  "key of the docker repository, defaults to the first path component of the repo",
+			EnvVar: "PLUGIN_REPO_KEY",
+		},
+		cli.BoolFlag{
+			Name:   "build-info",
+			Usage:  "set the build.name and build.number properties on the pushed tags",
+			EnvVar: "PLUGIN_BUILD_INFO",
+		},
+		cli.StringFlag{
+			Name:   "build-name",
+			Usage:  "build name of the build-info properties, defaults to the Drone repository",
+			EnvVar: "PLUGIN_BUILD_NAME",
+		},
+		cli.StringFlag{
+			Name:   "build-number",
+			Usage:  "build number of the build-info properties, defaults to the Drone build number",
+			EnvVar: "PLUGIN_BUILD_NUMBER",
+		},
+		cli.BoolFlag{
+			Name:   "skip-tls-verify",
+			Usage:  "Skip registry tls verify",
+			EnvVar: "PLUGIN_SKIP_TLS_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "snapshot-mode",
+			Usage:  "Specify one of full, redo or time as snapshot mode",
+			Value:  "redo",
+			EnvVar: "PLUGIN_SNAPSHOT_MODE",
+		},
+		cli.StringFlag{
+			Name:   "cache-repo",
+			Usage:  "Remote repository that will be used to store cached layers. enable-cache needs to be set to use this flag",
+			EnvVar: "PLUGIN_CACHE_REPO",
+		},
+		cli.StringFlag{
+			Name:   "registry-auths",
+			Usage:  "JSON or YAML list of registry, username and password objects of additional registries, e.g. of base images",
+			EnvVar: "PLUGIN_REGISTRY_AUTHS",
+		},
+		cli.StringSliceFlag{
+			Name:   "artifact-publishers",
+			Usage:  "Destinations the artifact is additionally published to: files, http(s) URLs the artifact is POSTed to",
+			EnvVar: "PLUGIN_ARTIFACT_PUBLISHERS",
+		},
+		cli.StringFlag{
+			Name:   "ledger",
+			Usage:  "JSON Lines ledger each build is recorded in, a file on a mounted volume",
+			EnvVar: "PLUGIN_LEDGER",
+		},
+		cli.StringFlag{
+			Name:   "vault-path",
+			Usage:  "Vault secret whose keys set the plugin settings of the same names, e.g. secret/data/drone/registry with username and password",
+			EnvVar: "PLUGIN_VAULT_PATH",
+		},
+	}, command.Flags()...)
+
+	if err := app.Run(os.Args); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, c *cli.Context) error {
+	if err := command.Setup(ctx, c, userAgent(c)); err != nil {
+		return err
+	}
+	username := c.String("username")
+	noPush := c.Bool("no-push")
+	secret, err := credential(c.String("password"), c.String("api-key"), c.String("access-token"))
+	if err != nil {
+		return err
+	}
+
+	// only setup auth when pushing or credentials are defined
+	if !noPush || username != "" {
+		if err := createDockerCfgFile(username, secret, c.String("registry")); err != nil {
+			return err
+		}
+	}
+
+	if c.Bool("build-info") && c.Bool("discover") {
+		return fmt.Errorf("build-info is not supported in discover mode")
+	}
+
+	if err := command.AddAuths(c); err != nil {
+		return err
+	}
+
+	build := command.Build(c)
+	build.Repo = buildRepo(c.String("registry"), c.String("repo"))
+	build.CacheRepo = buildRepo(c.String("registry"), c.String("cache-repo"))
+	build.CacheFrom = buildRepos(c.String("registry"), c.StringSlice("cache-from"))
+	plugin := kaniko.Plugin{
+		Build: build,
+		Artifact: kaniko.Artifact{
+			Tags:         c.StringSlice("tags"),
+			Repo:         buildRepo(c.String("registry"), c.String("repo")),
+			Registry:     c.String("registry"),
+			ArtifactFile: c.String("artifact-file"),
+			Format:       c.String("artifact-format"),
+			Publishers:   c.StringSlice("artifact-publishers"),
+			Headers:      c.StringSlice("artifact-publish-headers"),
+			RegistryType: artifact.Docker,
+		},
+		Promotion:  command.Promotion(c),
+		UserAgent:  userAgent(c),
+		MaskValues: command.MaskValues(c, "password", "api-key", "access-token"),
+		Context:    ctx,
+	}
+	if err := plugin.Exec(); err != nil {
+		return err
+	}
+
+	if c.Bool("build-info") && !noPush {
+		tags, err := plugin.Build.DestinationTags()
+		if err != nil {
+			return err
+		}
+		return setBuildInfo(ctx, c, plugin.Build.Repo, tags)
+	}
+	return nil
+}
+
+// credential returns the secret used with the username: the password, the
+// API key or the access token, of which only one may be set.
+func credential(password, apiKey, accessToken string) (string, error) {
+	var secret string
+	for _, s := range []string{password, apiKey, accessToken} {
+		if s == "" {
+			continue
+		}
+		if secret != "" {
+			return "", fmt.Errorf("only one of password, api-key and access-token can be specified")
+		}
+		secret = s
+	}
+	return secret, nil
+}
+
+// setBuildInfo sets the build-info properties on the tags of the pushed
+// image, linking them to the Artifactory build of the same name and number.
+func setBuildInfo(ctx context.Context, c *cli.Context, repo string, tags []string) error {
+	if _, err := os.Stat(command.DigestFile); os.IsNotExist(err) {
+		fmt.Println("No image was pushed, not setting build-info properties")
+		return nil
+	}
+	parsed, err := registry.ParseRepository(repo)
+	if err != nil {
+		return err
+	}
+	repoKey, path, err := artifactory.SplitRepository(parsed.Name, c.String("repo-key"))
+	if err != nil {
+		return err
+	}
+	buildName, buildNumber := c.String("build-name"), c.String("build-number")
+	if buildName == "" {
+		buildName = c.String("drone-repo")
+	}
+	if buildNumber == "" {
+		buildNumber = c.String("drone-build-number")
+	}
+	endpoint := c.String("url")
+	if endpoint == "" {
+		endpoint = "https://" + parsed.Registry + "/artifactory"
+	}
+
+	client := &artifactory.Client{
+		URL:         endpoint,
+		AccessToken: c.String("access-token"),
+		APIKey:      c.String("api-key"),
+		Username:    c.String("username"),
+		Password:    c.String("password"),
+		UserAgent:   userAgent(c),
+	}
+	properties := map[string]string{
+		artifactory.PropertyBuildName:   buildName,
+		artifactory.PropertyBuildNumber: buildNumber,
+	}
+	for _, tag := range tags {
+		if err := client.SetProperties(ctx, repoKey, path+"/"+tag, properties); err != nil {
+			return err
+		}
+		fmt.Printf("Set build-info properties of build %s #%s on %s/%s/%s\n", buildName, buildNumber, repoKey, path, tag)
+	}
+	return nil
+}
+
+// Create the docker config file for authentication
+func createDockerCfgFile(username, password, registry string) error {
+	if username == "" {
+		return fmt.Errorf("Username must be specified")
+	}
+	if password == "" {
+		return fmt.Errorf("Password must be specified")
+	}
+	if registry == "" {
+		return fmt.Errorf("Registry must be specified")
+	}
+
+	dockerPath := filepath.Dir(docker.ConfigPath)
+	err := os.MkdirAll(dockerPath, 0700)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to create %s directory", dockerPath))
+	}
+
+	authBytes := []byte(fmt.Sprintf("%s:%s", username, password))
+	encodedString := base64.StdEncoding.EncodeToString(authBytes)
+	jsonBytes := []byte(fmt.Sprintf(`{"auths": {"%s": {"auth": "%s"}}}`, registry, encodedString))
+	err = ioutil.WriteFile(docker.ConfigPath, jsonBytes, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker config file")
+	}
+	return nil
+}
+
+func buildRepo(registry, repo string) string {
+	if repo == "" {
+		// No repo, e.g. no cache repo
+		return ""
+	}
+	if registry == "" {
+		// No custom registry, just return the repo name
+		return repo
+	}
+	// Trim off trailing slash to prevent double slash when combining with repo
+	registry = strings.TrimSuffix(registry, "/")
+	if strings.HasPrefix(repo, registry+"/") {
+		// Repo already includes the registry prefix
+		// For backward compatibility, we won't add the prefix again.
+		return repo
+	}
+	// Prefix the repo with the registry
+	return registry + "/" + repo
+}
+
+// userAgent identifies the plugin and the Drone build in registry requests.
+func userAgent(c *cli.Context) string {
+	return command.UserAgent(c, "drone-kaniko-artifactory", version)
+}
+
+// buildRepos prefixes each repo with the registry, see buildRepo.
+func buildRepos(registry string, repos []string) []string {
+	var out []string
+	for _, repo := range repos {
+		out = append(out, buildRepo(registry, repo))
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func Test_buildRepo(t *testing.T) {
+	tests := []struct {
+		name     string
+		registry string
+		repo     string
+		want     string
+	}{
+		{
+			name: "no_registry",
+			repo: "docker-local/app",
+			want: "docker-local/app",
+		},
+		{
+			name:     "registry",
+			registry: "acme.jfrog.io/",
+			repo:     "docker-local/app",
+			want:     "acme.jfrog.io/docker-local/app",
+		},
+		{
+			name:     "empty",
+			registry: "acme.jfrog.io",
+			want:     "",
+		},
+		{
+			name:     "backward_compatibility",
+			registry: "acme.jfrog.io",
+			repo:     "acme.jfrog.io/docker-local/app",
+			want:     "acme.jfrog.io/docker-local/app",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildRepo(tt.registry, tt.repo); got != tt.want {
+				t.Errorf("buildRepo(%q, %q) = %v, want %v", tt.registry, tt.repo, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_credential(t *testing.T) {
+	tests := []struct {
+		name                          string
+		password, apiKey, accessToken string
+		want                          string
+		wantErr                       bool
+	}{
+		{name: "password", password: "secret", want: "secret"},
+		{name: "api_key", apiKey: "key", want: "key"},
+		{name: "access_token", accessToken: "token", want: "token"},
+		{name: "none", want: ""},
+		{name: "password_and_api_key", password: "secret", apiKey: "key", wantErr: true},
+		{name: "api_key_and_access_token", apiKey: "key", accessToken: "token", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := credential(tt.password, tt.apiKey, tt.accessToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("credential() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("credential() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
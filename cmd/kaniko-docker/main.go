@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/joho/godotenv"
@@ -14,18 +16,16 @@ import (
 
 	kaniko "github.com/gexops/drone-kaniko"
 	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/command"
+	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/gexops/drone-kaniko/pkg/dockerhub"
+	"github.com/gexops/drone-kaniko/pkg/registry"
 )
 
 const (
-	// Docker file path
-	dockerPath       string = "/kaniko/.docker"
-	dockerConfigPath string = "/kaniko/.docker/config.json"
-
 	v1RegistryURL    string = "https://index.docker.io/v1/" // Default registry
 	v2RegistryURL    string = "https://index.docker.io/v2/" // v2 registry is not supported
 	v2HubRegistryURL string = "https://registry.hub.docker.com/v2/"
-
-	defaultDigestFile string = "/kaniko/digest-file"
 )
 
 var (
@@ -43,84 +43,27 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "kaniko docker plugin"
 	app.Usage = "kaniko docker plugin"
-	app.Action = run
+	app.Action = func(c *cli.Context) error {
+		defer docker.RemoveCredentials()
+		ctx, stop := command.Context()
+		defer stop()
+		return kaniko.WriteResult(c.String("output"), func() error {
+			return kaniko.ReportError(c.String("error-file"), run(ctx, c))
+		})
+	}
 	app.Version = version
-	app.Flags = []cli.Flag{
-		cli.StringFlag{
-			Name:   "dockerfile",
-			Usage:  "build dockerfile",
-			Value:  "Dockerfile",
-			EnvVar: "PLUGIN_DOCKERFILE",
-		},
-		cli.StringFlag{
-			Name:   "context",
-			Usage:  "build context",
-			Value:  ".",
-			EnvVar: "PLUGIN_CONTEXT",
-		},
-		cli.StringFlag{
-			Name:   "drone-commit-ref",
-			Usage:  "git commit ref passed by Drone",
-			EnvVar: "DRONE_COMMIT_REF",
-		},
-		cli.StringFlag{
-			Name:   "drone-repo-branch",
-			Usage:  "git repository default branch passed by Drone",
-			EnvVar: "DRONE_REPO_BRANCH",
-		},
-		cli.StringSliceFlag{
-			Name:     "tags",
-			Usage:    "build tags",
-			Value:    &cli.StringSlice{"latest"},
-			EnvVar:   "PLUGIN_TAGS",
-			FilePath: ".tags",
-		},
-		cli.BoolFlag{
-			Name:   "expand-tag",
-			Usage:  "enable for semver tagging",
-			EnvVar: "PLUGIN_EXPAND_TAG",
-		},
-		cli.BoolFlag{
-			Name:   "auto-tag",
-			Usage:  "enable auto generation of build tags",
-			EnvVar: "PLUGIN_AUTO_TAG",
-		},
-		cli.StringFlag{
-			Name:   "auto-tag-suffix",
-			Usage:  "the suffix of auto build tags",
-			EnvVar: "PLUGIN_AUTO_TAG_SUFFIX",
-		},
-		cli.StringSliceFlag{
-			Name:   "args",
-			Usage:  "build args",
-			EnvVar: "PLUGIN_BUILD_ARGS",
-		},
-		cli.StringFlag{
-			Name:   "target",
-			Usage:  "build target",
-			EnvVar: "PLUGIN_TARGET",
-		},
+	app.Flags = append([]cli.Flag{
 		cli.StringFlag{
 			Name:   "repo",
 			Usage:  "docker repository",
 			EnvVar: "PLUGIN_REPO",
 		},
-		cli.StringSliceFlag{
-			Name:   "custom-labels",
-			Usage:  "additional k=v labels",
-			EnvVar: "PLUGIN_CUSTOM_LABELS",
-		},
 		cli.StringFlag{
 			Name:   "registry",
 			Usage:  "docker registry",
 			Value:  v1RegistryURL,
 			EnvVar: "PLUGIN_REGISTRY",
 		},
-		cli.StringSliceFlag{
-			Name:   "registry-mirrors",
-			Usage:  "docker registry mirrors",
-			EnvVar: "PLUGIN_REGISTRY_MIRRORS",
-		},
 		cli.StringFlag{
 			Name:   "username",
 			Usage:  "docker username",
@@ -128,9 +71,24 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:   "password",
-			Usage:  "docker password",
+			Usage:  "docker password, personal access token or organization access token",
 			EnvVar: "PLUGIN_PASSWORD",
 		},
+		cli.StringFlag{
+			Name:   "dockerhub-username",
+			Usage:  "Docker Hub username for authenticated base image pulls when pushing to another registry",
+			EnvVar: "PLUGIN_DOCKERHUB_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "dockerhub-password",
+			Usage:  "Docker Hub password or access token for authenticated base image pulls",
+			EnvVar: "PLUGIN_DOCKERHUB_PASSWORD",
+		},
+		cli.BoolTFlag{
+			Name:   "token-scope-check",
+			Usage:  "Check the scopes of Docker Hub access tokens against the repositories before the build",
+			EnvVar: "PLUGIN_TOKEN_SCOPE_CHECK",
+		},
 		cli.BoolFlag{
 			Name:   "skip-tls-verify",
 			Usage:  "Skip registry tls verify",
@@ -139,119 +97,109 @@ func main() {
 		cli.StringFlag{
 			Name:   "snapshot-mode",
 			Usage:  "Specify one of full, redo or time as snapshot mode",
-			Value: 	"redo",
+			Value:  "redo",
 			EnvVar: "PLUGIN_SNAPSHOT_MODE",
 		},
-		cli.BoolFlag{
-			Name:   "enable-cache",
-			Usage:  "Set this flag to opt into caching with kaniko",
-			EnvVar: "PLUGIN_ENABLE_CACHE",
-		},
-		cli.StringFlag{
-			Name:   "cache-dir",
-			Usage:  "Set this flag to specify a local directory cache for base images. enable-cache needs to be set to use this flag. Defaults to /cache.",
-			Value: 	"/cache",
-			EnvVar: "PLUGIN_CACHE_DIR",
-		},
-		cli.BoolFlag{
-			Name:   "cache-copy-layers",
-			Usage:  "Set this flag to cache copy layers. Defaults to false",
-			EnvVar: "PLUGIN_CACHE_COPY_LAYERS",
-		},
-		cli.BoolFlag{
-			Name:   "cache-no-compress",
-			Usage:  "Set this to true in order to prevent tar compression for cached layers.",
-			EnvVar: "PLUGIN_CACHE_NO_COMPRESS",
-		},
 		cli.StringFlag{
 			Name:   "cache-repo",
 			Usage:  "Remote repository that will be used to store cached layers. enable-cache needs to be set to use this flag",
 			EnvVar: "PLUGIN_CACHE_REPO",
 		},
-		cli.IntFlag{
-			Name:   "cache-ttl",
-			Usage:  "Cache timeout in hours. Defaults to two weeks.",
-			EnvVar: "PLUGIN_CACHE_TTL",
-		},
 		cli.StringFlag{
-			Name:   "artifact-file",
-			Usage:  "Artifact file location that will be generated by the plugin. This file will include information of docker images that are uploaded by the plugin.",
-			EnvVar: "PLUGIN_ARTIFACT_FILE",
+			Name:   "registry-auths",
+			Usage:  "JSON or YAML list of registry, username and password objects of additional registries, e.g. of base images",
+			EnvVar: "PLUGIN_REGISTRY_AUTHS",
 		},
-		cli.BoolFlag{
-			Name:   "no-push",
-			Usage:  "Set this flag if you only want to build the image, without pushing to a registry",
-			EnvVar: "PLUGIN_NO_PUSH",
+		cli.StringSliceFlag{
+			Name:   "artifact-publishers",
+			Usage:  "Destinations the artifact is additionally published to: files, http(s) URLs the artifact is POSTed to",
+			EnvVar: "PLUGIN_ARTIFACT_PUBLISHERS",
 		},
 		cli.StringFlag{
-			Name:   "verbosity",
-			Usage:  "Set this flag with value as oneof <panic|fatal|error|warn|info|debug|trace> to set the logging level for kaniko. Defaults to info.",
-			EnvVar: "PLUGIN_VERBOSITY",
-		},
-		cli.BoolFlag{
-			Name:   "use-new-run",
-			Usage:  "Set this flag if experimental run implementation for detecting changes without requiring file system snapshots. In some cases, this may improve build performance by 75%",
-			EnvVar: "PLUGIN_USE_NEW_RUN",
+			Name:   "ledger",
+			Usage:  "JSON Lines ledger each build is recorded in, a file on a mounted volume",
+			EnvVar: "PLUGIN_LEDGER",
 		},
 		cli.StringFlag{
-			Name:   "platform",
-			Usage:  "Allows to build with another default platform than the host, similarly to docker build --platform",
-			EnvVar: "PLUGIN_PLATFORM",
+			Name:   "vault-path",
+			Usage:  "Vault secret whose keys set the plugin settings of the same names, e.g. secret/data/drone/registry with username and password",
+			EnvVar: "PLUGIN_VAULT_PATH",
 		},
-	}
+	}, command.Flags()...)
 
 	if err := app.Run(os.Args); err != nil {
 		logrus.Fatal(err)
 	}
 }
 
-func run(c *cli.Context) error {
+func run(ctx context.Context, c *cli.Context) error {
+	if err := command.Setup(ctx, c, userAgent(c)); err != nil {
+		return err
+	}
 	username := c.String("username")
+	password := c.String("password")
 	noPush := c.Bool("no-push")
+	repos := []string{buildRepo(c.String("registry"), c.String("repo"))}
+	if c.Bool("enable-cache") && c.String("cache-repo") != "" {
+		repos = append(repos, buildRepo(c.String("registry"), c.String("cache-repo")))
+	}
+	hubRepos, err := dockerHubRepos(repos)
+	if err != nil {
+		return err
+	}
+
+	if dockerhub.IsOrganizationToken(password) && len(hubRepos) != 0 {
+		if username, err = organizationUsername(username, hubRepos[0]); err != nil {
+			return err
+		}
+	}
 
 	// only setup auth when pushing or credentials are defined
 	if !noPush || username != "" {
-		if err := createDockerCfgFile(username, c.String("password"), c.String("registry")); err != nil {
+		if err := createDockerCfgFile(username, password, c.String("registry")); err != nil {
 			return err
 		}
 	}
 
+	// Discovered services are pushed to repositories below the repo
+	if !noPush && !c.Bool("discover") && c.BoolT("token-scope-check") && dockerhub.IsAccessToken(password) {
+		client := &dockerhub.Client{UserAgent: userAgent(c)}
+		for _, repo := range hubRepos {
+			if err := client.CheckScopes(ctx, username, password, repo, true); err != nil {
+				return err
+			}
+			fmt.Printf("Docker Hub token scopes allow pushing to %s\n", repo)
+		}
+	}
+
+	if err := setupDockerHubPullAuth(ctx, c, len(hubRepos) != 0); err != nil {
+		return err
+	}
+
+	if err := command.AddAuths(c); err != nil {
+		return err
+	}
+
+	build := command.Build(c)
+	build.Repo = buildRepo(c.String("registry"), c.String("repo"))
+	build.CacheRepo = buildRepo(c.String("registry"), c.String("cache-repo"))
+	build.CacheFrom = buildRepos(c.String("registry"), c.StringSlice("cache-from"))
 	plugin := kaniko.Plugin{
-		Build: kaniko.Build{
-			DroneCommitRef:  c.String("drone-commit-ref"),
-			DroneRepoBranch: c.String("drone-repo-branch"),
-			Dockerfile:      c.String("dockerfile"),
-			Context:         c.String("context"),
-			Tags:            c.StringSlice("tags"),
-			AutoTag:         c.Bool("auto-tag"),
-			AutoTagSuffix:   c.String("auto-tag-suffix"),
-			ExpandTag:       c.Bool("expand-tag"),
-			Args:            c.StringSlice("args"),
-			Target:          c.String("target"),
-			Repo:            buildRepo(c.String("registry"), c.String("repo")),
-			Mirrors:         c.StringSlice("registry-mirrors"),
-			Labels:          c.StringSlice("custom-labels"),
-			SkipTlsVerify:   c.Bool("skip-tls-verify"),
-			SnapshotMode:    c.String("snapshot-mode"),
-			EnableCache:     c.Bool("enable-cache"),
-			CacheDir:		 c.String("cache-dir"),
-			CacheCopyLayers: c.Bool("cache-copy-layers"),
-			CacheNoCompress: c.Bool("cache-no-compress"),
-			CacheRepo:       buildRepo(c.String("registry"), c.String("cache-repo")),
-			CacheTTL:        c.Int("cache-ttl"),
-			DigestFile:      defaultDigestFile,
-			NoPush:          noPush,
-			Verbosity:       c.String("verbosity"),
-			UseNewRun: 		 c.Bool("use-new-run"),	
-			Platform:        c.String("platform"),
-		},
+		Build: build,
 		Artifact: kaniko.Artifact{
 			Tags:         c.StringSlice("tags"),
 			Repo:         buildRepo(c.String("registry"), c.String("repo")),
 			Registry:     c.String("registry"),
 			ArtifactFile: c.String("artifact-file"),
+			Format:       c.String("artifact-format"),
+			Publishers:   c.StringSlice("artifact-publishers"),
+			Headers:      c.StringSlice("artifact-publish-headers"),
 			RegistryType: artifact.Docker,
 		},
+		Promotion:  command.Promotion(c),
+		UserAgent:  userAgent(c),
+		MaskValues: command.MaskValues(c, "password", "dockerhub-password"),
+		Context:    ctx,
 	}
 	return plugin.Exec()
 }
@@ -274,7 +222,8 @@ func createDockerCfgFile(username, password, registry string) error {
 		registry = v1RegistryURL
 	}
 
-	err := os.MkdirAll(dockerPath, 0600)
+	dockerPath := filepath.Dir(docker.ConfigPath)
+	err := os.MkdirAll(dockerPath, 0700)
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("failed to create %s directory", dockerPath))
 	}
@@ -282,7 +231,7 @@ func createDockerCfgFile(username, password, registry string) error {
 	authBytes := []byte(fmt.Sprintf("%s:%s", username, password))
 	encodedString := base64.StdEncoding.EncodeToString(authBytes)
 	jsonBytes := []byte(fmt.Sprintf(`{"auths": {"%s": {"auth": "%s"}}}`, registry, encodedString))
-	err = ioutil.WriteFile(dockerConfigPath, jsonBytes, 0644)
+	err = ioutil.WriteFile(docker.ConfigPath, jsonBytes, 0600)
 	if err != nil {
 		return errors.Wrap(err, "failed to create docker config file")
 	}
@@ -290,6 +239,10 @@ func createDockerCfgFile(username, password, registry string) error {
 }
 
 func buildRepo(registry, repo string) string {
+	if repo == "" {
+		// No repo, e.g. no cache repo
+		return ""
+	}
 	if registry == "" {
 		// No custom registry, just return the repo name
 		return repo
@@ -304,3 +257,80 @@ func buildRepo(registry, repo string) string {
 	// Prefix the repo with the registry
 	return registry + "/" + repo
 }
+
+// dockerHubRepos returns the names, namespace/name, of the repositories on
+// Docker Hub.
+func dockerHubRepos(repos []string) ([]string, error) {
+	var names []string
+	for _, repo := range repos {
+		if repo == "" {
+			continue
+		}
+		parsed, err := registry.ParseRepository(repo)
+		if err != nil {
+			return nil, err
+		}
+		if parsed.IsDockerHub() {
+			names = append(names, parsed.Name)
+		}
+	}
+	return names, nil
+}
+
+// organizationUsername returns the username of organization access tokens,
+// which is the organization, i.e. the namespace of the repository.
+func organizationUsername(username, repo string) (string, error) {
+	organization := strings.SplitN(repo, "/", 2)[0]
+	if username == "" {
+		return organization, nil
+	}
+	if username != organization {
+		return "", fmt.Errorf("organization access tokens are used with the organization as username, expected %s, got %s", organization, username)
+	}
+	return username, nil
+}
+
+// setupDockerHubPullAuth adds the Docker Hub credentials to the docker config,
+// so that base images are pulled authenticated when pushing to another
+// registry, and logs their remaining pull rate limit.
+func setupDockerHubPullAuth(ctx context.Context, c *cli.Context, pushesToHub bool) error {
+	username, password := c.String("dockerhub-username"), c.String("dockerhub-password")
+	if username == "" {
+		return nil
+	}
+	if password == "" {
+		return fmt.Errorf("dockerhub-password must be specified with dockerhub-username")
+	}
+	if pushesToHub {
+		fmt.Println("Pushing to Docker Hub, base images are pulled with the push credentials instead of dockerhub-username")
+		return nil
+	}
+	if err := docker.AddAuth(docker.ConfigPath, v1RegistryURL, username, password); err != nil {
+		return err
+	}
+	client := &dockerhub.Client{UserAgent: userAgent(c)}
+	limit, err := client.RateLimit(ctx, username, password)
+	switch {
+	case err != nil:
+		fmt.Printf("Failed to check the Docker Hub pull rate limit of %s: %s\n", username, err)
+	case limit == nil:
+		fmt.Printf("Docker Hub reports no pull rate limit for %s\n", username)
+	default:
+		fmt.Printf("Docker Hub pull rate limit of %s: %s\n", username, limit)
+	}
+	return nil
+}
+
+// userAgent identifies the plugin and the Drone build in registry requests.
+func userAgent(c *cli.Context) string {
+	return command.UserAgent(c, "drone-kaniko-docker", version)
+}
+
+// buildRepos prefixes each repo with the registry, see buildRepo.
+func buildRepos(registry string, repos []string) []string {
+	var out []string
+	for _, repo := range repos {
+		out = append(out, buildRepo(registry, repo))
+	}
+	return out
+}
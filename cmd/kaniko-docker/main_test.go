@@ -20,6 +20,12 @@ func Test_buildRepo(t *testing.T) {
 			repo:     "service",
 			want:     "artifactory.example.com/service",
 		},
+		{
+			name:     "empty",
+			registry: "artifactory.example.com",
+			repo:     "",
+			want:     "",
+		},
 		{
 			name:     "backward_compatibility",
 			registry: "artifactory.example.com",
@@ -35,3 +41,25 @@ func Test_buildRepo(t *testing.T) {
 		})
 	}
 }
+
+func Test_organizationUsername(t *testing.T) {
+	if got, err := organizationUsername("", "acme/app"); err != nil || got != "acme" {
+		t.Errorf("organizationUsername() = %q, %v, want acme", got, err)
+	}
+	if got, err := organizationUsername("acme", "acme/app"); err != nil || got != "acme" {
+		t.Errorf("organizationUsername() = %q, %v, want acme", got, err)
+	}
+	if _, err := organizationUsername("octocat", "acme/app"); err == nil {
+		t.Error("organizationUsername() with other username error = nil")
+	}
+}
+
+func Test_dockerHubRepos(t *testing.T) {
+	got, err := dockerHubRepos([]string{"acme/app", "docker.io/acme/cache", "ghcr.io/acme/app", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "acme/app" || got[1] != "acme/cache" {
+		t.Errorf("dockerHubRepos() = %q, want [acme/app acme/cache]", got)
+	}
+}
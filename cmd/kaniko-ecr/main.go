@@ -1,21 +1,44 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
 	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	ecrpublictypes "github.com/aws/aws-sdk-go-v2/service/ecrpublic/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
 	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
 	kaniko "github.com/gexops/drone-kaniko"
 	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/command"
+	"github.com/gexops/drone-kaniko/pkg/discover"
 	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/gexops/drone-kaniko/pkg/ledger"
+	"github.com/gexops/drone-kaniko/pkg/patch"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/gexops/drone-kaniko/pkg/release"
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -23,19 +46,92 @@ import (
 )
 
 const (
-	accessKeyEnv     string = "AWS_ACCESS_KEY_ID"
-	secretKeyEnv     string = "AWS_SECRET_ACCESS_KEY"
-	dockerConfigPath string = "/kaniko/.docker/config.json"
-	ecrPublicDomain  string = "public.ecr.aws"
+	accessKeyEnv    string = "AWS_ACCESS_KEY_ID"
+	secretKeyEnv    string = "AWS_SECRET_ACCESS_KEY"
+	sessionTokenEnv string = "AWS_SESSION_TOKEN"
+	profileEnv      string = "AWS_PROFILE"
+	ecrPublicDomain string = "public.ecr.aws"
 
-	defaultDigestFile string = "/kaniko/digest-file"
+	// configFileEnv and credentialsFileEnv locate the AWS shared config
+	// and credentials files
+	configFileEnv      string = "AWS_CONFIG_FILE"
+	credentialsFileEnv string = "AWS_SHARED_CREDENTIALS_FILE"
+
+	// ecrEndpointEnv and stsEndpointEnv configure the endpoints of the
+	// ecr-login credential helper, which uses the AWS SDK as well
+	ecrEndpointEnv string = "AWS_ENDPOINT_URL_ECR"
+	stsEndpointEnv string = "AWS_ENDPOINT_URL_STS"
+
+	// refreshProfile is the profile of the assumed role credentials in the
+	// credentials file they are refreshed in
+	refreshProfile string = "drone-kaniko"
+
+	// creationTemplateRoot is the prefix of the creation template applying to all repositories
+	creationTemplateRoot string = "ROOT"
+
+	accessDeniedCode string = "AccessDeniedException"
+
+	// Modes of handling policy upload failures
+	policyFailureFail  string = "fail"
+	policyFailureWarn  string = "warn"
+	policyFailureRetry string = "retry"
+
+	// policyAttempts is how often policy uploads are attempted in the retry mode.
+	policyAttempts int = 3
+
+	// probeDigest and probeUploadID identify no layer or upload, so that
+	// permission probes cannot succeed
+	probeDigest   string = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	probeUploadID string = "00000000-0000-0000-0000-000000000000"
+
+	// probeRepoSuffix makes the name of the repository creation probe
+	// invalid, since path components must start with a letter or digit, so
+	// that the probe fails on the name once the creation is authorized
+	probeRepoSuffix string = "/-iam-preflight"
 )
 
 var (
 	version = "unknown"
+
+	// ecrRegistryPattern matches private ECR registry hosts, e.g.
+	// 123456789012.dkr.ecr.us-east-1.amazonaws.com.
+	ecrRegistryPattern = regexp.MustCompile(`^[0-9]{12}\.dkr\.ecr(-fips)?\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+	// pullThroughPrefixPattern matches repository prefixes of pull through
+	// cache rules.
+	pullThroughPrefixPattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*$`)
+
+	// policyRetryDelay is the delay before the first retry of a policy
+	// upload, doubled on every retry.
+	policyRetryDelay = 2 * time.Second
+
+	// scanPollDelay is the delay between polls of image scan findings.
+	scanPollDelay = 10 * time.Second
+
+	// userAgent identifies the plugin in AWS and registry requests, set by run.
+	userAgent = "drone-kaniko-ecr"
+
+	// endpointURL overrides the ECR and STS endpoints if set, set by run.
+	endpointURL string
+
+	// apiMaxAttempts and apiRetryDelay configure the retries of throttled
+	// and failed AWS API calls, set by run. Zero values keep the SDK defaults.
+	apiMaxAttempts int
+	apiRetryDelay  time.Duration
+
+	// credentialsFile is the shared credentials file the assumed role
+	// credentials are written to instead of the environment, so that they
+	// can be refreshed during the build, set by run.
+	credentialsFile string
+
+	// refreshedCredentialsFile is the credentials file used by credential refresh.
+	refreshedCredentialsFile = "/kaniko/.aws/refreshed-credentials"
 )
 
 func main() {
+	// The plugin also serves as wrapping credential helper
+	docker.RunCredHelperWrapper()
+
 	// Load env-file if it exists first
 	if env := os.Getenv("PLUGIN_ENV_FILE"); env != "" {
 		if err := godotenv.Load(env); err != nil {
@@ -46,15 +142,16 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "kaniko docker plugin"
 	app.Usage = "kaniko docker plugin"
-	app.Action = run
+	app.Action = func(c *cli.Context) error {
+		defer docker.RemoveCredentials()
+		ctx, stop := command.Context()
+		defer stop()
+		return kaniko.WriteResult(c.String("output"), func() error {
+			return kaniko.ReportError(c.String("error-file"), run(ctx, c))
+		})
+	}
 	app.Version = version
-	app.Flags = []cli.Flag{
-		cli.StringFlag{
-			Name:   "dockerfile",
-			Usage:  "build dockerfile",
-			Value:  "Dockerfile",
-			EnvVar: "PLUGIN_DOCKERFILE",
-		},
+	app.Flags = append([]cli.Flag{
 		cli.StringFlag{
 			Name:   "docker-username",
 			Usage:  "docker username",
@@ -66,62 +163,69 @@ func main() {
 			EnvVar: "PLUGIN_PASSWORD,DOCKER_PASSWORD",
 		},
 		cli.StringFlag{
-			Name:   "context",
-			Usage:  "build context",
-			Value:  ".",
-			EnvVar: "PLUGIN_CONTEXT",
+			Name:   "repo",
+			Usage:  "docker repository",
+			EnvVar: "PLUGIN_REPO",
+		},
+		cli.BoolFlag{
+			Name:   "create-repository",
+			Usage:  "create ECR repository",
+			EnvVar: "PLUGIN_CREATE_REPOSITORY",
+		},
+		cli.BoolFlag{
+			Name:   "scan-on-push",
+			Usage:  "enable image scanning on push of the created ECR repository",
+			EnvVar: "PLUGIN_SCAN_ON_PUSH",
+		},
+		cli.StringFlag{
+			Name:   "image-tag-mutability",
+			Usage:  "tag mutability of the created ECR repository (MUTABLE, IMMUTABLE)",
+			EnvVar: "PLUGIN_IMAGE_TAG_MUTABILITY",
 		},
 		cli.StringFlag{
-			Name:   "drone-commit-ref",
-			Usage:  "git commit ref passed by Drone",
-			EnvVar: "DRONE_COMMIT_REF",
+			Name:   "encryption-type",
+			Usage:  "encryption type of the created ECR repository (AES256, KMS), KMS if kms-key is set",
+			EnvVar: "PLUGIN_ENCRYPTION_TYPE",
 		},
 		cli.StringFlag{
-			Name:   "drone-repo-branch",
-			Usage:  "git repository default branch passed by Drone",
-			EnvVar: "DRONE_REPO_BRANCH",
+			Name:   "kms-key",
+			Usage:  "ARN of the KMS key encrypting the created ECR repository, the AWS managed key by default",
+			EnvVar: "PLUGIN_KMS_KEY",
 		},
 		cli.StringSliceFlag{
-			Name:     "tags",
-			Usage:    "build tags",
-			Value:    &cli.StringSlice{"latest"},
-			EnvVar:   "PLUGIN_TAGS",
-			FilePath: ".tags",
+			Name:   "repository-tags",
+			Usage:  "AWS resource tags of the created ECR repositories, as key=value",
+			EnvVar: "PLUGIN_REPOSITORY_TAGS",
 		},
-		cli.BoolFlag{
-			Name:   "expand-tag",
-			Usage:  "enable for semver tagging",
-			EnvVar: "PLUGIN_EXPAND_TAG",
+		cli.StringFlag{
+			Name:   "catalog-description",
+			Usage:  "short description of the ECR Public repository in the gallery",
+			EnvVar: "PLUGIN_CATALOG_DESCRIPTION",
 		},
-		cli.BoolFlag{
-			Name:   "auto-tag",
-			Usage:  "enable auto generation of build tags",
-			EnvVar: "PLUGIN_AUTO_TAG",
+		cli.StringFlag{
+			Name:   "catalog-about-text",
+			Usage:  "markdown about text of the ECR Public repository in the gallery",
+			EnvVar: "PLUGIN_CATALOG_ABOUT_TEXT",
 		},
 		cli.StringFlag{
-			Name:   "auto-tag-suffix",
-			Usage:  "the suffix of auto build tags",
-			EnvVar: "PLUGIN_AUTO_TAG_SUFFIX",
+			Name:   "catalog-usage-text",
+			Usage:  "markdown usage text of the ECR Public repository in the gallery",
+			EnvVar: "PLUGIN_CATALOG_USAGE_TEXT",
 		},
 		cli.StringSliceFlag{
-			Name:   "args",
-			Usage:  "build args",
-			EnvVar: "PLUGIN_BUILD_ARGS",
+			Name:   "catalog-architectures",
+			Usage:  "architectures of the images of the ECR Public repository, e.g. x86-64, ARM 64",
+			EnvVar: "PLUGIN_CATALOG_ARCHITECTURES",
 		},
-		cli.StringFlag{
-			Name:   "target",
-			Usage:  "build target",
-			EnvVar: "PLUGIN_TARGET",
+		cli.StringSliceFlag{
+			Name:   "catalog-operating-systems",
+			Usage:  "operating systems of the images of the ECR Public repository, e.g. Linux, Windows",
+			EnvVar: "PLUGIN_CATALOG_OPERATING_SYSTEMS",
 		},
 		cli.StringFlag{
-			Name:   "repo",
-			Usage:  "docker repository",
-			EnvVar: "PLUGIN_REPO",
-		},
-		cli.BoolFlag{
-			Name:   "create-repository",
-			Usage:  "create ECR repository",
-			EnvVar: "PLUGIN_CREATE_REPOSITORY",
+			Name:   "catalog-logo",
+			Usage:  "path of the logo image of the ECR Public repository in the gallery",
+			EnvVar: "PLUGIN_CATALOG_LOGO",
 		},
 		cli.StringFlag{
 			Name:   "region",
@@ -129,10 +233,40 @@ func main() {
 			Value:  "us-east-1",
 			EnvVar: "PLUGIN_REGION",
 		},
+		cli.StringFlag{
+			Name:   "registry-id",
+			Usage:  "AWS account ID of the registry repositories are created in and policies uploaded to, the account of the registry host by default",
+			EnvVar: "PLUGIN_REGISTRY_ID",
+		},
 		cli.StringSliceFlag{
-			Name:   "custom-labels",
-			Usage:  "additional k=v labels",
-			EnvVar: "PLUGIN_CUSTOM_LABELS",
+			Name:   "regions",
+			Usage:  "additional AWS regions the pushed image is copied to, into the registry of the same account",
+			EnvVar: "PLUGIN_REGIONS",
+		},
+		cli.StringFlag{
+			Name:   "aws-endpoint-url",
+			Usage:  "URL of the ECR and STS endpoints, e.g. of LocalStack or VPC interface endpoints",
+			EnvVar: "PLUGIN_AWS_ENDPOINT_URL",
+		},
+		cli.IntFlag{
+			Name:   "api-max-attempts",
+			Usage:  "maximum attempts of AWS API calls, e.g. throttled CreateRepository or policy calls, 3 by default",
+			EnvVar: "PLUGIN_API_MAX_ATTEMPTS",
+		},
+		cli.DurationFlag{
+			Name:   "api-retry-delay",
+			Usage:  "base delay of the exponential backoff between attempts of AWS API calls",
+			EnvVar: "PLUGIN_API_RETRY_DELAY",
+		},
+		cli.BoolFlag{
+			Name:   "fips-endpoint",
+			Usage:  "use the FIPS 140-2 validated ECR endpoint of the region",
+			EnvVar: "PLUGIN_FIPS_ENDPOINT",
+		},
+		cli.BoolFlag{
+			Name:   "dualstack-endpoint",
+			Usage:  "use the dual-stack (IPv4 and IPv6) ECR endpoint of the region",
+			EnvVar: "PLUGIN_DUALSTACK_ENDPOINT",
 		},
 		cli.StringFlag{
 			Name:   "registry",
@@ -140,9 +274,14 @@ func main() {
 			EnvVar: "PLUGIN_REGISTRY",
 		},
 		cli.StringSliceFlag{
-			Name:   "registry-mirrors",
-			Usage:  "docker registry mirrors",
-			EnvVar: "PLUGIN_REGISTRY_MIRRORS",
+			Name:   "base-image-registries",
+			Usage:  "Additional ECR registries, e.g. of shared base image accounts, pulled from with the plugin's AWS credentials",
+			EnvVar: "PLUGIN_BASE_IMAGE_REGISTRIES",
+		},
+		cli.StringSliceFlag{
+			Name:   "pull-through-cache-rules",
+			Usage:  "ECR pull through cache rules created before the build, as prefix=upstream-registry or prefix=upstream-registry,credential-arn",
+			EnvVar: "PLUGIN_PULL_THROUGH_CACHE_RULES",
 		},
 		cli.StringFlag{
 			Name:   "access-key",
@@ -154,6 +293,41 @@ func main() {
 			Usage:  "ECR secret key",
 			EnvVar: "PLUGIN_SECRET_KEY",
 		},
+		cli.StringFlag{
+			Name:   "session-token",
+			Usage:  "ECR session token of temporary credentials, e.g. from STS, Vault or OIDC",
+			EnvVar: "PLUGIN_SESSION_TOKEN,AWS_SESSION_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "aws-profile",
+			Usage:  "named profile of the AWS shared config, e.g. an SSO profile",
+			EnvVar: "PLUGIN_AWS_PROFILE,AWS_PROFILE",
+		},
+		cli.StringFlag{
+			Name:   "aws-config-dir",
+			Usage:  "directory of the AWS shared config and credentials files, e.g. a mounted ~/.aws",
+			EnvVar: "PLUGIN_AWS_CONFIG_DIR",
+		},
+		cli.StringFlag{
+			Name:   "assume-role",
+			Usage:  "ARN of an IAM role assumed with STS, whose temporary credentials are used for all AWS and ECR requests",
+			EnvVar: "PLUGIN_ASSUME_ROLE",
+		},
+		cli.StringFlag{
+			Name:   "external-id",
+			Usage:  "External ID required by the trust policy of the assumed role",
+			EnvVar: "PLUGIN_EXTERNAL_ID",
+		},
+		cli.StringFlag{
+			Name:   "web-identity-token-file",
+			Usage:  "File with an OIDC token, e.g. the service account token projected by IRSA, the assume-role role is assumed with",
+			EnvVar: "PLUGIN_WEB_IDENTITY_TOKEN_FILE",
+		},
+		cli.DurationFlag{
+			Name:   "credential-refresh",
+			Usage:  "Interval in which the assume-role role is assumed again during the build, e.g. 30m, so that long builds push with valid credentials",
+			EnvVar: "PLUGIN_CREDENTIAL_REFRESH",
+		},
 		cli.StringFlag{
 			Name:   "snapshot-mode",
 			Usage:  "Specify one of full, redo or time as snapshot mode",
@@ -164,170 +338,466 @@ func main() {
 			Usage:  "Path to lifecycle policy file",
 			EnvVar: "PLUGIN_LIFECYCLE_POLICY",
 		},
+		cli.StringFlag{
+			Name:   "lifecycle-policy-text",
+			Usage:  "Lifecycle policy as JSON or base64 encoded JSON, instead of a lifecycle policy file",
+			EnvVar: "PLUGIN_LIFECYCLE_POLICY_TEXT",
+		},
+		cli.StringFlag{
+			Name:   "cache-lifecycle-policy",
+			Usage:  "Path to the lifecycle policy file of the cache repository, e.g. expiring cached layers after some days",
+			EnvVar: "PLUGIN_CACHE_LIFECYCLE_POLICY",
+		},
 		cli.StringFlag{
 			Name:   "repository-policy",
 			Usage:  "Path to repository policy file",
 			EnvVar: "PLUGIN_REPOSITORY_POLICY",
 		},
-		cli.BoolFlag{
-			Name:   "enable-cache",
-			Usage:  "Set this flag to opt into caching with kaniko",
-			EnvVar: "PLUGIN_ENABLE_CACHE",
-		},
 		cli.StringFlag{
-			Name:   "cache-dir",
-			Usage:  "Set this flag to specify a local directory cache for base images. enable-cache needs to be set to use this flag. Defaults to /cache.",
-			Value: 	"/cache",
-			EnvVar: "PLUGIN_CACHE_DIR",
-		},
-		cli.BoolFlag{
-			Name:   "cache-copy-layers",
-			Usage:  "Set this flag to cache copy layers.",
-			EnvVar: "PLUGIN_CACHE_COPY_LAYERS",
+			Name:   "repository-policy-text",
+			Usage:  "Repository policy as JSON or base64 encoded JSON, instead of a repository policy file",
+			EnvVar: "PLUGIN_REPOSITORY_POLICY_TEXT",
 		},
-		cli.BoolFlag{
-			Name:   "cache-no-compress",
-			Usage:  "Set this to true in order to prevent tar compression for cached layers.",
-			EnvVar: "PLUGIN_CACHE_NO_COMPRESS",
+		cli.StringFlag{
+			Name:   "policy-failure-mode",
+			Usage:  "How failures uploading lifecycle and repository policies are handled: fail the step, warn and continue the build, or retry before failing",
+			Value:  policyFailureFail,
+			EnvVar: "PLUGIN_POLICY_FAILURE_MODE",
 		},
 		cli.StringFlag{
 			Name:   "cache-repo",
 			Usage:  "Remote repository that will be used to store cached layers. Cache repo should be present in specified registry. enable-cache needs to be set to use this flag",
 			EnvVar: "PLUGIN_CACHE_REPO",
 		},
-		cli.IntFlag{
-			Name:   "cache-ttl",
-			Usage:  "Cache timeout in hours. Defaults to two weeks.",
-			EnvVar: "PLUGIN_CACHE_TTL",
-		},
 		cli.StringFlag{
-			Name:   "artifact-file",
-			Usage:  "Artifact file location that will be generated by the plugin. This file will include information of docker images that are uploaded by the plugin.",
-			EnvVar: "PLUGIN_ARTIFACT_FILE",
+			Name:   "registry-auths",
+			Usage:  "JSON or YAML list of registry, username and password objects of additional registries, e.g. of base images",
+			EnvVar: "PLUGIN_REGISTRY_AUTHS",
+		},
+		cli.StringSliceFlag{
+			Name:   "repository-template-prefixes",
+			Usage:  "Prefixes of ECR repository creation templates with create on push. Matching repositories are created on push instead of with CreateRepository. ROOT matches all repositories",
+			EnvVar: "PLUGIN_REPOSITORY_TEMPLATE_PREFIXES",
 		},
 		cli.BoolFlag{
-			Name:   "no-push",
-			Usage:  "Set this flag if you only want to build the image, without pushing to a registry",
-			EnvVar: "PLUGIN_NO_PUSH",
+			Name:   "preflight-iam",
+			Usage:  "Probe the ECR actions needed to push the image and use the cache before the build and report any missing permission",
+			EnvVar: "PLUGIN_PREFLIGHT_IAM",
+		},
+		cli.StringSliceFlag{
+			Name:   "artifact-publishers",
+			Usage:  "Destinations the artifact is additionally published to: files, http(s) URLs the artifact is POSTed to, s3://<bucket>/<key>",
+			EnvVar: "PLUGIN_ARTIFACT_PUBLISHERS",
 		},
 		cli.StringFlag{
-			Name:   "verbosity",
-			Usage:  "Set this flag with value as oneof <panic|fatal|error|warn|info|debug|trace> to set the logging level for kaniko. Defaults to info.",
-			EnvVar: "PLUGIN_VERBOSITY",
+			Name:   "helper-proxy",
+			Usage:  "Proxy URL used by the registry credential helper only, e.g. for corporate proxies intercepting registry auth",
+			EnvVar: "PLUGIN_HELPER_PROXY",
 		},
-		cli.BoolFlag{
-			Name:   "use-new-run",
-			Usage:  "Set this flag if experimental run implementation for detecting changes without requiring file system snapshots. In some cases, this may improve build performance by 75%",
-			EnvVar: "PLUGIN_USE_NEW_RUN",
+		cli.StringFlag{
+			Name:   "helper-no-proxy",
+			Usage:  "Hosts the registry credential helper reaches without the proxy, as NO_PROXY",
+			EnvVar: "PLUGIN_HELPER_NO_PROXY",
+		},
+		cli.StringSliceFlag{
+			Name:   "helper-env",
+			Usage:  "Environment variables of the registry credential helper, as NAME=value, e.g. AWS_CA_BUNDLE=/kaniko/proxy-ca.pem",
+			EnvVar: "PLUGIN_HELPER_ENV",
 		},
 		cli.StringFlag{
-			Name:   "platform",
-			Usage:  "Allows to build with another default platform than the host, similarly to docker build --platform",
-			EnvVar: "PLUGIN_PLATFORM",
+			Name:   "ssm-parameter",
+			Usage:  "SSM parameter updated with the pushed image after push, e.g. /app/prod/image",
+			EnvVar: "PLUGIN_SSM_PARAMETER",
 		},
-	}
+		cli.StringFlag{
+			Name:   "ssm-parameter-value",
+			Usage:  "Value written to the SSM parameter, expanding ${REF}, ${REPO}, ${TAG} and ${DIGEST}",
+			Value:  patch.DefaultValue,
+			EnvVar: "PLUGIN_SSM_PARAMETER_VALUE",
+		},
+		cli.StringFlag{
+			Name:   "scan-severity-threshold",
+			Usage:  "Wait for the ECR image scan of the pushed image and fail on findings of this severity or higher (CRITICAL, HIGH, MEDIUM, LOW, INFORMATIONAL)",
+			EnvVar: "PLUGIN_SCAN_SEVERITY_THRESHOLD",
+		},
+		cli.DurationFlag{
+			Name:   "scan-timeout",
+			Usage:  "Maximum duration to wait for the ECR image scan results",
+			Value:  10 * time.Minute,
+			EnvVar: "PLUGIN_SCAN_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "ledger",
+			Usage:  "JSON Lines ledger each build is recorded in, a file on a mounted volume or s3://<bucket>/<key>",
+			EnvVar: "PLUGIN_LEDGER",
+		},
+		cli.StringFlag{
+			Name:   "vault-path",
+			Usage:  "Vault secret whose keys set the plugin settings of the same names, e.g. secret/data/drone/registry with username and password",
+			EnvVar: "PLUGIN_VAULT_PATH",
+		},
+	}, command.Flags()...)
 
 	if err := app.Run(os.Args); err != nil {
 		logrus.Fatal(err)
 	}
 }
 
-func run(c *cli.Context) error {
+func run(ctx context.Context, c *cli.Context) error {
+	userAgent = command.UserAgent(c, "drone-kaniko-ecr", version)
+	if err := command.Setup(ctx, c, userAgent); err != nil {
+		return err
+	}
+
+	if err := setupEndpoint(c.String("aws-endpoint-url")); err != nil {
+		return err
+	}
+	variant, err := newEndpointVariant(c.Bool("fips-endpoint"), c.Bool("dualstack-endpoint"))
+	if err != nil {
+		return err
+	}
+	if c.Int("api-max-attempts") < 0 || c.Duration("api-retry-delay") < 0 {
+		return fmt.Errorf("api-max-attempts and api-retry-delay must not be negative")
+	}
+	apiMaxAttempts, apiRetryDelay = c.Int("api-max-attempts"), c.Duration("api-retry-delay")
+	if c.String("aws-profile") != "" && c.String("access-key") != "" {
+		return fmt.Errorf("aws-profile conflicts with access-key and secret-key")
+	}
+	if err := setupProfile(c.String("aws-profile"), c.String("aws-config-dir")); err != nil {
+		return err
+	}
+
 	repo := c.String("repo")
 	registry := c.String("registry")
 	region := c.String("region")
 	noPush := c.Bool("no-push")
+	registryID := registryAccount(c.String("registry-id"), registry)
+	policyMode := c.String("policy-failure-mode")
+	if err := validatePolicyFailureMode(policyMode); err != nil {
+		return err
+	}
+	maskValues := command.MaskValues(c, "docker-password", "access-key", "secret-key", "session-token")
+	repositoryTags, err := parseRepositoryTags(c.StringSlice("repository-tags"))
+	if err != nil {
+		return err
+	}
+	settings := repositorySettings{
+		ScanOnPush:    c.Bool("scan-on-push"),
+		TagMutability: strings.ToUpper(c.String("image-tag-mutability")),
+		Encryption:    strings.ToUpper(c.String("encryption-type")),
+		KMSKey:        c.String("kms-key"),
+		Tags:          repositoryTags,
+	}
+	if err := settings.validate(registry); err != nil {
+		return err
+	}
+	catalog, err := repositoryCatalogData(
+		c.String("catalog-description"),
+		c.String("catalog-about-text"),
+		c.String("catalog-usage-text"),
+		c.StringSlice("catalog-architectures"),
+		c.StringSlice("catalog-operating-systems"),
+		c.String("catalog-logo"),
+	)
+	if err != nil {
+		return err
+	}
+	if catalog != nil && !isRegistryPublic(registry) {
+		return fmt.Errorf("catalog data is only supported by ECR Public")
+	}
 
-
+	// ECR supports nested repositories, the cache repo defaults to <repo>/cache
+	cacheRepo := c.String("cache-repo")
+	deriveCacheRepo := c.Bool("enable-cache") && cacheRepo == "" && repo != ""
+	if deriveCacheRepo {
+		cacheRepo = repo + "/cache"
+	}
 
 	dockerConfig, err := createDockerConfig(
 		c.String("docker-username"),
 		c.String("docker-password"),
 		c.String("access-key"),
 		c.String("secret-key"),
+		c.String("session-token"),
 		registry,
 		noPush,
 	)
 	if err != nil {
 		return err
 	}
-
-	jsonBytes, err := json.Marshal(dockerConfig)
+	refreshInterval := c.Duration("credential-refresh")
+	if refreshInterval > 0 {
+		if c.String("assume-role") == "" {
+			return fmt.Errorf("credential-refresh requires assume-role")
+		}
+		credentialsFile = refreshedCredentialsFile
+	}
+	refreshRole, err := setupRole(ctx, c, region, variant)
+	if err != nil {
+		return err
+	}
+	if err := setupBaseImageRegistries(dockerConfig, c.StringSlice("base-image-registries")); err != nil {
+		return err
+	}
+	regions := c.StringSlice("regions")
+	if len(regions) != 0 && (c.Bool("discover") || c.String("release") != "") {
+		return fmt.Errorf("regions are not supported in discover mode or with a release manifest")
+	}
+	regionRegistries, err := setupRegions(dockerConfig, registry, regions)
 	if err != nil {
 		return err
 	}
+	helperEnv, err := docker.HelperEnv(c.String("helper-proxy"), c.String("helper-no-proxy"), c.StringSlice("helper-env"))
+	if err != nil {
+		return err
+	}
+	if err := dockerConfig.WrapCredHelpers(helperEnv); err != nil {
+		return err
+	}
+
+	if err := dockerConfig.Save(docker.ConfigPath); err != nil {
+		return err
+	}
 
-	if err := ioutil.WriteFile(dockerConfigPath, jsonBytes, 0644); err != nil {
+	pullThroughRules, err := parsePullThroughCacheRules(c.StringSlice("pull-through-cache-rules"))
+	if err != nil {
 		return err
 	}
+	if len(pullThroughRules) != 0 {
+		if isRegistryPublic(registry) {
+			return fmt.Errorf("pull through cache rules are not supported by ECR Public")
+		}
+		cfg, err := loadAWSConfig(ctx, region, variant)
+		if err != nil {
+			return errors.Wrap(err, "failed to load aws config")
+		}
+		if err := createPullThroughCacheRules(ctx, cfg, registryID, pullThroughRules); err != nil {
+			return err
+		}
+	}
+
+	if c.IsSet("ssm-parameter") && (c.Bool("discover") || c.String("release") != "") {
+		return fmt.Errorf("ssm-parameter is not supported in discover mode or with a release manifest")
+	}
+	scanThreshold := strings.ToUpper(c.String("scan-severity-threshold"))
+	if scanThreshold != "" {
+		if _, ok := severityRank[types.FindingSeverity(scanThreshold)]; !ok {
+			return fmt.Errorf("invalid scan-severity-threshold %s, expected CRITICAL, HIGH, MEDIUM, LOW or INFORMATIONAL", scanThreshold)
+		}
+		if c.Bool("discover") || c.String("release") != "" || isRegistryPublic(registry) {
+			return fmt.Errorf("scan-severity-threshold is not supported in discover mode, with a release manifest or by ECR Public")
+		}
+	}
+
+	repos := []string{repo}
+	if c.Bool("discover") && (c.Bool("create-repository") || c.Bool("preflight-iam")) {
+		if repos, err = discoveredRepositories(repo, c.String("discover-root"), c.String("discover-pattern")); err != nil {
+			return err
+		}
+	}
+	if c.String("release") != "" && (c.Bool("create-repository") || c.Bool("preflight-iam")) {
+		manifest, err := release.Load(c.String("release"))
+		if err != nil {
+			return err
+		}
+		repos = manifest.Repositories(repo)
+	}
+
+	lifecyclePolicy, err := readPolicy(c.String("lifecycle-policy"), c.String("lifecycle-policy-text"))
+	if err != nil {
+		return errors.Wrap(err, "invalid lifecycle policy")
+	}
+	repositoryPolicy, err := readPolicy(c.String("repository-policy"), c.String("repository-policy-text"))
+	if err != nil {
+		return errors.Wrap(err, "invalid repository policy")
+	}
 
 	// only create repository when pushing and create-repository is true
+	var createRepos []string
 	if !noPush && c.Bool("create-repository") {
-		if err := createRepository(region, repo, registry); err != nil {
+		createRepos = append(createRepos, repos...)
+		// Kaniko fails pushing cache layers to a missing repository
+		if c.Bool("enable-cache") && cacheRepo != "" {
+			createRepos = append(createRepos, cacheRepo)
+		}
+	}
+
+	// The preflight precedes the repository creation to report a denied
+	// creation along with the other missing permissions
+	if c.Bool("preflight-iam") {
+		var pushRepos, cacheRepos []string
+		if !noPush {
+			pushRepos = repos
+		}
+		if c.Bool("enable-cache") && cacheRepo != "" {
+			cacheRepos = []string{cacheRepo}
+		}
+		if err := checkPermissions(ctx, region, variant, registry, registryID, pushRepos, cacheRepos, createRepos); err != nil {
 			return err
 		}
 	}
 
-	if c.IsSet("lifecycle-policy") {
-		contents, err := ioutil.ReadFile(c.String("lifecycle-policy"))
-		if err != nil {
-			logrus.Fatal(err)
+	if len(createRepos) != 0 {
+		for _, repo := range createRepos {
+			if prefix, ok := creationTemplatePrefix(repo, c.StringSlice("repository-template-prefixes")); ok && !isRegistryPublic(registry) {
+				policies := lifecyclePolicy != "" || repositoryPolicy != ""
+				if repo == cacheRepo {
+					policies = c.IsSet("cache-lifecycle-policy")
+				}
+				if policies {
+					return fmt.Errorf("repository %s is created on push from the creation template %s, set its policies in the template", repo, prefix)
+				}
+				fmt.Printf("Repository %s matches creation template %s, relying on create on push\n", repo, prefix)
+				continue
+			}
+			// The cache repository is only encrypted and tagged like the
+			// image repositories, scanning cache layers is of no use
+			repoSettings := settings
+			if repo == cacheRepo {
+				repoSettings = repositorySettings{Encryption: settings.Encryption, KMSKey: settings.KMSKey, Tags: settings.Tags}
+			}
+			if err := createRepository(ctx, region, variant, repo, registry, registryID, repoSettings); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Only the image repository is needed in the other regions
+	if !noPush && c.Bool("create-repository") {
+		for i, region := range regions {
+			if err := createRepository(ctx, region, variant, repo, regionRegistries[i], registryID, settings); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !noPush && catalog != nil {
+		for _, repo := range repos {
+			if err := putCatalogData(ctx, region, variant, repo, registryID, catalog); err != nil {
+				return err
+			}
 		}
-		if err := uploadLifeCyclePolicy(region, repo, string(contents)); err != nil {
-			logrus.Fatal(fmt.Sprintf("error uploading ECR lifecycle policy: %v", err))
+	}
+
+	if lifecyclePolicy != "" {
+		if err := applyPolicy(policyMode, func() error {
+			return uploadLifeCyclePolicy(ctx, region, variant, repo, registryID, lifecyclePolicy)
+		}); err != nil {
+			return errors.Wrap(err, "error uploading ECR lifecycle policy")
 		}
 	}
 
-	if c.IsSet("repository-policy") {
-		contents, err := ioutil.ReadFile(c.String("repository-policy"))
+	if c.IsSet("cache-lifecycle-policy") && c.Bool("enable-cache") && cacheRepo != "" {
+		contents, err := ioutil.ReadFile(c.String("cache-lifecycle-policy"))
 		if err != nil {
-			logrus.Fatal(err)
+			return err
 		}
-		if err := uploadRepositoryPolicy(region, repo, registry, string(contents)); err != nil {
-			logrus.Fatal(fmt.Sprintf("error uploading ECR lifecycle policy: %v", err))
+		if err := applyPolicy(policyMode, func() error {
+			return uploadLifeCyclePolicy(ctx, region, variant, cacheRepo, registryID, string(contents))
+		}); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to upload the lifecycle policy of the cache repository %s", cacheRepo))
 		}
 	}
 
+	if repositoryPolicy != "" {
+		if err := applyPolicy(policyMode, func() error {
+			return uploadRepositoryPolicy(ctx, region, variant, repo, registry, registryID, repositoryPolicy)
+		}); err != nil {
+			return errors.Wrap(err, "error uploading ECR repository policy")
+		}
+	}
+
+	if err := command.AddAuths(c); err != nil {
+		return err
+	}
+
+	build := command.Build(c)
+	build.Repo = fmt.Sprintf("%s/%s", c.String("registry"), c.String("repo"))
+	build.CacheRepo = registryRepo(c.String("registry"), cacheRepo)
+	build.CacheFrom = registryRepos(c.String("registry"), c.StringSlice("cache-from"))
 	plugin := kaniko.Plugin{
-		Build: kaniko.Build{
-			DroneCommitRef:  c.String("drone-commit-ref"),
-			DroneRepoBranch: c.String("drone-repo-branch"),
-			Dockerfile:      c.String("dockerfile"),
-			Context:         c.String("context"),
-			Tags:            c.StringSlice("tags"),
-			AutoTag:         c.Bool("auto-tag"),
-			AutoTagSuffix:   c.String("auto-tag-suffix"),
-			ExpandTag:       c.Bool("expand-tag"),
-			Args:            c.StringSlice("args"),
-			Target:          c.String("target"),
-			Repo:            fmt.Sprintf("%s/%s", c.String("registry"), c.String("repo")),
-			Mirrors:         c.StringSlice("registry-mirrors"),
-			Labels:          c.StringSlice("custom-labels"),
-			SnapshotMode:    c.String("snapshot-mode"),
-			EnableCache:     c.Bool("enable-cache"),
-			CacheDir:		 c.String("cache-dir"),
-			CacheCopyLayers: c.Bool("cache-copy-layers"),
-			CacheNoCompress:   c.Bool("cache-no-compress"),
-			CacheRepo:       fmt.Sprintf("%s/%s", c.String("registry"), c.String("cache-repo")),
-			CacheTTL:        c.Int("cache-ttl"),
-			DigestFile:      defaultDigestFile,
-			NoPush:          noPush,
-			Verbosity:       c.String("verbosity"),
-			UseNewRun: 		 c.Bool("use-new-run"),
-			Platform:        c.String("platform"),
-		},
+		Build: build,
 		Artifact: kaniko.Artifact{
 			Tags:         c.StringSlice("tags"),
 			Repo:         c.String("repo"),
 			Registry:     c.String("registry"),
 			ArtifactFile: c.String("artifact-file"),
+			Format:       c.String("artifact-format"),
+			Publishers:   c.StringSlice("artifact-publishers"),
+			Headers:      c.StringSlice("artifact-publish-headers"),
 			RegistryType: artifact.ECR,
 		},
+		Promotion:  command.Promotion(c),
+		UserAgent:  userAgent,
+		MaskValues: maskValues,
+		Context:    ctx,
+	}
+	if refreshInterval > 0 {
+		plugin.CredentialRefresh = refreshRole
+		plugin.CredentialRefreshInterval = refreshInterval
+	}
+	if ledgerURL := c.String("ledger"); strings.HasPrefix(ledgerURL, "s3://") {
+		bucket, key, err := parseS3URL(ledgerURL)
+		if err != nil {
+			return err
+		}
+		cfg, err := loadAWSConfig(ctx, region, variant)
+		if err != nil {
+			return errors.Wrap(err, "failed to load aws config")
+		}
+		plugin.LedgerStore = ledger.ObjectStore{Object: &s3Object{api: s3.NewFromConfig(cfg), bucket: bucket, key: key}}
+	}
+	if plugin.Promotion.Source != "" {
+		cfg, err := loadAWSConfig(ctx, region, variant)
+		if err != nil {
+			return errors.Wrap(err, "failed to load aws config")
+		}
+		plugin.Promotion.Copier = &ecrCopier{api: ecr.NewFromConfig(cfg), region: region}
+	}
+	plugin.Artifact.Openers = map[string]artifact.Opener{"s3": func(destination string) (artifact.Publisher, error) {
+		bucket, key, err := parseS3URL(destination)
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := loadAWSConfig(ctx, region, variant)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load aws config")
+		}
+		return &s3Publisher{api: s3.NewFromConfig(cfg), bucket: bucket, key: key}, nil
+	}}
+	if err := plugin.Exec(); err != nil {
+		return err
+	}
+
+	// The scan gates the image before it is published to SSM
+	if scanThreshold != "" && !noPush {
+		if err := checkImageScan(ctx, region, variant, registryID, repo, types.FindingSeverity(scanThreshold), c.Duration("scan-timeout")); err != nil {
+			return err
+		}
+	}
+
+	if len(regions) != 0 && !noPush {
+		tags, err := plugin.Build.DestinationTags()
+		if err != nil {
+			return err
+		}
+		if err := copyToRegions(ctx, registryClient(), command.DigestFile, registryRepo(registry, repo), regionRegistries, tags); err != nil {
+			return err
+		}
+	}
+
+	if c.IsSet("ssm-parameter") && !noPush {
+		tags := c.StringSlice("tags")
+		var tag string
+		if len(tags) != 0 {
+			tag = tags[0]
+		}
+		return putImageParameter(ctx, region, variant, c.String("ssm-parameter"), c.String("ssm-parameter-value"), registryRepo(registry, repo), tag)
 	}
-	return plugin.Exec()
+	return nil
 }
 
-func createDockerConfig(dockerUsername, dockerPassword, accessKey, secretKey, registry string, noPush bool) (*docker.Config, error) {
+func createDockerConfig(dockerUsername, dockerPassword, accessKey, secretKey, sessionToken, registry string, noPush bool) (*docker.Config, error) {
 	dockerConfig := docker.NewConfig()
 
 	if dockerUsername != "" {
@@ -353,6 +823,13 @@ func createDockerConfig(dockerUsername, dockerPassword, accessKey, secretKey, re
 			}
 		}
 
+		// Temporary credentials are only valid along with their session token
+		if sessionToken != "" {
+			if err := os.Setenv(sessionTokenEnv, sessionToken); err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("failed to set %s environment variable", sessionTokenEnv))
+			}
+		}
+
 		dockerConfig.SetCredHelper(ecrPublicDomain, "ecr-login")
 		dockerConfig.SetCredHelper(registry, "ecr-login")
 	}
@@ -360,34 +837,388 @@ func createDockerConfig(dockerUsername, dockerPassword, accessKey, secretKey, re
 	return dockerConfig, nil
 }
 
-func createRepository(region, repo, registry string) error {
-	if registry == "" {
-		return fmt.Errorf("registry must be specified")
+// setupRole assumes the assume-role role, with the web identity token if
+// set, so that its credentials are used from here on. It returns a function
+// assuming the role again with the original credentials, nil without role.
+func setupRole(ctx context.Context, c *cli.Context, region string, variant endpointVariant) (func(context.Context) error, error) {
+	roleARN, tokenFile := c.String("assume-role"), c.String("web-identity-token-file")
+	switch {
+	case roleARN == "" && tokenFile != "":
+		return nil, fmt.Errorf("web-identity-token-file requires assume-role")
+	case roleARN == "" && c.String("external-id") != "":
+		return nil, fmt.Errorf("external-id requires assume-role")
+	case tokenFile != "" && c.String("external-id") != "":
+		return nil, fmt.Errorf("external-id is not supported with web-identity-token-file")
+	case roleARN == "":
+		return nil, nil
 	}
-
-	if repo == "" {
-		return fmt.Errorf("repo must be specified")
+	cfg, err := loadAWSConfig(ctx, region, variant)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load aws config")
+	}
+	api, sessionName := sts.NewFromConfig(cfg), roleSessionName(c.String("drone-build-number"))
+	assume := func(ctx context.Context) error {
+		if tokenFile != "" {
+			return assumeRoleWithWebIdentity(ctx, api, roleARN, tokenFile, sessionName)
+		}
+		return assumeRole(ctx, api, roleARN, c.String("external-id"), sessionName)
 	}
+	return assume, assume(ctx)
+}
+
+// stsAPI is the part of the STS API used to assume a role.
+type stsAPI interface {
+	AssumeRole(context.Context, *sts.AssumeRoleInput, ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+	AssumeRoleWithWebIdentity(context.Context, *sts.AssumeRoleWithWebIdentityInput, ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error)
+}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+// assumeRole assumes the role and exports its temporary credentials to the
+// environment, where both the AWS clients of the plugin and the ecr-login
+// credential helper run by kaniko pick them up.
+func assumeRole(ctx context.Context, api stsAPI, roleARN, externalID, sessionName string) error {
+	in := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleARN),
+		RoleSessionName: aws.String(sessionName),
+	}
+	if externalID != "" {
+		in.ExternalId = aws.String(externalID)
+	}
+	out, err := api.AssumeRole(ctx, in)
 	if err != nil {
-		return errors.Wrap(err, "failed to load aws config")
+		return errors.Wrap(err, fmt.Sprintf("failed to assume role %s", roleARN))
+	}
+	if err := exportCredentials(out.Credentials); err != nil {
+		return err
 	}
+	fmt.Printf("Assumed role %s\n", roleARN)
+	return nil
+}
 
-	var createErr error
+// assumeRoleWithWebIdentity assumes the role with the OIDC token in
+// tokenFile, e.g. the service account token projected by IRSA, and exports
+// its temporary credentials like assumeRole.
+func assumeRoleWithWebIdentity(ctx context.Context, api stsAPI, roleARN, tokenFile, sessionName string) error {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to read web identity token")
+	}
+	out, err := api.AssumeRoleWithWebIdentity(ctx, &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(roleARN),
+		RoleSessionName:  aws.String(sessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to assume role %s with web identity", roleARN))
+	}
+	if err := exportCredentials(out.Credentials); err != nil {
+		return err
+	}
+	fmt.Printf("Assumed role %s with web identity\n", roleARN)
+	return nil
+}
+
+// exportCredentials sets the AWS credential environment variables or, with
+// credentialsFile set, writes the credentials to the file and points the
+// environment to it, so that refreshed credentials reach the ecr-login
+// credential helper run by kaniko.
+func exportCredentials(creds *ststypes.Credentials) error {
+	if credentialsFile != "" {
+		return writeCredentialsFile(credentialsFile, creds)
+	}
+	for name, value := range map[string]*string{
+		accessKeyEnv:    creds.AccessKeyId,
+		secretKeyEnv:    creds.SecretAccessKey,
+		sessionTokenEnv: creds.SessionToken,
+	} {
+		if err := os.Setenv(name, aws.ToString(value)); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to set %s environment variable", name))
+		}
+	}
+	return nil
+}
+
+// writeCredentialsFile replaces the shared credentials file at path with
+// the credentials, as refreshProfile, and selects it in the environment.
+func writeCredentialsFile(path string, creds *ststypes.Credentials) error {
+	content := fmt.Sprintf("[%s]\naws_access_key_id = %s\naws_secret_access_key = %s\naws_session_token = %s\n",
+		refreshProfile, aws.ToString(creds.AccessKeyId), aws.ToString(creds.SecretAccessKey), aws.ToString(creds.SessionToken))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "failed to create aws credentials directory")
+	}
+	// Credential helpers may read the file at any time
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(content), 0600); err != nil {
+		return errors.Wrap(err, "failed to write aws credentials file")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrap(err, "failed to write aws credentials file")
+	}
+	// Credentials in the environment take precedence over the file
+	for _, name := range []string{accessKeyEnv, secretKeyEnv, sessionTokenEnv} {
+		if err := os.Unsetenv(name); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to unset %s environment variable", name))
+		}
+	}
+	for name, value := range map[string]string{credentialsFileEnv: path, profileEnv: refreshProfile} {
+		if err := os.Setenv(name, value); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to set %s environment variable", name))
+		}
+	}
+	return nil
+}
+
+// roleSessionName names the role session of the build, which shows up in
+// CloudTrail.
+func roleSessionName(buildNumber string) string {
+	if buildNumber == "" {
+		return "drone-kaniko"
+	}
+	return "drone-kaniko-" + buildNumber
+}
+
+// setupBaseImageRegistries sets the ecr-login credential helper for the
+// additional ECR registries, so that base images in other accounts or
+// regions are pulled with the plugin's AWS credentials, whether or not the
+// image is pushed.
+func setupBaseImageRegistries(dockerConfig *docker.Config, registries []string) error {
+	for _, registry := range registries {
+		registry = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(registry), "https://"), "/")
+		if !ecrRegistryPattern.MatchString(registry) {
+			return fmt.Errorf("invalid base image registry %s, expected <account>.dkr.ecr.<region>.amazonaws.com", registry)
+		}
+		dockerConfig.SetCredHelper(registry, "ecr-login")
+	}
+	return nil
+}
+
+// setupRegions returns the registries of the account of the private ECR
+// registry in the additional regions, and sets the ecr-login credential
+// helper for them.
+func setupRegions(dockerConfig *docker.Config, host string, regions []string) ([]string, error) {
+	if len(regions) == 0 {
+		return nil, nil
+	}
+	_, _, ok := parseECRRegistry(host)
+	if !ok {
+		return nil, fmt.Errorf("regions require a private ECR registry, e.g. <account>.dkr.ecr.<region>.amazonaws.com, got %s", host)
+	}
+	var hosts []string
+	for _, region := range regions {
+		parts := strings.Split(host, ".")
+		parts[3] = region
+		regional := strings.Join(parts, ".")
+		dockerConfig.SetCredHelper(regional, "ecr-login")
+		hosts = append(hosts, regional)
+	}
+	return hosts, nil
+}
+
+// registryClient returns a registry client authenticated with the docker
+// config used by kaniko.
+func registryClient() *registry.Client {
+	client := registry.NewClient(registry.DockerKeychain(docker.ConfigPath), false)
+	client.UserAgent = userAgent
+	return client
+}
+
+// copyToRegions copies the pushed image, whose digest is in the digest file,
+// including its blobs, from the repository to the same repository of the
+// regional registries, tagging it with the tags.
+func copyToRegions(ctx context.Context, client *registry.Client, digestFile, repo string, registries []string, tags []string) error {
+	b, err := ioutil.ReadFile(digestFile)
+	if os.IsNotExist(err) {
+		fmt.Println("No image was pushed, not copying it to other regions")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read image digest")
+	}
+	digest := strings.TrimSpace(string(b))
+	src, err := registry.ParseRepository(repo)
+	if err != nil {
+		return err
+	}
+	for _, host := range registries {
+		dst := registry.Repository{Registry: host, Name: src.Name}
+		for _, tag := range tags {
+			if _, err := client.Copy(ctx, src, digest, dst, tag); err != nil {
+				return errors.Wrap(err, fmt.Sprintf("failed to copy %s@%s to %s:%s", src, digest, dst, tag))
+			}
+		}
+		fmt.Printf("Copied %s@%s to %s\n", src, digest, dst)
+	}
+	return nil
+}
+
+// pullThroughCacheRule maps an ECR repository prefix to an upstream
+// registry, whose images are pulled through ECR at <registry>/<prefix>/.
+type pullThroughCacheRule struct {
+	Prefix     string
+	Upstream   string // Upstream registry host, e.g. public.ecr.aws
+	Credential string // ARN of the Secrets Manager secret with the upstream credentials, if required
+}
+
+// parsePullThroughCacheRules parses rules given as prefix=upstream or
+// prefix=upstream,credential-arn.
+func parsePullThroughCacheRules(rules []string) ([]pullThroughCacheRule, error) {
+	var out []pullThroughCacheRule
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || !pullThroughPrefixPattern.MatchString(parts[0]) || parts[1] == "" {
+			return nil, fmt.Errorf("invalid pull through cache rule %s, expected prefix=upstream-registry[,credential-arn]", rule)
+		}
+		r := pullThroughCacheRule{Prefix: parts[0], Upstream: parts[1]}
+		if i := strings.Index(r.Upstream, ","); i != -1 {
+			r.Upstream, r.Credential = r.Upstream[:i], r.Upstream[i+1:]
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// createPullThroughCacheRules creates the pull through cache rules, leaving
+// existing rules of the prefixes unchanged.
+func createPullThroughCacheRules(ctx context.Context, cfg aws.Config, registryID string, rules []pullThroughCacheRule) error {
+	svc := ecr.NewFromConfig(cfg)
+	for _, rule := range rules {
+		in := &ecr.CreatePullThroughCacheRuleInput{
+			EcrRepositoryPrefix: aws.String(rule.Prefix),
+			UpstreamRegistryUrl: aws.String(rule.Upstream),
+		}
+		if registryID != "" {
+			in.RegistryId = aws.String(registryID)
+		}
+		if rule.Credential != "" {
+			in.CredentialArn = aws.String(rule.Credential)
+		}
+		_, err := svc.CreatePullThroughCacheRule(ctx, in)
+		var apiError smithy.APIError
+		switch {
+		case errors.As(err, &apiError) && apiError.ErrorCode() == "PullThroughCacheRuleAlreadyExistsException":
+			fmt.Printf("Pull through cache rule %s already exists\n", rule.Prefix)
+		case errors.As(err, &apiError) && apiError.ErrorCode() == accessDeniedCode:
+			return errors.Wrap(err, fmt.Sprintf("failed to create pull through cache rule %s: missing ecr:CreatePullThroughCacheRule permission", rule.Prefix))
+		case err != nil:
+			return errors.Wrap(err, fmt.Sprintf("failed to create pull through cache rule %s", rule.Prefix))
+		default:
+			fmt.Printf("Created pull through cache rule %s for %s\n", rule.Prefix, rule.Upstream)
+		}
+	}
+	return nil
+}
+
+// repositorySettings are the settings of created private repositories.
+type repositorySettings struct {
+	ScanOnPush    bool
+	TagMutability string // MUTABLE or IMMUTABLE, MUTABLE by default
+	Encryption    string // AES256 or KMS, AES256 by default or KMS with a KMSKey
+	KMSKey        string // ARN of the KMS key, the AWS managed key by default
+	Tags          []types.Tag
+}
+
+// validate checks the settings are valid and supported by the registry.
+func (s repositorySettings) validate(registry string) error {
+	switch types.ImageTagMutability(s.TagMutability) {
+	case "", types.ImageTagMutabilityMutable, types.ImageTagMutabilityImmutable:
+	default:
+		return fmt.Errorf("invalid image-tag-mutability %s, expected %s or %s", s.TagMutability, types.ImageTagMutabilityMutable, types.ImageTagMutabilityImmutable)
+	}
+	switch types.EncryptionType(s.Encryption) {
+	case "", types.EncryptionTypeKms:
+	case types.EncryptionTypeAes256:
+		if s.KMSKey != "" {
+			return fmt.Errorf("kms-key requires encryption-type %s", types.EncryptionTypeKms)
+		}
+	default:
+		return fmt.Errorf("invalid encryption-type %s, expected %s or %s", s.Encryption, types.EncryptionTypeAes256, types.EncryptionTypeKms)
+	}
+	if (s.ScanOnPush || s.TagMutability != "" || s.Encryption != "" || s.KMSKey != "") && isRegistryPublic(registry) {
+		return fmt.Errorf("scan-on-push, image-tag-mutability, encryption-type and kms-key are not supported by ECR Public")
+	}
+	return nil
+}
+
+// parseRepositoryTags parses repository tags given as key=value.
+func parseRepositoryTags(tags []string) ([]types.Tag, error) {
+	var out []types.Tag
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid repository tag %s, expected key=value", tag)
+		}
+		out = append(out, types.Tag{Key: aws.String(parts[0]), Value: aws.String(parts[1])})
+	}
+	return out, nil
+}
+
+// createRepositoryInput returns the input creating the private repository
+// with the settings.
+func createRepositoryInput(repo string, settings repositorySettings) *ecr.CreateRepositoryInput {
+	in := &ecr.CreateRepositoryInput{
+		RepositoryName:     aws.String(repo),
+		ImageTagMutability: types.ImageTagMutability(settings.TagMutability),
+		Tags:               settings.Tags,
+	}
+	if settings.ScanOnPush {
+		in.ImageScanningConfiguration = &types.ImageScanningConfiguration{ScanOnPush: true}
+	}
+	if settings.Encryption != "" || settings.KMSKey != "" {
+		in.EncryptionConfiguration = &types.EncryptionConfiguration{EncryptionType: types.EncryptionTypeKms}
+		if settings.Encryption != "" {
+			in.EncryptionConfiguration.EncryptionType = types.EncryptionType(settings.Encryption)
+		}
+		if settings.KMSKey != "" {
+			in.EncryptionConfiguration.KmsKey = aws.String(settings.KMSKey)
+		}
+	}
+	return in
+}
+
+// createRepository creates the repository, which ECR only supports in the
+// account of the caller, so a registryID of another account is rejected.
+func createRepository(ctx context.Context, region string, variant endpointVariant, repo, registry, registryID string, settings repositorySettings) error {
+	if registry == "" {
+		return fmt.Errorf("registry must be specified")
+	}
+
+	if repo == "" {
+		return fmt.Errorf("repo must be specified")
+	}
+
+	cfg, err := loadAWSConfig(ctx, region, variant)
+	if err != nil {
+		return errors.Wrap(err, "failed to load aws config")
+	}
+
+	if registryID != "" && !isRegistryPublic(registry) {
+		// Failures to look up the caller are left to CreateRepository
+		identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err == nil && aws.ToString(identity.Account) != registryID {
+			return fmt.Errorf("failed to create repository %s: ECR creates repositories in the caller's account %s only, create it in account %s", repo, aws.ToString(identity.Account), registryID)
+		}
+	}
+
+	var createErr error
 
 	//create public repo
 	//if registry string starts with public domain (ex: public.ecr.aws/example-registry)
 	if isRegistryPublic(registry) {
 		svc := ecrpublic.NewFromConfig(cfg)
-		_, createErr = svc.CreateRepository(context.TODO(), &ecrpublic.CreateRepositoryInput{RepositoryName: &repo})
+		in := &ecrpublic.CreateRepositoryInput{RepositoryName: &repo}
+		for _, tag := range settings.Tags {
+			in.Tags = append(in.Tags, ecrpublictypes.Tag{Key: tag.Key, Value: tag.Value})
+		}
+		_, createErr = svc.CreateRepository(ctx, in)
 		//create private repo
 	} else {
 		svc := ecr.NewFromConfig(cfg)
-		_, createErr = svc.CreateRepository(context.TODO(), &ecr.CreateRepositoryInput{RepositoryName: &repo})
+		_, createErr = svc.CreateRepository(ctx, createRepositoryInput(repo, settings))
 	}
 
 	var apiError smithy.APIError
+	if errors.As(createErr, &apiError) && apiError.ErrorCode() == accessDeniedCode {
+		return errors.Wrap(createErr, fmt.Sprintf("failed to create repository %s: missing ecr:CreateRepository permission", repo))
+	}
 	if errors.As(createErr, &apiError) && apiError.ErrorCode() != "RepositoryAlreadyExistsException" {
 		return errors.Wrap(createErr, "failed to create repository")
 	}
@@ -395,8 +1226,190 @@ func createRepository(region, repo, registry string) error {
 	return nil
 }
 
-func uploadLifeCyclePolicy(region, repo, lifecyclePolicy string) (err error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+// ecrAPI is the part of the ECR API probed by the IAM preflight.
+type ecrAPI interface {
+	GetAuthorizationToken(context.Context, *ecr.GetAuthorizationTokenInput, ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error)
+	BatchCheckLayerAvailability(context.Context, *ecr.BatchCheckLayerAvailabilityInput, ...func(*ecr.Options)) (*ecr.BatchCheckLayerAvailabilityOutput, error)
+	UploadLayerPart(context.Context, *ecr.UploadLayerPartInput, ...func(*ecr.Options)) (*ecr.UploadLayerPartOutput, error)
+	CompleteLayerUpload(context.Context, *ecr.CompleteLayerUploadInput, ...func(*ecr.Options)) (*ecr.CompleteLayerUploadOutput, error)
+	PutImage(context.Context, *ecr.PutImageInput, ...func(*ecr.Options)) (*ecr.PutImageOutput, error)
+	BatchGetImage(context.Context, *ecr.BatchGetImageInput, ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error)
+	GetDownloadUrlForLayer(context.Context, *ecr.GetDownloadUrlForLayerInput, ...func(*ecr.Options)) (*ecr.GetDownloadUrlForLayerOutput, error)
+	CreateRepository(context.Context, *ecr.CreateRepositoryInput, ...func(*ecr.Options)) (*ecr.CreateRepositoryOutput, error)
+}
+
+// permissionProbe calls an ECR action with arguments that are authorized
+// like a real request but cannot succeed, e.g. an unknown upload or an
+// invalid manifest, so probing has no side effects. ecr:InitiateLayerUpload
+// is not probed, since it starts an upload whenever it is allowed.
+type permissionProbe struct {
+	action string
+	pull   bool // Needed to pull from the repository rather than to push to it
+	probe  func(ctx context.Context, api ecrAPI, registryID, repo *string) error
+}
+
+var permissionProbes = []permissionProbe{
+	{action: "ecr:BatchCheckLayerAvailability", probe: func(ctx context.Context, api ecrAPI, registryID, repo *string) error {
+		_, err := api.BatchCheckLayerAvailability(ctx, &ecr.BatchCheckLayerAvailabilityInput{RegistryId: registryID, RepositoryName: repo, LayerDigests: []string{probeDigest}})
+		return err
+	}},
+	{action: "ecr:UploadLayerPart", probe: func(ctx context.Context, api ecrAPI, registryID, repo *string) error {
+		_, err := api.UploadLayerPart(ctx, &ecr.UploadLayerPartInput{
+			RegistryId:     registryID,
+			RepositoryName: repo,
+			UploadId:       aws.String(probeUploadID),
+			PartFirstByte:  aws.Int64(0),
+			PartLastByte:   aws.Int64(0),
+			LayerPartBlob:  []byte{0},
+		})
+		return err
+	}},
+	{action: "ecr:CompleteLayerUpload", probe: func(ctx context.Context, api ecrAPI, registryID, repo *string) error {
+		_, err := api.CompleteLayerUpload(ctx, &ecr.CompleteLayerUploadInput{RegistryId: registryID, RepositoryName: repo, UploadId: aws.String(probeUploadID), LayerDigests: []string{probeDigest}})
+		return err
+	}},
+	{action: "ecr:PutImage", probe: func(ctx context.Context, api ecrAPI, registryID, repo *string) error {
+		_, err := api.PutImage(ctx, &ecr.PutImageInput{RegistryId: registryID, RepositoryName: repo, ImageManifest: aws.String("{}")})
+		return err
+	}},
+	{action: "ecr:BatchGetImage", pull: true, probe: func(ctx context.Context, api ecrAPI, registryID, repo *string) error {
+		_, err := api.BatchGetImage(ctx, &ecr.BatchGetImageInput{RegistryId: registryID, RepositoryName: repo, ImageIds: []types.ImageIdentifier{{ImageDigest: aws.String(probeDigest)}}})
+		return err
+	}},
+	{action: "ecr:GetDownloadUrlForLayer", pull: true, probe: func(ctx context.Context, api ecrAPI, registryID, repo *string) error {
+		_, err := api.GetDownloadUrlForLayer(ctx, &ecr.GetDownloadUrlForLayerInput{RegistryId: registryID, RepositoryName: repo, LayerDigest: aws.String(probeDigest)})
+		return err
+	}},
+}
+
+// createProbe probes ecr:CreateRepository with an invalid name below the
+// repository, which fails after the action is authorized, so the probe can
+// never create a repository.
+var createProbe = permissionProbe{action: "ecr:CreateRepository", probe: func(ctx context.Context, api ecrAPI, registryID, repo *string) error {
+	_, err := api.CreateRepository(ctx, &ecr.CreateRepositoryInput{
+		RegistryId:     registryID,
+		RepositoryName: aws.String(aws.ToString(repo) + probeRepoSuffix),
+	})
+	return err
+}}
+
+// checkPermissions verifies that the ECR actions needed to push to
+// pushRepos, to push to and pull from cacheRepos and to create createRepos
+// are allowed, reporting every missing permission instead of a generic 403
+// at push time.
+func checkPermissions(ctx context.Context, region string, variant endpointVariant, registry, registryID string, pushRepos, cacheRepos, createRepos []string) error {
+	if isRegistryPublic(registry) {
+		fmt.Println("IAM preflight is not supported for ECR Public, skipping")
+		return nil
+	}
+	cfg, err := loadAWSConfig(ctx, region, variant)
+	if err != nil {
+		return errors.Wrap(err, "failed to load aws config")
+	}
+	missing, err := missingPermissions(ctx, ecr.NewFromConfig(cfg), registryID, pushRepos, cacheRepos, createRepos)
+	if err != nil {
+		return errors.Wrap(err, "IAM preflight failed")
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("IAM preflight failed, missing ECR permissions: %s", strings.Join(missing, ", "))
+	}
+	fmt.Println("IAM preflight succeeded")
+	return nil
+}
+
+// missingPermissions probes the needed actions and returns the denied ones,
+// with the repository they are denied on.
+func missingPermissions(ctx context.Context, api ecrAPI, registryID string, pushRepos, cacheRepos, createRepos []string) ([]string, error) {
+	var missing []string
+	denied := func(err error) (bool, error) {
+		var apiError smithy.APIError
+		if !errors.As(err, &apiError) {
+			return false, err
+		}
+		return apiError.ErrorCode() == accessDeniedCode, nil
+	}
+
+	_, err := api.GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenInput{})
+	if ok, err := denied(err); err != nil {
+		return nil, err
+	} else if ok {
+		missing = append(missing, "ecr:GetAuthorizationToken")
+	}
+
+	for _, repo := range createRepos {
+		err := createProbe.probe(ctx, api, optionalString(registryID), aws.String(repo))
+		var apiError smithy.APIError
+		if errors.As(err, &apiError) && apiError.ErrorCode() == accessDeniedCode {
+			missing = append(missing, fmt.Sprintf("%s on %s", createProbe.action, repo))
+		}
+	}
+
+	type target struct {
+		repo string
+		pull bool
+	}
+	var targets []target
+	for _, repo := range pushRepos {
+		targets = append(targets, target{repo: repo})
+	}
+	for _, repo := range cacheRepos {
+		targets = append(targets, target{repo: repo, pull: true})
+	}
+	for _, t := range targets {
+		for _, p := range permissionProbes {
+			if p.pull && !t.pull {
+				continue
+			}
+			err := p.probe(ctx, api, optionalString(registryID), aws.String(t.repo))
+			var apiError smithy.APIError
+			if errors.As(err, &apiError) && apiError.ErrorCode() == "RepositoryNotFoundException" {
+				fmt.Printf("Repository %s does not exist, skipping its IAM preflight\n", t.repo)
+				break
+			}
+			if ok, err := denied(err); err != nil {
+				return nil, err
+			} else if ok {
+				missing = append(missing, fmt.Sprintf("%s on %s", p.action, t.repo))
+			}
+		}
+	}
+	return missing, nil
+}
+
+// validatePolicyFailureMode checks the policy-failure-mode setting.
+func validatePolicyFailureMode(mode string) error {
+	switch mode {
+	case policyFailureFail, policyFailureWarn, policyFailureRetry:
+		return nil
+	}
+	return fmt.Errorf("invalid policy failure mode %s, expected %s, %s or %s", mode, policyFailureFail, policyFailureWarn, policyFailureRetry)
+}
+
+// applyPolicy runs the policy upload and handles its failure by the mode:
+// the error is returned, only logged so that the build continues, or
+// returned once the retries are exhausted.
+func applyPolicy(mode string, upload func() error) error {
+	attempts := 1
+	if mode == policyFailureRetry {
+		attempts = policyAttempts
+	}
+	delay := policyRetryDelay
+	err := upload()
+	for attempt := 1; err != nil && attempt < attempts; attempt++ {
+		fmt.Printf("Policy upload failed, retrying in %s (retry %d of %d): %s\n", delay, attempt, attempts-1, err)
+		time.Sleep(delay)
+		delay *= 2
+		err = upload()
+	}
+	if err != nil && mode == policyFailureWarn {
+		logrus.Warnf("Policy upload failed, continuing the build: %s", err)
+		return nil
+	}
+	return err
+}
+
+func uploadLifeCyclePolicy(ctx context.Context, region string, variant endpointVariant, repo, registryID, lifecyclePolicy string) (err error) {
+	cfg, err := loadAWSConfig(ctx, region, variant)
 	if err != nil {
 		return errors.Wrap(err, "failed to load aws config")
 	}
@@ -405,15 +1418,16 @@ func uploadLifeCyclePolicy(region, repo, lifecyclePolicy string) (err error) {
 
 	input := &ecr.PutLifecyclePolicyInput{
 		LifecyclePolicyText: aws.String(lifecyclePolicy),
+		RegistryId:          optionalString(registryID),
 		RepositoryName:      aws.String(repo),
 	}
-	_, err = svc.PutLifecyclePolicy(context.TODO(), input)
+	_, err = svc.PutLifecyclePolicy(ctx, input)
 
 	return err
 }
 
-func uploadRepositoryPolicy(region, repo, registry, repositoryPolicy string) (err error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+func uploadRepositoryPolicy(ctx context.Context, region string, variant endpointVariant, repo, registry, registryID, repositoryPolicy string) (err error) {
+	cfg, err := loadAWSConfig(ctx, region, variant)
 	if err != nil {
 		return errors.Wrap(err, "failed to load aws config")
 	}
@@ -423,23 +1437,596 @@ func uploadRepositoryPolicy(region, repo, registry, repositoryPolicy string) (er
 
 		input := &ecrpublic.SetRepositoryPolicyInput{
 			PolicyText:     aws.String(repositoryPolicy),
+			RegistryId:     optionalString(registryID),
 			RepositoryName: aws.String(repo),
 		}
-		_, err = svc.SetRepositoryPolicy(context.TODO(), input)
+		_, err = svc.SetRepositoryPolicy(ctx, input)
 	} else {
 
 		svc := ecr.NewFromConfig(cfg)
 
 		input := &ecr.SetRepositoryPolicyInput{
 			PolicyText:     aws.String(repositoryPolicy),
+			RegistryId:     optionalString(registryID),
 			RepositoryName: aws.String(repo),
 		}
-		_, err = svc.SetRepositoryPolicy(context.TODO(), input)
+		_, err = svc.SetRepositoryPolicy(ctx, input)
 	}
 
 	return err
 }
 
+// readPolicy returns the policy read from the file at path, or given as
+// text, either JSON or base64 encoded JSON, e.g. from a secret. It returns
+// an empty policy if neither is set.
+func readPolicy(path, text string) (string, error) {
+	switch {
+	case path != "" && text != "":
+		return "", fmt.Errorf("policy file and text are mutually exclusive")
+	case path != "":
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return string(contents), nil
+	case text == "":
+		return "", nil
+	}
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "{") {
+		decoded, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return "", fmt.Errorf("expected JSON or base64 encoded JSON")
+		}
+		text = string(decoded)
+	}
+	if !json.Valid([]byte(text)) {
+		return "", fmt.Errorf("policy is not valid JSON")
+	}
+	return text, nil
+}
+
+// repositoryCatalogData returns the gallery catalog data of ECR Public
+// repositories, or nil if none is set. The logo is read from the file at
+// logo.
+func repositoryCatalogData(description, aboutText, usageText string, architectures, operatingSystems []string, logo string) (*ecrpublictypes.RepositoryCatalogDataInput, error) {
+	if description == "" && aboutText == "" && usageText == "" && len(architectures) == 0 && len(operatingSystems) == 0 && logo == "" {
+		return nil, nil
+	}
+	catalog := &ecrpublictypes.RepositoryCatalogDataInput{
+		Description:      optionalString(description),
+		AboutText:        optionalString(aboutText),
+		UsageText:        optionalString(usageText),
+		Architectures:    architectures,
+		OperatingSystems: operatingSystems,
+	}
+	if logo != "" {
+		blob, err := ioutil.ReadFile(logo)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read catalog logo")
+		}
+		catalog.LogoImageBlob = blob
+	}
+	return catalog, nil
+}
+
+// putCatalogData sets the gallery catalog data of the ECR Public repository.
+func putCatalogData(ctx context.Context, region string, variant endpointVariant, repo, registryID string, catalog *ecrpublictypes.RepositoryCatalogDataInput) error {
+	cfg, err := loadAWSConfig(ctx, region, variant)
+	if err != nil {
+		return errors.Wrap(err, "failed to load aws config")
+	}
+	_, err = ecrpublic.NewFromConfig(cfg).PutRepositoryCatalogData(ctx, &ecrpublic.PutRepositoryCatalogDataInput{
+		CatalogData:    catalog,
+		RegistryId:     optionalString(registryID),
+		RepositoryName: aws.String(repo),
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to put the catalog data of repository %s", repo))
+	}
+	return nil
+}
+
+// ecrImageAPI is the part of the ECR API used to copy images.
+type ecrImageAPI interface {
+	BatchGetImage(context.Context, *ecr.BatchGetImageInput, ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error)
+	PutImage(context.Context, *ecr.PutImageInput, ...func(*ecr.Options)) (*ecr.PutImageOutput, error)
+}
+
+// ecrCopier promotes images between ECR repositories of a region by copying
+// the manifest with BatchGetImage and PutImage, without pulling or pushing
+// any layer.
+type ecrCopier struct {
+	api    ecrImageAPI
+	region string
+}
+
+// Copy copies the manifest of srcRef to the tag of dst. It reports false,
+// so that the image is copied through the registry API instead, when either
+// repository is not a private ECR repository of the copier's region, the
+// image is not found or the layers are missing in dst, as PutImage does not
+// mount layers from other repositories.
+func (c *ecrCopier) Copy(ctx context.Context, src registry.Repository, srcRef string, dst registry.Repository, tag string) (bool, error) {
+	srcAccount, srcRegion, ok := parseECRRegistry(src.Registry)
+	if !ok || srcRegion != c.region {
+		return false, nil
+	}
+	dstAccount, dstRegion, ok := parseECRRegistry(dst.Registry)
+	if !ok || dstRegion != c.region {
+		return false, nil
+	}
+
+	id := types.ImageIdentifier{ImageTag: aws.String(srcRef)}
+	if strings.HasPrefix(srcRef, "sha256:") {
+		id = types.ImageIdentifier{ImageDigest: aws.String(srcRef)}
+	}
+	out, err := c.api.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+		RegistryId:     aws.String(srcAccount),
+		RepositoryName: aws.String(src.Name),
+		ImageIds:       []types.ImageIdentifier{id},
+		AcceptedMediaTypes: []string{
+			registry.MediaTypeDockerManifest,
+			registry.MediaTypeDockerManifestList,
+			registry.MediaTypeOCIManifest,
+			registry.MediaTypeOCIIndex,
+		},
+	})
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to get image %s:%s", src, srcRef))
+	}
+	if len(out.Images) == 0 {
+		return false, nil
+	}
+	image := out.Images[0]
+
+	_, err = c.api.PutImage(ctx, &ecr.PutImageInput{
+		RegistryId:             aws.String(dstAccount),
+		RepositoryName:         aws.String(dst.Name),
+		ImageManifest:          image.ImageManifest,
+		ImageManifestMediaType: image.ImageManifestMediaType,
+		ImageDigest:            id.ImageDigest,
+		ImageTag:               aws.String(tag),
+	})
+	if err == nil {
+		return true, nil
+	}
+	var apiError smithy.APIError
+	if errors.As(err, &apiError) {
+		switch apiError.ErrorCode() {
+		case "ImageAlreadyExistsException":
+			// The tag already refers to the image
+			return true, nil
+		case "LayersNotFoundException", "ReferencedImagesNotFoundException":
+			return false, nil
+		}
+	}
+	return false, errors.Wrap(err, fmt.Sprintf("failed to put image %s:%s", dst, tag))
+}
+
+// registryAccount returns the registry ID of the repository operations:
+// the id if set, otherwise the account of a private ECR registry host, or
+// none for the account of the caller.
+func registryAccount(id, registry string) string {
+	if id != "" {
+		return id
+	}
+	account, _, _ := parseECRRegistry(registry)
+	return account
+}
+
+// optionalString returns a pointer to s, or nil if s is empty, for
+// optional API input fields.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+// parseECRRegistry returns the account and region of a private ECR registry
+// host, e.g. 123456789012.dkr.ecr.us-east-1.amazonaws.com.
+func parseECRRegistry(host string) (account, region string, ok bool) {
+	if !ecrRegistryPattern.MatchString(host) {
+		return "", "", false
+	}
+	parts := strings.Split(host, ".")
+	return parts[0], parts[3], true
+}
+
+// putImageParameter writes the pushed image to the SSM parameter, with the
+// value template expanded, e.g. for ECS deployments reading the current image
+// from Parameter Store.
+func putImageParameter(ctx context.Context, region string, variant endpointVariant, name, value, repo, tag string) error {
+	b, err := ioutil.ReadFile(command.DigestFile)
+	if os.IsNotExist(err) {
+		fmt.Printf("No image was pushed, not updating SSM parameter %s\n", name)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read image digest")
+	}
+	image := patch.Image{Repo: repo, Tag: tag, Digest: strings.TrimSpace(string(b))}
+
+	cfg, err := loadAWSConfig(ctx, region, variant)
+	if err != nil {
+		return errors.Wrap(err, "failed to load aws config")
+	}
+	if err := putParameter(ctx, ssm.NewFromConfig(cfg), name, image.Expand(value)); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to update SSM parameter %s", name))
+	}
+	fmt.Printf("Updated SSM parameter %s with %s\n", name, image.Expand(value))
+	return nil
+}
+
+// severityRank orders the finding severities of ECR image scans.
+var severityRank = map[types.FindingSeverity]int{
+	types.FindingSeverityInformational: 1,
+	types.FindingSeverityLow:           2,
+	types.FindingSeverityMedium:        3,
+	types.FindingSeverityHigh:          4,
+	types.FindingSeverityCritical:      5,
+}
+
+// checkImageScan waits for the scan of the pushed image and fails if it
+// found vulnerabilities of the threshold severity or higher.
+func checkImageScan(ctx context.Context, region string, variant endpointVariant, registryID, repo string, threshold types.FindingSeverity, timeout time.Duration) error {
+	b, err := ioutil.ReadFile(command.DigestFile)
+	if os.IsNotExist(err) {
+		fmt.Println("No image was pushed, not checking the image scan")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read image digest")
+	}
+	cfg, err := loadAWSConfig(ctx, region, variant)
+	if err != nil {
+		return errors.Wrap(err, "failed to load aws config")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return waitForScan(ctx, ecr.NewFromConfig(cfg), registryID, repo, strings.TrimSpace(string(b)), threshold)
+}
+
+// ecrScanAPI is the part of the ECR API used to wait for image scans.
+type ecrScanAPI interface {
+	DescribeImageScanFindings(context.Context, *ecr.DescribeImageScanFindingsInput, ...func(*ecr.Options)) (*ecr.DescribeImageScanFindingsOutput, error)
+}
+
+// waitForScan polls the scan findings of the image until the scan is
+// complete, and fails if it found vulnerabilities of the threshold severity
+// or higher. A scan that has not started yet, e.g. right after the push, is
+// waited for as well.
+func waitForScan(ctx context.Context, api ecrScanAPI, registryID, repo, digest string, threshold types.FindingSeverity) error {
+	in := &ecr.DescribeImageScanFindingsInput{
+		RegistryId:     optionalString(registryID),
+		RepositoryName: aws.String(repo),
+		ImageId:        &types.ImageIdentifier{ImageDigest: aws.String(digest)},
+	}
+	for {
+		out, err := api.DescribeImageScanFindings(ctx, in)
+		var apiError smithy.APIError
+		switch {
+		case errors.As(err, &apiError) && apiError.ErrorCode() == "ScanNotFoundException":
+		case err != nil:
+			return errors.Wrap(err, fmt.Sprintf("failed to get the scan findings of %s@%s", repo, digest))
+		case out.ImageScanStatus != nil && out.ImageScanStatus.Status == types.ScanStatusComplete:
+			return checkFindings(repo, digest, out.ImageScanFindings, threshold)
+		case out.ImageScanStatus != nil && out.ImageScanStatus.Status == types.ScanStatusFailed:
+			return fmt.Errorf("image scan of %s@%s failed: %s", repo, digest, aws.ToString(out.ImageScanStatus.Description))
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for the image scan of %s@%s", repo, digest)
+		case <-time.After(scanPollDelay):
+		}
+	}
+}
+
+// checkFindings fails if the findings count vulnerabilities of the threshold
+// severity or higher.
+func checkFindings(repo, digest string, findings *types.ImageScanFindings, threshold types.FindingSeverity) error {
+	var blocking []string
+	if findings != nil {
+		for _, severity := range []types.FindingSeverity{
+			types.FindingSeverityCritical,
+			types.FindingSeverityHigh,
+			types.FindingSeverityMedium,
+			types.FindingSeverityLow,
+			types.FindingSeverityInformational,
+		} {
+			if count := findings.FindingSeverityCounts[string(severity)]; count > 0 && severityRank[severity] >= severityRank[threshold] {
+				blocking = append(blocking, fmt.Sprintf("%d %s", count, severity))
+			}
+		}
+	}
+	if len(blocking) != 0 {
+		return fmt.Errorf("image scan of %s@%s found vulnerabilities of severity %s or higher: %s", repo, digest, threshold, strings.Join(blocking, ", "))
+	}
+	fmt.Printf("Image scan of %s@%s found no vulnerabilities of severity %s or higher\n", repo, digest, threshold)
+	return nil
+}
+
+// ssmAPI is the part of the SSM API used to publish the pushed image.
+type ssmAPI interface {
+	PutParameter(context.Context, *ssm.PutParameterInput, ...func(*ssm.Options)) (*ssm.PutParameterOutput, error)
+}
+
+// putParameter creates or overwrites the string parameter name.
+func putParameter(ctx context.Context, api ssmAPI, name, value string) error {
+	_, err := api.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Value:     aws.String(value),
+		Type:      ssmtypes.ParameterTypeString,
+		Overwrite: aws.Bool(true),
+	})
+	return err
+}
+
+// parseS3URL splits an s3://bucket/key URL into bucket and key.
+func parseS3URL(s string) (bucket, key string, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(s, "s3://"), "/", 2)
+	if !strings.HasPrefix(s, "s3://") || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid object %s, expected s3://<bucket>/<key>", s)
+	}
+	return parts[0], parts[1], nil
+}
+
+// s3API is the part of the S3 API used to append to the build ledger and to
+// publish artifacts.
+type s3API interface {
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// s3Object is an S3 object of the build ledger, versioned by its ETag. S3
+// has no conditional writes, so the ETag is only compared right before the
+// object is written, leaving a short window in which concurrent builds can
+// overwrite each other's entries.
+type s3Object struct {
+	api    s3API
+	bucket string
+	key    string
+}
+
+func (o *s3Object) Read(ctx context.Context) ([]byte, string, error) {
+	out, err := o.api.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(o.bucket), Key: aws.String(o.key)})
+	var apiError smithy.APIError
+	if errors.As(err, &apiError) && apiError.ErrorCode() == "NoSuchKey" {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer out.Body.Close()
+	content, err := ioutil.ReadAll(out.Body)
+	return content, aws.ToString(out.ETag), err
+}
+
+func (o *s3Object) Write(ctx context.Context, content []byte, version string) error {
+	var etag string
+	head, err := o.api.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(o.bucket), Key: aws.String(o.key)})
+	var apiError smithy.APIError
+	switch {
+	case err == nil:
+		etag = aws.ToString(head.ETag)
+	case !errors.As(err, &apiError) || apiError.ErrorCode() != "NotFound":
+		return err
+	}
+	if etag != version {
+		return ledger.ErrConflict
+	}
+	_, err = o.api.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(o.bucket),
+		Key:         aws.String(o.key),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	return err
+}
+
+func (o *s3Object) String() string {
+	return fmt.Sprintf("s3://%s/%s", o.bucket, o.key)
+}
+
+// s3Publisher publishes artifacts to an S3 object.
+type s3Publisher struct {
+	api    s3API
+	bucket string
+	key    string
+}
+
+func (p *s3Publisher) Publish(ctx context.Context, content []byte, format artifact.FormatEnum) error {
+	_, err := p.api.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(p.bucket),
+		Key:         aws.String(p.key),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String(format.ContentType()),
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to put artifact to %s", p))
+	}
+	return nil
+}
+
+func (p *s3Publisher) String() string {
+	return fmt.Sprintf("s3://%s/%s", p.bucket, p.key)
+}
+
+// discoveredRepositories returns the repository names used for the
+// Dockerfiles found in discover mode.
+func discoveredRepositories(repo, root, pattern string) ([]string, error) {
+	services, err := discover.Services(root, pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to discover dockerfiles")
+	}
+	var repos []string
+	for _, service := range services {
+		if service.Name == "" {
+			repos = append(repos, repo)
+		} else {
+			repos = append(repos, repo+"/"+service.Name)
+		}
+	}
+	return repos, nil
+}
+
+// loadAWSConfig loads the default AWS config for the region, identifying
+// the plugin in the User-Agent of API requests.
+func loadAWSConfig(ctx context.Context, region string, variant endpointVariant) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithAPIOptions([]func(*middleware.Stack) error{
+			awsmiddleware.AddUserAgentKey(userAgent),
+		}),
+	}
+	if apiMaxAttempts != 0 || apiRetryDelay != 0 {
+		opts = append(opts, config.WithRetryer(func() aws.Retryer {
+			return newRetryer(apiMaxAttempts, apiRetryDelay)
+		}))
+	}
+	if endpointURL != "" {
+		opts = append(opts, config.WithEndpointResolver(endpointResolver(endpointURL)))
+	}
+	if variant.fips {
+		opts = append(opts, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if variant.dualStack {
+		opts = append(opts, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	return config.LoadDefaultConfig(ctx, opts...)
+}
+
+// newRetryer returns the standard retryer of the SDK, retrying throttling
+// and transient errors, with maxAttempts attempts and an exponential backoff
+// starting at baseDelay. Zero values keep the defaults.
+func newRetryer(maxAttempts int, baseDelay time.Duration) aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		if maxAttempts != 0 {
+			o.MaxAttempts = maxAttempts
+		}
+		if baseDelay != 0 {
+			o.Backoff = retry.BackoffDelayerFunc(func(attempt int, _ error) (time.Duration, error) {
+				return backoffDelay(baseDelay, o.MaxBackoff, attempt), nil
+			})
+		}
+	})
+}
+
+// backoffDelay returns the delay before the retry after the attempt, the
+// base delay doubled on every retry and capped at max, of which up to half
+// is jitter.
+func backoffDelay(base, max time.Duration, attempt int) time.Duration {
+	d := max
+	if attempt < 32 && base<<(attempt-1) < max {
+		d = base << (attempt - 1)
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// setupProfile exports the AWS profile and the shared config files in
+// configDir, if set, to the environment, where both the AWS clients of the
+// plugin and the ecr-login credential helper pick them up.
+func setupProfile(profile, configDir string) error {
+	env := map[string]string{}
+	if profile != "" {
+		env[profileEnv] = profile
+	}
+	if configDir != "" {
+		if info, err := os.Stat(configDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("aws-config-dir %s is not a directory", configDir)
+		}
+		env[configFileEnv] = filepath.Join(configDir, "config")
+		env[credentialsFileEnv] = filepath.Join(configDir, "credentials")
+	}
+	for name, value := range env {
+		if err := os.Setenv(name, value); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to set %s environment variable", name))
+		}
+	}
+	return nil
+}
+
+// setupEndpoint validates the endpoint URL and, if set, uses it for the AWS
+// clients of the plugin and exports it to the ecr-login credential helper.
+func setupEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid aws-endpoint-url %s, expected an http or https URL", endpoint)
+	}
+	for _, name := range []string{ecrEndpointEnv, stsEndpointEnv} {
+		if err := os.Setenv(name, endpoint); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to set %s environment variable", name))
+		}
+	}
+	endpointURL = endpoint
+	return nil
+}
+
+// endpointResolver resolves the ECR and STS endpoints to endpoint, and the
+// endpoints of other services, e.g. S3 and SSM, as usual.
+func endpointResolver(endpoint string) aws.EndpointResolver {
+	return aws.EndpointResolverFunc(func(service, region string) (aws.Endpoint, error) {
+		switch service {
+		case ecr.ServiceID, sts.ServiceID:
+			return aws.Endpoint{
+				URL:               endpoint,
+				HostnameImmutable: true,
+				SigningRegion:     region,
+				Source:            aws.EndpointSourceCustom,
+			}, nil
+		}
+		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+	})
+}
+
+// endpointVariant selects the FIPS and dual-stack (IPv4 and IPv6)
+// endpoints of the AWS services.
+type endpointVariant struct {
+	fips, dualStack bool
+}
+
+// newEndpointVariant returns the endpoint variant, which cannot be combined
+// with a custom endpoint.
+func newEndpointVariant(fips, dualStack bool) (endpointVariant, error) {
+	if (fips || dualStack) && endpointURL != "" {
+		return endpointVariant{}, fmt.Errorf("fips-endpoint and dualstack-endpoint cannot be combined with aws-endpoint-url")
+	}
+	return endpointVariant{fips: fips, dualStack: dualStack}, nil
+}
+
+// creationTemplatePrefix returns the repository creation template prefix
+// matching repo. A prefix matches the repositories in its namespace, e.g.
+// prod matches prod/app, and ROOT matches every repository.
+func creationTemplatePrefix(repo string, prefixes []string) (string, bool) {
+	for _, prefix := range prefixes {
+		if prefix == creationTemplateRoot || strings.HasPrefix(repo, strings.TrimSuffix(prefix, "/")+"/") {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
 func isRegistryPublic(registry string) bool {
 	return strings.HasPrefix(registry, ecrPublicDomain)
 }
+
+// registryRepo prefixes the repo, if any, with the registry.
+func registryRepo(registry, repo string) string {
+	if repo == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", registry, repo)
+}
+
+// registryRepos prefixes each repo with the registry.
+func registryRepos(registry string, repos []string) []string {
+	var out []string
+	for _, repo := range repos {
+		out = append(out, registryRepo(registry, repo))
+	}
+	return out
+}
@@ -1,18 +1,49 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+	"github.com/gexops/drone-kaniko/pkg/artifact"
 	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/gexops/drone-kaniko/pkg/ledger"
+	"github.com/gexops/drone-kaniko/pkg/patch"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/gexops/drone-kaniko/pkg/registry/registrytest"
+	"github.com/pkg/errors"
 )
 
 func TestCreateDockerConfig(t *testing.T) {
+	for _, name := range []string{accessKeyEnv, secretKeyEnv, sessionTokenEnv} {
+		t.Setenv(name, "")
+	}
 	got, err := createDockerConfig(
 		"docker-username",
 		"docker-password",
 		"access-key",
 		"secret-key",
+		"session-token",
 		"ecr-registry",
 		false,
 	)
@@ -28,4 +59,885 @@ func TestCreateDockerConfig(t *testing.T) {
 	if !reflect.DeepEqual(want, got) {
 		t.Errorf("not equal:\n  want: %#v\n   got: %#v", want, got)
 	}
+	for name, value := range map[string]string{accessKeyEnv: "access-key", secretKeyEnv: "secret-key", sessionTokenEnv: "session-token"} {
+		if got := os.Getenv(name); got != value {
+			t.Errorf("%s = %s, want %s", name, got, value)
+		}
+	}
+}
+
+func TestSetupBaseImageRegistries(t *testing.T) {
+	got := docker.NewConfig()
+	if err := setupBaseImageRegistries(got, []string{"111111111111.dkr.ecr.us-east-1.amazonaws.com", "https://222222222222.dkr.ecr.cn-north-1.amazonaws.com.cn/"}); err != nil {
+		t.Fatal(err)
+	}
+	want := docker.NewConfig()
+	want.SetCredHelper("111111111111.dkr.ecr.us-east-1.amazonaws.com", "ecr-login")
+	want.SetCredHelper("222222222222.dkr.ecr.cn-north-1.amazonaws.com.cn", "ecr-login")
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("not equal:\n  want: %#v\n   got: %#v", want, got)
+	}
+
+	for _, registry := range []string{"docker.io", "1111.dkr.ecr.us-east-1.amazonaws.com", "public.ecr.aws"} {
+		if err := setupBaseImageRegistries(docker.NewConfig(), []string{registry}); err == nil {
+			t.Errorf("setupBaseImageRegistries(%s) error = nil", registry)
+		}
+	}
+}
+
+func TestCreationTemplatePrefix(t *testing.T) {
+	tests := []struct {
+		repo     string
+		prefixes []string
+		want     string
+		ok       bool
+	}{
+		{repo: "prod/app", prefixes: []string{"dev", "prod"}, want: "prod", ok: true},
+		{repo: "prod/team/app", prefixes: []string{"prod/team/"}, want: "prod/team/", ok: true},
+		{repo: "production/app", prefixes: []string{"prod"}},
+		{repo: "prod", prefixes: []string{"prod"}},
+		{repo: "app", prefixes: []string{"ROOT"}, want: "ROOT", ok: true},
+		{repo: "app"},
+	}
+	for _, test := range tests {
+		got, ok := creationTemplatePrefix(test.repo, test.prefixes)
+		if got != test.want || ok != test.ok {
+			t.Errorf("creationTemplatePrefix(%q, %q) = %q, %v, want %q, %v", test.repo, test.prefixes, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// fakeECR denies the actions in denied, keyed by action and repository,
+// and fails requests on repositories that don't exist like ECR does. The
+// repositories are in the registry registryID, if set.
+type fakeECR struct {
+	denied     map[string]bool
+	missing    map[string]bool
+	registryID string
+}
+
+func (f fakeECR) result(action string, registryID, repo *string) error {
+	name := ""
+	if repo != nil {
+		name = *repo
+	}
+	if f.registryID != "" && aws.ToString(registryID) != f.registryID {
+		return &smithy.GenericAPIError{Code: "RepositoryNotFoundException"}
+	}
+	if f.denied[action+" "+name] {
+		return &smithy.GenericAPIError{Code: accessDeniedCode}
+	}
+	if f.missing[name] {
+		return &smithy.GenericAPIError{Code: "RepositoryNotFoundException"}
+	}
+	return &smithy.GenericAPIError{Code: "InvalidParameterException"}
+}
+
+func (f fakeECR) GetAuthorizationToken(context.Context, *ecr.GetAuthorizationTokenInput, ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error) {
+	if f.denied["ecr:GetAuthorizationToken "] {
+		return nil, &smithy.GenericAPIError{Code: accessDeniedCode}
+	}
+	return &ecr.GetAuthorizationTokenOutput{}, nil
+}
+
+func (f fakeECR) BatchCheckLayerAvailability(_ context.Context, in *ecr.BatchCheckLayerAvailabilityInput, _ ...func(*ecr.Options)) (*ecr.BatchCheckLayerAvailabilityOutput, error) {
+	return nil, f.result("ecr:BatchCheckLayerAvailability", in.RegistryId, in.RepositoryName)
+}
+
+func (f fakeECR) CreateRepository(_ context.Context, in *ecr.CreateRepositoryInput, _ ...func(*ecr.Options)) (*ecr.CreateRepositoryOutput, error) {
+	name := aws.ToString(in.RepositoryName)
+	if !strings.HasSuffix(name, probeRepoSuffix) {
+		return &ecr.CreateRepositoryOutput{}, nil
+	}
+	return nil, f.result("ecr:CreateRepository", in.RegistryId, aws.String(strings.TrimSuffix(name, probeRepoSuffix)))
+}
+
+func (f fakeECR) UploadLayerPart(_ context.Context, in *ecr.UploadLayerPartInput, _ ...func(*ecr.Options)) (*ecr.UploadLayerPartOutput, error) {
+	return nil, f.result("ecr:UploadLayerPart", in.RegistryId, in.RepositoryName)
+}
+
+func (f fakeECR) CompleteLayerUpload(_ context.Context, in *ecr.CompleteLayerUploadInput, _ ...func(*ecr.Options)) (*ecr.CompleteLayerUploadOutput, error) {
+	return nil, f.result("ecr:CompleteLayerUpload", in.RegistryId, in.RepositoryName)
+}
+
+func (f fakeECR) PutImage(_ context.Context, in *ecr.PutImageInput, _ ...func(*ecr.Options)) (*ecr.PutImageOutput, error) {
+	return nil, f.result("ecr:PutImage", in.RegistryId, in.RepositoryName)
+}
+
+func (f fakeECR) BatchGetImage(_ context.Context, in *ecr.BatchGetImageInput, _ ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error) {
+	return nil, f.result("ecr:BatchGetImage", in.RegistryId, in.RepositoryName)
+}
+
+func (f fakeECR) GetDownloadUrlForLayer(_ context.Context, in *ecr.GetDownloadUrlForLayerInput, _ ...func(*ecr.Options)) (*ecr.GetDownloadUrlForLayerOutput, error) {
+	return nil, f.result("ecr:GetDownloadUrlForLayer", in.RegistryId, in.RepositoryName)
+}
+
+func TestMissingPermissions(t *testing.T) {
+	api := fakeECR{
+		denied: map[string]bool{
+			"ecr:PutImage app":                     true,
+			"ecr:BatchGetImage app":                true, // Not needed to push
+			"ecr:GetDownloadUrlForLayer app/cache": true,
+		},
+		missing: map[string]bool{"other": true},
+	}
+	got, err := missingPermissions(context.Background(), api, "", []string{"app", "other"}, []string{"app/cache"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{"ecr:PutImage on app", "ecr:GetDownloadUrlForLayer on app/cache"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("missingPermissions() = %v, want %v", got, want)
+	}
+
+	api = fakeECR{denied: map[string]bool{"ecr:GetAuthorizationToken ": true}}
+	got, err = missingPermissions(context.Background(), api, "", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"ecr:GetAuthorizationToken"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missingPermissions() = %v, want %v", got, want)
+	}
+
+	// The repositories of another account are only found with its registry ID
+	api = fakeECR{denied: map[string]bool{"ecr:PutImage app": true}, registryID: "123456789012"}
+	got, err = missingPermissions(context.Background(), api, "", []string{"app"}, nil, nil)
+	if err != nil || len(got) != 0 {
+		t.Errorf("missingPermissions() without registry ID = %v, %v", got, err)
+	}
+	got, err = missingPermissions(context.Background(), api, "123456789012", []string{"app"}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"ecr:PutImage on app"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missingPermissions() with registry ID = %v, want %v", got, want)
+	}
+
+	// Repositories to be created are probed before they exist
+	api = fakeECR{denied: map[string]bool{"ecr:CreateRepository app/cache": true}, missing: map[string]bool{"app": true, "app/cache": true}}
+	got, err = missingPermissions(context.Background(), api, "", []string{"app"}, []string{"app/cache"}, []string{"app", "app/cache"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"ecr:CreateRepository on app/cache"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missingPermissions() of created repositories = %v, want %v", got, want)
+	}
+	api = fakeECR{denied: map[string]bool{"ecr:CreateRepository app": true}, missing: map[string]bool{"app": true}, registryID: "123456789012"}
+	got, err = missingPermissions(context.Background(), api, "123456789012", nil, nil, []string{"app"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"ecr:CreateRepository on app"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missingPermissions() of created repositories with registry ID = %v, want %v", got, want)
+	}
+}
+
+func TestRegistryAccount(t *testing.T) {
+	tests := []struct {
+		id, registry, want string
+	}{
+		{registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com", want: "123456789012"},
+		{id: "210987654321", registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com", want: "210987654321"},
+		{registry: "public.ecr.aws"},
+	}
+	for _, test := range tests {
+		if got := registryAccount(test.id, test.registry); got != test.want {
+			t.Errorf("registryAccount(%q, %q) = %q, want %q", test.id, test.registry, got, test.want)
+		}
+	}
+}
+
+type fakeSSM struct {
+	input *ssm.PutParameterInput
+}
+
+func (f *fakeSSM) PutParameter(_ context.Context, in *ssm.PutParameterInput, _ ...func(*ssm.Options)) (*ssm.PutParameterOutput, error) {
+	f.input = in
+	return &ssm.PutParameterOutput{}, nil
+}
+
+func TestPutParameter(t *testing.T) {
+	api := &fakeSSM{}
+	image := patch.Image{Repo: "123456789012.dkr.ecr.us-east-1.amazonaws.com/app", Tag: "1.0.0", Digest: "sha256:abc"}
+	if err := putParameter(context.Background(), api, "/app/prod/image", image.Expand(patch.DefaultValue)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.ToString(api.input.Value), "123456789012.dkr.ecr.us-east-1.amazonaws.com/app@sha256:abc"; got != want {
+		t.Errorf("value = %s, want %s", got, want)
+	}
+	if aws.ToString(api.input.Name) != "/app/prod/image" || !aws.ToBool(api.input.Overwrite) || api.input.Type != ssmtypes.ParameterTypeString {
+		t.Errorf("unexpected input %+v", api.input)
+	}
+}
+
+type fakeSTS struct {
+	input *sts.AssumeRoleInput
+}
+
+func (f *fakeSTS) AssumeRole(_ context.Context, in *sts.AssumeRoleInput, _ ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.input = in
+	if aws.ToString(in.ExternalId) != "secret" {
+		return nil, &smithy.GenericAPIError{Code: "AccessDenied"}
+	}
+	return &sts.AssumeRoleOutput{Credentials: &ststypes.Credentials{
+		AccessKeyId:     aws.String("ASIATEMP"),
+		SecretAccessKey: aws.String("temp-secret"),
+		SessionToken:    aws.String("temp-token"),
+	}}, nil
+}
+
+func (f *fakeSTS) AssumeRoleWithWebIdentity(_ context.Context, in *sts.AssumeRoleWithWebIdentityInput, _ ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	if aws.ToString(in.WebIdentityToken) != "oidc-token" {
+		return nil, &smithy.GenericAPIError{Code: "InvalidIdentityToken"}
+	}
+	return &sts.AssumeRoleWithWebIdentityOutput{Credentials: &ststypes.Credentials{
+		AccessKeyId:     aws.String("ASIAWEB"),
+		SecretAccessKey: aws.String("web-secret"),
+		SessionToken:    aws.String("web-token"),
+	}}, nil
+}
+
+func TestAssumeRoleWithWebIdentity(t *testing.T) {
+	for _, name := range []string{accessKeyEnv, secretKeyEnv, sessionTokenEnv} {
+		t.Setenv(name, "")
+	}
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("oidc-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	const role = "arn:aws:iam::123456789012:role/push"
+	if err := assumeRoleWithWebIdentity(context.Background(), &fakeSTS{}, role, tokenFile+".missing", "drone-kaniko"); err == nil {
+		t.Error("expected error for a missing token file")
+	}
+	if err := assumeRoleWithWebIdentity(context.Background(), &fakeSTS{}, role, tokenFile, "drone-kaniko"); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{accessKeyEnv: "ASIAWEB", secretKeyEnv: "web-secret", sessionTokenEnv: "web-token"} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	for _, name := range []string{accessKeyEnv, secretKeyEnv, sessionTokenEnv} {
+		t.Setenv(name, "")
+	}
+	api := &fakeSTS{}
+	const role = "arn:aws:iam::123456789012:role/push"
+	if err := assumeRole(context.Background(), api, role, "wrong", roleSessionName("")); err == nil {
+		t.Error("expected error assuming role with the wrong external id")
+	}
+	if err := assumeRole(context.Background(), api, role, "secret", roleSessionName("42")); err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(api.input.RoleArn) != role || aws.ToString(api.input.RoleSessionName) != "drone-kaniko-42" {
+		t.Errorf("unexpected input %+v", api.input)
+	}
+	for name, want := range map[string]string{accessKeyEnv: "ASIATEMP", secretKeyEnv: "temp-secret", sessionTokenEnv: "temp-token"} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestAssumeRole_credentialsFile(t *testing.T) {
+	for _, name := range []string{accessKeyEnv, secretKeyEnv, sessionTokenEnv, profileEnv, credentialsFileEnv, configFileEnv} {
+		t.Setenv(name, "")
+	}
+	t.Setenv(accessKeyEnv, "AKIASOURCE")
+	file := filepath.Join(t.TempDir(), "aws", "credentials")
+	defer func(file string) { credentialsFile = file }(credentialsFile)
+	credentialsFile = file
+	if err := assumeRole(context.Background(), &fakeSTS{}, "arn:aws:iam::123456789012:role/push", "secret", roleSessionName("")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := os.LookupEnv(accessKeyEnv); ok {
+		t.Errorf("%s is still set", accessKeyEnv)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("credentials file %v, %v", info, err)
+	}
+	cfg, err := loadAWSConfig(context.Background(), "us-east-1", endpointVariant{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := cfg.Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIATEMP" || creds.SecretAccessKey != "temp-secret" || creds.SessionToken != "temp-token" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+}
+
+func TestSetupProfile(t *testing.T) {
+	for _, name := range []string{profileEnv, configFileEnv, credentialsFileEnv} {
+		t.Setenv(name, "")
+	}
+	dir := t.TempDir()
+	if err := setupProfile("sso-dev", dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		profileEnv:         "sso-dev",
+		configFileEnv:      filepath.Join(dir, "config"),
+		credentialsFileEnv: filepath.Join(dir, "credentials"),
+	} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+	if err := setupProfile("", filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for a missing config directory")
+	}
+}
+
+func TestSetupEndpoint(t *testing.T) {
+	for _, name := range []string{ecrEndpointEnv, stsEndpointEnv} {
+		t.Setenv(name, "")
+	}
+	t.Cleanup(func() { endpointURL = "" })
+	for _, endpoint := range []string{"localhost:4566", "ftp://localhost:4566", "http://"} {
+		if err := setupEndpoint(endpoint); err == nil {
+			t.Errorf("expected error for endpoint %s", endpoint)
+		}
+	}
+	const endpoint = "http://localhost:4566"
+	if err := setupEndpoint(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{ecrEndpointEnv, stsEndpointEnv} {
+		if got := os.Getenv(name); got != endpoint {
+			t.Errorf("%s = %s, want %s", name, got, endpoint)
+		}
+	}
+
+	cfg, err := loadAWSConfig(context.Background(), "eu-west-1", endpointVariant{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, service := range []string{ecr.ServiceID, sts.ServiceID} {
+		e, err := cfg.EndpointResolver.ResolveEndpoint(service, "eu-west-1")
+		if err != nil || e.URL != endpoint || e.SigningRegion != "eu-west-1" {
+			t.Errorf("endpoint of %s = %+v, %v", service, e, err)
+		}
+	}
+	var notFound *aws.EndpointNotFoundError
+	if _, err := cfg.EndpointResolver.ResolveEndpoint(s3.ServiceID, "eu-west-1"); !errors.As(err, &notFound) {
+		t.Errorf("expected S3 endpoint to resolve as usual, got %v", err)
+	}
+}
+
+func TestEndpointVariant(t *testing.T) {
+	t.Cleanup(func() { endpointURL = "" })
+	endpointURL = "http://localhost:4566"
+	if _, err := newEndpointVariant(true, false); err == nil {
+		t.Error("expected error combining fips-endpoint with aws-endpoint-url")
+	}
+	endpointURL = ""
+
+	variant, err := newEndpointVariant(true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadAWSConfig(context.Background(), "us-gov-west-1", variant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := ecr.NewFromConfig(cfg).Options().EndpointOptions
+	if options.UseFIPSEndpoint != aws.FIPSEndpointStateEnabled || options.UseDualStackEndpoint != aws.DualStackEndpointStateEnabled {
+		t.Errorf("unexpected endpoint options %+v", options)
+	}
+}
+
+func TestRepositorySettings(t *testing.T) {
+	const registry = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+	tests := []struct {
+		settings repositorySettings
+		registry string
+		valid    bool
+	}{
+		{registry: registry, valid: true},
+		{settings: repositorySettings{ScanOnPush: true, TagMutability: "IMMUTABLE"}, registry: registry, valid: true},
+		{settings: repositorySettings{TagMutability: "FROZEN"}, registry: registry},
+		{registry: "public.ecr.aws", valid: true},
+		{settings: repositorySettings{ScanOnPush: true}, registry: "public.ecr.aws"},
+		{settings: repositorySettings{Tags: []types.Tag{{Key: aws.String("team"), Value: aws.String("platform")}}}, registry: "public.ecr.aws", valid: true},
+		{settings: repositorySettings{KMSKey: "arn:aws:kms:us-east-1:123456789012:key/app"}, registry: registry, valid: true},
+		{settings: repositorySettings{Encryption: "AES256", KMSKey: "arn:aws:kms:us-east-1:123456789012:key/app"}, registry: registry},
+		{settings: repositorySettings{Encryption: "DES"}, registry: registry},
+	}
+	for _, test := range tests {
+		if err := test.settings.validate(test.registry); (err == nil) != test.valid {
+			t.Errorf("%+v.validate(%s) = %v, want valid %v", test.settings, test.registry, err, test.valid)
+		}
+	}
+
+	in := createRepositoryInput("app", repositorySettings{ScanOnPush: true, TagMutability: "IMMUTABLE"})
+	if aws.ToString(in.RepositoryName) != "app" || in.ImageTagMutability != types.ImageTagMutabilityImmutable ||
+		in.ImageScanningConfiguration == nil || !in.ImageScanningConfiguration.ScanOnPush {
+		t.Errorf("unexpected input %+v", in)
+	}
+	in = createRepositoryInput("app", repositorySettings{})
+	if in.ImageTagMutability != "" || in.ImageScanningConfiguration != nil || in.EncryptionConfiguration != nil {
+		t.Errorf("expected the default settings, got %+v", in)
+	}
+	tags, err := parseRepositoryTags([]string{"team=platform", "cost-center="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in = createRepositoryInput("app", repositorySettings{Tags: tags})
+	if len(in.Tags) != 2 || aws.ToString(in.Tags[0].Key) != "team" || aws.ToString(in.Tags[0].Value) != "platform" || aws.ToString(in.Tags[1].Value) != "" {
+		t.Errorf("unexpected tags %+v", in.Tags)
+	}
+	if _, err := parseRepositoryTags([]string{"team"}); err == nil {
+		t.Error("expected error for a tag without value")
+	}
+	in = createRepositoryInput("app", repositorySettings{KMSKey: "arn:aws:kms:us-east-1:123456789012:key/app"})
+	if e := in.EncryptionConfiguration; e == nil || e.EncryptionType != types.EncryptionTypeKms || aws.ToString(e.KmsKey) != "arn:aws:kms:us-east-1:123456789012:key/app" {
+		t.Errorf("unexpected encryption configuration %+v", e)
+	}
+}
+
+func TestRepositoryCatalogData(t *testing.T) {
+	catalog, err := repositoryCatalogData("", "", "", nil, nil, "")
+	if err != nil || catalog != nil {
+		t.Errorf("repositoryCatalogData() without data = %+v, %v, want nil", catalog, err)
+	}
+
+	logo := filepath.Join(t.TempDir(), "logo.png")
+	if err := ioutil.WriteFile(logo, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err = repositoryCatalogData("An app", "# App", "", []string{"x86-64"}, []string{"Linux"}, logo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(catalog.Description) != "An app" || aws.ToString(catalog.AboutText) != "# App" || catalog.UsageText != nil ||
+		!reflect.DeepEqual(catalog.Architectures, []string{"x86-64"}) || !reflect.DeepEqual(catalog.OperatingSystems, []string{"Linux"}) ||
+		string(catalog.LogoImageBlob) != "png" {
+		t.Errorf("unexpected catalog data %+v", catalog)
+	}
+
+	if _, err := repositoryCatalogData("", "", "", nil, nil, logo+".missing"); err == nil {
+		t.Error("expected error for a missing logo")
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	const policy = `{"rules": []}`
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := ioutil.WriteFile(path, []byte(policy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path, text, want string
+		valid            bool
+	}{
+		{valid: true},
+		{path: path, want: policy, valid: true},
+		{text: policy, want: policy, valid: true},
+		{text: base64.StdEncoding.EncodeToString([]byte(policy)), want: policy, valid: true},
+		{path: path, text: policy},
+		{text: "not a policy"},
+		{text: base64.StdEncoding.EncodeToString([]byte("rules"))},
+		{path: path + ".missing"},
+	}
+	for _, test := range tests {
+		got, err := readPolicy(test.path, test.text)
+		if (err == nil) != test.valid || got != test.want {
+			t.Errorf("readPolicy(%q, %q) = %q, %v, want %q", test.path, test.text, got, err, test.want)
+		}
+	}
+}
+
+// fakeScan returns the scan findings outputs in order, not found before
+// the first one.
+type fakeScan struct {
+	outputs  []*ecr.DescribeImageScanFindingsOutput
+	notFound int
+	calls    int
+}
+
+func (f *fakeScan) DescribeImageScanFindings(context.Context, *ecr.DescribeImageScanFindingsInput, ...func(*ecr.Options)) (*ecr.DescribeImageScanFindingsOutput, error) {
+	f.calls++
+	if f.calls <= f.notFound {
+		return nil, &smithy.GenericAPIError{Code: "ScanNotFoundException"}
+	}
+	out := f.outputs[0]
+	if len(f.outputs) > 1 {
+		f.outputs = f.outputs[1:]
+	}
+	return out, nil
+}
+
+func TestWaitForScan(t *testing.T) {
+	defer func(d time.Duration) { scanPollDelay = d }(scanPollDelay)
+	scanPollDelay = time.Millisecond
+
+	const digest = "sha256:abc"
+	inProgress := &ecr.DescribeImageScanFindingsOutput{ImageScanStatus: &types.ImageScanStatus{Status: types.ScanStatusInProgress}}
+	complete := &ecr.DescribeImageScanFindingsOutput{
+		ImageScanStatus:   &types.ImageScanStatus{Status: types.ScanStatusComplete},
+		ImageScanFindings: &types.ImageScanFindings{FindingSeverityCounts: map[string]int32{"HIGH": 2, "LOW": 5}},
+	}
+
+	api := &fakeScan{outputs: []*ecr.DescribeImageScanFindingsOutput{inProgress, complete}, notFound: 1}
+	if err := waitForScan(context.Background(), api, "", "app", digest, types.FindingSeverityCritical); err != nil {
+		t.Errorf("unexpected error below the threshold: %s", err)
+	}
+	if api.calls != 3 {
+		t.Errorf("expected 3 polls, got %d", api.calls)
+	}
+
+	api = &fakeScan{outputs: []*ecr.DescribeImageScanFindingsOutput{complete}}
+	err := waitForScan(context.Background(), api, "", "app", digest, types.FindingSeverityMedium)
+	if err == nil || !strings.Contains(err.Error(), "2 HIGH") || strings.Contains(err.Error(), "LOW") {
+		t.Errorf("expected error listing the high findings, got %v", err)
+	}
+
+	failed := &ecr.DescribeImageScanFindingsOutput{ImageScanStatus: &types.ImageScanStatus{Status: types.ScanStatusFailed}}
+	if err := waitForScan(context.Background(), &fakeScan{outputs: []*ecr.DescribeImageScanFindingsOutput{failed}}, "", "app", digest, types.FindingSeverityHigh); err == nil {
+		t.Error("expected error for a failed scan")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := waitForScan(ctx, &fakeScan{outputs: []*ecr.DescribeImageScanFindingsOutput{inProgress}}, "", "app", digest, types.FindingSeverityHigh); err == nil {
+		t.Error("expected timeout waiting for the scan")
+	}
+}
+
+func TestSetupRegions(t *testing.T) {
+	dockerConfig := docker.NewConfig()
+	got, err := setupRegions(dockerConfig, "123456789012.dkr.ecr.us-east-1.amazonaws.com", []string{"eu-west-1", "us-west-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"123456789012.dkr.ecr.eu-west-1.amazonaws.com", "123456789012.dkr.ecr.us-west-2.amazonaws.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("setupRegions() = %v, want %v", got, want)
+	}
+	for _, host := range want {
+		if dockerConfig.CredHelpers[host] != "ecr-login" {
+			t.Errorf("expected ecr-login credential helper for %s, got %v", host, dockerConfig.CredHelpers)
+		}
+	}
+	if _, err := setupRegions(dockerConfig, "public.ecr.aws", []string{"eu-west-1"}); err == nil {
+		t.Error("expected error for ECR Public")
+	}
+}
+
+func TestCopyToRegions(t *testing.T) {
+	src, dst := registrytest.New(t), registrytest.New(t)
+	digest := src.PushImage("app", "latest", []byte(`{"architecture":"amd64"}`), []byte("layer"))
+	digestFile := filepath.Join(t.TempDir(), "digest")
+	if err := ioutil.WriteFile(digestFile, []byte(digest+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := registry.NewClient(registry.KeychainFunc(func(string) (registry.Credential, error) {
+		return registry.Credential{Username: registrytest.Username, Password: registrytest.Password}, nil
+	}), true)
+	if err := copyToRegions(context.Background(), client, digestFile, src.Host()+"/app", []string{dst.Host()}, []string{"latest", "1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"latest", "1.0"} {
+		if _, content, ok := dst.Manifest("app", tag); !ok || registry.Digest(content) != digest {
+			t.Errorf("expected app:%s to reference %s", tag, digest)
+		}
+	}
+	if _, ok := dst.Blob("app", registrytest.Digest([]byte("layer"))); !ok {
+		t.Error("expected the layer to be copied")
+	}
+
+	// Nothing is copied without a pushed image
+	if err := copyToRegions(context.Background(), client, digestFile+".missing", src.Host()+"/app", []string{dst.Host()}, []string{"latest"}); err != nil {
+		t.Errorf("unexpected error without a pushed image: %s", err)
+	}
+}
+
+func TestParsePullThroughCacheRules(t *testing.T) {
+	got, err := parsePullThroughCacheRules([]string{"ecr-public=public.ecr.aws", "docker-hub=registry-1.docker.io,arn:aws:secretsmanager:us-east-1:123456789012:secret:ecr-pullthroughcache/docker-hub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pullThroughCacheRule{
+		{Prefix: "ecr-public", Upstream: "public.ecr.aws"},
+		{Prefix: "docker-hub", Upstream: "registry-1.docker.io", Credential: "arn:aws:secretsmanager:us-east-1:123456789012:secret:ecr-pullthroughcache/docker-hub"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePullThroughCacheRules() = %+v, want %+v", got, want)
+	}
+	for _, rule := range []string{"public.ecr.aws", "Quay=quay.io", "quay="} {
+		if _, err := parsePullThroughCacheRules([]string{rule}); err == nil {
+			t.Errorf("expected error for rule %s", rule)
+		}
+	}
+}
+
+func TestCreatePullThroughCacheRules(t *testing.T) {
+	var requests []map[string]string
+	throttled := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "AmazonEC2ContainerRegistry_V20150921.CreatePullThroughCacheRule" || r.Header.Get("Authorization") == "" {
+			t.Errorf("unexpected request headers %v", r.Header)
+		}
+		var in map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Error(err)
+		}
+		requests = append(requests, in)
+		switch in["ecrRepositoryPrefix"] {
+		case "quay":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"com.amazonaws.ecr#PullThroughCacheRuleAlreadyExistsException","message":"exists"}`)
+		case "denied":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"AccessDeniedException","Message":"denied"}`)
+		case "throttled":
+			if !throttled {
+				throttled = true
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type":"ThrottlingException","message":"rate exceeded"}`)
+				return
+			}
+			fmt.Fprint(w, `{}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer server.Close()
+
+	cfg := aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+		EndpointResolver: endpointResolver(server.URL),
+		Retryer:          func() aws.Retryer { return newRetryer(2, time.Millisecond) },
+	}
+	rules := []pullThroughCacheRule{{Prefix: "ecr-public", Upstream: "public.ecr.aws"}, {Prefix: "quay", Upstream: "quay.io"}}
+	if err := createPullThroughCacheRules(context.Background(), cfg, "123456789012", rules); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]string{
+		{"ecrRepositoryPrefix": "ecr-public", "upstreamRegistryUrl": "public.ecr.aws", "registryId": "123456789012"},
+		{"ecrRepositoryPrefix": "quay", "upstreamRegistryUrl": "quay.io", "registryId": "123456789012"},
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+
+	err := createPullThroughCacheRules(context.Background(), cfg, "", []pullThroughCacheRule{{Prefix: "denied", Upstream: "quay.io"}})
+	if err == nil || !strings.Contains(err.Error(), "ecr:CreatePullThroughCacheRule") {
+		t.Errorf("expected missing permission error, got %v", err)
+	}
+
+	requests = nil
+	if err := createPullThroughCacheRules(context.Background(), cfg, "", []pullThroughCacheRule{{Prefix: "throttled", Upstream: "quay.io"}}); err != nil {
+		t.Errorf("expected throttled request to be retried, got %v", err)
+	}
+	if len(requests) != 2 {
+		t.Errorf("expected 2 attempts, got %d", len(requests))
+	}
+}
+
+func TestNewRetryer(t *testing.T) {
+	if got := newRetryer(0, 0).MaxAttempts(); got != 3 {
+		t.Errorf("default max attempts = %d, want 3", got)
+	}
+	r := newRetryer(8, 100*time.Millisecond)
+	if got := r.MaxAttempts(); got != 8 {
+		t.Errorf("max attempts = %d, want 8", got)
+	}
+	if d, err := r.RetryDelay(3, fmt.Errorf("throttled")); err != nil || d < 200*time.Millisecond || d > 400*time.Millisecond {
+		t.Errorf("delay of the third retry = %s, %v, want between 200ms and 400ms", d, err)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{attempt: 1, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 2, min: time.Second, max: 2 * time.Second},
+		{attempt: 4, min: 4 * time.Second, max: 8 * time.Second},
+		{attempt: 10, min: 10 * time.Second, max: 20 * time.Second},
+		{attempt: 100, min: 10 * time.Second, max: 20 * time.Second},
+	}
+	for _, test := range tests {
+		if d := backoffDelay(time.Second, 20*time.Second, test.attempt); d < test.min || d > test.max {
+			t.Errorf("backoffDelay() of attempt %d = %s, want between %s and %s", test.attempt, d, test.min, test.max)
+		}
+	}
+}
+
+// fakeS3 stores a single object, whose ETag changes on every write.
+type fakeS3 struct {
+	content []byte
+	etag    string
+}
+
+func (f *fakeS3) GetObject(_ context.Context, _ *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if f.etag == "" {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchKey"}
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(f.content)), ETag: aws.String(f.etag)}, nil
+}
+
+func (f *fakeS3) HeadObject(_ context.Context, _ *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if f.etag == "" {
+		return nil, &smithy.GenericAPIError{Code: "NotFound"}
+	}
+	return &s3.HeadObjectOutput{ETag: aws.String(f.etag)}, nil
+}
+
+func (f *fakeS3) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.content, _ = ioutil.ReadAll(in.Body)
+	f.etag += "x"
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestS3Object(t *testing.T) {
+	api := &fakeS3{}
+	store := ledger.ObjectStore{Object: &s3Object{api: api, bucket: "builds", key: "ledger.jsonl"}}
+	for _, line := range []string{"first\n", "second\n"} {
+		if err := store.Append(context.Background(), []byte(line)); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	if got, want := string(api.content), "first\nsecond\n"; got != want {
+		t.Errorf("ledger = %q, want %q", got, want)
+	}
+
+	o := &s3Object{api: api, bucket: "builds", key: "ledger.jsonl"}
+	if err := o.Write(context.Background(), nil, "stale"); !errors.Is(err, ledger.ErrConflict) {
+		t.Errorf("Write() of stale version error = %v", err)
+	}
+
+	if bucket, key, err := parseS3URL("s3://builds/app/ledger.jsonl"); err != nil || bucket != "builds" || key != "app/ledger.jsonl" {
+		t.Errorf("parseS3URL() = %s, %s, %v", bucket, key, err)
+	}
+	if _, _, err := parseS3URL("s3://builds"); err == nil {
+		t.Error("parseS3URL() without key error = nil")
+	}
+}
+
+func TestS3Publisher(t *testing.T) {
+	api := &fakeS3{}
+	p := &s3Publisher{api: api, bucket: "deploy", key: "app/image.json"}
+	if err := p.Publish(context.Background(), []byte(`{"kind":"docker/v1"}`), artifact.JSON); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(api.content), `{"kind":"docker/v1"}`; got != want {
+		t.Errorf("artifact = %q, want %q", got, want)
+	}
+	if got, want := p.String(), "s3://deploy/app/image.json"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+// fakeImageECR holds the manifests of an ECR registry by repository and
+// reference, and the layers missing in repositories.
+type fakeImageECR struct {
+	images  map[string]types.Image
+	missing map[string]bool
+}
+
+func (f *fakeImageECR) BatchGetImage(_ context.Context, in *ecr.BatchGetImageInput, _ ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error) {
+	ref := aws.ToString(in.ImageIds[0].ImageTag) + aws.ToString(in.ImageIds[0].ImageDigest)
+	image, ok := f.images[aws.ToString(in.RepositoryName)+":"+ref]
+	if !ok {
+		return &ecr.BatchGetImageOutput{Failures: []types.ImageFailure{{FailureCode: types.ImageFailureCodeImageNotFound}}}, nil
+	}
+	return &ecr.BatchGetImageOutput{Images: []types.Image{image}}, nil
+}
+
+func (f *fakeImageECR) PutImage(_ context.Context, in *ecr.PutImageInput, _ ...func(*ecr.Options)) (*ecr.PutImageOutput, error) {
+	repo := aws.ToString(in.RepositoryName)
+	if f.missing[repo] {
+		return nil, &smithy.GenericAPIError{Code: "LayersNotFoundException"}
+	}
+	key := repo + ":" + aws.ToString(in.ImageTag)
+	if existing, ok := f.images[key]; ok && aws.ToString(existing.ImageManifest) == aws.ToString(in.ImageManifest) {
+		return nil, &smithy.GenericAPIError{Code: "ImageAlreadyExistsException"}
+	}
+	f.images[key] = types.Image{ImageManifest: in.ImageManifest, ImageManifestMediaType: in.ImageManifestMediaType}
+	return &ecr.PutImageOutput{}, nil
+}
+
+func TestECRCopier(t *testing.T) {
+	const host = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+	api := &fakeImageECR{
+		images: map[string]types.Image{
+			"staging/app:sha256:abc": {ImageManifest: aws.String("{}"), ImageManifestMediaType: aws.String(registry.MediaTypeOCIManifest)},
+		},
+		missing: map[string]bool{"other": true},
+	}
+	c := &ecrCopier{api: api, region: "us-east-1"}
+	src := registry.Repository{Registry: host, Name: "staging/app"}
+	prod := registry.Repository{Registry: host, Name: "prod/app"}
+
+	for i := 0; i < 2; i++ {
+		copied, err := c.Copy(context.Background(), src, "sha256:abc", prod, "1.0.0")
+		if err != nil || !copied {
+			t.Fatalf("Copy() = %v, %v, want true", copied, err)
+		}
+	}
+	if image := api.images["prod/app:1.0.0"]; aws.ToString(image.ImageManifestMediaType) != registry.MediaTypeOCIManifest {
+		t.Errorf("unexpected image %+v", image)
+	}
+
+	// Not copied, so that the registry API copy is used
+	for _, test := range []struct {
+		src, dst registry.Repository
+		ref      string
+	}{
+		{src, registry.Repository{Registry: host, Name: "other"}, "sha256:abc"},
+		{src, prod, "sha256:def"},
+		{src, registry.Repository{Registry: "123456789012.dkr.ecr.eu-west-1.amazonaws.com", Name: "prod/app"}, "sha256:abc"},
+		{registry.Repository{Registry: "docker.io", Name: "library/app"}, prod, "sha256:abc"},
+	} {
+		copied, err := c.Copy(context.Background(), test.src, test.ref, test.dst, "1.0.0")
+		if err != nil || copied {
+			t.Errorf("Copy(%s@%s, %s) = %v, %v, want false", test.src, test.ref, test.dst, copied, err)
+		}
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	defer func(d time.Duration) { policyRetryDelay = d }(policyRetryDelay)
+	policyRetryDelay = time.Millisecond
+
+	tests := []struct {
+		mode     string
+		failures int
+		calls    int
+		wantErr  bool
+	}{
+		{mode: policyFailureFail, failures: 0, calls: 1},
+		{mode: policyFailureFail, failures: 1, calls: 1, wantErr: true},
+		{mode: policyFailureWarn, failures: 5, calls: 1},
+		{mode: policyFailureRetry, failures: 2, calls: 3},
+		{mode: policyFailureRetry, failures: 5, calls: policyAttempts, wantErr: true},
+	}
+	for _, test := range tests {
+		calls := 0
+		err := applyPolicy(test.mode, func() error {
+			calls++
+			if calls <= test.failures {
+				return &smithy.GenericAPIError{Code: accessDeniedCode}
+			}
+			return nil
+		})
+		if (err != nil) != test.wantErr || calls != test.calls {
+			t.Errorf("applyPolicy(%s) with %d failures = %v after %d calls, want error %v after %d calls", test.mode, test.failures, err, calls, test.wantErr, test.calls)
+		}
+	}
+
+	if err := validatePolicyFailureMode("ignore"); err == nil {
+		t.Error("expected error for invalid policy failure mode")
+	}
 }
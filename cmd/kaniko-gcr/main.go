@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
@@ -12,14 +16,18 @@ import (
 
 	kaniko "github.com/gexops/drone-kaniko"
 	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/command"
+	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/gexops/drone-kaniko/pkg/gcp"
+	"github.com/gexops/drone-kaniko/pkg/ledger"
+	"github.com/gexops/drone-kaniko/pkg/patch"
+	"github.com/gexops/drone-kaniko/pkg/registry"
 )
 
 const (
 	// GCR JSON key file path
 	gcrKeyPath     string = "/kaniko/config.json"
 	gcrEnvVariable string = "GOOGLE_APPLICATION_CREDENTIALS"
-
-	defaultDigestFile string = "/kaniko/digest-file"
 )
 
 var (
@@ -27,6 +35,9 @@ var (
 )
 
 func main() {
+	// The plugin also serves as wrapping credential helper
+	docker.RunCredHelperWrapper()
+
 	// Load env-file if it exists first
 	if env := os.Getenv("PLUGIN_ENV_FILE"); env != "" {
 		if err := godotenv.Load(env); err != nil {
@@ -37,73 +48,21 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "kaniko gcr plugin"
 	app.Usage = "kaniko gcr plugin"
-	app.Action = run
+	app.Action = func(c *cli.Context) error {
+		defer docker.RemoveCredentials(gcrKeyPath)
+		ctx, stop := command.Context()
+		defer stop()
+		return kaniko.WriteResult(c.String("output"), func() error {
+			return kaniko.ReportError(c.String("error-file"), run(ctx, c))
+		})
+	}
 	app.Version = version
-	app.Flags = []cli.Flag{
-		cli.StringFlag{
-			Name:   "dockerfile",
-			Usage:  "build dockerfile",
-			Value:  "Dockerfile",
-			EnvVar: "PLUGIN_DOCKERFILE",
-		},
-		cli.StringFlag{
-			Name:   "context",
-			Usage:  "build context",
-			Value:  ".",
-			EnvVar: "PLUGIN_CONTEXT",
-		},
-		cli.StringFlag{
-			Name:   "drone-commit-ref",
-			Usage:  "git commit ref passed by Drone",
-			EnvVar: "DRONE_COMMIT_REF",
-		},
-		cli.StringFlag{
-			Name:   "drone-repo-branch",
-			Usage:  "git repository default branch passed by Drone",
-			EnvVar: "DRONE_REPO_BRANCH",
-		},
-		cli.StringSliceFlag{
-			Name:     "tags",
-			Usage:    "build tags",
-			Value:    &cli.StringSlice{"latest"},
-			EnvVar:   "PLUGIN_TAGS",
-			FilePath: ".tags",
-		},
-		cli.BoolFlag{
-			Name:   "expand-tag",
-			Usage:  "enable for semver tagging",
-			EnvVar: "PLUGIN_EXPAND_TAG",
-		},
-		cli.BoolFlag{
-			Name:   "auto-tag",
-			Usage:  "enable auto generation of build tags",
-			EnvVar: "PLUGIN_AUTO_TAG",
-		},
-		cli.StringFlag{
-			Name:   "auto-tag-suffix",
-			Usage:  "the suffix of auto build tags",
-			EnvVar: "PLUGIN_AUTO_TAG_SUFFIX",
-		},
-		cli.StringSliceFlag{
-			Name:   "args",
-			Usage:  "build args",
-			EnvVar: "PLUGIN_BUILD_ARGS",
-		},
-		cli.StringFlag{
-			Name:   "target",
-			Usage:  "build target",
-			EnvVar: "PLUGIN_TARGET",
-		},
+	app.Flags = append([]cli.Flag{
 		cli.StringFlag{
 			Name:   "repo",
 			Usage:  "gcr repository",
 			EnvVar: "PLUGIN_REPO",
 		},
-		cli.StringSliceFlag{
-			Name:   "custom-labels",
-			Usage:  "additional k=v labels",
-			EnvVar: "PLUGIN_CUSTOM_LABELS",
-		},
 		cli.StringFlag{
 			Name:   "registry",
 			Usage:  "gcr registry",
@@ -111,9 +70,9 @@ func main() {
 			EnvVar: "PLUGIN_REGISTRY",
 		},
 		cli.StringSliceFlag{
-			Name:   "registry-mirrors",
-			Usage:  "docker registry mirrors",
-			EnvVar: "PLUGIN_REGISTRY_MIRRORS",
+			Name:   "artifact-registry-mirrors",
+			Usage:  "Artifact Registry remote repositories Docker Hub base images are pulled through, e.g. us-docker.pkg.dev/project/dockerhub, authenticated with the plugin's Google credentials",
+			EnvVar: "PLUGIN_ARTIFACT_REGISTRY_MIRRORS",
 		},
 		cli.StringFlag{
 			Name:   "json-key",
@@ -121,74 +80,115 @@ func main() {
 			EnvVar: "PLUGIN_JSON_KEY",
 		},
 		cli.StringFlag{
-			Name:   "snapshot-mode",
-			Usage:  "Specify one of full, redo or time as snapshot mode",
-			EnvVar: "PLUGIN_SNAPSHOT_MODE",
+			Name:   "json-key-b64",
+			Usage:  "base64 encoded service account JSON key",
+			EnvVar: "PLUGIN_JSON_KEY_B64",
 		},
-		cli.BoolFlag{
-			Name:   "enable-cache",
-			Usage:  "Set this flag to opt into caching with kaniko",
-			EnvVar: "PLUGIN_ENABLE_CACHE",
+		cli.StringFlag{
+			Name:   "access-token",
+			Usage:  "OAuth2 access token, e.g. of an external token broker, used in place of the JSON key",
+			EnvVar: "PLUGIN_ACCESS_TOKEN",
 		},
 		cli.StringFlag{
-			Name:   "cache-dir",
-			Usage:  "Set this flag to specify a local directory cache for base images. enable-cache needs to be set to use this flag. Defaults to /cache.",
-			Value: 	"/cache",
-			EnvVar: "PLUGIN_CACHE_DIR",
+			Name:   "impersonate-service-account",
+			Usage:  "email of the service account to push as, with short-lived access tokens generated with the JSON key or workload identity",
+			EnvVar: "PLUGIN_IMPERSONATE_SERVICE_ACCOUNT",
 		},
 		cli.BoolFlag{
-			Name:   "cache-copy-layers",
-			Usage:  "Set this flag to cache copy layers. Defaults to false",
-			EnvVar: "PLUGIN_CACHE_COPY_LAYERS",
+			Name:   "create-repository",
+			Usage:  "Create the Artifact Registry repository of <location>-docker.pkg.dev/<project>/<repository>/<image> repos when missing",
+			EnvVar: "PLUGIN_CREATE_REPOSITORY",
 		},
-		cli.BoolFlag{
-			Name:   "cache-no-compress",
-			Usage:  "Set this to true in order to prevent tar compression for cached layers.",
-			EnvVar: "PLUGIN_CACHE_NO_COMPRESS",
+		cli.StringSliceFlag{
+			Name:   "repository-labels",
+			Usage:  "Labels of the Artifact Registry repository, as key=value, added to existing repositories",
+			EnvVar: "PLUGIN_REPOSITORY_LABELS",
+		},
+		cli.StringFlag{
+			Name:   "cleanup-policies",
+			Usage:  "Path to a JSON file with the cleanup policies of the Artifact Registry repository, keyed by policy id",
+			EnvVar: "PLUGIN_CLEANUP_POLICIES",
+		},
+		cli.StringFlag{
+			Name:   "snapshot-mode",
+			Usage:  "Specify one of full, redo or time as snapshot mode",
+			EnvVar: "PLUGIN_SNAPSHOT_MODE",
 		},
 		cli.StringFlag{
 			Name:   "cache-repo",
 			Usage:  "Remote repository that will be used to store cached layers. Cache repo should be present in specified registry. enable-cache needs to be set to use this flag",
 			EnvVar: "PLUGIN_CACHE_REPO",
 		},
-		cli.IntFlag{
-			Name:   "cache-ttl",
-			Usage:  "Cache timeout in hours. Defaults to two weeks.",
-			EnvVar: "PLUGIN_CACHE_TTL",
-		},
 		cli.StringFlag{
-			Name:   "artifact-file",
-			Usage:  "Artifact file location that will be generated by the plugin. This file will include information of docker images that are uploaded by the plugin.",
-			EnvVar: "PLUGIN_ARTIFACT_FILE",
+			Name:   "registry-auths",
+			Usage:  "JSON or YAML list of registry, username and password objects of additional registries, e.g. of base images",
+			EnvVar: "PLUGIN_REGISTRY_AUTHS",
 		},
 		cli.BoolFlag{
-			Name:   "no-push",
-			Usage:  "Set this flag if you only want to build the image, without pushing to a registry",
-			EnvVar: "PLUGIN_NO_PUSH",
+			Name:   "preflight-key",
+			Usage:  "Validate the JSON key, check that its project matches the repo and exchange it for an access token before starting the build",
+			EnvVar: "PLUGIN_PREFLIGHT_KEY",
+		},
+		cli.StringSliceFlag{
+			Name:   "artifact-publishers",
+			Usage:  "Destinations the artifact is additionally published to: files, http(s) URLs the artifact is POSTed to, gs://<bucket>/<object>",
+			EnvVar: "PLUGIN_ARTIFACT_PUBLISHERS",
 		},
 		cli.StringFlag{
-			Name:   "verbosity",
-			Usage:  "Set this flag as --verbosity=<panic|fatal|error|warn|info|debug|trace> to set the logging level for kaniko. Defaults to info.",
-			EnvVar: "PLUGIN_VERBOSITY",
+			Name:   "helper-proxy",
+			Usage:  "Proxy URL used by the registry credential helper only, e.g. for corporate proxies intercepting registry auth",
+			EnvVar: "PLUGIN_HELPER_PROXY",
 		},
-		cli.BoolFlag{
-			Name:   "use-new-run",
-			Usage:  "Set this flag if experimental run implementation for detecting changes without requiring file system snapshots. In some cases, this may improve build performance by 75%",
-			EnvVar: "PLUGIN_USE_NEW_RUN",
+		cli.StringFlag{
+			Name:   "helper-no-proxy",
+			Usage:  "Hosts the registry credential helper reaches without the proxy, as NO_PROXY",
+			EnvVar: "PLUGIN_HELPER_NO_PROXY",
+		},
+		cli.StringSliceFlag{
+			Name:   "helper-env",
+			Usage:  "Environment variables of the registry credential helper, as NAME=value, e.g. AWS_CA_BUNDLE=/kaniko/proxy-ca.pem",
+			EnvVar: "PLUGIN_HELPER_ENV",
 		},
 		cli.StringFlag{
-			Name:   "platform",
-			Usage:  "Allows to build with another default platform than the host, similarly to docker build --platform",
-			EnvVar: "PLUGIN_PLATFORM",
+			Name:   "gcs-object",
+			Usage:  "GCS object written with the pushed image after push, as gs://<bucket>/<object>",
+			EnvVar: "PLUGIN_GCS_OBJECT",
 		},
-	}
+		cli.StringFlag{
+			Name:   "secret-manager-secret",
+			Usage:  "Secret Manager secret a version with the pushed image is added to after push, as projects/<project>/secrets/<secret>",
+			EnvVar: "PLUGIN_SECRET_MANAGER_SECRET",
+		},
+		cli.StringFlag{
+			Name:   "publish-value",
+			Usage:  "Value published to the GCS object and the secret, expanding ${REF}, ${REPO}, ${TAG} and ${DIGEST}",
+			Value:  patch.DefaultValue,
+			EnvVar: "PLUGIN_PUBLISH_VALUE",
+		},
+		cli.StringFlag{
+			Name:   "ledger",
+			Usage:  "JSON Lines ledger each build is recorded in, a file on a mounted volume or gs://<bucket>/<object>",
+			EnvVar: "PLUGIN_LEDGER",
+		},
+		cli.StringFlag{
+			Name:   "vault-path",
+			Usage:  "Vault secret whose keys set the plugin settings of the same names, e.g. secret/data/drone/registry with username and password",
+			EnvVar: "PLUGIN_VAULT_PATH",
+		},
+	}, command.Flags()...)
 
 	if err := app.Run(os.Args); err != nil {
 		logrus.Fatal(err)
 	}
 }
 
-func run(c *cli.Context) error {
+func run(ctx context.Context, c *cli.Context) error {
+	if err := command.Setup(ctx, c, userAgent(c)); err != nil {
+		return err
+	}
+	if err := decodeJSONKey(c); err != nil {
+		return err
+	}
 	noPush := c.Bool("no-push")
 	jsonKey := c.String("json-key")
 
@@ -201,47 +201,236 @@ func run(c *cli.Context) error {
 		}
 	}
 
+	if err := validatePublish(c.String("gcs-object"), c.String("secret-manager-secret"), c.Bool("discover")); err != nil {
+		return err
+	}
+	if c.String("access-token") != "" && jsonKey != "" {
+		return fmt.Errorf("access-token and json-key are mutually exclusive")
+	}
+	if sa := c.String("impersonate-service-account"); sa != "" && !strings.Contains(sa, "@") {
+		return fmt.Errorf("invalid impersonate-service-account %s, expected the email of a service account", sa)
+	}
+	if c.Bool("preflight-key") {
+		if err := preflightKey(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	if !noPush {
+		if err := setupArtifactRepository(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	arMirrors, err := artifactRegistryMirrors(c.StringSlice("artifact-registry-mirrors"))
+	if err != nil {
+		return err
+	}
+	if err := setupCredHelpers(c.String("registry"), arMirrors, c.String("helper-proxy"), c.String("helper-no-proxy"), c.StringSlice("helper-env")); err != nil {
+		return err
+	}
+
+	if err := setupTokenAuth(ctx, c, arMirrors); err != nil {
+		return err
+	}
+
+	if err := command.AddAuths(c); err != nil {
+		return err
+	}
+
+	build := command.Build(c)
+	build.Repo = fmt.Sprintf("%s/%s", c.String("registry"), c.String("repo"))
+	build.CacheRepo = registryRepo(c.String("registry"), c.String("cache-repo"))
+	build.CacheFrom = registryRepos(c.String("registry"), c.StringSlice("cache-from"))
+	build.Mirrors = append(arMirrors, c.StringSlice("registry-mirrors")...)
 	plugin := kaniko.Plugin{
-		Build: kaniko.Build{
-			DroneCommitRef:  c.String("drone-commit-ref"),
-			DroneRepoBranch: c.String("drone-repo-branch"),
-			Dockerfile:      c.String("dockerfile"),
-			Context:         c.String("context"),
-			Tags:            c.StringSlice("tags"),
-			AutoTag:         c.Bool("auto-tag"),
-			AutoTagSuffix:   c.String("auto-tag-suffix"),
-			ExpandTag:       c.Bool("expand-tag"),
-			Args:            c.StringSlice("args"),
-			Target:          c.String("target"),
-			Repo:            fmt.Sprintf("%s/%s", c.String("registry"), c.String("repo")),
-			Mirrors:         c.StringSlice("registry-mirrors"),
-			Labels:          c.StringSlice("custom-labels"),
-			SnapshotMode:    c.String("snapshot-mode"),
-			EnableCache:     c.Bool("enable-cache"),
-			CacheDir:		 c.String("cache-dir"),
-			CacheCopyLayers: c.Bool("cache-copy-layers"),
-			CacheNoCompress: c.Bool("cache-no-compress"),
-			CacheRepo:       fmt.Sprintf("%s/%s", c.String("registry"), c.String("cache-repo")),
-			CacheTTL:        c.Int("cache-ttl"),
-			DigestFile:      defaultDigestFile,
-			NoPush:          noPush,
-			Verbosity:       c.String("verbosity"),
-			UseNewRun: 		 c.Bool("use-new-run"),
-			Platform:        c.String("platform"),
-		},
+		Build: build,
 		Artifact: kaniko.Artifact{
 			Tags:         c.StringSlice("tags"),
 			Repo:         c.String("repo"),
 			Registry:     c.String("registry"),
 			ArtifactFile: c.String("artifact-file"),
+			Format:       c.String("artifact-format"),
+			Publishers:   c.StringSlice("artifact-publishers"),
+			Headers:      c.StringSlice("artifact-publish-headers"),
 			RegistryType: artifact.GCR,
 		},
+		Promotion:  command.Promotion(c),
+		UserAgent:  userAgent(c),
+		MaskValues: append(command.MaskValues(c, "json-key", "access-token"), gcp.KeySecrets(c.String("json-key"))...),
+		Context:    ctx,
+	}
+	if ledgerURL := c.String("ledger"); strings.HasPrefix(ledgerURL, "gs://") {
+		bucket, object, err := gcp.ParseObjectURL(ledgerURL)
+		if err != nil {
+			return err
+		}
+		plugin.LedgerStore = ledger.ObjectStore{Object: &gcsObject{
+			client:  gcpClient(c),
+			jsonKey: jsonKey,
+			bucket:  bucket,
+			object:  object,
+		}}
+	}
+	plugin.Artifact.Openers = map[string]artifact.Opener{"gs": func(destination string) (artifact.Publisher, error) {
+		bucket, object, err := gcp.ParseObjectURL(destination)
+		if err != nil {
+			return nil, err
+		}
+		return &gcsPublisher{client: gcpClient(c), jsonKey: jsonKey, bucket: bucket, object: object}, nil
+	}}
+	if err := plugin.Exec(); err != nil {
+		return err
+	}
+
+	if !noPush && (c.IsSet("gcs-object") || c.IsSet("secret-manager-secret")) {
+		tags := c.StringSlice("tags")
+		var tag string
+		if len(tags) != 0 {
+			tag = tags[0]
+		}
+		return publishImage(ctx, c, registryRepo(c.String("registry"), c.String("repo")), tag)
+	}
+	return nil
+}
+
+// decodeJSONKey replaces the json-key setting with the decoded JSON of the
+// json-key or json-key-b64 setting, which may both be base64 encoded.
+func decodeJSONKey(c *cli.Context) error {
+	key, encoded := c.String("json-key"), c.String("json-key-b64")
+	if key != "" && encoded != "" {
+		return fmt.Errorf("json-key and json-key-b64 are mutually exclusive")
+	}
+	if encoded != "" {
+		key = encoded
+	}
+	if key == "" {
+		return nil
+	}
+	decoded, err := gcp.DecodeKey(key)
+	if err != nil {
+		return err
 	}
-	return plugin.Exec()
+	return c.Set("json-key", decoded)
+}
+
+// validatePublish checks the GCS object and Secret Manager secret the pushed
+// image is published to before the build.
+func validatePublish(object, secret string, discover bool) error {
+	if (object != "" || secret != "") && discover {
+		return fmt.Errorf("gcs-object and secret-manager-secret are not supported in discover mode")
+	}
+	if object != "" {
+		if _, _, err := gcp.ParseObjectURL(object); err != nil {
+			return err
+		}
+	}
+	if secret != "" {
+		return gcp.ValidateSecret(secret)
+	}
+	return nil
+}
+
+// publishImage writes the pushed image, with the value template expanded,
+// to the GCS object and as a new version of the Secret Manager secret, for
+// GCP-native deployment automation.
+func publishImage(ctx context.Context, c *cli.Context, repo, tag string) error {
+	b, err := ioutil.ReadFile(command.DigestFile)
+	if os.IsNotExist(err) {
+		fmt.Println("No image was pushed, not publishing the image reference")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read image digest")
+	}
+	image := patch.Image{Repo: repo, Tag: tag, Digest: strings.TrimSpace(string(b))}
+	value := image.Expand(c.String("publish-value"))
+
+	client := gcpClient(c)
+	token, err := client.Token(ctx, c.String("json-key"))
+	if err != nil {
+		return err
+	}
+	if object := c.String("gcs-object"); object != "" {
+		bucket, name, _ := gcp.ParseObjectURL(object)
+		if err := client.UploadObject(ctx, token, bucket, name, "text/plain", []byte(value)); err != nil {
+			return err
+		}
+		fmt.Printf("Published %s to %s\n", value, object)
+	}
+	if secret := c.String("secret-manager-secret"); secret != "" {
+		if err := client.AddSecretVersion(ctx, token, secret, []byte(value)); err != nil {
+			return err
+		}
+		fmt.Printf("Published %s to %s\n", value, secret)
+	}
+	return nil
+}
+
+// gcsObject is a GCS object of the build ledger, written conditionally on
+// the generation read.
+type gcsObject struct {
+	client  *gcp.Client
+	jsonKey string
+	bucket  string
+	object  string
+	token   string
+}
+
+func (o *gcsObject) Read(ctx context.Context) ([]byte, string, error) {
+	if o.token == "" {
+		token, err := o.client.Token(ctx, o.jsonKey)
+		if err != nil {
+			return nil, "", err
+		}
+		o.token = token
+	}
+	content, generation, err := o.client.DownloadObject(ctx, o.token, o.bucket, o.object)
+	if gcp.HasStatus(err, http.StatusNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return content, strconv.FormatInt(generation, 10), nil
+}
+
+func (o *gcsObject) Write(ctx context.Context, content []byte, version string) error {
+	// Generation 0 requires the object to not exist yet
+	generation, _ := strconv.ParseInt(version, 10, 64)
+	err := o.client.UploadObjectIfGeneration(ctx, o.token, o.bucket, o.object, "application/x-ndjson", content, generation)
+	if gcp.HasStatus(err, http.StatusPreconditionFailed) {
+		return ledger.ErrConflict
+	}
+	return err
+}
+
+func (o *gcsObject) String() string {
+	return fmt.Sprintf("gs://%s/%s", o.bucket, o.object)
+}
+
+// gcsPublisher publishes artifacts to a GCS object.
+type gcsPublisher struct {
+	client  *gcp.Client
+	jsonKey string
+	bucket  string
+	object  string
+}
+
+func (p *gcsPublisher) Publish(ctx context.Context, content []byte, format artifact.FormatEnum) error {
+	token, err := p.client.Token(ctx, p.jsonKey)
+	if err != nil {
+		return err
+	}
+	return p.client.UploadObject(ctx, token, p.bucket, p.object, format.ContentType(), content)
+}
+
+func (p *gcsPublisher) String() string {
+	return fmt.Sprintf("gs://%s/%s", p.bucket, p.object)
 }
 
 func setupGCRAuth(jsonKey string) error {
-	err := ioutil.WriteFile(gcrKeyPath, []byte(jsonKey), 0644)
+	err := ioutil.WriteFile(gcrKeyPath, []byte(jsonKey), 0600)
 	if err != nil {
 		return errors.Wrap(err, "failed to write GCR JSON key")
 	}
@@ -252,3 +441,166 @@ func setupGCRAuth(jsonKey string) error {
 	}
 	return nil
 }
+
+// setupCredHelpers configures the gcr credential helper for the Artifact
+// Registry mirrors, so that base images are pulled with the plugin's Google
+// credentials, and, to run with the given proxy and environment, for the
+// registry. Without helper environment kaniko fetches GCR tokens itself,
+// using its ambient environment.
+func setupCredHelpers(registry string, mirrors []string, proxy, noProxy string, vars []string) error {
+	env, err := docker.HelperEnv(proxy, noProxy, vars)
+	if err != nil || (len(env) == 0 && len(mirrors) == 0) {
+		return err
+	}
+	config, err := docker.LoadConfig(docker.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if len(env) != 0 {
+		config.SetCredHelper(registry, "gcr")
+	}
+	for _, mirror := range mirrors {
+		config.SetCredHelper(strings.SplitN(mirror, "/", 2)[0], "gcr")
+	}
+	if err := config.WrapCredHelpers(env); err != nil {
+		return err
+	}
+	return config.Save(docker.ConfigPath)
+}
+
+// preflightKey checks that the JSON key is a valid service account key of
+// the project of the repo, unless another service account is impersonated,
+// and exchanges the credentials for an access token, so that authentication
+// errors surface before the build rather than at push time.
+func preflightKey(ctx context.Context, c *cli.Context) error {
+	if key := c.String("json-key"); key != "" {
+		project, email, err := gcp.KeyProject(key)
+		if err != nil {
+			return errors.Wrap(err, "key preflight failed")
+		}
+		// Domain-scoped projects, e.g. example.com:project, are paths in repos
+		prefix := strings.Replace(project, ":", "/", 1) + "/"
+		if repo := c.String("repo"); c.String("impersonate-service-account") == "" && repo != "" && !strings.HasPrefix(repo, prefix) {
+			return fmt.Errorf("key preflight failed, %s of project %s does not match repo %s", email, project, repo)
+		}
+	}
+	if _, err := gcpClient(c).Token(ctx, c.String("json-key")); err != nil {
+		return errors.Wrap(err, "key preflight failed")
+	}
+	fmt.Println("Key preflight succeeded")
+	return nil
+}
+
+// setupTokenAuth adds the access token, or tokens of the impersonated service
+// account, for the registry and the Artifact Registry mirrors to the docker
+// config as oauth2accesstoken basic auth, in place of the gcr credential
+// helper, which only knows the JSON key.
+func setupTokenAuth(ctx context.Context, c *cli.Context, mirrors []string) error {
+	if c.String("access-token") == "" && c.String("impersonate-service-account") == "" {
+		return nil
+	}
+	token, err := gcpClient(c).Token(ctx, c.String("json-key"))
+	if err != nil {
+		return err
+	}
+	config, err := docker.LoadConfig(docker.ConfigPath)
+	if err != nil {
+		return err
+	}
+	for _, host := range append([]string{c.String("registry")}, mirrors...) {
+		host = strings.SplitN(host, "/", 2)[0]
+		delete(config.CredHelpers, host)
+		config.SetAuth(host, "oauth2accesstoken", token)
+	}
+	return config.Save(docker.ConfigPath)
+}
+
+// setupArtifactRepository creates the Artifact Registry repository of the
+// image when create-repository is set and it is missing, and applies the
+// repository labels and cleanup policies.
+func setupArtifactRepository(ctx context.Context, c *cli.Context) error {
+	labels, err := gcp.ParseLabels(c.StringSlice("repository-labels"))
+	if err != nil {
+		return err
+	}
+	settings := gcp.RepositorySettings{Labels: labels}
+	if path := c.String("cleanup-policies"); path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "failed to read cleanup policies")
+		}
+		if settings.CleanupPolicies, err = gcp.ParseCleanupPolicies(content); err != nil {
+			return err
+		}
+	}
+	if !c.Bool("create-repository") && settings.Empty() {
+		return nil
+	}
+	repo, err := gcp.ParseArtifactRepository(c.String("registry"), c.String("repo"))
+	if err != nil {
+		return err
+	}
+
+	client := gcpClient(c)
+	token, err := client.Token(ctx, c.String("json-key"))
+	if err != nil {
+		return err
+	}
+	if !c.Bool("create-repository") {
+		return client.UpdateRepository(ctx, token, repo, settings)
+	}
+	created, err := client.EnsureRepository(ctx, token, repo, settings)
+	if err != nil {
+		return err
+	}
+	if created {
+		fmt.Printf("Created repository %s\n", repo.Name())
+	}
+	return nil
+}
+
+// artifactRegistryMirrors validates the Artifact Registry remote repositories
+// used as registry mirrors, given as <location>-docker.pkg.dev/<project>/<repository>.
+func artifactRegistryMirrors(mirrors []string) ([]string, error) {
+	var out []string
+	for _, mirror := range mirrors {
+		repo, err := registry.ParseRepository(mirror)
+		if err != nil || !strings.HasSuffix(repo.Registry, "-docker.pkg.dev") || strings.Count(repo.Name, "/") != 1 {
+			return nil, fmt.Errorf("invalid artifact registry mirror %s, expected <location>-docker.pkg.dev/<project>/<repository>", mirror)
+		}
+		out = append(out, repo.Registry+"/"+repo.Name)
+	}
+	return out, nil
+}
+
+// gcpClient returns the client of the Google Cloud APIs, using the access
+// token and acting as the impersonated service account if set.
+func gcpClient(c *cli.Context) *gcp.Client {
+	return &gcp.Client{
+		UserAgent:   userAgent(c),
+		AccessToken: c.String("access-token"),
+		Impersonate: c.String("impersonate-service-account"),
+	}
+}
+
+// userAgent identifies the plugin and the Drone build in registry requests.
+func userAgent(c *cli.Context) string {
+	return command.UserAgent(c, "drone-kaniko-gcr", version)
+}
+
+// registryRepo prefixes the repo, if any, with the registry.
+func registryRepo(registry, repo string) string {
+	if repo == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", registry, repo)
+}
+
+// registryRepos prefixes each repo with the registry.
+func registryRepos(registry string, repos []string) []string {
+	var out []string
+	for _, repo := range repos {
+		out = append(out, registryRepo(registry, repo))
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_validatePublish(t *testing.T) {
+	tests := []struct {
+		name     string
+		object   string
+		secret   string
+		discover bool
+		wantErr  bool
+	}{
+		{name: "none"},
+		{name: "object", object: "gs://bucket/images/app"},
+		{name: "secret", secret: "projects/acme/secrets/app-image"},
+		{name: "invalid_object", object: "bucket/images/app", wantErr: true},
+		{name: "invalid_secret", secret: "app-image", wantErr: true},
+		{name: "discover", object: "gs://bucket/images/app", discover: true, wantErr: true},
+		{name: "discover_without_publish", discover: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePublish(tt.object, tt.secret, tt.discover); (err != nil) != tt.wantErr {
+				t.Errorf("validatePublish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_artifactRegistryMirrors(t *testing.T) {
+	got, err := artifactRegistryMirrors([]string{"europe-docker.pkg.dev/acme/dockerhub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"europe-docker.pkg.dev/acme/dockerhub"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("artifactRegistryMirrors() = %v, want %v", got, want)
+	}
+	for _, mirror := range []string{"mirror.gcr.io", "europe-docker.pkg.dev/acme", "europe-docker.pkg.dev/acme/dockerhub/library"} {
+		if _, err := artifactRegistryMirrors([]string{mirror}); err == nil {
+			t.Errorf("artifactRegistryMirrors(%s) error = nil", mirror)
+		}
+	}
+}
+
+func Test_registryRepos(t *testing.T) {
+	got := registryRepos("gcr.io", []string{"acme/app", ""})
+	if want := []string{"gcr.io/acme/app", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("registryRepos() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	kaniko "github.com/gexops/drone-kaniko"
+	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/command"
+	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/gexops/drone-kaniko/pkg/ibmcr"
+)
+
+var (
+	version = "unknown"
+)
+
+func main() {
+	// Load env-file if it exists first
+	if env := os.Getenv("PLUGIN_ENV_FILE"); env != "" {
+		if err := godotenv.Load(env); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	app := cli.NewApp()
+	app.Name = "kaniko ibmcr plugin"
+	app.Usage = "kaniko ibmcr plugin"
+	app.Action = func(c *cli.Context) error {
+		defer docker.RemoveCredentials()
+		ctx, stop := command.Context()
+		defer stop()
+		return kaniko.WriteResult(c.String("output"), func() error {
+			return kaniko.ReportError(c.String("error-file"), run(ctx, c))
+		})
+	}
+	app.Version = version
+	app.Flags = append([]cli.Flag{
+		cli.StringFlag{
+			Name:   "repo",
+			Usage:  "docker repository, prefixed with the registry namespace",
+			EnvVar: "PLUGIN_REPO",
+		},
+		cli.StringFlag{
+			Name:   "registry",
+			Usage:  "ibm cloud container registry, defaults to the registry of the region",
+			EnvVar: "PLUGIN_REGISTRY",
+		},
+		cli.StringFlag{
+			Name:   "region",
+			Usage:  "ibm cloud region, e.g. us-south, or global",
+			EnvVar: "PLUGIN_REGION",
+		},
+		cli.StringFlag{
+			Name:   "iam-endpoint",
+			Usage:  "ibm cloud iam endpoint the api key is exchanged with, e.g. the private endpoint",
+			Value:  ibmcr.DefaultIAMEndpoint,
+			EnvVar: "PLUGIN_IAM_ENDPOINT",
+		},
+		cli.DurationFlag{
+			Name:   "credential-refresh",
+			Usage:  "Interval in which the iam access token, which expires after an hour, is renewed during the build, 0 to disable",
+			Value:  45 * time.Minute,
+			EnvVar: "PLUGIN_CREDENTIAL_REFRESH",
+		},
+		cli.StringFlag{
+			Name:   "api-key",
+			Usage:  "ibm cloud iam api key of a user or service id, exchanged for a registry token",
+			EnvVar: "PLUGIN_API_KEY,PLUGIN_PASSWORD",
+		},
+		cli.BoolFlag{
+			Name:   "skip-tls-verify",
+			Usage:  "Skip registry tls verify",
+			EnvVar: "PLUGIN_SKIP_TLS_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "snapshot-mode",
+			Usage:  "Specify one of full, redo or time as snapshot mode",
+			Value:  "redo",
+			EnvVar: "PLUGIN_SNAPSHOT_MODE",
+		},
+		cli.StringFlag{
+			Name:   "cache-repo",
+			Usage:  "Remote repository that will be used to store cached layers. enable-cache needs to be set to use this flag",
+			EnvVar: "PLUGIN_CACHE_REPO",
+		},
+		cli.StringFlag{
+			Name:   "registry-auths",
+			Usage:  "JSON or YAML list of registry, username and password objects of additional registries, e.g. of base images",
+			EnvVar: "PLUGIN_REGISTRY_AUTHS",
+		},
+		cli.StringSliceFlag{
+			Name:   "artifact-publishers",
+			Usage:  "Destinations the artifact is additionally published to: files, http(s) URLs the artifact is POSTed to",
+			EnvVar: "PLUGIN_ARTIFACT_PUBLISHERS",
+		},
+		cli.StringFlag{
+			Name:   "ledger",
+			Usage:  "JSON Lines ledger each build is recorded in, a file on a mounted volume",
+			EnvVar: "PLUGIN_LEDGER",
+		},
+		cli.StringFlag{
+			Name:   "vault-path",
+			Usage:  "Vault secret whose keys set the plugin settings of the same names, e.g. secret/data/drone/registry with username and password",
+			EnvVar: "PLUGIN_VAULT_PATH",
+		},
+	}, command.Flags()...)
+
+	if err := app.Run(os.Args); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, c *cli.Context) error {
+	if err := command.Setup(ctx, c, userAgent(c)); err != nil {
+		return err
+	}
+	reg := c.String("registry")
+	if reg == "" {
+		var err error
+		if reg, err = ibmcr.Registry(c.String("region")); err != nil {
+			return err
+		}
+	}
+	apiKey := c.String("api-key")
+	noPush := c.Bool("no-push")
+
+	// only setup auth when pushing or credentials are defined
+	var refreshToken func(context.Context) error
+	if !noPush || apiKey != "" {
+		if apiKey == "" {
+			return fmt.Errorf("API key must be specified")
+		}
+		client := &ibmcr.Client{IAMEndpoint: c.String("iam-endpoint"), UserAgent: userAgent(c)}
+		token, err := client.Token(ctx, apiKey)
+		if err != nil {
+			return err
+		}
+		if err := createDockerCfgFile(ibmcr.TokenUsername, token, reg); err != nil {
+			return err
+		}
+		refreshToken = func(ctx context.Context) error {
+			token, err := client.Token(ctx, apiKey)
+			if err != nil {
+				return err
+			}
+			return docker.AddAuth(docker.ConfigPath, reg, ibmcr.TokenUsername, token)
+		}
+	}
+
+	if err := command.AddAuths(c); err != nil {
+		return err
+	}
+
+	build := command.Build(c)
+	build.Repo = buildRepo(reg, c.String("repo"))
+	build.CacheRepo = buildRepo(reg, c.String("cache-repo"))
+	build.CacheFrom = buildRepos(reg, c.StringSlice("cache-from"))
+	plugin := kaniko.Plugin{
+		Build: build,
+		Artifact: kaniko.Artifact{
+			Tags:         c.StringSlice("tags"),
+			Repo:         buildRepo(reg, c.String("repo")),
+			Registry:     reg,
+			ArtifactFile: c.String("artifact-file"),
+			Format:       c.String("artifact-format"),
+			Publishers:   c.StringSlice("artifact-publishers"),
+			Headers:      c.StringSlice("artifact-publish-headers"),
+			RegistryType: artifact.Docker,
+		},
+		Promotion:  command.Promotion(c),
+		UserAgent:  userAgent(c),
+		MaskValues: command.MaskValues(c, "api-key"),
+		Context:    ctx,
+	}
+	if refreshToken != nil {
+		plugin.CredentialRefresh = refreshToken
+		plugin.CredentialRefreshInterval = c.Duration("credential-refresh")
+	}
+	return plugin.Exec()
+}
+
+// Create the docker config file for authentication
+func createDockerCfgFile(username, password, registry string) error {
+	if username == "" {
+		return fmt.Errorf("Username must be specified")
+	}
+	if password == "" {
+		return fmt.Errorf("Password must be specified")
+	}
+	if registry == "" {
+		return fmt.Errorf("Registry must be specified")
+	}
+
+	dockerPath := filepath.Dir(docker.ConfigPath)
+	err := os.MkdirAll(dockerPath, 0700)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to create %s directory", dockerPath))
+	}
+
+	authBytes := []byte(fmt.Sprintf("%s:%s", username, password))
+	encodedString := base64.StdEncoding.EncodeToString(authBytes)
+	jsonBytes := []byte(fmt.Sprintf(`{"auths": {"%s": {"auth": "%s"}}}`, registry, encodedString))
+	err = ioutil.WriteFile(docker.ConfigPath, jsonBytes, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker config file")
+	}
+	return nil
+}
+
+// buildRepo prefixes the repo with the registry.
+func buildRepo(registry, repo string) string {
+	if repo == "" {
+		// No repo, e.g. no cache repo
+		return ""
+	}
+	// Trim off trailing slash to prevent double slash when combining with repo
+	registry = strings.TrimSuffix(registry, "/")
+	// Repos may already include the registry prefix
+	if strings.HasPrefix(repo, registry+"/") {
+		return repo
+	}
+	return registry + "/" + repo
+}
+
+// userAgent identifies the plugin and the Drone build in registry requests.
+func userAgent(c *cli.Context) string {
+	return command.UserAgent(c, "drone-kaniko-ibmcr", version)
+}
+
+// buildRepos prefixes each repo with the registry, see buildRepo.
+func buildRepos(registry string, repos []string) []string {
+	var out []string
+	for _, repo := range repos {
+		out = append(out, buildRepo(registry, repo))
+	}
+	return out
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func Test_buildRepo(t *testing.T) {
+	tests := []struct {
+		name string
+		repo string
+		want string
+	}{
+		{name: "namespace", repo: "team/app", want: "us.icr.io/team/app"},
+		{name: "registry", repo: "us.icr.io/team/app", want: "us.icr.io/team/app"},
+		{name: "empty", repo: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildRepo("us.icr.io/", tt.repo); got != tt.want {
+				t.Errorf("buildRepo(%q) = %v, want %v", tt.repo, got, tt.want)
+			}
+		})
+	}
+}
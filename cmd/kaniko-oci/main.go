@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	kaniko "github.com/gexops/drone-kaniko"
+	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/command"
+	"github.com/gexops/drone-kaniko/pkg/docker"
+)
+
+var (
+	version = "unknown"
+)
+
+func main() {
+	// Load env-file if it exists first
+	if env := os.Getenv("PLUGIN_ENV_FILE"); env != "" {
+		if err := godotenv.Load(env); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	app := cli.NewApp()
+	app.Name = "kaniko oci plugin"
+	app.Usage = "kaniko oci plugin"
+	app.Action = func(c *cli.Context) error {
+		defer docker.RemoveCredentials()
+		ctx, stop := command.Context()
+		defer stop()
+		return kaniko.WriteResult(c.String("output"), func() error {
+			return kaniko.ReportError(c.String("error-file"), run(ctx, c))
+		})
+	}
+	app.Version = version
+	app.Flags = append([]cli.Flag{
+		cli.StringFlag{
+			Name:   "repo",
+			Usage:  "docker repository",
+			EnvVar: "PLUGIN_REPO",
+		},
+		cli.StringFlag{
+			Name:   "registry",
+			Usage:  "oci registry, e.g. nexus.example.com:8443",
+			EnvVar: "PLUGIN_REGISTRY",
+		},
+		cli.StringFlag{
+			Name:   "username",
+			Usage:  "docker username",
+			EnvVar: "PLUGIN_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "password",
+			Usage:  "docker password",
+			EnvVar: "PLUGIN_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:   "docker-config",
+			Usage:  "docker config JSON with the auths and credHelpers of the registry and any other registries",
+			EnvVar: "PLUGIN_DOCKER_CONFIG",
+		},
+		cli.StringFlag{
+			Name:   "registry-credentials",
+			Usage:  "JSON or YAML list of registry, username and password objects of the registry and any other registries",
+			EnvVar: "PLUGIN_REGISTRY_CREDENTIALS,PLUGIN_REGISTRY_AUTHS",
+		},
+		cli.BoolFlag{
+			Name:   "skip-tls-verify",
+			Usage:  "Skip registry tls verify",
+			EnvVar: "PLUGIN_SKIP_TLS_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "snapshot-mode",
+			Usage:  "Specify one of full, redo or time as snapshot mode",
+			Value:  "redo",
+			EnvVar: "PLUGIN_SNAPSHOT_MODE",
+		},
+		cli.StringFlag{
+			Name:   "cache-repo",
+			Usage:  "Remote repository that will be used to store cached layers. enable-cache needs to be set to use this flag",
+			EnvVar: "PLUGIN_CACHE_REPO",
+		},
+		cli.StringSliceFlag{
+			Name:   "artifact-publishers",
+			Usage:  "Destinations the artifact is additionally published to: files, http(s) URLs the artifact is POSTed to",
+			EnvVar: "PLUGIN_ARTIFACT_PUBLISHERS",
+		},
+		cli.StringFlag{
+			Name:   "ledger",
+			Usage:  "JSON Lines ledger each build is recorded in, a file on a mounted volume",
+			EnvVar: "PLUGIN_LEDGER",
+		},
+		cli.StringFlag{
+			Name:   "vault-path",
+			Usage:  "Vault secret whose keys set the plugin settings of the same names, e.g. secret/data/drone/registry with username and password",
+			EnvVar: "PLUGIN_VAULT_PATH",
+		},
+	}, command.Flags()...)
+
+	if err := app.Run(os.Args); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, c *cli.Context) error {
+	if err := command.Setup(ctx, c, userAgent(c)); err != nil {
+		return err
+	}
+	reg := c.String("registry")
+	if reg == "" {
+		return fmt.Errorf("Registry must be specified")
+	}
+	config, err := dockerConfig(c.String("docker-config"), c.String("registry-credentials"), reg, c.String("username"), c.String("password"))
+	if err != nil {
+		return err
+	}
+	noPush := c.Bool("no-push")
+
+	// only setup auth when credentials are defined, registries such as
+	// zot may accept anonymous pushes
+	if len(config.Auths) != 0 || len(config.CredHelpers) != 0 {
+		if err := config.Save(docker.ConfigPath); err != nil {
+			return err
+		}
+	} else if !noPush {
+		logrus.Warnf("no credentials configured for %s, pushing anonymously", reg)
+	}
+
+	if err := command.AddAuths(c); err != nil {
+		return err
+	}
+
+	build := command.Build(c)
+	build.Repo = buildRepo(reg, c.String("repo"))
+	build.CacheRepo = buildRepo(reg, c.String("cache-repo"))
+	build.CacheFrom = buildRepos(reg, c.StringSlice("cache-from"))
+	plugin := kaniko.Plugin{
+		Build: build,
+		Artifact: kaniko.Artifact{
+			Tags:         c.StringSlice("tags"),
+			Repo:         buildRepo(reg, c.String("repo")),
+			Registry:     reg,
+			ArtifactFile: c.String("artifact-file"),
+			Format:       c.String("artifact-format"),
+			Publishers:   c.StringSlice("artifact-publishers"),
+			Headers:      c.StringSlice("artifact-publish-headers"),
+			RegistryType: artifact.Docker,
+		},
+		Promotion:  command.Promotion(c),
+		UserAgent:  userAgent(c),
+		MaskValues: append(command.MaskValues(c, "password"), docker.RegistryAuthSecrets(c.String("registry-credentials"))...),
+		Context:    ctx,
+	}
+	return plugin.Exec()
+}
+
+// dockerConfig combines the docker config JSON, the registry credentials and
+// the username and password of the registry into a single docker config.
+// Later sources replace the entries of the same registries.
+func dockerConfig(content, credentials, registry, username, password string) (*docker.Config, error) {
+	config := docker.NewConfig()
+	if strings.TrimSpace(content) != "" {
+		c, err := docker.ParseConfig([]byte(content))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse docker config")
+		}
+		config.Merge(c)
+	}
+	if strings.TrimSpace(credentials) != "" {
+		creds, err := docker.ParseRegistryAuths(credentials)
+		if err != nil {
+			return nil, err
+		}
+		for _, cred := range creds {
+			config.SetAuth(cred.Registry, cred.Username, cred.Password)
+		}
+	}
+	if username != "" {
+		if password == "" {
+			return nil, fmt.Errorf("Password must be specified")
+		}
+		config.SetAuth(registry, username, password)
+	}
+	return config, nil
+}
+
+// buildRepo prefixes the repo with the registry.
+func buildRepo(registry, repo string) string {
+	if repo == "" {
+		// No repo, e.g. no cache repo
+		return ""
+	}
+	// Trim off trailing slash to prevent double slash when combining with repo
+	registry = strings.TrimSuffix(registry, "/")
+	// Repos may already include the registry prefix
+	if strings.HasPrefix(repo, registry+"/") {
+		return repo
+	}
+	return registry + "/" + repo
+}
+
+// userAgent identifies the plugin and the Drone build in registry requests.
+func userAgent(c *cli.Context) string {
+	return command.UserAgent(c, "drone-kaniko-oci", version)
+}
+
+// buildRepos prefixes each repo with the registry, see buildRepo.
+func buildRepos(registry string, repos []string) []string {
+	var out []string
+	for _, repo := range repos {
+		out = append(out, buildRepo(registry, repo))
+	}
+	return out
+}
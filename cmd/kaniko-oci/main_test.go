@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func Test_dockerConfig(t *testing.T) {
+	config, err := dockerConfig(
+		`{"auths": {"nexus.example.com": {"username": "nexus", "password": "secret"}}, "credHelpers": {"gcr.io": "gcr"}}`,
+		`[{"registry": "gitea.example.com", "username": "gitea", "password": "secret"}, {"registry": "nexus.example.com", "username": "other", "password": "secret"}]`,
+		"zot.example.com", "zot", "secret",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for registry, want := range map[string]string{"nexus.example.com": "other", "gitea.example.com": "gitea", "zot.example.com": "zot"} {
+		if username, _, _ := config.Credentials(registry); username != want {
+			t.Errorf("Credentials(%s) username = %q, want %q", registry, username, want)
+		}
+	}
+	if config.CredHelpers["gcr.io"] != "gcr" {
+		t.Errorf("expected gcr.io credential helper, got %#v", config.CredHelpers)
+	}
+
+	for name, args := range map[string][3]string{
+		"invalid config":      {`{"auths": [`, "", ""},
+		"invalid credentials": {"", `{"registry": "zot.example.com"}`, ""},
+		"missing username":    {"", `[{"registry": "zot.example.com", "password": "secret"}]`, ""},
+		"missing password":    {"", "", "zot"},
+	} {
+		if _, err := dockerConfig(args[0], args[1], "zot.example.com", args[2], ""); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	config, err = dockerConfig("", "", "zot.example.com", "", "")
+	if err != nil || len(config.Auths) != 0 {
+		t.Errorf("expected empty config, got %#v, %v", config, err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func Test_buildRepo(t *testing.T) {
+	tests := []struct {
+		name string
+		repo string
+		want string
+	}{
+		{name: "namespace", repo: "team/app", want: "iad.ocir.io/axaxnpcrorw5/team/app"},
+		{name: "qualified", repo: "axaxnpcrorw5/team/app", want: "iad.ocir.io/axaxnpcrorw5/team/app"},
+		{name: "registry", repo: "iad.ocir.io/axaxnpcrorw5/team/app", want: "iad.ocir.io/axaxnpcrorw5/team/app"},
+		{name: "empty", repo: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildRepo("iad.ocir.io", "axaxnpcrorw5", tt.repo); got != tt.want {
+				t.Errorf("buildRepo(%q) = %v, want %v", tt.repo, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	kaniko "github.com/gexops/drone-kaniko"
+	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/command"
+	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/gexops/drone-kaniko/pkg/quay"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+var (
+	version = "unknown"
+)
+
+func main() {
+	// Load env-file if it exists first
+	if env := os.Getenv("PLUGIN_ENV_FILE"); env != "" {
+		if err := godotenv.Load(env); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	app := cli.NewApp()
+	app.Name = "kaniko quay plugin"
+	app.Usage = "kaniko quay plugin"
+	app.Action = func(c *cli.Context) error {
+		defer docker.RemoveCredentials()
+		ctx, stop := command.Context()
+		defer stop()
+		return kaniko.WriteResult(c.String("output"), func() error {
+			return kaniko.ReportError(c.String("error-file"), run(ctx, c))
+		})
+	}
+	app.Version = version
+	app.Flags = append([]cli.Flag{
+		cli.StringFlag{
+			Name:   "repo",
+			Usage:  "docker repository",
+			EnvVar: "PLUGIN_REPO",
+		},
+		cli.StringFlag{
+			Name:   "registry",
+			Usage:  "quay registry",
+			Value:  quay.DefaultRegistry,
+			EnvVar: "PLUGIN_REGISTRY",
+		},
+		cli.StringFlag{
+			Name:   "robot-account",
+			Usage:  "quay robot account, <namespace>+<name>, or username",
+			EnvVar: "PLUGIN_ROBOT_ACCOUNT,PLUGIN_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "robot-token",
+			Usage:  "quay robot account token or password",
+			EnvVar: "PLUGIN_ROBOT_TOKEN,PLUGIN_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:   "api-token",
+			Usage:  "quay OAuth access token used to apply the visibility and team permissions of new repositories",
+			EnvVar: "PLUGIN_API_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "visibility",
+			Usage:  "visibility of new repositories, public or private",
+			EnvVar: "PLUGIN_VISIBILITY",
+		},
+		cli.StringSliceFlag{
+			Name:   "team-permissions",
+			Usage:  "team:role permissions granted on new repositories, role is read, write or admin",
+			EnvVar: "PLUGIN_TEAM_PERMISSIONS",
+		},
+		cli.BoolFlag{
+			Name:   "skip-tls-verify",
+			Usage:  "Skip registry tls verify",
+			EnvVar: "PLUGIN_SKIP_TLS_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "snapshot-mode",
+			Usage:  "Specify one of full, redo or time as snapshot mode",
+			Value:  "redo",
+			EnvVar: "PLUGIN_SNAPSHOT_MODE",
+		},
+		cli.StringFlag{
+			Name:   "cache-repo",
+			Usage:  "Remote repository that will be used to store cached layers. enable-cache needs to be set to use this flag",
+			EnvVar: "PLUGIN_CACHE_REPO",
+		},
+		cli.StringFlag{
+			Name:   "registry-auths",
+			Usage:  "JSON or YAML list of registry, username and password objects of additional registries, e.g. of base images",
+			EnvVar: "PLUGIN_REGISTRY_AUTHS",
+		},
+		cli.StringSliceFlag{
+			Name:   "artifact-publishers",
+			Usage:  "Destinations the artifact is additionally published to: files, http(s) URLs the artifact is POSTed to",
+			EnvVar: "PLUGIN_ARTIFACT_PUBLISHERS",
+		},
+		cli.StringFlag{
+			Name:   "ledger",
+			Usage:  "JSON Lines ledger each build is recorded in, a file on a mounted volume",
+			EnvVar: "PLUGIN_LEDGER",
+		},
+		cli.StringFlag{
+			Name:   "vault-path",
+			Usage:  "Vault secret whose keys set the plugin settings of the same names, e.g. secret/data/drone/registry with username and password",
+			EnvVar: "PLUGIN_VAULT_PATH",
+		},
+	}, command.Flags()...)
+
+	if err := app.Run(os.Args); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, c *cli.Context) error {
+	if err := command.Setup(ctx, c, userAgent(c)); err != nil {
+		return err
+	}
+	username := c.String("robot-account")
+	noPush := c.Bool("no-push")
+	repo := buildRepo(c.String("registry"), c.String("repo"))
+
+	if strings.Contains(username, "+") {
+		if err := validateRobotAccount(username, repo); err != nil {
+			return err
+		}
+	}
+
+	// only setup auth when pushing or credentials are defined
+	if !noPush || username != "" {
+		if err := createDockerCfgFile(username, c.String("robot-token"), c.String("registry")); err != nil {
+			return err
+		}
+	}
+
+	setup, err := newRepoSetup(c, repo)
+	if err != nil {
+		return err
+	}
+
+	if err := command.AddAuths(c); err != nil {
+		return err
+	}
+
+	build := command.Build(c)
+	build.Repo = repo
+	build.CacheRepo = buildRepo(c.String("registry"), c.String("cache-repo"))
+	build.CacheFrom = buildRepos(c.String("registry"), c.StringSlice("cache-from"))
+	plugin := kaniko.Plugin{
+		Build: build,
+		Artifact: kaniko.Artifact{
+			Tags:         c.StringSlice("tags"),
+			Repo:         repo,
+			Registry:     c.String("registry"),
+			ArtifactFile: c.String("artifact-file"),
+			Format:       c.String("artifact-format"),
+			Publishers:   c.StringSlice("artifact-publishers"),
+			Headers:      c.StringSlice("artifact-publish-headers"),
+			RegistryType: artifact.Docker,
+		},
+		Promotion:  command.Promotion(c),
+		UserAgent:  userAgent(c),
+		MaskValues: command.MaskValues(c, "robot-token", "api-token"),
+		Context:    ctx,
+	}
+	if err := setup.check(ctx); err != nil {
+		return err
+	}
+	if err := plugin.Exec(); err != nil {
+		return err
+	}
+	return setup.apply(ctx)
+}
+
+// repoSetup applies the visibility and team permissions to the repository
+// after the first push, i.e. when it didn't exist before the build.
+type repoSetup struct {
+	client      *quay.Client
+	repo        string // Repository path, namespace/name
+	visibility  string
+	permissions []quay.TeamPermission
+	created     bool
+}
+
+// newRepoSetup returns the setup of the repository, nil when neither the
+// visibility nor team permissions are set or the image isn't pushed.
+func newRepoSetup(c *cli.Context, repo string) (*repoSetup, error) {
+	visibility := c.String("visibility")
+	teams := c.StringSlice("team-permissions")
+	if visibility == "" && len(teams) == 0 || c.Bool("no-push") {
+		return nil, nil
+	}
+	if c.Bool("discover") {
+		return nil, fmt.Errorf("visibility and team-permissions are not supported with discover")
+	}
+	if c.String("api-token") == "" {
+		return nil, fmt.Errorf("api-token must be specified to set the visibility or team permissions, robot accounts cannot use the Quay API")
+	}
+	if visibility != "" && visibility != quay.VisibilityPublic && visibility != quay.VisibilityPrivate {
+		return nil, fmt.Errorf("invalid visibility %s, must be %s or %s", visibility, quay.VisibilityPublic, quay.VisibilityPrivate)
+	}
+	var permissions []quay.TeamPermission
+	for _, team := range teams {
+		permission, err := quay.ParseTeamPermission(team)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	parsed, err := registry.ParseRepository(repo)
+	if err != nil {
+		return nil, err
+	}
+	return &repoSetup{
+		client: &quay.Client{
+			Registry:  parsed.Registry,
+			Token:     c.String("api-token"),
+			UserAgent: userAgent(c),
+		},
+		repo:        parsed.Name,
+		visibility:  visibility,
+		permissions: permissions,
+	}, nil
+}
+
+// check records whether the repository is created by the build.
+func (s *repoSetup) check(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	exists, err := s.client.Exists(ctx, s.repo)
+	if err != nil {
+		return err
+	}
+	s.created = !exists
+	if exists {
+		fmt.Printf("Repository %s exists, its visibility and team permissions are left unchanged\n", s.repo)
+	}
+	return nil
+}
+
+// apply sets the visibility and team permissions of created repositories.
+func (s *repoSetup) apply(ctx context.Context) error {
+	if s == nil || !s.created {
+		return nil
+	}
+	// Skipped builds, e.g. by trigger-paths, don't create the repository
+	if exists, err := s.client.Exists(ctx, s.repo); err != nil || !exists {
+		return err
+	}
+	if s.visibility != "" {
+		if err := s.client.SetVisibility(ctx, s.repo, s.visibility); err != nil {
+			return err
+		}
+		fmt.Printf("Made repository %s %s\n", s.repo, s.visibility)
+	}
+	for _, permission := range s.permissions {
+		if err := s.client.SetTeamPermission(ctx, s.repo, permission); err != nil {
+			return err
+		}
+		fmt.Printf("Granted team %s %s on repository %s\n", permission.Team, permission.Role, s.repo)
+	}
+	return nil
+}
+
+// validateRobotAccount checks that the robot account belongs to the
+// namespace of the repository, which it can't push to otherwise.
+func validateRobotAccount(username, repo string) error {
+	parsed, err := registry.ParseRepository(repo)
+	if err != nil {
+		return err
+	}
+	return quay.ValidateRobotAccount(username, parsed.Name)
+}
+
+// Create the docker config file for authentication
+func createDockerCfgFile(username, password, registry string) error {
+	if username == "" {
+		return fmt.Errorf("Username must be specified")
+	}
+	if password == "" {
+		return fmt.Errorf("Password must be specified")
+	}
+	if registry == "" {
+		return fmt.Errorf("Registry must be specified")
+	}
+
+	dockerPath := filepath.Dir(docker.ConfigPath)
+	err := os.MkdirAll(dockerPath, 0700)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to create %s directory", dockerPath))
+	}
+
+	authBytes := []byte(fmt.Sprintf("%s:%s", username, password))
+	encodedString := base64.StdEncoding.EncodeToString(authBytes)
+	jsonBytes := []byte(fmt.Sprintf(`{"auths": {"%s": {"auth": "%s"}}}`, registry, encodedString))
+	err = ioutil.WriteFile(docker.ConfigPath, jsonBytes, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker config file")
+	}
+	return nil
+}
+
+func buildRepo(registry, repo string) string {
+	if repo == "" {
+		// No repo, e.g. no cache repo
+		return ""
+	}
+	if registry == "" {
+		// No custom registry, just return the repo name
+		return repo
+	}
+	// Trim off trailing slash to prevent double slash when combining with repo
+	registry = strings.TrimSuffix(registry, "/")
+	if strings.HasPrefix(repo, registry+"/") {
+		// Repo already includes the registry prefix
+		// For backward compatibility, we won't add the prefix again.
+		return repo
+	}
+	// Prefix the repo with the registry
+	return registry + "/" + repo
+}
+
+// userAgent identifies the plugin and the Drone build in registry requests.
+func userAgent(c *cli.Context) string {
+	return command.UserAgent(c, "drone-kaniko-quay", version)
+}
+
+// buildRepos prefixes each repo with the registry, see buildRepo.
+func buildRepos(registry string, repos []string) []string {
+	var out []string
+	for _, repo := range repos {
+		out = append(out, buildRepo(registry, repo))
+	}
+	return out
+}
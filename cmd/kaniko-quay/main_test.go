@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func Test_buildRepo(t *testing.T) {
+	tests := []struct {
+		name     string
+		registry string
+		repo     string
+		want     string
+	}{
+		{name: "namespace", registry: "quay.io", repo: "acme/app", want: "quay.io/acme/app"},
+		{name: "trailing_slash", registry: "quay.io/", repo: "acme/app", want: "quay.io/acme/app"},
+		{name: "registry", registry: "quay.io", repo: "quay.io/acme/app", want: "quay.io/acme/app"},
+		{name: "empty", registry: "quay.io", repo: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildRepo(tt.registry, tt.repo); got != tt.want {
+				t.Errorf("buildRepo(%q, %q) = %v, want %v", tt.registry, tt.repo, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateRobotAccount(t *testing.T) {
+	if err := validateRobotAccount("acme+ci", "quay.io/acme/app"); err != nil {
+		t.Errorf("validateRobotAccount() error = %v", err)
+	}
+	if err := validateRobotAccount("other+ci", "quay.io/acme/app"); err == nil {
+		t.Error("validateRobotAccount() of other namespace error = nil")
+	}
+	if err := validateRobotAccount("acme", "quay.io/acme/app"); err == nil {
+		t.Error("validateRobotAccount() of user error = nil")
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	kaniko "github.com/gexops/drone-kaniko"
+	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/command"
+	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/gexops/drone-kaniko/pkg/scaleway"
+)
+
+var (
+	version = "unknown"
+)
+
+func main() {
+	// Load env-file if it exists first
+	if env := os.Getenv("PLUGIN_ENV_FILE"); env != "" {
+		if err := godotenv.Load(env); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	app := cli.NewApp()
+	app.Name = "kaniko scaleway plugin"
+	app.Usage = "kaniko scaleway plugin"
+	app.Action = func(c *cli.Context) error {
+		defer docker.RemoveCredentials()
+		ctx, stop := command.Context()
+		defer stop()
+		return kaniko.WriteResult(c.String("output"), func() error {
+			return kaniko.ReportError(c.String("error-file"), run(ctx, c))
+		})
+	}
+	app.Version = version
+	app.Flags = append([]cli.Flag{
+		cli.StringFlag{
+			Name:   "repo",
+			Usage:  "docker repository, prefixed with the registry namespace",
+			EnvVar: "PLUGIN_REPO",
+		},
+		cli.StringFlag{
+			Name:   "registry",
+			Usage:  "scaleway container registry, defaults to the registry of the region",
+			EnvVar: "PLUGIN_REGISTRY",
+		},
+		cli.StringFlag{
+			Name:   "region",
+			Usage:  "scaleway region, e.g. fr-par, nl-ams or pl-waw",
+			Value:  scaleway.DefaultRegion,
+			EnvVar: "PLUGIN_REGION",
+		},
+		cli.BoolFlag{
+			Name:   "create-namespace",
+			Usage:  "create the registry namespace of the repository as private namespace when missing",
+			EnvVar: "PLUGIN_CREATE_NAMESPACE",
+		},
+		cli.StringFlag{
+			Name:   "project-id",
+			Usage:  "scaleway project id new namespaces are created in",
+			EnvVar: "PLUGIN_PROJECT_ID,SCW_DEFAULT_PROJECT_ID",
+		},
+		cli.StringFlag{
+			Name:   "secret-key",
+			Usage:  "scaleway secret key of an api key with container registry permissions",
+			EnvVar: "PLUGIN_SECRET_KEY,PLUGIN_PASSWORD,SCW_SECRET_KEY",
+		},
+		cli.BoolFlag{
+			Name:   "skip-tls-verify",
+			Usage:  "Skip registry tls verify",
+			EnvVar: "PLUGIN_SKIP_TLS_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "snapshot-mode",
+			Usage:  "Specify one of full, redo or time as snapshot mode",
+			Value:  "redo",
+			EnvVar: "PLUGIN_SNAPSHOT_MODE",
+		},
+		cli.StringFlag{
+			Name:   "cache-repo",
+			Usage:  "Remote repository that will be used to store cached layers. enable-cache needs to be set to use this flag",
+			EnvVar: "PLUGIN_CACHE_REPO",
+		},
+		cli.StringFlag{
+			Name:   "registry-auths",
+			Usage:  "JSON or YAML list of registry, username and password objects of additional registries, e.g. of base images",
+			EnvVar: "PLUGIN_REGISTRY_AUTHS",
+		},
+		cli.StringSliceFlag{
+			Name:   "artifact-publishers",
+			Usage:  "Destinations the artifact is additionally published to: files, http(s) URLs the artifact is POSTed to",
+			EnvVar: "PLUGIN_ARTIFACT_PUBLISHERS",
+		},
+		cli.StringFlag{
+			Name:   "ledger",
+			Usage:  "JSON Lines ledger each build is recorded in, a file on a mounted volume",
+			EnvVar: "PLUGIN_LEDGER",
+		},
+		cli.StringFlag{
+			Name:   "vault-path",
+			Usage:  "Vault secret whose keys set the plugin settings of the same names, e.g. secret/data/drone/registry with username and password",
+			EnvVar: "PLUGIN_VAULT_PATH",
+		},
+	}, command.Flags()...)
+
+	if err := app.Run(os.Args); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, c *cli.Context) error {
+	if err := command.Setup(ctx, c, userAgent(c)); err != nil {
+		return err
+	}
+	region := strings.ToLower(c.String("region"))
+	reg := c.String("registry")
+	if reg == "" {
+		var err error
+		if reg, err = scaleway.Registry(region); err != nil {
+			return err
+		}
+	}
+	secretKey := c.String("secret-key")
+	noPush := c.Bool("no-push")
+
+	// only setup auth when pushing or credentials are defined
+	if !noPush || secretKey != "" {
+		if secretKey == "" {
+			return fmt.Errorf("Secret key must be specified")
+		}
+		if err := createDockerCfgFile(scaleway.TokenUsername, secretKey, reg); err != nil {
+			return err
+		}
+	}
+
+	if c.Bool("create-namespace") && !noPush {
+		if err := createNamespace(ctx, c, region, reg, secretKey); err != nil {
+			return err
+		}
+	}
+
+	if err := command.AddAuths(c); err != nil {
+		return err
+	}
+
+	build := command.Build(c)
+	build.Repo = buildRepo(reg, c.String("repo"))
+	build.CacheRepo = buildRepo(reg, c.String("cache-repo"))
+	build.CacheFrom = buildRepos(reg, c.StringSlice("cache-from"))
+	plugin := kaniko.Plugin{
+		Build: build,
+		Artifact: kaniko.Artifact{
+			Tags:         c.StringSlice("tags"),
+			Repo:         buildRepo(reg, c.String("repo")),
+			Registry:     reg,
+			ArtifactFile: c.String("artifact-file"),
+			Format:       c.String("artifact-format"),
+			Publishers:   c.StringSlice("artifact-publishers"),
+			Headers:      c.StringSlice("artifact-publish-headers"),
+			RegistryType: artifact.Docker,
+		},
+		Promotion:  command.Promotion(c),
+		UserAgent:  userAgent(c),
+		MaskValues: command.MaskValues(c, "secret-key"),
+		Context:    ctx,
+	}
+	return plugin.Exec()
+}
+
+// createNamespace creates the registry namespace of the repository unless
+// it exists, since pushing to a missing namespace fails.
+func createNamespace(ctx context.Context, c *cli.Context, region, reg, secretKey string) error {
+	if c.Bool("discover") {
+		return fmt.Errorf("create-namespace is not supported with discover")
+	}
+	namespace, err := scaleway.Namespace(strings.TrimPrefix(buildRepo(reg, c.String("repo")), strings.TrimSuffix(reg, "/")+"/"))
+	if err != nil {
+		return err
+	}
+	client := &scaleway.Client{SecretKey: secretKey, UserAgent: userAgent(c)}
+	created, err := client.EnsureNamespace(ctx, region, namespace, c.String("project-id"))
+	if err != nil {
+		return err
+	}
+	if created {
+		fmt.Fprintf(os.Stdout, "Created namespace %s\n", namespace)
+	}
+	return nil
+}
+
+// Create the docker config file for authentication
+func createDockerCfgFile(username, password, registry string) error {
+	if username == "" {
+		return fmt.Errorf("Username must be specified")
+	}
+	if password == "" {
+		return fmt.Errorf("Password must be specified")
+	}
+	if registry == "" {
+		return fmt.Errorf("Registry must be specified")
+	}
+
+	dockerPath := filepath.Dir(docker.ConfigPath)
+	err := os.MkdirAll(dockerPath, 0700)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to create %s directory", dockerPath))
+	}
+
+	authBytes := []byte(fmt.Sprintf("%s:%s", username, password))
+	encodedString := base64.StdEncoding.EncodeToString(authBytes)
+	jsonBytes := []byte(fmt.Sprintf(`{"auths": {"%s": {"auth": "%s"}}}`, registry, encodedString))
+	err = ioutil.WriteFile(docker.ConfigPath, jsonBytes, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker config file")
+	}
+	return nil
+}
+
+// buildRepo prefixes the repo with the registry.
+func buildRepo(registry, repo string) string {
+	if repo == "" {
+		// No repo, e.g. no cache repo
+		return ""
+	}
+	// Trim off trailing slash to prevent double slash when combining with repo
+	registry = strings.TrimSuffix(registry, "/")
+	// Repos may already include the registry prefix
+	if strings.HasPrefix(repo, registry+"/") {
+		return repo
+	}
+	return registry + "/" + repo
+}
+
+// userAgent identifies the plugin and the Drone build in registry requests.
+func userAgent(c *cli.Context) string {
+	return command.UserAgent(c, "drone-kaniko-scaleway", version)
+}
+
+// buildRepos prefixes each repo with the registry, see buildRepo.
+func buildRepos(registry string, repos []string) []string {
+	var out []string
+	for _, repo := range repos {
+		out = append(out, buildRepo(registry, repo))
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package kaniko
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// requiredLabelEnv returns the environment variable providing the value of
+// the required label name, e.g. LABEL_COST_CENTER for cost-center.
+func requiredLabelEnv(name string) string {
+	return "LABEL_" + strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name))
+}
+
+// requiredLabels returns the required labels, prefixed with the required
+// label prefix, that the custom labels don't set. Their values are read from
+// the LABEL_<NAME> environment variables, falling back to the required labels
+// file, a YAML map of names to values. Labels without value fail the build.
+func (b Build) requiredLabels() ([]string, error) {
+	if len(b.RequiredLabels) == 0 {
+		return nil, nil
+	}
+	set := map[string]bool{}
+	for _, label := range b.Labels {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) == 2 && parts[1] != "" {
+			set[parts[0]] = true
+		}
+	}
+	values := map[string]string{}
+	if b.RequiredLabelsFile != "" {
+		content, err := ioutil.ReadFile(b.RequiredLabelsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read required labels file: %s", err)
+		}
+		if err := yaml.Unmarshal(content, &values); err != nil {
+			return nil, fmt.Errorf("failed to parse required labels file %s: %s", b.RequiredLabelsFile, err)
+		}
+	}
+
+	var labels, missing []string
+	for _, name := range b.RequiredLabels {
+		key := b.RequiredLabelPrefix + name
+		if set[key] {
+			continue
+		}
+		value, ok := os.LookupEnv(requiredLabelEnv(name))
+		if !ok || value == "" {
+			value = values[name]
+		}
+		if strings.TrimSpace(value) == "" {
+			missing = append(missing, fmt.Sprintf("%s (set %s, the label %s=<value> or %s in the required labels file)", key, requiredLabelEnv(name), key, name))
+			continue
+		}
+		labels = append(labels, key+"="+value)
+	}
+	if len(missing) != 0 {
+		return nil, fmt.Errorf("missing required labels: %s", strings.Join(missing, ", "))
+	}
+	return labels, nil
+}
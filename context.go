@@ -0,0 +1,50 @@
+package kaniko
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// remoteContext reports whether the build context is fetched by kaniko,
+// e.g. git://github.com/octocat/app.git#refs/heads/main, rather than a
+// directory in the workspace.
+func (b Build) remoteContext() bool {
+	return strings.Contains(b.Context, "://") && !strings.HasPrefix(b.Context, "dir://")
+}
+
+// contextArg returns the kaniko context of the build.
+func (b Build) contextArg() string {
+	if strings.Contains(b.Context, "://") {
+		return b.Context
+	}
+	return "dir://" + b.Context
+}
+
+// validateContext checks the context sub-path and rejects options that
+// read the Dockerfile or context from the workspace for remote contexts.
+func (b Build) validateContext() error {
+	if b.ContextSubPath != "" {
+		if path.IsAbs(b.ContextSubPath) || strings.HasPrefix(path.Clean(b.ContextSubPath), "..") {
+			return fmt.Errorf("context sub-path %s must be relative to the context", b.ContextSubPath)
+		}
+	}
+	if !b.remoteContext() {
+		return nil
+	}
+	if b.StrictMirrors {
+		return fmt.Errorf("strict mirror mode is not supported with the remote context %s", b.Context)
+	}
+	if b.SkipIdentical {
+		return fmt.Errorf("skip-identical is not supported with the remote context %s", b.Context)
+	}
+	if len(b.SecretFiles) != 0 {
+		return fmt.Errorf("secret files are not supported with the remote context %s", b.Context)
+	}
+	for _, platform := range b.targetPlatforms() {
+		if strings.HasPrefix(platform, windowsOS+"/") {
+			return fmt.Errorf("windows targets are not supported with the remote context %s", b.Context)
+		}
+	}
+	return nil
+}
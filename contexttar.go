@@ -0,0 +1,124 @@
+package kaniko
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tarballContext reports whether the build context is a tarball in the
+// workspace, e.g. context.tar or context.tar.gz.
+func (b Build) tarballContext() bool {
+	if strings.Contains(b.Context, "://") {
+		return false
+	}
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(b.Context, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractContext extracts the tarball context into a directory next to it,
+// so that the workspace volume, which kaniko excludes from snapshots, holds
+// it, and returns a function removing it. The Dockerfile
+// is read from the extracted context when the tarball contains it.
+func (b *Build) extractContext() (func(), error) {
+	dir, err := ioutil.TempDir(filepath.Dir(b.Context), ".context-")
+	if err != nil {
+		return func() {}, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	if err := extractTarball(b.Context, dir); err != nil {
+		cleanup()
+		return func() {}, fmt.Errorf("failed to extract context %s: %s", b.Context, err)
+	}
+	dockerfile := filepath.Join(dir, b.ContextSubPath, b.Dockerfile)
+	if _, err := os.Stat(dockerfile); err == nil && !filepath.IsAbs(b.Dockerfile) {
+		b.Dockerfile = dockerfile
+	}
+	b.Context = dir
+	return cleanup, nil
+}
+
+// extractTarball extracts the tarball, optionally gzipped, at path into dir.
+// Entries and symlinks escaping dir are rejected.
+func extractTarball(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("entry %s is outside of the context", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// Later entries could otherwise be written through the link
+			link := filepath.Join(filepath.Dir(name), filepath.FromSlash(hdr.Linkname))
+			if filepath.IsAbs(hdr.Linkname) || link == ".." || strings.HasPrefix(link, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("symlink %s points outside of the context", hdr.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		default:
+			// Hard links, devices and the like have no place in a context
+			return fmt.Errorf("entry %s has unsupported type %c", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
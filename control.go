@@ -0,0 +1,175 @@
+package kaniko
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// controlCancelPath is the path of the control endpoint that cancels builds.
+const controlCancelPath string = "/cancel"
+
+// controlGracePeriod is how long the executor has to exit after a cancel
+// request or the build timeout interrupted it, before it is killed.
+var controlGracePeriod = 10 * time.Second
+
+// errCanceled is returned by executor runs aborted by a cancel request.
+var errCanceled = errors.New("build canceled by a request to the control endpoint")
+
+// control is the opt-in local HTTP endpoint through which external watchdogs
+// cancel runaway builds: POST /cancel cancels the context of the build,
+// interrupting the running executor and any registry request, and aborts
+// the build with errCanceled.
+type control struct {
+	server *http.Server
+	socket string             // Path of the unix socket, removed on close
+	cancel context.CancelFunc // Cancels the context of the build
+	done   chan struct{}
+	once   sync.Once
+}
+
+// listenControl parses the control address, host:port on a loopback host
+// or unix:<path> of a unix socket.
+func listenControl(address string) (net.Listener, string, error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(strings.TrimPrefix(address, "unix:"), "//")
+		if path == "" {
+			return nil, "", fmt.Errorf("invalid control address %s, expected unix:<path>", address)
+		}
+		// Remove the socket left behind by an earlier build, if any
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to listen on the control socket")
+		}
+		return listener, path, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid control address %s, expected host:port or unix:<path>", address)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, "", fmt.Errorf("invalid control address %s, the host must be a loopback address", address)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to listen on the control address")
+	}
+	return listener, "", nil
+}
+
+// startControl serves the control endpoint on the address until closed.
+// Cancel requests call cancel.
+func startControl(address string, cancel context.CancelFunc) (*control, error) {
+	listener, socket, err := listenControl(address)
+	if err != nil {
+		return nil, err
+	}
+	c := &control{socket: socket, cancel: cancel, done: make(chan struct{})}
+	c.server = &http.Server{Handler: c}
+	go c.server.Serve(listener)
+	fmt.Fprintf(os.Stdout, "Accepting POST %s requests on %s\n", controlCancelPath, address)
+	return c, nil
+}
+
+func (c *control) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != controlCancelPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c.once.Do(func() {
+		fmt.Fprintf(os.Stdout, "Canceling the build on request of the control endpoint\n")
+		close(c.done)
+		c.cancel()
+	})
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "canceling build")
+}
+
+// withControl runs fn with the control endpoint of the ControlAddress, if
+// any, started and canceling the context of the build. Sub-builds share the
+// endpoint of their parent build.
+func (p Plugin) withControl(fn func(Plugin) error) error {
+	if p.Build.ControlAddress == "" || p.control != nil {
+		return fn(p)
+	}
+	ctx, cancel := context.WithCancel(p.stepContext())
+	defer cancel()
+	c, err := startControl(p.Build.ControlAddress, cancel)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	p.control = c
+	p.Context = ctx
+	return fn(p)
+}
+
+// canceled reports whether a cancel request has been received.
+func (c *control) canceled() bool {
+	if c == nil {
+		return false
+	}
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// runCommand runs the command until it exits or the context is done, in
+// which case it is interrupted and, after the grace period, killed.
+func runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	select {
+	case err := <-exited:
+		return err
+	case <-ctx.Done():
+	}
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case <-exited:
+	case <-time.After(controlGracePeriod):
+		fmt.Fprintf(os.Stderr, "kaniko executor did not exit within %s of being interrupted, killing it\n", controlGracePeriod)
+		cmd.Process.Kill()
+		<-exited
+	}
+	return ctx.Err()
+}
+
+// Close stops serving the control endpoint.
+func (c *control) Close() error {
+	err := c.server.Close()
+	if c.socket != "" {
+		os.Remove(c.socket)
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package kaniko
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlugin_controlCancel(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "executor")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\nexec sleep 30\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "control.sock")
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	p := Plugin{Build: Build{ExecutorPath: path, ControlAddress: "unix:" + socket}}
+	errc := make(chan error, 1)
+	var ctx context.Context
+	go func() {
+		errc <- p.withControl(func(p Plugin) error {
+			ctx = p.stepContext()
+			return p.runExecutor(nil)
+		})
+	}()
+
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Post("http://control/cancel", "", nil); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("cancel request failed: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("cancel status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+
+	select {
+	case err := <-errc:
+		if err != errCanceled {
+			t.Errorf("runExecutor() error = %v, want %v", err, errCanceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("build was not canceled")
+	}
+	if ctx.Err() == nil {
+		t.Error("expected the context of the build to be canceled")
+	}
+	if _, err := client.Get("http://control/cancel"); err == nil {
+		t.Error("expected the control endpoint to be closed after the build")
+	}
+}
+
+func TestPlugin_runExecutorStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := Plugin{Build: Build{ExecutorPath: "/nonexistent/executor", PullRetry: 2}, Context: ctx}
+	if err := p.runExecutor(nil); err != context.Canceled {
+		t.Errorf("runExecutor() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestListenControl(t *testing.T) {
+	for _, address := range []string{"0.0.0.0:8080", "example.com:8080", "8080", "unix:"} {
+		if l, _, err := listenControl(address); err == nil {
+			l.Close()
+			t.Errorf("listenControl(%q) error = nil", address)
+		}
+	}
+	l, _, err := listenControl("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
@@ -0,0 +1,79 @@
+package kaniko
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// digestPattern matches image digests.
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// validateExpectedDigest checks the expected digest, which is only defined
+// for builds of a single image.
+func (b Build) validateExpectedDigest() error {
+	if b.ExpectedDigest == "" {
+		return nil
+	}
+	if !digestPattern.MatchString(b.ExpectedDigest) {
+		return fmt.Errorf("invalid expected digest %s, expected sha256:<hex>", b.ExpectedDigest)
+	}
+	if b.Discover || len(b.TagArgs) != 0 || b.Release != "" {
+		return fmt.Errorf("expected digest is not supported with discover, tag build args or a release manifest")
+	}
+	if b.DigestFile == "" {
+		return fmt.Errorf("expected digest requires the digest file")
+	}
+	return nil
+}
+
+// verifyDigest fails unless the digest recorded in the digest file is the
+// expected digest, e.g. when verifying that a rebuild is reproducible.
+func (p Plugin) verifyDigest() error {
+	if p.Build.ExpectedDigest == "" {
+		return nil
+	}
+	digest := p.imageDigest()
+	if digest == "" {
+		return fmt.Errorf("failed to verify the image digest: no digest recorded in %s", p.Build.DigestFile)
+	}
+	image := "pushed"
+	if p.Build.NoPush {
+		image = "built"
+	}
+	if digest != p.Build.ExpectedDigest {
+		return fmt.Errorf("%s image digest %s does not match the expected digest %s", image, digest, p.Build.ExpectedDigest)
+	}
+	fmt.Fprintf(os.Stdout, "Digest of the %s image matches the expected digest %s\n", image, digest)
+	return nil
+}
+
+// exportDigest copies the digest recorded in the digest file to the extra
+// digest files and prints it as a DIGEST= line, since runners differ in
+// which of these locations later steps can read.
+func (p Plugin) exportDigest() {
+	if p.Build.DigestFile == "" || (len(p.Build.DigestFiles) == 0 && !p.Build.DigestStdout) {
+		return
+	}
+	content, err := ioutil.ReadFile(p.Build.DigestFile)
+	if err != nil {
+		p.warnf("failed to read digest file contents at path: %s with error: %s\n", p.Build.DigestFile, err)
+		return
+	}
+	digest := strings.TrimSpace(string(content))
+	for _, path := range p.Build.DigestFiles {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			p.warnf("failed to create directory of digest file at path: %s with error: %s\n", path, err)
+			continue
+		}
+		if err := ioutil.WriteFile(path, []byte(digest), 0644); err != nil {
+			p.warnf("failed to write digest file at path: %s with error: %s\n", path, err)
+		}
+	}
+	if p.Build.DigestStdout {
+		fmt.Fprintf(os.Stdout, "DIGEST=%s\n", digest)
+	}
+}
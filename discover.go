@@ -0,0 +1,73 @@
+package kaniko
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/changes"
+	"github.com/gexops/drone-kaniko/pkg/discover"
+)
+
+// execDiscovered builds an image for every discovered Dockerfile whose
+// directory changed in the commit range. Each image is pushed to a
+// repository named after its directory below the configured repository.
+func (p Plugin) execDiscovered() error {
+	services, err := discover.Services(p.Build.DiscoverRoot, p.Build.DiscoverPattern)
+	if err != nil {
+		return fmt.Errorf("failed to discover dockerfiles below %s: %s", p.Build.DiscoverRoot, err)
+	}
+	if len(services) == 0 {
+		return fmt.Errorf("no dockerfiles found below %s", p.Build.DiscoverRoot)
+	}
+
+	if changes.Known(p.Build.DroneCommitBefore, p.Build.DroneCommitSha) {
+		files, err := changes.Files("", p.Build.DroneCommitBefore, p.Build.DroneCommitSha)
+		if err != nil {
+			return fmt.Errorf("failed to detect changed files of the discovered dockerfiles: %s", err)
+		}
+		root, err := changes.Root("")
+		if err != nil {
+			return err
+		}
+		if services, err = discover.Changed(services, root, files); err != nil {
+			return err
+		}
+	}
+	if len(services) == 0 {
+		fmt.Fprintf(os.Stdout, "No discovered dockerfiles changed, skipping build\n")
+		return nil
+	}
+
+	for _, service := range services {
+		fmt.Fprintf(os.Stdout, "Building %s from %s\n", service.Dockerfile, service.Dir)
+		if err := p.forService(service).Exec(); err != nil {
+			return fmt.Errorf("failed to build %s: %s", service.Dockerfile, err)
+		}
+	}
+	return nil
+}
+
+// forService returns a copy of the plugin building a single discovered service.
+func (p Plugin) forService(service discover.Service) Plugin {
+	sub := p
+	sub.Build.Discover = false
+	sub.Build.TriggerPaths = nil
+	sub.Build.AddHosts = nil // Already written to /etc/hosts
+	sub.Build.Cleanup = true // The next service is built in the same container
+	sub.Build.Dockerfile = service.Dockerfile
+	sub.Build.Context = service.Dir
+	sub.Build.Repo = joinRepo(p.Build.Repo, service.Name)
+	sub.Artifact.Repo = joinRepo(p.Artifact.Repo, service.Name)
+	if service.Name != "" {
+		sub.Artifact = sub.Artifact.withSuffix(service.Name)
+	}
+	return sub
+}
+
+func joinRepo(repo, name string) string {
+	if name == "" {
+		return repo
+	}
+	return strings.TrimSuffix(repo, "/") + "/" + name
+}
@@ -0,0 +1,113 @@
+package kaniko
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/errreport"
+	"github.com/pkg/errors"
+)
+
+// Phases of the plugin reported on failure
+const (
+	PhaseSetup     string = "setup"     // Credential and repository setup by the commands, before Exec
+	PhaseValidate  string = "validate"  // Validation of the settings
+	PhasePreflight string = "preflight" // Registry auth preflight and cache seeding
+	PhaseBuild     string = "build"     // Kaniko build and push
+	PhasePublish   string = "publish"   // Artifacts, charts and notifications published after the push
+	PhasePromote   string = "promote"   // Image promotion
+)
+
+// pushFailureMarkers identify executor errors raised while pushing the image.
+var pushFailureMarkers = []string{
+	"error pushing image",
+	"failed to push",
+}
+
+// PhaseError is an error raised in a phase of Exec.
+type PhaseError struct {
+	Phase string
+	Err   error
+}
+
+func (e *PhaseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PhaseError) Unwrap() error {
+	return e.Err
+}
+
+// executorError is a failed kaniko executor run, with the tail of its output.
+type executorError struct {
+	err    error
+	output string
+}
+
+func (e *executorError) Error() string {
+	return e.err.Error()
+}
+
+func (e *executorError) Unwrap() error {
+	return e.err
+}
+
+// ReportError writes the error report of err to path, if both are set, and
+// returns err. Errors not raised by Exec are reported in the setup phase.
+func ReportError(path string, err error) error {
+	if path == "" || err == nil {
+		return err
+	}
+	if werr := errreport.Write(path, errorReport(err)); werr != nil {
+		fmt.Fprintf(os.Stderr, "failed to write error report to %s: %s\n", path, werr)
+	}
+	return err
+}
+
+// errorReport classifies err. Executor failures are classified by their
+// output and, unless caused by the registry, by whether the image pull,
+// the push or the build itself failed.
+func errorReport(err error) errreport.Report {
+	phase := PhaseSetup
+	var phaseErr *PhaseError
+	if errors.As(err, &phaseErr) {
+		phase = phaseErr.Phase
+	}
+
+	var execErr *executorError
+	if !errors.As(err, &execErr) {
+		report := errreport.Classify(phase, err, "")
+		if report.Category == errreport.CategoryInternal && (phase == PhaseSetup || phase == PhaseValidate) {
+			report.Category = errreport.CategoryConfig
+		}
+		return report
+	}
+
+	report := errreport.Classify(phase, err, execErr.output)
+	if line := lastErrorLine(execErr.output); line != "" {
+		report.Message = line
+	}
+	switch {
+	case report.Category != errreport.CategoryInternal:
+	case containsAny(execErr.output, pullFailureMarkers):
+		report.Category = errreport.CategoryPull
+	case containsAny(execErr.output, pushFailureMarkers):
+		report.Category = errreport.CategoryPush
+	default:
+		report.Category = errreport.CategoryBuild
+	}
+	return report
+}
+
+// lastErrorLine returns the last line of the executor output reporting an
+// error, which is more telling than the exit status.
+func lastErrorLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.Contains(strings.ToLower(lines[i]), "error") {
+			return strings.TrimSpace(lines[i])
+		}
+	}
+	return ""
+}
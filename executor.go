@@ -0,0 +1,309 @@
+package kaniko
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultExecutorPath is the kaniko executor binary of the plugin images.
+	defaultExecutorPath string = "/kaniko/executor"
+
+	// outputTailSize is how much executor output is kept to classify failures.
+	outputTailSize int = 64 << 10
+)
+
+// pullFailureMarkers identify executor errors raised while pulling images.
+var pullFailureMarkers = []string{
+	"retrieving image",
+	"failed to get filesystem from image",
+	"error fetching",
+	"unable to complete operation after",
+}
+
+// transientMarkers identify errors that are likely to succeed on retry.
+var transientMarkers = []string{
+	"TOOMANYREQUESTS",
+	"429 Too Many Requests",
+	"500 Internal Server Error",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+	"connection reset by peer",
+	"connection refused",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"unexpected EOF",
+	"context deadline exceeded",
+	"no such host",
+}
+
+// cacheCorruptionMarkers identify executor errors caused by corrupted or
+// incompatible cached layers, e.g. truncated uploads or layers cached by an
+// other kaniko version.
+var cacheCorruptionMarkers = []string{
+	"error verifying sha256 checksum",
+	"invalid checksum digest format",
+	"gzip: invalid header",
+	"flate: corrupt input",
+	"zlib: invalid header",
+	"archive/tar: invalid tar header",
+	"error while retrieving image from cache",
+	"failed to get cached image",
+}
+
+// cacheArgPrefixes are the executor arguments enabling and configuring the cache.
+var cacheArgPrefixes = []string{
+	"--cache=",
+	"--cache-repo=",
+	"--cache-dir=",
+	"--cache-copy-layers",
+	"--compressed-caching=",
+	"--cache-ttl=",
+}
+
+// executorVersionPattern matches the version printed by executor version.
+var executorVersionPattern = regexp.MustCompile(`Kaniko version\s*:\s*(\S+)`)
+
+// executorPath returns the kaniko executor binary, ExecutorPath if set.
+func (b Build) executorPath() string {
+	if b.ExecutorPath != "" {
+		return b.ExecutorPath
+	}
+	return defaultExecutorPath
+}
+
+// checkExecutor verifies the executor binary against the expected sha256
+// checksum and version, so that custom plugin images bundling patched or
+// newer executors fail early when they bundle the wrong one.
+func (b Build) checkExecutor() error {
+	path := b.executorPath()
+	if b.ExecutorChecksum != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open kaniko executor: %s", err)
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return fmt.Errorf("failed to read kaniko executor: %s", err)
+		}
+		want := strings.ToLower(strings.TrimPrefix(b.ExecutorChecksum, "sha256:"))
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			return fmt.Errorf("kaniko executor %s has checksum sha256:%s, want sha256:%s", path, got, want)
+		}
+	}
+	if b.ExecutorVersion != "" {
+		out, err := exec.Command(path, "version").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to get kaniko executor version: %s", err)
+		}
+		m := executorVersionPattern.FindStringSubmatch(string(out))
+		if m == nil {
+			return fmt.Errorf("failed to parse kaniko executor version from %q", strings.TrimSpace(string(out)))
+		}
+		if strings.TrimPrefix(m[1], "v") != strings.TrimPrefix(b.ExecutorVersion, "v") {
+			return fmt.Errorf("kaniko executor %s has version %s, want %s", path, m[1], b.ExecutorVersion)
+		}
+	}
+	return nil
+}
+
+// runExecutor runs the kaniko executor with the given arguments. Runs that
+// fail while pulling base images with a transient error are retried up to
+// PullRetry times with exponential backoff, and runs that fail on the cache
+// once more with the cache disabled.
+func (p Plugin) runExecutor(args []string) error {
+	backoff := p.Build.PullRetryBackoff
+	if backoff <= 0 {
+		backoff = 5 * time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		output, err := p.runExecutorOnce(args)
+		if err == nil || p.canceledErr() != nil {
+			return err
+		}
+		if _, ok := err.(*timeoutError); ok {
+			// A hung run is not retried, the next one would likely hang as well
+			return &executorError{err: err, output: output}
+		}
+		if p.Build.CacheRetry && cacheCorruption(output, args) {
+			fmt.Fprintf(os.Stdout, "Build failed on corrupted or incompatible cached layers, retrying with the cache disabled\n")
+			p.flagCachePrune()
+			return p.runExecutor(withoutCache(args))
+		}
+		if attempt >= p.Build.PullRetry || !transientPullFailure(output) {
+			return &executorError{err: err, output: output}
+		}
+		delay := backoff << uint(attempt)
+		fmt.Fprintf(os.Stdout, "Image pull failed with a transient error, retrying in %s (retry %d of %d)\n", delay, attempt+1, p.Build.PullRetry)
+		select {
+		case <-time.After(delay):
+		case <-p.stepContext().Done():
+			return p.canceledErr()
+		}
+	}
+}
+
+// runExecutorOnce runs the executor, returning the tail of its output.
+func (p Plugin) runExecutorOnce(args []string) (string, error) {
+	ctx := p.stepContext()
+	if p.Build.BuildTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Build.BuildTimeout)
+		defer cancel()
+	}
+
+	tail := &tailBuffer{size: outputTailSize}
+	cmd := exec.Command(p.Build.executorPath(), args...)
+	stdout, stderr := []io.Writer{os.Stdout, tail}, []io.Writer{os.Stderr, tail}
+	if p.cacheCounter != nil {
+		stdout, stderr = append(stdout, p.cacheCounter), append(stderr, p.cacheCounter)
+	}
+	cmd.Stdout = io.MultiWriter(stdout...)
+	cmd.Stderr = io.MultiWriter(stderr...)
+	var masked []*maskWriter
+	var traceOut io.Writer = os.Stdout
+	if len(p.maskedValues) != 0 {
+		masked = []*maskWriter{newMaskWriter(cmd.Stdout, p.maskedValues), newMaskWriter(cmd.Stderr, p.maskedValues)}
+		cmd.Stdout, cmd.Stderr = masked[0], masked[1]
+		traceOut = newMaskWriter(os.Stdout, p.maskedValues)
+	}
+	if p.Build.rootless() {
+		cmd.SysProcAttr = userNamespaceAttr()
+	}
+	trace(traceOut, cmd)
+
+	err := runCommand(ctx, cmd)
+	for _, w := range masked {
+		w.Flush()
+	}
+	if err := p.canceledErr(); err != nil {
+		return tail.String(), err
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return tail.String(), &timeoutError{timeout: p.Build.BuildTimeout}
+	}
+	return tail.String(), err
+}
+
+// timeoutError is returned by executor runs aborted after the BuildTimeout.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("kaniko executor timed out after %s", e.timeout)
+}
+
+// canceledErr returns errCanceled once the control endpoint canceled the
+// build and the error of the step context once it is otherwise done, e.g.
+// because the command was stopped.
+func (p Plugin) canceledErr() error {
+	if p.control.canceled() {
+		return errCanceled
+	}
+	return p.stepContext().Err()
+}
+
+// transientPullFailure reports whether the executor output shows an image
+// pull that failed with a transient error.
+func transientPullFailure(output string) bool {
+	return containsAny(output, pullFailureMarkers) && containsAny(output, transientMarkers)
+}
+
+// cacheCorruption reports whether the executor output of a run with the
+// given arguments shows a failure caused by the cache.
+func cacheCorruption(output string, args []string) bool {
+	for _, arg := range args {
+		if arg == "--cache=true" {
+			return containsAny(output, cacheCorruptionMarkers)
+		}
+	}
+	return false
+}
+
+// withoutCache returns the executor arguments without the cache arguments.
+func withoutCache(args []string) []string {
+	var out []string
+	for _, arg := range args {
+		cache := false
+		for _, prefix := range cacheArgPrefixes {
+			if strings.HasPrefix(arg, prefix) {
+				cache = true
+			}
+		}
+		if !cache {
+			out = append(out, arg)
+		}
+	}
+	return out
+}
+
+// flagCachePrune reports the cache repository and directory for pruning,
+// appending them to the cache prune file, if set, for later steps.
+func (p Plugin) flagCachePrune() {
+	var caches []string
+	for _, cache := range []string{p.Build.CacheRepo, p.Build.CacheDir} {
+		if cache != "" {
+			caches = append(caches, cache)
+		}
+	}
+	if len(caches) == 0 {
+		return
+	}
+	p.warnf("WARNING: the cache %s should be pruned\n", strings.Join(caches, " and "))
+	if p.Build.CachePruneFile == "" {
+		return
+	}
+	f, err := os.OpenFile(p.Build.CachePruneFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		p.warnf("failed to write cache prune file at path: %s with error: %s\n", p.Build.CachePruneFile, err)
+		return
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s\n", strings.Join(caches, "\n")); err != nil {
+		p.warnf("failed to write cache prune file at path: %s with error: %s\n", p.Build.CachePruneFile, err)
+	}
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// tailBuffer keeps the last size bytes written to it.
+type tailBuffer struct {
+	mu   sync.Mutex
+	size int
+	buf  []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.size {
+		t.buf = t.buf[len(t.buf)-t.size:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
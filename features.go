@@ -0,0 +1,193 @@
+package kaniko
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/dockerfile"
+)
+
+const (
+	dockerfileCheckEmulate string = "emulate"
+	dockerfileCheckStrict  string = "strict"
+	dockerfileCheckOff     string = "off"
+)
+
+// emulatedDockerfile is where the Dockerfile rewritten to emulate
+// unsupported features is written, outside of the build context.
+var emulatedDockerfile = "/kaniko/Dockerfile.emulated"
+
+// dockerfileFinding is a use of a Dockerfile feature kaniko does not support.
+type dockerfileFinding struct {
+	Line    int
+	Feature string
+	Hint    string // How to avoid the feature, for features that cannot be emulated
+	Drop    string // Flag removed from the Dockerfile to emulate the feature
+	Ignore  string // Path excluded from snapshots to emulate the feature
+	Secret  string // Secret written to Ignore to emulate a secret mount
+}
+
+func (f dockerfileFinding) emulated() bool {
+	return f.Hint == ""
+}
+
+// emulation is the result of emulating unsupported Dockerfile features.
+type emulation struct {
+	Dockerfile  string            // Dockerfile to build
+	IgnorePaths []string          // Paths to exclude from snapshots
+	Secrets     map[string]string // Ids of the secrets to write, by path
+}
+
+// unsupportedFeatures returns the BuildKit features used by the Dockerfile
+// that kaniko does not support. Mounts of the given secrets are emulated.
+func unsupportedFeatures(d *dockerfile.Dockerfile, secrets map[string]bool) []dockerfileFinding {
+	var findings []dockerfileFinding
+	for _, inst := range d.Instructions {
+		if inst.Heredoc {
+			findings = append(findings, dockerfileFinding{
+				Line:    inst.Line,
+				Feature: inst.Command + " heredoc",
+				Hint:    "move the script or file content into a file in the context and COPY it",
+			})
+		}
+		for _, flag := range inst.Flags {
+			if finding, ok := unsupportedFlag(inst.Command, flag, secrets); ok {
+				finding.Line = inst.Line
+				findings = append(findings, finding)
+			}
+		}
+	}
+	return findings
+}
+
+func unsupportedFlag(command, flag string, secrets map[string]bool) (dockerfileFinding, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(flag, "--"), "=", 2)
+	name, value := parts[0], ""
+	if len(parts) == 2 {
+		value = parts[1]
+	}
+
+	switch {
+	case command == "RUN" && name == "mount":
+		options := map[string]string{"type": "bind"}
+		for _, option := range strings.Split(value, ",") {
+			kv := strings.SplitN(option, "=", 2)
+			if len(kv) == 2 {
+				options[kv[0]] = kv[1]
+			}
+		}
+		target := options["target"]
+		if target == "" {
+			target = options["dst"]
+		}
+		finding := dockerfileFinding{Feature: fmt.Sprintf("RUN --mount=type=%s", options["type"])}
+		switch options["type"] {
+		case "cache", "tmpfs":
+			// The mount target is left out of the image, as with BuildKit
+			finding.Drop, finding.Ignore = flag, target
+		case "secret":
+			// As with BuildKit, the id defaults to the target name and the
+			// target to /run/secrets/<id>
+			id := options["id"]
+			if id == "" {
+				id = path.Base(target)
+			}
+			if target == "" {
+				target = path.Join(secretsDir, id)
+			}
+			switch {
+			case options["env"] != "":
+				finding.Hint = "mount the secret as a file instead of an environment variable"
+			case secrets[id]:
+				finding.Drop, finding.Ignore, finding.Secret = flag, target, id
+			case options["required"] == "true":
+				finding.Hint = fmt.Sprintf("add the secret %s to the secret files", id)
+			default:
+				// Optional secrets that are not provided are absent
+				finding.Drop = flag
+			}
+		case "ssh":
+			finding.Hint = "kaniko cannot forward an SSH agent, fetch private dependencies in an earlier step"
+		default:
+			finding.Hint = "COPY the files into the stage instead"
+		}
+		return finding, true
+	case command == "RUN" && name == "network":
+		// RUN steps use the network of the plugin
+		if value == "default" || value == "host" {
+			return dockerfileFinding{Feature: "RUN " + flag, Drop: flag}, true
+		}
+		return dockerfileFinding{Feature: "RUN " + flag, Hint: "RUN steps always use the network of the plugin"}, true
+	case command == "RUN" && name == "security":
+		return dockerfileFinding{Feature: "RUN " + flag, Hint: "kaniko runs every step with the privileges of the plugin"}, true
+	case (command == "COPY" || command == "ADD") && name == "link":
+		// --link only changes how layers are cached, not their contents
+		return dockerfileFinding{Feature: command + " --link", Drop: flag}, true
+	}
+	return dockerfileFinding{}, false
+}
+
+// checkDockerfile reports the unsupported features used by the Dockerfile.
+// In emulate mode, features that can be emulated are rewritten into a copy
+// of the Dockerfile, which is returned along with the paths to exclude from
+// snapshots; the other features fail the build before it starts. Mounts of
+// the secret files are emulated in strict mode as well.
+func (b Build) checkDockerfile() (emulation, error) {
+	result := emulation{Dockerfile: b.Dockerfile, Secrets: map[string]string{}}
+	content, err := ioutil.ReadFile(b.Dockerfile)
+	if err != nil {
+		return result, err
+	}
+	d, err := dockerfile.Parse(strings.NewReader(string(content)))
+	if err != nil {
+		return result, fmt.Errorf("failed to parse %s: %s", b.Dockerfile, err)
+	}
+	secrets, err := b.secretFiles()
+	if err != nil {
+		return result, err
+	}
+	provided := map[string]bool{}
+	for id := range secrets {
+		provided[id] = true
+	}
+
+	var (
+		unsupported []string
+		rewritten   = string(content)
+	)
+	for _, finding := range unsupportedFeatures(d, provided) {
+		location := fmt.Sprintf("%s:%d: %s", b.Dockerfile, finding.Line, finding.Feature)
+		if !finding.emulated() {
+			unsupported = append(unsupported, fmt.Sprintf("%s is not supported by kaniko, %s", location, finding.Hint))
+			continue
+		}
+		if b.DockerfileCheck == dockerfileCheckStrict && finding.Secret == "" {
+			unsupported = append(unsupported, fmt.Sprintf("%s is not supported by kaniko", location))
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%s is not supported by kaniko, emulating it\n", location)
+		rewritten = regexp.MustCompile(`(\s)`+regexp.QuoteMeta(finding.Drop)+`(\s|$)`).ReplaceAllString(rewritten, "$1$2")
+		if finding.Ignore != "" {
+			result.IgnorePaths = append(result.IgnorePaths, finding.Ignore)
+		}
+		if finding.Secret != "" {
+			result.Secrets[finding.Ignore] = finding.Secret
+		}
+	}
+	if len(unsupported) != 0 {
+		return result, fmt.Errorf("%s", strings.Join(unsupported, "\n"))
+	}
+
+	if rewritten == string(content) {
+		return result, nil
+	}
+	if err := ioutil.WriteFile(emulatedDockerfile, []byte(rewritten), 0644); err != nil {
+		return result, err
+	}
+	result.Dockerfile = emulatedDockerfile
+	return result, nil
+}
@@ -0,0 +1,58 @@
+package kaniko
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/helm"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// helmChart loads the chart and returns the repository it is pushed to:
+// the chart name below HelmRepo in the registry of the image.
+func (b Build) helmChart() (*helm.Chart, registry.Repository, error) {
+	if b.HelmRepo == "" {
+		return nil, registry.Repository{}, fmt.Errorf("helm repo must be specified to push the chart %s", b.HelmChart)
+	}
+	chart, err := helm.Load(b.HelmChart)
+	if err != nil {
+		return nil, registry.Repository{}, fmt.Errorf("invalid helm chart %s: %s", b.HelmChart, err)
+	}
+	image, err := registry.ParseRepository(b.Repo)
+	if err != nil {
+		return nil, registry.Repository{}, err
+	}
+	repo := registry.Repository{Registry: image.Registry, Name: strings.Trim(b.HelmRepo, "/") + "/" + chart.Name()}
+	return chart, repo, nil
+}
+
+// pushHelmChart sets the tag and digest of the pushed image in the chart
+// values, packages the chart and pushes it to the registry of the image.
+func (p Plugin) pushHelmChart(tags []string) error {
+	chart, repo, err := p.Build.helmChart()
+	if err != nil {
+		return err
+	}
+	if p.Build.HelmChartVersion != "" {
+		chart.SetVersion(p.Build.HelmChartVersion)
+	}
+
+	values := map[string]string{}
+	if p.Build.HelmTagKey != "" && len(tags) != 0 {
+		values[p.Build.HelmTagKey] = tags[0]
+	}
+	if digest := p.imageDigest(); p.Build.HelmDigestKey != "" && digest != "" {
+		values[p.Build.HelmDigestKey] = digest
+	}
+	if err := chart.SetValues(values); err != nil {
+		return err
+	}
+
+	digest, err := chart.Push(p.stepContext(), p.registryClient(), repo)
+	if err != nil {
+		return fmt.Errorf("failed to push helm chart to %s: %s", repo, err)
+	}
+	fmt.Fprintf(os.Stdout, "Pushed helm chart %s:%s@%s\n", repo, chart.Version(), digest)
+	return nil
+}
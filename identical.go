@@ -0,0 +1,52 @@
+package kaniko
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// retagIdentical looks up an image previously built from identical inputs,
+// stored under keyTag, and tags it with labels instead of rebuilding. It
+// reports whether the build can be skipped. Lookup failures are logged and
+// fall back to a regular build, while a failure to tag the image is returned:
+// the tags moved before it would otherwise be left pointing at the old image
+// until the rebuild, if it succeeds at all.
+func (p Plugin) retagIdentical(keyTag string, labels []string) (bool, error) {
+	repo, err := registry.ParseRepository(p.Build.Repo)
+	if err != nil {
+		p.warnf("failed to parse repository %s, building image: %s\n", p.Build.Repo, err)
+		return false, nil
+	}
+
+	ctx := p.stepContext()
+	client := p.registryClient()
+	if _, found, err := client.HeadManifest(ctx, repo, keyTag); err != nil || !found {
+		if err != nil {
+			p.warnf("failed to look up %s:%s, building image: %s\n", repo, keyTag, err)
+		}
+		return false, nil
+	}
+
+	manifest, err := client.GetManifest(ctx, repo, keyTag)
+	if err != nil {
+		p.warnf("failed to fetch %s:%s, building image: %s\n", repo, keyTag, err)
+		return false, nil
+	}
+	fmt.Fprintf(os.Stdout, "Found image with identical build inputs at %s:%s, skipping build\n", repo, keyTag)
+	for _, label := range labels {
+		if _, err := client.PutManifest(ctx, repo, label, manifest); err != nil {
+			return false, fmt.Errorf("failed to tag %s:%s with the image of %s:%s: %s", repo, label, repo, keyTag, err)
+		}
+		fmt.Fprintf(os.Stdout, "Tagged %s:%s\n", repo, label)
+	}
+
+	if p.Build.DigestFile != "" {
+		if err := ioutil.WriteFile(p.Build.DigestFile, []byte(manifest.Digest), 0644); err != nil {
+			p.warnf("failed to write digest file at path: %s with error: %s\n", p.Build.DigestFile, err)
+		}
+	}
+	return true, nil
+}
@@ -1,261 +1,717 @@
 package kaniko
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/exec"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/buildkey"
+	"github.com/gexops/drone-kaniko/pkg/cachestats"
+	"github.com/gexops/drone-kaniko/pkg/changes"
+	"github.com/gexops/drone-kaniko/pkg/cosign"
+	"github.com/gexops/drone-kaniko/pkg/dns"
+	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/gexops/drone-kaniko/pkg/ledger"
+	"github.com/gexops/drone-kaniko/pkg/registry"
 	"github.com/gexops/drone-kaniko/pkg/tagger"
-	"golang.org/x/mod/semver"
+	"github.com/pkg/errors"
 )
 
 type (
 	// Build defines Docker build parameters.
 	Build struct {
-		DroneCommitRef  string   // Drone git commit reference
-		DroneRepoBranch string   // Drone repo branch
-		Dockerfile      string   // Docker build Dockerfile
-		Context         string   // Docker build context
-		Tags            []string // Docker build tags
-		AutoTag         bool     // Set this to auto detect tags from git commits and semver-tagged labels
-		AutoTagSuffix   string   // Suffix to append to the auto detect tags
-		ExpandTag       bool     // Set this to expand the `Tags` into semver-tagged labels
-		Args            []string // Docker build args
-		Target          string   // Docker build target
-		Repo            string   // Docker build repository
-		Mirrors         []string // Docker repository mirrors
-		Labels          []string // Label map
-		SkipTlsVerify   bool     // Docker skip tls certificate verify for registry
-		SnapshotMode    string   // Kaniko snapshot mode
-		EnableCache     bool     // Whether to enable kaniko cache
-		CacheDir		string   // Set this flag to specify a local directory cache for base images. Defaults to /cache.
-		CacheCopyLayers	bool	 // Set this flag to cache copy layers. Defaults to false
-		CacheNoCompress	bool	 // Set this to true in order to prevent tar compression for cached layers. Defaults to false.
-		CacheRepo       string   // Remote repository that will be used to store cached layers
-		CacheTTL        int      // Cache timeout in hours
-		DigestFile      string   // Digest file location
-		NoPush          bool     // Set this flag if you only want to build the image, without pushing to a registry
-		Verbosity       string   // Log level
-		UseNewRun 		bool 	 // experimental run implementation for detecting changes without requiring file system snapshots. In some cases, this may improve build performance by 75%
-		Platform        string   // Allows to build with another default platform than the host, similarly to docker build --platform
+		DroneCommitRef      string        // Drone git commit reference
+		DroneRepoBranch     string        // Drone repo branch
+		DroneCommitBefore   string        // Drone previous commit sha of the push
+		DroneCommitSha      string        // Drone commit sha
+		Dockerfile          string        // Docker build Dockerfile
+		Context             string        // Docker build context, a workspace directory, a tarball or a kaniko context URL such as git://
+		ContextSubPath      string        // Sub-path of the context used as build context, e.g. services/api
+		Tags                []string      // Docker build tags
+		AutoTag             bool          // Set this to auto detect tags from git commits and semver-tagged labels
+		AutoTagSuffix       string        // Suffix to append to the auto detect tags
+		ExpandTag           bool          // Set this to expand the `Tags` into semver-tagged labels
+		Taggers             []string      // Registered taggers applied after auto-tag and expand-tag
+		Args                []string      // Docker build args
+		TagArgs             []string      // Build args of individual tags built in separate runs, as tag:NAME=value
+		Target              string        // Docker build target
+		Repo                string        // Docker build repository
+		Mirrors             []string      // Docker repository mirrors
+		StrictMirrors       bool          // Fail when base images would be pulled from registries other than the mirrors
+		Labels              []string      // Label map
+		RetentionLabels     []string      // Retention labels read by registry cleanup jobs, as key=value
+		RetentionPresets    bool          // Add the retention labels preset for the Drone event
+		RequiredLabels      []string      // Names of org-mandated labels the image must carry, e.g. owner or cost-center
+		RequiredLabelPrefix string        // Prefix of the required labels, e.g. com.example.
+		RequiredLabelsFile  string        // YAML file with the values of required labels not set otherwise
+		DroneBuildEvent     string        // Drone build event, e.g. push, pull_request or tag
+		SkipTlsVerify       bool          // Docker skip tls certificate verify for registry
+		SnapshotMode        string        // Kaniko snapshot mode
+		SingleSnapshot      bool          // Take a single snapshot of the filesystem at the end of the build
+		IgnorePaths         []string      // Paths excluded from snapshots
+		IncludeVarRun       bool          // Include /var/run in snapshots
+		Cleanup             bool          // Clean up the filesystem after the build, for further kaniko runs in the container
+		DockerfileCheck     string        // Check the Dockerfile for features kaniko does not support: emulate, strict or off
+		SecretFiles         []string      // Secrets mounted by RUN --mount=type=secret steps, as id=path, id=file:path or id=env:NAME
+		EnableCache         bool          // Whether to enable kaniko cache
+		CacheDir            string        // Set this flag to specify a local directory cache for base images. Defaults to /cache.
+		CacheCopyLayers     bool          // Set this flag to cache copy layers. Defaults to false
+		CacheNoCompress     bool          // Set this to true in order to prevent tar compression for cached layers. Defaults to false.
+		CacheRetry          bool          // Retry builds failing on corrupted or incompatible cached layers once with the cache disabled
+		CachePruneFile      string        // File the caches of such builds are appended to for pruning
+		CacheRepo           string        // Remote repository that will be used to store cached layers
+		CacheFrom           []string      // Cache repositories whose cached layers are used, in order, when missing in CacheRepo
+		CacheStats          bool          // Record cache hits and the last use of cached layers as metadata in CacheRepo
+		CacheTTL            int           // Cache timeout in hours
+		DigestFile          string        // Digest file location
+		DigestFiles         []string      // Additional files, e.g. in the workspace, the digest is copied to
+		DigestStdout        bool          // Print the digest as a DIGEST=sha256:... line
+		ExpectedDigest      string        // Digest the image must have, e.g. of a previous hermetic build, failing the build otherwise
+		NoPush              bool          // Set this flag if you only want to build the image, without pushing to a registry
+		Verbosity           string        // Log level
+		UseNewRun           bool          // experimental run implementation for detecting changes without requiring file system snapshots. In some cases, this may improve build performance by 75%
+		Platform            string        // Allows to build with another default platform than the host, similarly to docker build --platform
+		Platforms           []string      // Platforms to build and publish under a single multi-platform index
+		PlatformFields      []string      // Index descriptor fields of platforms, as platform:field=value, e.g. linux/arm:variant=v7
+		OCIArtifacts        []string      // Workspace files, as path or path:mediatype, pushed as an OCI artifact referring to the image
+		OCIArtifactsTag     string        // Tag of the OCI artifact, defaults to the first image tag with a -files suffix
+		OCIArtifactType     string        // Artifact type of the OCI artifact
+		HelmChart           string        // Chart directory packaged and pushed after the image
+		HelmRepo            string        // Repository path in the image registry the chart is pushed below
+		HelmChartVersion    string        // Overrides the chart version
+		HelmTagKey          string        // Values key set to the image tag, e.g. image.tag
+		HelmDigestKey       string        // Values key set to the image digest, e.g. image.digest
+		PatchFiles          []string      // Workspace manifests patched with the pushed image reference, as file[:path[=value]]
+		PRComment           bool          // Comment the pushed image reference on the originating pull request
+		CommitStatus        bool          // Set a commit status with the pushed image reference
+		SCMProvider         string        // github or gitlab, detected from DroneRepoLink when empty
+		SCMURL              string        // API endpoint of the scm provider
+		SCMToken            string        // Token used to comment and set commit statuses
+		DroneRepo           string        // Drone repository, e.g. octocat/hello-world
+		DroneRepoLink       string        // Drone repository link
+		DronePullRequest    int           // Drone pull request number
+		DroneBuildLink      string        // Drone build link
+		DroneBuildNumber    string        // Drone build number
+		DroneStageName      string        // Drone pipeline stage name
+		DroneStepName       string        // Drone pipeline step name
+		DroneStageMachine   string        // Drone machine running the stage
+		DroneRunnerHostname string        // Drone runner hostname
+		CIAnnotations       bool          // Annotate the pushed manifest with the Drone build metadata
+		Annotations         []string      // Annotations of the pushed manifest, as key=value
+		SkipIdentical       bool          // Retag an existing image built from identical inputs instead of rebuilding
+		TriggerPaths        []string      // Only build when files matching these globs changed in the pushed commit range
+		AssertEntrypoint    string        // Expected image entrypoint, as JSON array or space separated words
+		AssertPorts         []string      // Ports the image must expose
+		AssertEnv           []string      // Environment variables the image must set, as NAME or NAME=value
+		AssertLabels        []string      // Labels the image must set, as key or key=value
+		SecretScan          string        // Scan the final image for secrets and either warn or fail
+		SecretScanIgnore    []string      // Globs of image paths excluded from the secret scan
+		DNSServers          []string      // DNS servers used by the plugin and kaniko
+		HostOverrides       []string      // Static host:ip mappings used by the plugin and kaniko
+		AddHosts            []string      // Static host:ip mappings used by RUN steps only, like docker build --add-host
+		PreflightAuth       bool          // Check registry credentials before starting the build
+		PullRetry           int           // Number of retries for transient base image pull failures
+		PullRetryBackoff    time.Duration // Initial delay between retries, doubled on every retry
+		BuildTimeout        time.Duration // Maximum duration of each kaniko executor run
+		ExecutorPath        string        // Kaniko executor binary, defaults to /kaniko/executor
+		ControlAddress      string        // Local address, host:port or unix:<path>, accepting POST /cancel requests that abort the build
+		ExecutorChecksum    string        // Expected sha256 checksum of the executor binary
+		ExecutorVersion     string        // Expected version of the executor, e.g. v1.9.1
+		Rootless            bool          // Run the executor in a user namespace when the plugin does not run as root
+		Discover            bool          // Discover Dockerfiles below DiscoverRoot and build one image per directory
+		DiscoverRoot        string        // Root directory for Dockerfile discovery
+		DiscoverPattern     string        // Glob relative to DiscoverRoot matching the Dockerfiles to build
+		Release             string        // Release manifest of related images built and pushed with the same tags
+		Ledger              string        // JSON Lines ledger each build is recorded in, a file or a URL supported by the command
 	}
 
 	// Artifact defines content of artifact file
 	Artifact struct {
-		Tags         []string                  // Docker artifact tags
-		Repo         string                    // Docker artifact repository
-		Registry     string                    // Docker artifact registry
-		RegistryType artifact.RegistryTypeEnum // Rocker artifact registry type
-		ArtifactFile string                    // Artifact file location
+		Tags         []string                   // Docker artifact tags
+		Repo         string                     // Docker artifact repository
+		Registry     string                     // Docker artifact registry
+		RegistryType artifact.RegistryTypeEnum  // Rocker artifact registry type
+		ArtifactFile string                     // Artifact file location
+		Format       string                     // Artifact file format, json, yaml or env
+		Publishers   []string                   // Destinations the artifact is published to, files, http(s) URLs or URLs supported by the command
+		Headers      []string                   // Request headers of http(s) publishers, as Name: value
+		Openers      map[string]artifact.Opener // Publishers of the URL schemes supported by the command
+	}
+
+	// Promotion defines the parameters for promoting an existing image
+	// instead of building one.
+	Promotion struct {
+		Source    string // Image to promote, as repo@digest or repo:tag
+		VerifyKey string // Cosign public key the source image signature must verify against
+		SignKey   string // Cosign private key the promoted image is re-signed with
+		Copier    Copier // Copies images within the registry natively, set by commands supporting it
 	}
 
 	// Plugin defines the Docker plugin parameters.
 	Plugin struct {
-		Build    Build    // Docker build configuration
-		Artifact Artifact // Artifact file content
+		Build     Build     // Docker build configuration
+		Artifact  Artifact  // Artifact file content
+		Promotion Promotion // Image promotion configuration
+		UserAgent string    // User-Agent for registry requests made by the plugin
+
+		MaskValues []string // Sensitive settings, e.g. registry passwords and keys, masked in the executor output
+
+		LedgerStore ledger.Store // Store of ledger URLs, set by commands supporting them
+
+		CredentialRefresh         func(context.Context) error // Refreshes expiring registry credentials, set by commands supporting it
+		CredentialRefreshInterval time.Duration               // Interval of CredentialRefresh during the step
+
+		Context context.Context // Context of the command, e.g. canceled when it is stopped, the step cancels on return
+
+		control      *control            // Control endpoint of the build, shared with sub-builds
+		cacheCounter *cachestats.Counter // Cache lookups of the build, collected when CacheStats is set
+		result       *result             // Result of the step, shared with sub-builds
+		maskedValues []string            // Values masked in the executor output
 	}
 )
 
 // labelsForTag returns the labels to use for the given tag, subject to the value of ExpandTag.
-//
-// Build information (e.g. +linux_amd64) is carried through to all labels.
-// Pre-release information (e.g. -rc1) suppresses major and major+minor auto-labels.
 func (b Build) labelsForTag(tag string) (labels []string) {
-	// We strip "v" off of the beginning of semantic versions, as they are not used in docker tags
-	const VersionPrefix = "v"
+	if !b.ExpandTag {
+		return []string{tag}
+	}
+	return tagger.ExpandTag(tag)
+}
 
-	// Semantic Versions don't allow underscores, so replace them with dashes.
-	//   https://semver.org/
-	semverTag := strings.ReplaceAll(tag, "_", "-")
+// Returns the auto detected tags. See the AutoTag section of
+// https://plugins.drone.io/drone-plugins/drone-docker/ for more info.
+func (b Build) AutoTags() (tags []string, err error) {
+	return tagger.Auto{Suffix: b.AutoTagSuffix}.Tags(b.tagMetadata())
+}
 
-	// Allow tags of the form "1.2.3" as well as "v1.2.3" to avoid confusion.
-	if withV := VersionPrefix + semverTag; !semver.IsValid(semverTag) && semver.IsValid(withV) {
-		semverTag = withV
+func (b Build) tagMetadata() tagger.Metadata {
+	return tagger.Metadata{
+		Tags:          b.Tags,
+		CommitRef:     b.DroneCommitRef,
+		CommitSha:     b.DroneCommitSha,
+		DefaultBranch: b.DroneRepoBranch,
+		BuildNumber:   b.DroneBuildNumber,
+		BuildEvent:    b.DroneBuildEvent,
 	}
+}
 
-	// Pass through tags if expand-tag is not set, or if the tag is not a semantic version
-	if !b.ExpandTag || !semver.IsValid(semverTag) {
-		return []string{tag}
+// tagger returns the tagger computing the destination tags: auto-tag and
+// expand-tag, followed by the registered Taggers.
+func (b Build) tagger() (tagger.Tagger, error) {
+	if b.AutoTag && b.ExpandTag {
+		return nil, fmt.Errorf("The auto-tag flag conflicts with the expand-tag flag")
 	}
-	tag = semverTag
-
-	// If the version is pre-release, only the full release should be tagged, not the major/minor versions.
-	if semver.Prerelease(tag) != "" {
-		return []string{
-			strings.TrimPrefix(tag, VersionPrefix),
+	var taggers []tagger.Tagger
+	if b.AutoTag {
+		taggers = append(taggers, tagger.Auto{Suffix: b.AutoTagSuffix})
+	}
+	if b.ExpandTag {
+		taggers = append(taggers, tagger.Expand{})
+	}
+	for _, name := range b.Taggers {
+		t, err := tagger.Lookup(name)
+		if err != nil {
+			return nil, err
 		}
+		taggers = append(taggers, t)
 	}
+	return tagger.Chain(taggers...), nil
+}
 
-	// tagFor carries any build information from the semantic version through to major and minor tags.
-	labelFor := func(base string) string {
-		return strings.TrimPrefix(base, VersionPrefix) + semver.Build(tag)
+// triggered reports whether the build should run given TriggerPaths. The
+// build always runs when the commit range is unknown, and fails when it
+// cannot be diffed, e.g. since the before commit is not in a shallow clone.
+func (b Build) triggered() (bool, error) {
+	if len(b.TriggerPaths) == 0 {
+		return true, nil
+	}
+	if !changes.Known(b.DroneCommitBefore, b.DroneCommitSha) {
+		fmt.Fprintf(os.Stdout, "Commit range is unknown, ignoring trigger paths\n")
+		return true, nil
 	}
-	return []string{
-		labelFor(semver.Major(tag)),
-		labelFor(semver.MajorMinor(tag)),
-		labelFor(semver.Canonical(tag)),
+	files, err := changes.Files("", b.DroneCommitBefore, b.DroneCommitSha)
+	if err != nil {
+		return false, fmt.Errorf("trigger paths: failed to detect changed files: %s", err)
 	}
+	if matched := changes.Matching(b.TriggerPaths, files); len(matched) > 0 {
+		fmt.Fprintf(os.Stdout, "Changed files match trigger paths: %s\n", strings.Join(matched, ", "))
+		return true, nil
+	}
+	fmt.Fprintf(os.Stdout, "No changed files match trigger paths %s, skipping build\n", b.TriggerPaths)
+	return false, nil
 }
 
-// Returns the auto detected tags. See the AutoTag section of
-// https://plugins.drone.io/drone-plugins/drone-docker/ for more info.
-func (b Build) AutoTags() (tags []string, err error) {
-	if len(b.Tags) > 1 || len(b.Tags) == 1 && b.Tags[0] != "latest" {
-		err = fmt.Errorf("The auto-tag flag does not work with user provided tags %s", b.Tags)
-		return
+// DestinationTags returns the tags the image is pushed with, after applying
+// the taggers. Tags are trimmed and deduplicated.
+func (b Build) DestinationTags() ([]string, error) {
+	tags, err := canonicalTags(b.Tags)
+	if err != nil {
+		return nil, err
 	}
-	// We have tried the best to prevent enabling auto-tag and passing in
-	// user specified at the same time. Starts to auto detect tags.
-	// Note: passing in a "latest" tag with auto-tag enabled won't trigger the
-	// early returns above, because we cannot tell if the tag is provided by
-	// the default value or by the users.
-	commitRef := b.DroneCommitRef
-	if !tagger.UseAutoTag(commitRef, b.DroneRepoBranch) {
-		err = fmt.Errorf("Could not auto detect the tag. Skipping automated docker build for commit %s", commitRef)
-		return
+	b.Tags = tags
+	t, err := b.tagger()
+	if err != nil {
+		return nil, err
 	}
-	tags, err = tagger.AutoTagsSuffix(commitRef, b.AutoTagSuffix)
+	tags, err = t.Tags(b.tagMetadata())
 	if err != nil {
-		err = fmt.Errorf("Invalid semantic version when auto detecting the tag. Skipping automated docker build for %s.", commitRef)
+		return nil, err
+	}
+	return canonicalTags(tags)
+}
+
+// tagPattern matches valid docker tags.
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// canonicalTags trims the tags, which may come from the .tags file, the tags
+// setting and auto-tag, drops empty and duplicate tags and validates the rest.
+func canonicalTags(tags []string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > 128 {
+			return nil, fmt.Errorf("invalid tag %s, tags must be at most 128 characters long", tag)
+		}
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q, tags may only contain letters, digits, underscores, periods and dashes and must not start with a period or dash", tag)
+		}
+		seen[tag] = true
+		out = append(out, tag)
 	}
-	return
+	return out, nil
 }
 
-// Exec executes the plugin step
+// Exec executes the plugin step. Errors are returned as *PhaseError,
+// carrying the phase they were raised in.
 func (p Plugin) Exec() error {
+	start := time.Now()
+	if p.result == nil {
+		// Registry requests and executor runs of the step and its sub-builds
+		// share the context, canceled by the control endpoint
+		ctx, cancel := context.WithCancel(p.stepContext())
+		defer cancel()
+		p.Context = ctx
+		p.result = newResult()
+		defer func(r *result) {
+			r.Durations["total"] = time.Since(start).Round(time.Millisecond).Seconds()
+			lastResult = r
+		}(p.result)
+		// Sub-builds share the credential refresh of the step
+		defer p.startCredentialRefresh()()
+	}
+	clock := newPhaseClock(PhaseValidate)
+	err := p.withControl(func(p Plugin) error {
+		return p.exec(clock)
+	})
+	phase := clock.phase
+	var phaseErr *PhaseError
+	if err != nil && !errors.As(err, &phaseErr) {
+		err = &PhaseError{Phase: phase, Err: err}
+	}
+	// Discovered services, tag variants and release images are recorded by
+	// their own builds
+	if !p.Build.Discover && len(p.Build.TagArgs) == 0 && p.Build.Release == "" {
+		p.recordResult(clock, err)
+		if p.Build.Ledger != "" {
+			p.recordBuild(start, phase, err)
+		}
+	}
+	return err
+}
+
+// recordResult adds the phase durations of the build and, unless it failed
+// or was skipped, its image to the result.
+func (p Plugin) recordResult(clock *phaseClock, err error) {
+	phase := clock.phase
+	p.result.addDurations(clock.stop())
+	if err != nil || phase == PhaseValidate {
+		return
+	}
+	image := resultImage{Repo: p.Build.Repo, Digest: p.imageDigest()}
+	image.Tags, _ = p.Build.DestinationTags()
+	image.Pushed = !p.Build.NoPush && image.Digest != ""
+	p.result.addImage(image)
+}
+
+// stepContext returns the context of the step, the background context
+// unless the command set one.
+func (p Plugin) stepContext() context.Context {
+	if p.Context == nil {
+		return context.Background()
+	}
+	return p.Context
+}
+
+func (p Plugin) exec(phase *phaseClock) error {
 	if !p.Build.NoPush && p.Build.Repo == "" {
 		return fmt.Errorf("repository name to publish image must be specified")
 	}
-
-	if _, err := os.Stat(p.Build.Dockerfile); os.IsNotExist(err) {
-		return fmt.Errorf("dockerfile does not exist at path: %s", p.Build.Dockerfile)
+	if _, err := artifact.ParseFormat(p.Artifact.Format); err != nil {
+		return err
+	}
+	if _, err := p.artifactPublishers(); err != nil {
+		return err
+	}
+	if err := p.Build.validateExpectedDigest(); err != nil {
+		return err
+	}
+	if p.Promotion.VerifyKey != "" || p.Promotion.SignKey != "" {
+		if err := cosign.Check(); err != nil {
+			return err
+		}
+	}
+	if p.Build.Ledger != "" {
+		if _, err := p.ledgerStore(); err != nil {
+			return err
+		}
 	}
 
-	var tags = p.Build.Tags
-	if p.Build.AutoTag && p.Build.ExpandTag {
-		return fmt.Errorf("The auto-tag flag conflicts with the expand-tag flag")
+	resolver, err := dns.New(p.Build.DNSServers, p.Build.HostOverrides)
+	if err != nil {
+		return err
 	}
-	if p.Build.AutoTag {
-		var err error
-		tags, err = p.Build.AutoTags()
+	if !resolver.Empty() {
+		restore, err := resolver.Apply()
 		if err != nil {
 			return err
 		}
+		defer p.restoreResolution(restore)
+	}
+	// RUN steps resolve names with the /etc/hosts of the plugin container,
+	// which kaniko excludes from snapshots
+	buildHosts, err := dns.New(nil, p.Build.AddHosts)
+	if err != nil {
+		return err
+	}
+	if !buildHosts.Empty() {
+		restore, err := buildHosts.Apply()
+		if err != nil {
+			return err
+		}
+		defer p.restoreResolution(restore)
 	}
 
-	cmdArgs := []string{
-		fmt.Sprintf("--dockerfile=%s", p.Build.Dockerfile),
-		fmt.Sprintf("--context=dir://%s", p.Build.Context),
+	if triggered, err := p.Build.triggered(); err != nil || !triggered {
+		return err
 	}
 
-	// Set the destination repository
-	if !p.Build.NoPush {
-		for _, tag := range tags {
-			for _, label := range p.Build.labelsForTag(tag) {
-				cmdArgs = append(cmdArgs, fmt.Sprintf("--destination=%s:%s", p.Build.Repo, label))
-			}
+	if p.Build.Release != "" {
+		return p.execRelease()
+	}
+	if p.Build.Discover {
+		return p.execDiscovered()
+	}
+	if len(p.Build.TagArgs) != 0 {
+		if p.Promotion.Source != "" {
+			return fmt.Errorf("tag build args are not supported when promoting an image")
 		}
+		return p.execTagVariants()
 	}
-	// Set the build arguments
-	for _, arg := range p.Build.Args {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("--build-arg=%s", arg))
+
+	if p.Promotion.Source != "" {
+		phase.enter(PhasePromote)
+		return p.promote()
 	}
-	// Set the labels
-	for _, label := range p.Build.Labels {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("--label=%s", label))
+
+	if p.Build.EnableCache && p.Build.CacheRepo == "" && p.Build.Repo != "" {
+		p.Build.CacheRepo = defaultCacheRepo(p.Build.Repo)
+		fmt.Fprintf(os.Stdout, "Using cache repo %s\n", p.Build.CacheRepo)
 	}
-	// Set repository mirrors
-	for _, mirror := range p.Build.Mirrors {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("--registry-mirror=%s", mirror))
+
+	if err := p.Build.checkExecutor(); err != nil {
+		return err
 	}
-	if p.Build.Target != "" {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("--target=%s", p.Build.Target))
+	if err := p.Build.checkRootless(); err != nil {
+		return err
 	}
 
-	if p.Build.SkipTlsVerify {
-		cmdArgs = append(cmdArgs, "--skip-tls-verify=true")
+	if p.Build.tarballContext() {
+		cleanup, err := p.Build.extractContext()
+		defer cleanup()
+		if err != nil {
+			return err
+		}
+	}
+	if err := p.Build.validateContext(); err != nil {
+		return err
+	}
+	if p.Build.Args, err = p.Build.argFiles(); err != nil {
+		return err
+	}
+	// The Dockerfile of remote contexts is relative to the fetched context
+	if _, err := os.Stat(p.Build.Dockerfile); os.IsNotExist(err) && !p.Build.remoteContext() {
+		return fmt.Errorf("dockerfile does not exist at path: %s", p.Build.Dockerfile)
 	}
 
-	if p.Build.SnapshotMode != "" {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("--snapshotMode=%s", p.Build.SnapshotMode))
+	if p.Build.StrictMirrors {
+		if err := p.Build.checkStrictMirrors(); err != nil {
+			return err
+		}
 	}
 
-	if p.Build.EnableCache {
-		cmdArgs = append(cmdArgs, "--cache=true")
+	labels, err := p.Build.DestinationTags()
+	if err != nil {
+		return err
+	}
 
-		if p.Build.CacheRepo != "" {
-			cmdArgs = append(cmdArgs, fmt.Sprintf("--cache-repo=%s", p.Build.CacheRepo))
+	if _, err := p.Build.assertions(); err != nil {
+		return err
+	}
+	if _, err := p.Build.manifestAnnotations(); err != nil {
+		return err
+	}
+	retentionLabels, err := p.Build.retentionLabels()
+	if err != nil {
+		return err
+	}
+	requiredLabels, err := p.Build.requiredLabels()
+	if err != nil {
+		return err
+	}
+	switch p.Build.SecretScan {
+	case "", secretScanWarn, secretScanFail:
+	default:
+		return fmt.Errorf("invalid secret scan mode %s, must be one of %s or %s", p.Build.SecretScan, secretScanWarn, secretScanFail)
+	}
+	switch p.Build.DockerfileCheck {
+	case "", dockerfileCheckEmulate, dockerfileCheckStrict, dockerfileCheckOff:
+	default:
+		return fmt.Errorf("invalid dockerfile check mode %s, must be one of %s, %s or %s", p.Build.DockerfileCheck, dockerfileCheckEmulate, dockerfileCheckStrict, dockerfileCheckOff)
+	}
+	if err := p.Build.validateSnapshot(); err != nil {
+		return err
+	}
+	if err := p.Build.validatePlatforms(); err != nil {
+		return err
+	}
+	// The Dockerfile of remote contexts is not available before the build
+	if !p.Build.remoteContext() {
+		if err := p.Build.validateStages(); err != nil {
+			return err
 		}
-		
-		if p.Build.CacheDir != "" {
-			if _, err := os.Stat(p.Build.CacheDir); !os.IsNotExist(err) {
-				cmdArgs = append(cmdArgs, fmt.Sprintf("--cache-dir=%s", p.Build.CacheDir))
-			}
+	}
+	if _, err := p.Build.ociArtifactFiles(); err != nil {
+		return err
+	}
+	if p.Build.HelmChart != "" {
+		if _, _, err := p.Build.helmChart(); err != nil {
+			return err
 		}
-		
-		if p.Build.CacheCopyLayers {
-			cmdArgs = append(cmdArgs, "--cache-copy-layers")
+	}
+	if _, err := p.Build.patchRules(); err != nil {
+		return err
+	}
+	if p.Build.PRComment || p.Build.CommitStatus {
+		if _, err := p.Build.scmClient(); err != nil {
+			return err
 		}
+	}
+	if err := p.checkWindowsPlatforms(); err != nil {
+		return err
+	}
+	// Windows images are always published under an index, whose platform
+	// descriptors carry the os.version that Windows hosts select images by.
+	if len(p.Build.Platforms) == 0 && strings.HasPrefix(p.Build.Platform, windowsOS+"/") {
+		p.Build.Platforms = []string{p.Build.Platform}
+	}
+	if _, err := p.Build.platformFields(); err != nil {
+		return err
+	}
 
-		if p.Build.CacheNoCompress {
-			cmdArgs = append(cmdArgs, "--compressed-caching=false")
+	// The Dockerfile of remote contexts is not available before the build
+	if p.Build.DockerfileCheck != dockerfileCheckOff && !p.Build.remoteContext() {
+		emulated, err := p.Build.checkDockerfile()
+		if err != nil {
+			return err
+		}
+		p.Build.Dockerfile = emulated.Dockerfile
+		p.Build.IgnorePaths = append(p.Build.IgnorePaths, emulated.IgnorePaths...)
+		cleanup, err := p.Build.writeSecrets(emulated.Secrets)
+		defer cleanup()
+		if err != nil {
+			return err
+		}
+		if p.maskedValues, err = p.Build.secretValues(); err != nil {
+			return err
+		}
+	} else if len(p.Build.SecretFiles) != 0 {
+		return fmt.Errorf("secret files require the dockerfile check")
+	}
+	// kaniko occasionally echoes build args
+	p.maskedValues = maskValues(append(append(p.maskedValues, p.MaskValues...), p.Build.secretArgValues()...))
+
+	phase.enter(PhasePreflight)
+	var keyTag string
+	if p.Build.SkipIdentical && !p.Build.NoPush {
+		platform := p.Build.Platform
+		if len(p.Build.Platforms) > 0 {
+			platform = strings.Join(p.Build.Platforms, ",")
+		}
+		key, err := buildkey.Compute(buildkey.Inputs{
+			Dockerfile: p.Build.Dockerfile,
+			Context:    p.Build.Context,
+			Args:       p.Build.Args,
+			Target:     p.Build.Target,
+			Platform:   platform,
+		})
+		if err != nil {
+			return err
+		}
+		keyTag = buildkey.TagPrefix + key
+		retagged, err := p.retagIdentical(keyTag, labels)
+		if err != nil {
+			return err
+		}
+		if retagged {
+			if err := p.verifyDigest(); err != nil {
+				return err
+			}
+			p.exportDigest()
+			p.publishArtifact()
+			return nil
 		}
 	}
-	
 
-	if p.Build.CacheTTL != 0 {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("--cache-ttl=%dh", p.Build.CacheTTL))
+	if p.Build.PreflightAuth {
+		if err := p.preflightAuth(); err != nil {
+			return err
+		}
 	}
 
-	if p.Build.DigestFile != "" {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("--digest-file=%s", p.Build.DigestFile))
+	if p.Build.usesCacheFrom() {
+		p.seedCache()
 	}
 
-	if p.Build.NoPush {
-		cmdArgs = append(cmdArgs, "--no-push")
+	if p.Build.usesCacheStats() {
+		p.cacheCounter = &cachestats.Counter{}
 	}
 
-	if p.Build.Verbosity != "" {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("--verbosity=%s", p.Build.Verbosity))
+	phase.enter(PhaseBuild)
+	destinations := labels
+	// Record the build key so that identical builds can be skipped
+	if keyTag != "" {
+		destinations = append(destinations, keyTag)
 	}
 
-	if p.Build.UseNewRun {
-		cmdArgs = append(cmdArgs, "--use-new-run")
-	}
+	// Images checked before they are pushed and multi-platform images are
+	// written to OCI layouts and pushed by the plugin instead of kaniko.
+	multiPlatform := len(p.Build.Platforms) > 0
+	useLayout := p.Build.usesLayout() || multiPlatform
 
-	if p.Build.Platform != "" {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("--customPlatform=%s", p.Build.Platform))
+	build := p.Build
+	build.Labels = append(append(append([]string{}, p.Build.Labels...), retentionLabels...), requiredLabels...)
+	// Every platform is built by a kaniko run of its own, and a retried run
+	// must not start from the filesystem left by the failed one
+	build.Cleanup = build.Cleanup || multiPlatform || p.Build.PullRetry > 0 || (p.Build.CacheRetry && p.Build.EnableCache)
+	// kaniko fails on cache directories that don't exist
+	if _, err := os.Stat(build.CacheDir); os.IsNotExist(err) {
+		build.CacheDir = ""
 	}
+	cmdArgs := ExecutorArgs(build, kanikoDestinations(destinations), useLayout)
 
-	cmd := exec.Command("/kaniko/executor", cmdArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	trace(cmd)
-
-	err := cmd.Run()
+	if multiPlatform {
+		err = p.buildPlatforms(cmdArgs, destinations)
+	} else {
+		err = p.buildImage(cmdArgs, destinations, useLayout)
+	}
+	if p.cacheCounter != nil {
+		p.recordCacheStats()
+	}
 	if err != nil {
 		return err
 	}
+	if err := p.verifyDigest(); err != nil {
+		return err
+	}
 
-	if p.Build.DigestFile != "" && p.Artifact.ArtifactFile != "" {
-		content, err := ioutil.ReadFile(p.Build.DigestFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to read digest file contents at path: %s with error: %s\n", p.Build.DigestFile, err)
+	phase.enter(PhasePublish)
+	if len(p.Build.OCIArtifacts) != 0 && !p.Build.NoPush {
+		if err := p.pushOCIArtifacts(labels); err != nil {
+			return err
 		}
-		err = artifact.WritePluginArtifactFile(p.Artifact.RegistryType, p.Artifact.ArtifactFile, p.Artifact.Registry, p.Artifact.Repo, string(content), p.Artifact.Tags)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to write plugin artifact file at path: %s with error: %s\n", p.Artifact.ArtifactFile, err)
+	}
+
+	if p.Build.HelmChart != "" && !p.Build.NoPush {
+		if err := p.pushHelmChart(labels); err != nil {
+			return err
 		}
 	}
 
+	if len(p.Build.PatchFiles) != 0 && !p.Build.NoPush {
+		if err := p.patchManifests(labels); err != nil {
+			return err
+		}
+	}
+
+	if (p.Build.PRComment || p.Build.CommitStatus) && !p.Build.NoPush {
+		p.reportResult(labels)
+	}
+
+	p.exportDigest()
+	p.publishArtifact()
+
 	return nil
 }
 
+// buildImage runs kaniko for a single platform and, for images checked
+// before push, pushes the OCI layout written by kaniko. Otherwise kaniko
+// pushes the first destination, which is then tagged with the others.
+func (p Plugin) buildImage(cmdArgs []string, destinations []string, useLayout bool) error {
+	if p.Build.Platform != "" {
+		targetArgs, err := p.Build.targetPlatformArgs(p.Build.Platform)
+		if err != nil {
+			return err
+		}
+		cmdArgs = append(append(cmdArgs, targetArgs...), fmt.Sprintf("--customPlatform=%s", p.Build.Platform))
+	}
+
+	if useLayout {
+		if err := os.RemoveAll(layoutPath); err != nil {
+			return err
+		}
+		cmdArgs = append(cmdArgs, fmt.Sprintf("--oci-layout-path=%s", layoutPath))
+	}
+
+	if err := p.runExecutor(cmdArgs); err != nil {
+		return err
+	}
+
+	if useLayout {
+		return p.pushLayout(destinations)
+	}
+	return p.tagPushed(destinations)
+}
+
+// restoreResolution restores the name resolution files changed for the
+// build. Failures are only logged, the build itself is done.
+func (p Plugin) restoreResolution(restore func() error) {
+	if err := restore(); err != nil {
+		p.warnf("failed to restore name resolution: %s\n", err)
+	}
+}
+
+// registryClient returns a client authenticated with the docker config used by kaniko.
+func (p Plugin) registryClient() *registry.Client {
+	client := registry.NewClient(registry.DockerKeychain(docker.ConfigPath), p.Build.SkipTlsVerify)
+	client.UserAgent = p.UserAgent
+	if resolver, err := dns.New(p.Build.DNSServers, p.Build.HostOverrides); err == nil && !resolver.Empty() {
+		client.SetDialer(resolver.DialContext)
+	}
+	return client
+}
+
 // trace writes each command to stdout with the command wrapped in an xml
 // tag so that it can be extracted and displayed in the logs.
-func trace(cmd *exec.Cmd) {
-	fmt.Fprintf(os.Stdout, "+ %s\n", strings.Join(cmd.Args, " "))
+func trace(w io.Writer, cmd *exec.Cmd) {
+	fmt.Fprintf(w, "+ %s\n", strings.Join(cmd.Args, " "))
 }
@@ -1,9 +1,28 @@
 package kaniko
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/discover"
+	"github.com/gexops/drone-kaniko/pkg/ledger"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/gexops/drone-kaniko/pkg/release"
+	"github.com/gexops/drone-kaniko/pkg/tagger"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestBuild_labelsForTag(t *testing.T) {
@@ -139,3 +158,1202 @@ func TestBuild_AutoTags(t *testing.T) {
 		}
 	})
 }
+
+func TestBuild_triggered(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   Build
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:  "no trigger paths",
+			build: Build{DroneCommitBefore: "abc", DroneCommitSha: "def"},
+			want:  true,
+		},
+		{
+			name:  "unknown commit range",
+			build: Build{TriggerPaths: []string{"services/api/**"}, DroneCommitBefore: "0000000000000000000000000000000000000000", DroneCommitSha: "def"},
+			want:  true,
+		},
+		{
+			name:    "diff failure",
+			build:   Build{TriggerPaths: []string{"services/api/**"}, DroneCommitBefore: "not-a-commit", DroneCommitSha: "also-not-a-commit"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.build.triggered()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("triggered() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("triggered() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlugin_forService(t *testing.T) {
+	p := Plugin{
+		Build: Build{
+			Repo:         "registry.example.com/team",
+			Discover:     true,
+			TriggerPaths: []string{"services/**"},
+		},
+		Artifact: Artifact{
+			Repo:         "team",
+			ArtifactFile: "/drone/artifact.json",
+			Publishers:   []string{"s3://bucket/images/api.json", "https://deploy.example.com/images"},
+		},
+	}
+	sub := p.forService(discover.Service{Name: "api", Dir: "services/api", Dockerfile: "services/api/Dockerfile"})
+
+	if sub.Build.Discover || sub.Build.TriggerPaths != nil {
+		t.Errorf("expected discovery and trigger paths to be disabled for service builds")
+	}
+	if !sub.Build.Cleanup {
+		t.Errorf("expected service builds to clean up the filesystem")
+	}
+	if got, want := sub.Build.Repo, "registry.example.com/team/api"; got != want {
+		t.Errorf("Build.Repo = %q, want %q", got, want)
+	}
+	if got, want := sub.Build.Context, "services/api"; got != want {
+		t.Errorf("Build.Context = %q, want %q", got, want)
+	}
+	if got, want := sub.Artifact.Repo, "team/api"; got != want {
+		t.Errorf("Artifact.Repo = %q, want %q", got, want)
+	}
+	if got, want := sub.Artifact.ArtifactFile, "/drone/artifact-api.json"; got != want {
+		t.Errorf("Artifact.ArtifactFile = %q, want %q", got, want)
+	}
+	if diff := cmp.Diff([]string{"s3://bucket/images/api-api.json", "https://deploy.example.com/images"}, sub.Artifact.Publishers); diff != "" {
+		t.Errorf("Artifact.Publishers mismatch (-want +got):\n%s", diff)
+	}
+
+	root := p.forService(discover.Service{Dir: ".", Dockerfile: "Dockerfile"})
+	if got, want := root.Build.Repo, p.Build.Repo; got != want {
+		t.Errorf("Build.Repo for root service = %q, want %q", got, want)
+	}
+}
+
+func TestPlugin_artifactPublishers(t *testing.T) {
+	var opened []string
+	p := Plugin{Artifact: Artifact{
+		ArtifactFile: "artifact.json",
+		Publishers:   []string{"/drone/image.json", "https://deploy.example.com/images", "s3://bucket/image.json"},
+		Headers:      []string{"Authorization: Bearer token"},
+		Openers: map[string]artifact.Opener{"s3": func(destination string) (artifact.Publisher, error) {
+			opened = append(opened, destination)
+			return artifact.File("s3"), nil
+		}},
+	}}
+	publishers, err := p.artifactPublishers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, publisher := range publishers {
+		got = append(got, publisher.String())
+	}
+	if diff := cmp.Diff([]string{"artifact.json", "/drone/image.json", "https://deploy.example.com/images", "s3"}, got); diff != "" {
+		t.Errorf("artifactPublishers() mismatch (-want +got):\n%s", diff)
+	}
+	if got := publishers[2].(artifact.HTTP).Header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization header = %q, want %q", got, "Bearer token")
+	}
+	if diff := cmp.Diff([]string{"s3://bucket/image.json"}, opened); diff != "" {
+		t.Errorf("opened destinations mismatch (-want +got):\n%s", diff)
+	}
+
+	p.Artifact.Publishers = []string{"gs://bucket/image.json"}
+	if _, err := p.artifactPublishers(); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("artifactPublishers() with unsupported scheme error = %v", err)
+	}
+}
+
+func TestBuild_assertions(t *testing.T) {
+	b := Build{}
+	if b.usesLayout() {
+		t.Error("expected builds without assertions to be pushed by kaniko")
+	}
+
+	b = Build{AssertEntrypoint: `["/bin/app", "serve"]`, AssertPorts: []string{"8080"}}
+	if !b.usesLayout() {
+		t.Error("expected builds with assertions to be pushed from a layout")
+	}
+	a, err := b.assertions()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := a.Entrypoint, []string{"/bin/app", "serve"}; !cmp.Equal(got, want) {
+		t.Errorf("entrypoint = %q, want %q", got, want)
+	}
+
+	if _, err := (Build{AssertEntrypoint: `["/bin/app"`}).assertions(); err == nil {
+		t.Error("expected error for invalid entrypoint")
+	}
+}
+
+func TestTransientPullFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{
+			name:   "rate limited",
+			output: `error building image: retrieving image "alpine": GET https://index.docker.io/v2/library/alpine/manifests/3.14: TOOMANYREQUESTS: rate limit exceeded`,
+			want:   true,
+		},
+		{
+			name:   "connection reset",
+			output: "error building image: failed to get filesystem from image: read tcp 10.0.0.2:4312->10.0.0.10:443: read: connection reset by peer",
+			want:   true,
+		},
+		{
+			name:   "unknown image",
+			output: `error building image: retrieving image "alpine:nope": GET https://index.docker.io/v2/library/alpine/manifests/nope: MANIFEST_UNKNOWN`,
+			want:   false,
+		},
+		{
+			name:   "failing run step",
+			output: "error building image: error building stage: failed to execute command: waiting for process to exit: exit status 1",
+			want:   false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := transientPullFailure(test.output); got != test.want {
+				t.Errorf("transientPullFailure() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPlugin_runExecutor_cacheRetry(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "executor")
+	calls := filepath.Join(dir, "calls")
+	// Fails on the cache whenever it is enabled
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\ncase \"$*\" in *--cache=true*) echo 'error building image: error verifying sha256 checksum after reading 1024 bytes' >&2; exit 1;; esac\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"--dockerfile=Dockerfile", "--cache=true", "--cache-repo=gcr.io/p/app/cache"}
+	prune := filepath.Join(dir, "prune")
+
+	p := Plugin{Build: Build{ExecutorPath: path, CacheRepo: "gcr.io/p/app/cache", CacheRetry: true, CachePruneFile: prune}}
+	if err := p.runExecutor(args); err != nil {
+		t.Fatalf("runExecutor() error = %v", err)
+	}
+	got, _ := ioutil.ReadFile(calls)
+	if want := "--dockerfile=Dockerfile --cache=true --cache-repo=gcr.io/p/app/cache\n--dockerfile=Dockerfile\n"; string(got) != want {
+		t.Errorf("executor runs = %q, want %q", got, want)
+	}
+	if got, _ := ioutil.ReadFile(prune); string(got) != "gcr.io/p/app/cache\n" {
+		t.Errorf("prune file = %q", got)
+	}
+
+	p.Build.CacheRetry = false
+	if err := p.runExecutor(args); err == nil {
+		t.Error("runExecutor() without cache retry error = nil")
+	}
+}
+
+func TestPlugin_runExecutorOnce_masked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "executor")
+	// Echoes its build arg, like kaniko occasionally does
+	script := "#!/bin/sh\necho \"INFO build arg $1\"\nprintf 'npm_secret' >&2\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	b := Build{ExecutorPath: path, Args: []string{"NPM_TOKEN=npm_secret", "VERSION=1.2.3"}}
+	p := Plugin{Build: b, maskedValues: maskValues(b.secretArgValues())}
+	output, err := p.runExecutorOnce([]string{"--build-arg=NPM_TOKEN=npm_secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output, "npm_secret") || strings.Count(output, secretMask) != 2 {
+		t.Errorf("executor output %q not masked", output)
+	}
+}
+
+func TestBuild_secretArgValues(t *testing.T) {
+	b := Build{Args: []string{"NPM_TOKEN=npm", "db_password=db", "AWS_SECRET_ACCESS_KEY=aws", "VERSION=1.2.3", "AUTHOR=octocat", "EMPTY"}}
+	if diff := cmp.Diff([]string{"npm", "db", "aws"}, b.secretArgValues()); diff != "" {
+		t.Errorf("secret arg values mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{size: 4}
+	b.Write([]byte("abc"))
+	b.Write([]byte("def"))
+	if got, want := b.String(), "cdef"; got != want {
+		t.Errorf("tail = %q, want %q", got, want)
+	}
+}
+
+func TestBuild_checkStrictMirrors(t *testing.T) {
+	dockerfile := filepath.Join(t.TempDir(), "Dockerfile")
+	content := "ARG BASE=golang:1.17\nFROM ${BASE} AS build\nFROM gcr.io/distroless/static\nCOPY --from=build /app /app\n"
+	if err := ioutil.WriteFile(dockerfile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	registryDockerfile := filepath.Join(t.TempDir(), "Dockerfile")
+	if err := ioutil.WriteFile(registryDockerfile, []byte("ARG REGISTRY\nFROM ${REGISTRY}/base\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		build   Build
+		wantErr bool
+	}{
+		{
+			name:    "no mirrors",
+			build:   Build{Repo: "registry.example.com/app"},
+			wantErr: true,
+		},
+		{
+			name:    "docker hub mirror only",
+			build:   Build{Repo: "registry.example.com/app", Mirrors: []string{"mirror.example.com"}},
+			wantErr: true,
+		},
+		{
+			name:  "all registries mirrored",
+			build: Build{Repo: "registry.example.com/app", Mirrors: []string{"mirror.example.com", "https://gcr.io/"}},
+		},
+		{
+			name:  "base images from destination registry",
+			build: Build{Repo: "registry.example.com/app", Args: []string{"BASE=registry.example.com/golang:1.17"}, Mirrors: []string{"gcr.io"}},
+		},
+		{
+			name:    "unresolved base image",
+			build:   Build{Repo: "registry.example.com/app", Args: []string{"BASE=$UNSET"}, Mirrors: []string{"mirror.example.com", "gcr.io"}},
+			wantErr: true,
+		},
+		{
+			name:    "unset registry arg",
+			build:   Build{Dockerfile: registryDockerfile, Repo: "registry.example.com/app", Mirrors: []string{"mirror.example.com"}},
+			wantErr: true,
+		},
+		{
+			name:  "set registry arg",
+			build: Build{Dockerfile: registryDockerfile, Repo: "registry.example.com/app", Args: []string{"REGISTRY=registry.example.com"}, Mirrors: []string{"mirror.example.com"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.build.Dockerfile == "" {
+				test.build.Dockerfile = dockerfile
+			}
+			err := test.build.checkStrictMirrors()
+			if (err != nil) != test.wantErr {
+				t.Errorf("checkStrictMirrors() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuild_validatePlatforms(t *testing.T) {
+	tests := []struct {
+		platforms []string
+		wantErr   bool
+	}{
+		{platforms: nil},
+		{platforms: []string{"linux/amd64", "linux/arm64", "linux/arm/v7"}},
+		{platforms: []string{"amd64"}, wantErr: true},
+		{platforms: []string{"linux/amd64", "linux/amd64"}, wantErr: true},
+		{platforms: []string{"linux/arm/v7/extra"}, wantErr: true},
+	}
+	for _, test := range tests {
+		err := Build{Platforms: test.platforms}.validatePlatforms()
+		if (err != nil) != test.wantErr {
+			t.Errorf("validatePlatforms(%q) error = %v, wantErr %v", test.platforms, err, test.wantErr)
+		}
+	}
+}
+
+func TestBuild_platformFields(t *testing.T) {
+	b := Build{
+		Platforms: []string{"linux/arm", "windows/amd64"},
+		PlatformFields: []string{
+			"linux/arm:variant=v7",
+			"windows/amd64:os.version=10.0.17763.4252",
+			"windows/amd64:os.features=win32k",
+		},
+	}
+	got, err := b.platformFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]*registry.Platform{
+		"linux/arm":     {Variant: "v7"},
+		"windows/amd64": {OSVersion: "10.0.17763.4252", OSFeatures: []string{"win32k"}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("platformFields() mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, field := range []string{"linux/arm", "linux/arm:variant", "linux/arm:variant=", "linux/arm64:variant=v8", "linux/arm:os=linux"} {
+		if _, err := (Build{Platforms: b.Platforms, PlatformFields: []string{field}}).platformFields(); err == nil {
+			t.Errorf("platformFields(%q) error = nil", field)
+		}
+	}
+}
+
+func TestPlugin_checkWindowsPlatforms(t *testing.T) {
+	dir := t.TempDir()
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfile, []byte("FROM scratch\nRUN echo hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (Plugin{Build: Build{Dockerfile: dockerfile, Platform: "linux/amd64"}}).checkWindowsPlatforms(); err != nil {
+		t.Errorf("unexpected error for linux target: %s", err)
+	}
+	if err := (Plugin{Build: Build{Dockerfile: dockerfile, Platform: "windows/amd64"}}).checkWindowsPlatforms(); err == nil {
+		t.Error("expected error for RUN instruction with windows target")
+	}
+
+	if err := ioutil.WriteFile(dockerfile, []byte("FROM --platform=linux/amd64 scratch\nCOPY app.exe /app.exe\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Plugin{Build: Build{Dockerfile: dockerfile, Platforms: []string{"windows/amd64"}}}).checkWindowsPlatforms(); err == nil {
+		t.Error("expected error for linux stage platform with windows target")
+	}
+
+	// Linux stages cross-compiling for the windows stage are not checked
+	content := "FROM --platform=$BUILDPLATFORM scratch AS build\nRUN go build\nFROM --platform=linux/amd64 scratch AS tools\nRUN echo tools\nFROM scratch\nCOPY --from=build /app.exe /app.exe\n"
+	if err := ioutil.WriteFile(dockerfile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Plugin{Build: Build{Dockerfile: dockerfile, Platforms: []string{"linux/amd64", "windows/amd64"}}}).checkWindowsPlatforms(); err != nil {
+		t.Errorf("unexpected error for linux stages of a mixed build: %s", err)
+	}
+	if err := (Plugin{Build: Build{Dockerfile: dockerfile, Platforms: []string{"linux/amd64", "windows/amd64"}, Target: "tools"}}).checkWindowsPlatforms(); err == nil {
+		t.Error("expected error for a linux target stage with windows target")
+	}
+	if err := ioutil.WriteFile(dockerfile, []byte("FROM --platform=$TARGETPLATFORM scratch\nRUN echo hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Plugin{Build: Build{Dockerfile: dockerfile, Platforms: []string{"linux/amd64", "windows/amd64"}}}).checkWindowsPlatforms(); err == nil {
+		t.Error("expected error for RUN instruction in the windows stage of a mixed build")
+	}
+}
+
+func TestBuild_ociArtifactFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.yaml")
+	if err := ioutil.WriteFile(path, []byte("replicas: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Build{OCIArtifacts: []string{path + ":application/yaml"}}.ociArtifactFiles()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(files) != 1 || files[0].Path != path || files[0].MediaType != "application/yaml" {
+		t.Errorf("unexpected files %v", files)
+	}
+
+	if _, err := (Build{OCIArtifacts: []string{path + ".missing"}}).ociArtifactFiles(); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestBuild_helmChart(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("apiVersion: v2\nname: app\nversion: 0.1.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, repo, err := Build{Repo: "registry.example.com/team/app", HelmChart: dir, HelmRepo: "/team/charts/"}.helmChart()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := repo.String(), "registry.example.com/team/charts/app"; got != want {
+		t.Errorf("chart repository = %s, want %s", got, want)
+	}
+
+	if _, _, err := (Build{Repo: "registry.example.com/team/app", HelmChart: dir}).helmChart(); err == nil {
+		t.Error("expected error without helm repo")
+	}
+}
+
+func TestBuild_validateSnapshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   Build
+		wantErr bool
+	}{
+		{name: "defaults", build: Build{}},
+		{name: "redo", build: Build{SnapshotMode: "redo", IgnorePaths: []string{"/tmp/cache"}}},
+		{name: "unknown mode", build: Build{SnapshotMode: "fast"}, wantErr: true},
+		{name: "single snapshot with cache", build: Build{SingleSnapshot: true, EnableCache: true}, wantErr: true},
+		{name: "relative ignore path", build: Build{IgnorePaths: []string{"tmp"}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.build.validateSnapshot(); (err != nil) != test.wantErr {
+				t.Errorf("validateSnapshot() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPatchImage(t *testing.T) {
+	image, err := patchImage("https://index.docker.io/v1/library/nginx", "v1", "sha256:abcd")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := image.Ref(), "nginx@sha256:abcd"; got != want {
+		t.Errorf("Ref() = %s, want %s", got, want)
+	}
+	wantNames := []string{"docker.io/library/nginx", "index.docker.io/library/nginx", "library/nginx", "docker.io/nginx", "nginx"}
+	if diff := cmp.Diff(wantNames, image.Names); diff != "" {
+		t.Errorf("Names mismatch (-want +got):\n%s", diff)
+	}
+
+	image, err = patchImage("gcr.io/project/app", "v1", "sha256:abcd")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := image.Ref(), "gcr.io/project/app@sha256:abcd"; got != want {
+		t.Errorf("Ref() = %s, want %s", got, want)
+	}
+}
+
+func TestBuild_scmClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    Build
+		provider string
+		wantErr  bool
+	}{
+		{name: "detected", build: Build{DroneRepoLink: "https://github.com/octocat/app", SCMToken: "token"}, provider: "github"},
+		{name: "explicit", build: Build{DroneRepoLink: "https://git.example.com/team/app", SCMProvider: "gitlab", SCMToken: "token"}, provider: "gitlab"},
+		{name: "undetected", build: Build{DroneRepoLink: "https://git.example.com/team/app", SCMToken: "token"}, wantErr: true},
+		{name: "no token", build: Build{SCMProvider: "github"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := test.build.scmClient()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("scmClient() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err == nil && client.Provider != test.provider {
+				t.Errorf("provider = %s, want %s", client.Provider, test.provider)
+			}
+		})
+	}
+}
+
+func TestErrorReport(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		phase    string
+		category string
+		message  string
+	}{
+		{
+			name:     "setup",
+			err:      errors.New("registry must be specified"),
+			phase:    PhaseSetup,
+			category: "config",
+			message:  "registry must be specified",
+		},
+		{
+			name: "pull",
+			err: &PhaseError{Phase: PhaseBuild, Err: &executorError{
+				err:    errors.New("exit status 1"),
+				output: "INFO[0000] Retrieving image manifest alpine:3.15\nerror building image: retrieving image: unknown blob\n",
+			}},
+			phase:    PhaseBuild,
+			category: "pull",
+			message:  "error building image: retrieving image: unknown blob",
+		},
+		{
+			name: "build",
+			err: &PhaseError{Phase: PhaseBuild, Err: &executorError{
+				err:    errors.New("exit status 1"),
+				output: "INFO[0001] RUN make\nerror building image: error building stage: failed to execute command\n",
+			}},
+			phase:    PhaseBuild,
+			category: "build",
+			message:  "error building image: error building stage: failed to execute command",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := errorReport(test.err)
+			if report.Phase != test.phase || report.Category != test.category || report.Message != test.message {
+				t.Errorf("errorReport() = %+v, want phase %s, category %s and message %s", report, test.phase, test.category, test.message)
+			}
+		})
+	}
+}
+
+func TestBuild_checkExecutor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "executor")
+	script := "#!/bin/sh\necho 'Kaniko version : v1.9.1'\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	checksum := "sha256:" + fmt.Sprintf("%x", sha256.Sum256([]byte(script)))
+
+	tests := []struct {
+		name    string
+		build   Build
+		wantErr bool
+	}{
+		{name: "unchecked", build: Build{ExecutorPath: path}},
+		{name: "checksum", build: Build{ExecutorPath: path, ExecutorChecksum: checksum}},
+		{name: "checksum mismatch", build: Build{ExecutorPath: path, ExecutorChecksum: "sha256:" + strings.Repeat("0", 64)}, wantErr: true},
+		{name: "version", build: Build{ExecutorPath: path, ExecutorVersion: "1.9.1"}},
+		{name: "version mismatch", build: Build{ExecutorPath: path, ExecutorVersion: "v1.6.0"}, wantErr: true},
+		{name: "missing", build: Build{ExecutorPath: path + "-missing", ExecutorVersion: "v1.9.1"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.build.checkExecutor(); (err != nil) != test.wantErr {
+				t.Errorf("checkExecutor() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuild_validateContext(t *testing.T) {
+	const remote = "git://github.com/octocat/monorepo.git#refs/heads/main"
+	tests := []struct {
+		name    string
+		build   Build
+		arg     string
+		wantErr bool
+	}{
+		{name: "workspace", build: Build{Context: "services/api"}, arg: "dir://services/api"},
+		{name: "remote", build: Build{Context: remote, ContextSubPath: "services/api"}, arg: remote},
+		{name: "absolute sub-path", build: Build{Context: remote, ContextSubPath: "/services/api"}, arg: remote, wantErr: true},
+		{name: "escaping sub-path", build: Build{Context: remote, ContextSubPath: "services/../../etc"}, arg: remote, wantErr: true},
+		{name: "remote with strict mirrors", build: Build{Context: remote, StrictMirrors: true}, arg: remote, wantErr: true},
+		{name: "remote windows", build: Build{Context: remote, Platform: "windows/amd64"}, arg: remote, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.build.validateContext(); (err != nil) != test.wantErr {
+				t.Errorf("validateContext() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got := test.build.contextArg(); got != test.arg {
+				t.Errorf("contextArg() = %s, want %s", got, test.arg)
+			}
+		})
+	}
+}
+
+func TestPlugin_Exec_invalidAddHosts(t *testing.T) {
+	p := Plugin{Build: Build{NoPush: true, AddHosts: []string{"registry.internal"}}}
+	err := p.Exec()
+	if err == nil || !strings.Contains(err.Error(), "invalid host mapping") {
+		t.Errorf("Exec() error = %v, want invalid host mapping", err)
+	}
+}
+
+func TestBuild_checkRootless(t *testing.T) {
+	defer func(euid func() int, sysctls []string) { geteuid, userNamespaceSysctls = euid, sysctls }(geteuid, userNamespaceSysctls)
+	sysctl := filepath.Join(t.TempDir(), "max_user_namespaces")
+	if err := ioutil.WriteFile(sysctl, []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	userNamespaceSysctls = []string{sysctl}
+
+	geteuid = func() int { return 0 }
+	if err := (Build{Rootless: true}).checkRootless(); err != nil {
+		t.Errorf("checkRootless() as root error = %v", err)
+	}
+	if (Build{Rootless: true}).rootless() {
+		t.Error("rootless() as root = true, want false")
+	}
+
+	geteuid = func() int { return 1000 }
+	if err := (Build{}).checkRootless(); err != nil {
+		t.Errorf("checkRootless() without rootless mode error = %v, want only a warning", err)
+	}
+	err := (Build{Rootless: true}).checkRootless()
+	if err == nil || !strings.Contains(err.Error(), "user namespaces") {
+		t.Errorf("checkRootless() with disabled user namespaces error = %v", err)
+	}
+}
+
+func TestBuild_manifestAnnotations(t *testing.T) {
+	b := Build{
+		CIAnnotations:    true,
+		DroneCommitSha:   "abc123",
+		DroneBuildLink:   "https://drone.example.com/octocat/hello-world/42",
+		DroneBuildNumber: "42",
+		DroneStageName:   "default",
+		Annotations:      []string{"io.drone.stage.name=release", "team=platform"},
+	}
+	got, err := b.manifestAnnotations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"org.opencontainers.image.revision": "abc123",
+		"io.drone.build.link":               "https://drone.example.com/octocat/hello-world/42",
+		"io.drone.build.number":             "42",
+		"io.drone.stage.name":               "release",
+		"team":                              "platform",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("manifestAnnotations() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := (Build{Annotations: []string{"team"}}).manifestAnnotations(); err == nil {
+		t.Error("manifestAnnotations() with invalid annotation error = nil")
+	}
+	if !b.usesLayout() {
+		t.Error("usesLayout() with annotations = false, want true")
+	}
+}
+
+func TestBuild_retentionLabels(t *testing.T) {
+	tests := []struct {
+		build Build
+		want  []string
+	}{
+		{build: Build{RetentionLabels: []string{"team=platform"}}, want: []string{"team=platform"}},
+		{build: Build{RetentionPresets: true, DroneBuildEvent: "pull_request"}, want: []string{"env=pr", "retention=14d"}},
+		{build: Build{RetentionPresets: true, DroneBuildEvent: "tag", RetentionLabels: []string{"retention=365d"}}, want: []string{"env=release", "retention=365d"}},
+		{build: Build{RetentionPresets: true, DroneBuildEvent: "cron"}, want: []string{"env=branch", "retention=30d"}},
+	}
+	for _, test := range tests {
+		got, err := test.build.retentionLabels()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("retentionLabels() mismatch (-want +got):\n%s", diff)
+		}
+	}
+	if _, err := (Build{RetentionLabels: []string{"=30d"}}).retentionLabels(); err == nil {
+		t.Error("retentionLabels() with invalid label error = nil")
+	}
+}
+
+func TestBuild_requiredLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "labels.yaml")
+	if err := ioutil.WriteFile(path, []byte("owner: team-a\ncost-center: \"4711\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LABEL_COST_CENTER", "1234")
+	t.Setenv("LABEL_DATA_CLASSIFICATION", "")
+
+	b := Build{
+		RequiredLabels:      []string{"owner", "cost-center", "data-classification"},
+		RequiredLabelPrefix: "com.example.",
+		RequiredLabelsFile:  path,
+		Labels:              []string{"com.example.data-classification=internal"},
+	}
+	got, err := b.requiredLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"com.example.owner=team-a", "com.example.cost-center=1234"}, got); diff != "" {
+		t.Errorf("requiredLabels() mismatch (-want +got):\n%s", diff)
+	}
+
+	b.Labels = nil
+	if _, err := b.requiredLabels(); err == nil || !strings.Contains(err.Error(), "com.example.data-classification (set LABEL_DATA_CLASSIFICATION") {
+		t.Errorf("requiredLabels() with missing label error = %v", err)
+	}
+}
+
+func TestBuild_argFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(path, []byte("-----BEGIN CERTIFICATE-----\nMIIB,abc\n-----END CERTIFICATE-----\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := Build{Args: []string{"CA_CERT=@" + path, "VERSION=1.0", "HANDLE=@@octocat", "EMPTY"}}
+	got, err := b.argFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"CA_CERT=-----BEGIN CERTIFICATE-----\nMIIB,abc\n-----END CERTIFICATE-----", "VERSION=1.0", "HANDLE=@octocat", "EMPTY"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("argFiles() mismatch (-want +got):\n%s", diff)
+	}
+
+	b.Args = []string{"CA_CERT=@" + path + ".missing"}
+	if _, err := b.argFiles(); err == nil || !strings.Contains(err.Error(), "build arg CA_CERT") {
+		t.Errorf("argFiles() with missing file error = %v", err)
+	}
+}
+
+func TestPlugin_verifyDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	digestFile := filepath.Join(t.TempDir(), "digest-file")
+	if err := ioutil.WriteFile(digestFile, []byte(digest+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := Plugin{Build: Build{DigestFile: digestFile, ExpectedDigest: digest}}
+	if err := p.verifyDigest(); err != nil {
+		t.Errorf("verifyDigest() error = %v", err)
+	}
+	p.Build.ExpectedDigest = "sha256:" + strings.Repeat("b", 64)
+	if err := p.verifyDigest(); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("verifyDigest() of different digest error = %v", err)
+	}
+	p.Build.DigestFile = digestFile + ".missing"
+	if err := p.verifyDigest(); err == nil {
+		t.Error("verifyDigest() without digest error = nil")
+	}
+
+	for _, b := range []Build{
+		{DigestFile: digestFile, ExpectedDigest: "sha256:abc"},
+		{DigestFile: digestFile, ExpectedDigest: digest, Discover: true},
+		{DigestFile: digestFile, ExpectedDigest: digest, Release: "release.yaml"},
+		{ExpectedDigest: digest},
+	} {
+		if err := b.validateExpectedDigest(); err == nil {
+			t.Errorf("validateExpectedDigest(%+v) error = nil", b)
+		}
+	}
+}
+
+func TestPlugin_exportDigest(t *testing.T) {
+	dir := t.TempDir()
+	digestFile := filepath.Join(dir, "digest-file")
+	if err := ioutil.WriteFile(digestFile, []byte("sha256:abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	copies := []string{filepath.Join(dir, "workspace", ".digest"), filepath.Join(dir, "digest")}
+	p := Plugin{Build: Build{DigestFile: digestFile, DigestFiles: copies}}
+	p.exportDigest()
+	for _, path := range copies {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "sha256:abc" {
+			t.Errorf("digest at %s = %q, want %q", path, b, "sha256:abc")
+		}
+	}
+}
+
+func TestBuild_targetPlatformArgs(t *testing.T) {
+	tests := []struct {
+		platform string
+		args     []string
+		want     []string
+	}{
+		{
+			platform: "linux/amd64",
+			want:     []string{"--build-arg=TARGETPLATFORM=linux/amd64", "--build-arg=TARGETOS=linux", "--build-arg=TARGETARCH=amd64"},
+		},
+		{
+			platform: "linux/arm/v7",
+			args:     []string{"TARGETARCH=arm32", "VERSION=1"},
+			want:     []string{"--build-arg=TARGETPLATFORM=linux/arm/v7", "--build-arg=TARGETOS=linux", "--build-arg=TARGETVARIANT=v7"},
+		},
+	}
+	for _, test := range tests {
+		got, err := Build{Args: test.args}.targetPlatformArgs(test.platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("targetPlatformArgs(%q) mismatch (-want +got):\n%s", test.platform, diff)
+		}
+	}
+	if _, err := (Build{}).targetPlatformArgs("amd64"); err == nil {
+		t.Error("targetPlatformArgs() with invalid platform error = nil")
+	}
+}
+
+func TestBuild_checkDockerfile(t *testing.T) {
+	defer func(path string) { emulatedDockerfile = path }(emulatedDockerfile)
+	dir := t.TempDir()
+	emulatedDockerfile = filepath.Join(dir, "Dockerfile.emulated")
+
+	write := func(content string) string {
+		path := filepath.Join(dir, "Dockerfile")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := write("FROM golang\nRUN --mount=type=cache,target=/root/.cache/go-build \\\n    --network=default go build ./...\nCOPY --link . /src\n")
+	emulated, err := Build{Dockerfile: path}.checkDockerfile()
+	if err != nil {
+		t.Fatalf("checkDockerfile() error = %v", err)
+	}
+	if emulated.Dockerfile != emulatedDockerfile {
+		t.Errorf("dockerfile = %s, want %s", emulated.Dockerfile, emulatedDockerfile)
+	}
+	if diff := cmp.Diff([]string{"/root/.cache/go-build"}, emulated.IgnorePaths); diff != "" {
+		t.Errorf("ignore paths mismatch (-want +got):\n%s", diff)
+	}
+	b, _ := ioutil.ReadFile(emulated.Dockerfile)
+	if strings.Contains(string(b), "--") {
+		t.Errorf("emulated Dockerfile still contains flags:\n%s", b)
+	}
+
+	if _, err := (Build{Dockerfile: path, DockerfileCheck: dockerfileCheckStrict}).checkDockerfile(); err == nil {
+		t.Error("checkDockerfile() in strict mode error = nil")
+	}
+
+	path = write("FROM alpine\nRUN --mount=type=secret,id=npmrc,required=true npm ci\nRUN <<EOF\napk add curl\nEOF\n")
+	_, err = Build{Dockerfile: path}.checkDockerfile()
+	if err == nil || !strings.Contains(err.Error(), "Dockerfile:2: RUN --mount=type=secret") || !strings.Contains(err.Error(), "Dockerfile:3: RUN heredoc") {
+		t.Errorf("checkDockerfile() with unsupported features error = %v", err)
+	}
+
+	path = write("FROM alpine\nRUN apk add curl\n")
+	if emulated, err := (Build{Dockerfile: path}).checkDockerfile(); err != nil || emulated.Dockerfile != path {
+		t.Errorf("checkDockerfile() = %s, %v, want %s", emulated.Dockerfile, err, path)
+	}
+}
+
+func TestBuild_checkDockerfile_secrets(t *testing.T) {
+	defer func(path string) { emulatedDockerfile = path }(emulatedDockerfile)
+	dir := t.TempDir()
+	emulatedDockerfile = filepath.Join(dir, "Dockerfile.emulated")
+	path := filepath.Join(dir, "Dockerfile")
+	content := "FROM node\nRUN --mount=type=secret,id=npmrc,target=/root/.npmrc --mount=type=secret,id=token npm ci\nRUN --mount=type=secret,id=optional true\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	npmrc := filepath.Join(dir, ".npmrc")
+	if err := ioutil.WriteFile(npmrc, []byte("//registry.npmjs.org/:_authToken=secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NPM_TOKEN", "token")
+
+	b := Build{Dockerfile: path, DockerfileCheck: dockerfileCheckStrict, SecretFiles: []string{"npmrc=" + npmrc, "token=env:NPM_TOKEN"}}
+	if _, err := b.checkDockerfile(); err == nil || !strings.Contains(err.Error(), "Dockerfile:3: RUN --mount=type=secret") {
+		t.Errorf("checkDockerfile() in strict mode with optional secret error = %v", err)
+	}
+	b.DockerfileCheck = dockerfileCheckEmulate
+	emulated, err := b.checkDockerfile()
+	if err != nil {
+		t.Fatalf("checkDockerfile() error = %v", err)
+	}
+	want := map[string]string{"/root/.npmrc": "npmrc", "/run/secrets/token": "token"}
+	if diff := cmp.Diff(want, emulated.Secrets); diff != "" {
+		t.Errorf("secrets mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"/root/.npmrc", "/run/secrets/token"}, emulated.IgnorePaths); diff != "" {
+		t.Errorf("ignore paths mismatch (-want +got):\n%s", diff)
+	}
+
+	target := filepath.Join(dir, "run", "secrets", "token")
+	cleanup, err := b.writeSecrets(map[string]string{target: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(target); string(got) != "token" {
+		t.Errorf("secret = %q, want %q", got, "token")
+	}
+	cleanup()
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("secret not removed: %v", err)
+	}
+
+	if _, err := (Build{SecretFiles: []string{"npmrc"}}).secretFiles(); err == nil {
+		t.Error("secretFiles() with invalid secret error = nil")
+	}
+}
+
+func TestBuild_secretValues(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "netrc")
+	if err := ioutil.WriteFile(file, []byte("machine github.com\n  password ghp_secret\n\nx\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NPM_TOKEN", "npm_secret")
+	values, err := Build{SecretFiles: []string{"netrc=file:" + file, "token=env:NPM_TOKEN", "copy=env:NPM_TOKEN"}}.secretValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"password ghp_secret", "machine github.com", "npm_secret"}
+	if diff := cmp.Diff(want, values); diff != "" {
+		t.Errorf("secret values mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMaskWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newMaskWriter(&out, []string{"npm_secret_token", "npm_secret"})
+	for _, chunk := range []string{"INFO token npm_sec", "ret_token\nINFO npm_secret\n", "INFO npm_se", "cret"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := out.String(), "INFO token ********\nINFO ********\n"; got != want {
+		t.Errorf("output before flush = %q, want %q", got, want)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "INFO token ********\nINFO ********\nINFO ********"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestBuild_mountSecretsDir(t *testing.T) {
+	defer func(mount, unmount func(string) error) { mountTmpfs, unmountTmpfs = mount, unmount }(mountTmpfs, unmountTmpfs)
+	var mounted []string
+	mountTmpfs = func(dir string) error {
+		mounted = append(mounted, dir)
+		return nil
+	}
+	unmountTmpfs = func(dir string) error {
+		mounted = mounted[:len(mounted)-1]
+		return nil
+	}
+
+	dir := filepath.Join(t.TempDir(), "secrets")
+	unmount := Build{}.mountSecretsDir(dir)
+	if unmount == nil || len(mounted) != 1 || mounted[0] != dir {
+		t.Fatalf("mountSecretsDir() mounted %v", mounted)
+	}
+	unmount()
+	if _, err := os.Stat(dir); len(mounted) != 0 || !os.IsNotExist(err) {
+		t.Errorf("mountSecretsDir() cleanup left %v mounted, stat %v", mounted, err)
+	}
+
+	// Directories with contents are not hidden
+	if err := os.MkdirAll(filepath.Join(dir, "kubernetes.io"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if unmount := (Build{}).mountSecretsDir(dir); unmount != nil || len(mounted) != 0 {
+		t.Errorf("mountSecretsDir() of a directory with contents mounted %v", mounted)
+	}
+
+	mountTmpfs = func(string) error { return fmt.Errorf("operation not permitted") }
+	if unmount := (Build{}).mountSecretsDir(filepath.Join(t.TempDir(), "secrets")); unmount != nil {
+		t.Error("mountSecretsDir() with failing mount returned an unmount function")
+	}
+}
+
+func TestBuild_tagArgs(t *testing.T) {
+	tags, args, err := Build{TagArgs: []string{"prod:ENVIRONMENT=prod", "staging:ENVIRONMENT=staging", "prod:REPLICAS=3"}}.tagArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"prod", "staging"}, tags); diff != "" {
+		t.Errorf("tags mismatch (-want +got):\n%s", diff)
+	}
+	want := map[string][]string{"prod": {"ENVIRONMENT=prod", "REPLICAS=3"}, "staging": {"ENVIRONMENT=staging"}}
+	if diff := cmp.Diff(want, args); diff != "" {
+		t.Errorf("args mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, spec := range []string{"prod", "prod:ENVIRONMENT", ":ENVIRONMENT=prod", "prod:=prod"} {
+		if _, _, err := (Build{TagArgs: []string{spec}}).tagArgs(); err == nil {
+			t.Errorf("tagArgs(%q) error = nil", spec)
+		}
+	}
+}
+
+func TestPlugin_forTags(t *testing.T) {
+	p := Plugin{
+		Build:    Build{Tags: []string{"latest", "prod"}, Args: []string{"VERSION=1"}, TagArgs: []string{"prod:ENVIRONMENT=prod"}},
+		Artifact: Artifact{ArtifactFile: "artifact.json"},
+	}
+	sub := p.forTags([]string{"prod"}, []string{"ENVIRONMENT=prod"})
+	if diff := cmp.Diff([]string{"VERSION=1", "ENVIRONMENT=prod"}, sub.Build.Args); diff != "" {
+		t.Errorf("args mismatch (-want +got):\n%s", diff)
+	}
+	if len(sub.Build.TagArgs) != 0 || !sub.Build.Cleanup || sub.Artifact.ArtifactFile != "artifact-prod.json" {
+		t.Errorf("unexpected plugin %+v", sub)
+	}
+	if diff := cmp.Diff([]string{"VERSION=1"}, p.Build.Args); diff != "" {
+		t.Errorf("args of the plugin changed (-want +got):\n%s", diff)
+	}
+}
+
+func TestPlugin_forReleaseImage(t *testing.T) {
+	p := Plugin{
+		Build:    Build{Repo: "registry.example.com/team", Release: "release.yaml", Target: "prod", Args: []string{"VERSION=1"}},
+		Artifact: Artifact{Repo: "team", ArtifactFile: "artifact.json", Publishers: []string{"s3://bucket/release.json"}},
+	}
+	sub := p.forReleaseImage(release.Image{Name: "migrations", Context: "db", Dockerfile: "db/Dockerfile", Args: []string{"TOOL=flyway"}})
+	if sub.Build.Release != "" || sub.Build.Repo != "registry.example.com/team/migrations" || sub.Artifact.Repo != "team/migrations" {
+		t.Errorf("unexpected plugin %+v", sub)
+	}
+	if sub.Build.Context != "db" || sub.Build.Dockerfile != "db/Dockerfile" || sub.Build.Target != "prod" || !sub.Build.Cleanup {
+		t.Errorf("unexpected build %+v", sub.Build)
+	}
+	if diff := cmp.Diff([]string{"VERSION=1", "TOOL=flyway"}, sub.Build.Args); diff != "" {
+		t.Errorf("args mismatch (-want +got):\n%s", diff)
+	}
+	// The release artifact replaces those of the images
+	if sub.Artifact.ArtifactFile != "" || len(sub.Artifact.Publishers) != 0 {
+		t.Errorf("unexpected artifact %+v", sub.Artifact)
+	}
+}
+
+func TestBuild_DestinationTags(t *testing.T) {
+	if _, err := tagger.Lookup("test-sha"); err != nil {
+		tagger.Register("test-sha", tagger.Func(func(m tagger.Metadata) ([]string, error) {
+			return append(m.Tags, m.Tags[0]+"-"+m.CommitSha[:7]), nil
+		}))
+	}
+
+	tests := []struct {
+		build   Build
+		want    []string
+		wantErr bool
+	}{
+		{build: Build{Tags: []string{" latest", "1.0\n", "", "latest"}}, want: []string{"latest", "1.0"}},
+		{build: Build{Tags: []string{"v1.2.3", "1.2.3"}, ExpandTag: true}, want: []string{"1", "1.2", "1.2.3"}},
+		{build: Build{Tags: []string{"feature/login"}}, wantErr: true},
+		{build: Build{Tags: []string{".hidden"}}, wantErr: true},
+		{build: Build{Tags: []string{strings.Repeat("a", 129)}}, wantErr: true},
+		{build: Build{Tags: []string{"v1.2.3"}, ExpandTag: true, Taggers: []string{"test-sha"}, DroneCommitSha: "0123456789abcdef"}, want: []string{"1", "1.2", "1.2.3", "1-0123456"}},
+		{build: Build{Tags: []string{"latest"}, Taggers: []string{"unknown"}}, wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := test.build.DestinationTags()
+		if (err != nil) != test.wantErr {
+			t.Errorf("DestinationTags(%q) error = %v, wantErr %v", test.build.Tags, err, test.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("DestinationTags(%q) mismatch (-want +got):\n%s", test.build.Tags, diff)
+		}
+	}
+}
+
+func TestBuild_extractContext(t *testing.T) {
+	writeTarball := func(t *testing.T, path string, headers ...*tar.Header) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, hdr := range headers {
+			if hdr.Typeflag == tar.TypeReg {
+				hdr.Size = int64(len(hdr.Name))
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				tw.Write([]byte(hdr.Name))
+			}
+		}
+		tw.Close()
+		gz.Close()
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "context.tar.gz")
+	writeTarball(t, path,
+		&tar.Header{Name: "app/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "app/Dockerfile", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "app/main.go", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "app/current", Typeflag: tar.TypeSymlink, Linkname: "main.go"},
+	)
+	b := Build{Context: path, ContextSubPath: "app", Dockerfile: "Dockerfile"}
+	if !b.tarballContext() {
+		t.Fatal("tarballContext() = false")
+	}
+	cleanup, err := b.extractContext()
+	if err != nil {
+		t.Fatalf("extractContext() error = %v", err)
+	}
+	if b.Dockerfile != filepath.Join(b.Context, "app", "Dockerfile") {
+		t.Errorf("Dockerfile = %s, want the extracted Dockerfile", b.Dockerfile)
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(b.Context, "app", "current")); string(got) != "app/main.go" {
+		t.Errorf("app/current = %q, want %q", got, "app/main.go")
+	}
+	if got := b.contextArg(); got != "dir://"+b.Context {
+		t.Errorf("contextArg() = %s", got)
+	}
+	cleanup()
+	if _, err := os.Stat(b.Context); !os.IsNotExist(err) {
+		t.Errorf("context not removed: %v", err)
+	}
+
+	for name, hdr := range map[string]*tar.Header{
+		"escaping entry":   {Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644},
+		"escaping symlink": {Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+	} {
+		writeTarball(t, path, hdr)
+		b := Build{Context: path, Dockerfile: "Dockerfile"}
+		if _, err := b.extractContext(); err == nil {
+			t.Errorf("extractContext() with %s error = nil", name)
+		}
+	}
+
+	if (Build{Context: "tar://context.tar.gz"}).tarballContext() {
+		t.Error("tarballContext() with kaniko tar context = true")
+	}
+}
+
+func TestPlugin_Exec_ledger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "builds.jsonl")
+	p := Plugin{Build: Build{
+		NoPush:         true,
+		Dockerfile:     filepath.Join(t.TempDir(), "Dockerfile"),
+		Tags:           []string{"latest", "1.0"},
+		Repo:           "octocat/app",
+		DroneRepo:      "octocat/app",
+		DroneCommitSha: "d8cb326",
+		Ledger:         path,
+	}}
+	if err := p.Exec(); err == nil {
+		t.Fatal("Exec() with missing Dockerfile error = nil")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry ledger.Entry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		t.Fatalf("invalid ledger %q: %s", b, err)
+	}
+	want := ledger.Entry{
+		Repo:   "octocat/app",
+		Commit: "d8cb326",
+		Image:  "octocat/app",
+		Tags:   []string{"latest", "1.0"},
+		Result: ledger.ResultFailure,
+		Phase:  PhaseValidate,
+		Error:  "dockerfile does not exist at path: " + p.Build.Dockerfile,
+	}
+	if diff := cmp.Diff(want, entry, cmpopts.IgnoreFields(ledger.Entry{}, "Time", "Duration")); diff != "" {
+		t.Errorf("ledger entry mismatch (-want +got):\n%s", diff)
+	}
+
+	p.Build.Ledger = "s3://builds/ledger.jsonl"
+	if err := p.Exec(); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Exec() with unsupported ledger error = %v", err)
+	}
+}
+
+func TestPlugin_runExecutor_buildTimeout(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "executor")
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho run >> " + calls + "\necho 'retrieving image: 503 Service Unavailable' >&2\nexec sleep 30\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	p := Plugin{Build: Build{ExecutorPath: path, BuildTimeout: 200 * time.Millisecond, PullRetry: 2, PullRetryBackoff: time.Millisecond}}
+	err := p.runExecutor(nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Fatalf("runExecutor() error = %v, want timeout", err)
+	}
+	if got, _ := ioutil.ReadFile(calls); string(got) != "run\n" {
+		t.Errorf("executor runs = %q, want a single run", got)
+	}
+}
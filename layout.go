@@ -0,0 +1,188 @@
+package kaniko
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/gexops/drone-kaniko/pkg/assert"
+	"github.com/gexops/drone-kaniko/pkg/dockerfile"
+	"github.com/gexops/drone-kaniko/pkg/layout"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/gexops/drone-kaniko/pkg/secretscan"
+)
+
+const (
+	// layoutPath is where kaniko writes the OCI layout of images checked before push.
+	layoutPath string = "/kaniko/oci-layout"
+
+	secretScanWarn string = "warn"
+	secretScanFail string = "fail"
+)
+
+// usesLayout reports whether the image must be checked before it is pushed.
+func (b Build) usesLayout() bool {
+	return b.AssertEntrypoint != "" || len(b.AssertPorts) > 0 || len(b.AssertEnv) > 0 || len(b.AssertLabels) > 0 ||
+		b.SecretScan != "" || b.CIAnnotations || len(b.Annotations) > 0
+}
+
+// assertions returns the image config assertions of the build.
+func (b Build) assertions() (assert.Assertions, error) {
+	entrypoint, err := assert.ParseEntrypoint(b.AssertEntrypoint)
+	return assert.Assertions{
+		Entrypoint:   entrypoint,
+		ExposedPorts: b.AssertPorts,
+		Env:          b.AssertEnv,
+		Labels:       b.AssertLabels,
+	}, err
+}
+
+// pushLayout checks the image kaniko wrote to the OCI layout and, unless
+// no-push is set, pushes it with the given tags.
+func (p Plugin) pushLayout(tags []string) error {
+	l, err := layout.Open(layoutPath)
+	if err != nil {
+		return err
+	}
+	if err := p.checkLayout(l); err != nil {
+		return err
+	}
+
+	if p.Build.NoPush {
+		return nil
+	}
+	if err := p.annotateLayout(l); err != nil {
+		return err
+	}
+	repo, err := registry.ParseRepository(p.Build.Repo)
+	if err != nil {
+		return err
+	}
+	digest, err := l.Push(p.stepContext(), p.registryClient(), repo, tags)
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %s", repo, err)
+	}
+	for _, tag := range tags {
+		fmt.Fprintf(os.Stdout, "Pushed %s:%s@%s\n", repo, tag, digest)
+	}
+	p.writeDigestFile(digest)
+	return nil
+}
+
+// checkLayout runs the image assertions and the secret scan against the
+// image in the layout.
+func (p Plugin) checkLayout(l *layout.Layout) error {
+	config, err := l.Config()
+	if err != nil {
+		return err
+	}
+
+	assertions, err := p.Build.assertions()
+	if err != nil {
+		return err
+	}
+	if err := assertions.Check(config); err != nil {
+		return err
+	}
+	if !assertions.Empty() {
+		fmt.Fprintf(os.Stdout, "Image assertions passed\n")
+	}
+
+	if p.Build.SecretScan != "" {
+		return p.scanSecrets(l, config)
+	}
+	return nil
+}
+
+// annotateLayout adds the manifest annotations to the image in the layout.
+func (p Plugin) annotateLayout(l *layout.Layout) error {
+	annotations, err := p.Build.manifestAnnotations()
+	if err != nil {
+		return err
+	}
+	if err := l.Annotate(annotations); err != nil {
+		return fmt.Errorf("failed to annotate image: %s", err)
+	}
+	return nil
+}
+
+// writeDigestFile records the digest of an image pushed by the plugin.
+func (p Plugin) writeDigestFile(digest string) {
+	if p.Build.DigestFile == "" {
+		return
+	}
+	if err := ioutil.WriteFile(p.Build.DigestFile, []byte(digest), 0644); err != nil {
+		p.warnf("failed to write digest file at path: %s with error: %s\n", p.Build.DigestFile, err)
+	}
+}
+
+// scanSecrets scans the layers the build added on top of its base image for
+// secrets. Findings fail the build in fail mode and are only reported in warn
+// mode.
+func (p Plugin) scanSecrets(l *layout.Layout, config *registry.ImageConfig) error {
+	m, err := l.Manifest()
+	if err != nil {
+		return err
+	}
+	image, err := m.Image()
+	if err != nil {
+		return err
+	}
+
+	layers := image.Layers
+	base, err := p.baseLayerCount(config)
+	switch {
+	case err != nil:
+		p.warnf("secret scan: failed to inspect the base image, scanning all layers: %s\n", err)
+	case base > len(layers):
+		p.warnf("secret scan: the base image has more layers than the image, scanning all layers\n")
+	default:
+		layers = layers[base:]
+	}
+
+	scanner := secretscan.New(p.Build.SecretScanIgnore)
+	var findings []secretscan.Finding
+	for _, desc := range layers {
+		f, err := os.Open(l.BlobPath(desc.Digest))
+		if err != nil {
+			return err
+		}
+		layerFindings, err := scanner.ScanLayer(desc.Digest, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		findings = append(findings, layerFindings...)
+	}
+
+	if len(findings) == 0 {
+		fmt.Fprintf(os.Stdout, "Secret scan found no secrets in %d layers\n", len(layers))
+		return nil
+	}
+	for _, finding := range findings {
+		p.warnf("secret scan: possible %s\n", finding)
+	}
+	if p.Build.SecretScan == secretScanFail {
+		return fmt.Errorf("secret scan found %d possible secrets in the image", len(findings))
+	}
+	return nil
+}
+
+// baseLayerCount returns the number of layers of the base image of the final
+// stage, which the image starts with, for the platform of the image.
+func (p Plugin) baseLayerCount(config *registry.ImageConfig) (int, error) {
+	d, err := dockerfile.ParseFile(p.Build.Dockerfile)
+	if err != nil {
+		return 0, err
+	}
+	image := d.FinalBaseImage(p.Build.Target, p.Build.Args)
+	if image == "" {
+		return 0, nil
+	}
+	repo, reference, err := registry.ParseReference(image)
+	if err != nil {
+		return 0, fmt.Errorf("invalid base image %s: %s", image, err)
+	}
+	platform := registry.Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+	return p.registryClient().LayerCount(p.stepContext(), repo, reference, platform)
+}
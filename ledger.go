@@ -0,0 +1,69 @@
+package kaniko
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gexops/drone-kaniko/pkg/ledger"
+	"github.com/pkg/errors"
+)
+
+// ledgerStore returns the store of the build ledger, a file or, for URLs
+// such as s3:// and gs://, the LedgerStore set by the command.
+func (p Plugin) ledgerStore() (ledger.Store, error) {
+	if !strings.Contains(p.Build.Ledger, "://") {
+		return ledger.File(p.Build.Ledger), nil
+	}
+	if p.LedgerStore == nil {
+		return nil, fmt.Errorf("ledger %s is not supported by this plugin, expected a file path", p.Build.Ledger)
+	}
+	return p.LedgerStore, nil
+}
+
+// recordBuild appends the result of the build started at start to the
+// ledger. Failures are only logged, so that they don't fail builds whose
+// image is already pushed.
+func (p Plugin) recordBuild(start time.Time, phase string, err error) {
+	store, serr := p.ledgerStore()
+	if serr != nil {
+		return // Already reported by exec
+	}
+	entry := ledger.Entry{
+		Time:        start.UTC(),
+		Repo:        p.Build.DroneRepo,
+		Commit:      p.Build.DroneCommitSha,
+		Ref:         p.Build.DroneCommitRef,
+		Event:       p.Build.DroneBuildEvent,
+		BuildNumber: p.Build.DroneBuildNumber,
+		BuildLink:   p.Build.DroneBuildLink,
+		Stage:       p.Build.DroneStageName,
+		Step:        p.Build.DroneStepName,
+		Image:       p.Build.Repo,
+		Duration:    time.Since(start).Round(time.Millisecond).Seconds(),
+		Result:      ledger.ResultSuccess,
+	}
+	entry.Tags, _ = p.Build.DestinationTags()
+	switch {
+	case err != nil:
+		entry.Result = ledger.ResultFailure
+		entry.Phase = phase
+		var phaseErr *PhaseError
+		if errors.As(err, &phaseErr) {
+			entry.Phase = phaseErr.Phase
+		}
+		entry.Error = err.Error()
+	case phase == PhaseValidate:
+		entry.Result = ledger.ResultSkipped
+	default:
+		entry.Digest = p.imageDigest()
+		entry.Pushed = !p.Build.NoPush && entry.Digest != ""
+	}
+
+	if err := ledger.Append(p.stepContext(), store, entry); err != nil {
+		p.warnf("failed to record build in ledger %s: %s\n", p.Build.Ledger, err)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "Recorded build in ledger %s\n", p.Build.Ledger)
+}
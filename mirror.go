@@ -0,0 +1,58 @@
+package kaniko
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/dockerfile"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// checkStrictMirrors fails when a base image of the Dockerfile would be
+// pulled from a registry other than the configured mirrors or the
+// destination registry. Docker Hub images are allowed when mirrors are
+// configured, since kaniko rewrites them to the mirrors and is told not to
+// fall back to Docker Hub.
+func (b Build) checkStrictMirrors() error {
+	d, err := dockerfile.ParseFile(b.Dockerfile)
+	if err != nil {
+		return fmt.Errorf("strict mirror mode: failed to parse %s: %s", b.Dockerfile, err)
+	}
+
+	allowed := map[string]bool{}
+	for _, mirror := range b.Mirrors {
+		allowed[mirrorHost(mirror)] = true
+	}
+	if repo, err := registry.ParseRepository(b.Repo); err == nil {
+		allowed[repo.Registry] = true
+	}
+
+	// Unset variables expand to an empty string, turning e.g. ${REGISTRY}/base
+	// into a Docker Hub image, so they are rejected before expanding
+	if unset := d.UnsetArgs(b.Args); len(unset) != 0 {
+		return fmt.Errorf("strict mirror mode: cannot resolve the base image of %s:%d, %s is not set", b.Dockerfile, unset[0].Line, unset[0].Name)
+	}
+	for _, image := range d.BaseImages(b.Args) {
+		if strings.Contains(image, "$") {
+			return fmt.Errorf("strict mirror mode: cannot resolve base image %s", image)
+		}
+		repo, _, err := registry.ParseReference(image)
+		if err != nil {
+			return fmt.Errorf("strict mirror mode: invalid base image %s: %s", image, err)
+		}
+		if repo.Registry == "registry-1.docker.io" && len(b.Mirrors) != 0 {
+			continue
+		}
+		if !allowed[repo.Registry] {
+			return fmt.Errorf("strict mirror mode: base image %s would be pulled from %s, which is not a configured mirror", image, repo.Registry)
+		}
+	}
+	return nil
+}
+
+// mirrorHost returns the registry host of a kaniko registry mirror, which
+// may include a scheme and a path.
+func mirrorHost(mirror string) string {
+	mirror = strings.TrimPrefix(strings.TrimPrefix(mirror, "https://"), "http://")
+	return strings.SplitN(mirror, "/", 2)[0]
+}
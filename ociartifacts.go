@@ -0,0 +1,91 @@
+package kaniko
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/ociartifact"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// ociArtifactFiles parses the OCI artifact files and checks that they exist.
+func (b Build) ociArtifactFiles() ([]ociartifact.File, error) {
+	var files []ociartifact.File
+	for _, s := range b.OCIArtifacts {
+		f, err := ociartifact.ParseFile(s)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(f.Path); err != nil {
+			return nil, fmt.Errorf("OCI artifact file %s: %s", f.Path, err)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// pushOCIArtifacts pushes the OCI artifact files to the image repository.
+// The artifact refers to the pushed image when its digest is known, so that
+// it is listed by the referrers API of the image.
+func (p Plugin) pushOCIArtifacts(tags []string) error {
+	files, err := p.Build.ociArtifactFiles()
+	if err != nil {
+		return err
+	}
+	tag := p.Build.OCIArtifactsTag
+	if tag == "" {
+		if len(tags) == 0 {
+			return fmt.Errorf("OCI artifacts tag must be specified")
+		}
+		tag = tags[0] + "-files"
+	}
+	artifactType := p.Build.OCIArtifactType
+	if artifactType == "" {
+		artifactType = ociartifact.DefaultArtifactType
+	}
+
+	repo, err := registry.ParseRepository(p.Build.Repo)
+	if err != nil {
+		return err
+	}
+	client := p.registryClient()
+	subject, err := p.imageDescriptor(client, repo)
+	if err != nil {
+		return err
+	}
+	digest, err := ociartifact.Push(p.stepContext(), client, repo, tag, artifactType, files, subject)
+	if err != nil {
+		return fmt.Errorf("failed to push OCI artifacts to %s: %s", repo, err)
+	}
+	fmt.Fprintf(os.Stdout, "Pushed %d files to %s:%s@%s\n", len(files), repo, tag, digest)
+	return nil
+}
+
+// imageDescriptor returns the descriptor of the pushed image, or nil when
+// its digest is unknown.
+func (p Plugin) imageDescriptor(client *registry.Client, repo registry.Repository) (*registry.Descriptor, error) {
+	digest := p.imageDigest()
+	if digest == "" {
+		return nil, nil
+	}
+	m, err := client.GetManifest(p.stepContext(), repo, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pushed image: %s", err)
+	}
+	return &registry.Descriptor{MediaType: m.MediaType, Digest: m.Digest, Size: int64(len(m.Content))}, nil
+}
+
+// imageDigest returns the digest of the pushed image recorded in the digest
+// file, or an empty string when it is unknown.
+func (p Plugin) imageDigest() string {
+	if p.Build.DigestFile == "" {
+		return ""
+	}
+	b, err := ioutil.ReadFile(p.Build.DigestFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,152 @@
+package kaniko
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Output formats of the plugin result
+const (
+	OutputText string = "text" // Log output only
+	OutputJSON string = "json" // Log output followed by the result as a line of JSON
+)
+
+// Statuses of the plugin result
+const (
+	StatusSuccess string = "success"
+	StatusFailure string = "failure"
+	StatusSkipped string = "skipped" // No image built, since no trigger paths changed
+)
+
+// lastResult is the result of the last Exec, written by WriteResult.
+var lastResult *result
+
+// result is the outcome of a plugin step, collected by Exec and the builds
+// it runs for discovered services and tag variants.
+type result struct {
+	Status    string             `json:"status"`
+	Images    []resultImage      `json:"images"`
+	Digest    string             `json:"digest,omitempty"` // Digest of the first image
+	Durations map[string]float64 `json:"durations"`        // Seconds spent in each phase, and in total
+	Warnings  []string           `json:"warnings"`         // Non-fatal failures logged by the plugin
+	Phase     string             `json:"phase,omitempty"`  // Phase the step failed in
+	Error     string             `json:"error,omitempty"`
+}
+
+// resultImage is an image built or promoted by the plugin.
+type resultImage struct {
+	Repo   string   `json:"repo"`
+	Tags   []string `json:"tags"`
+	Digest string   `json:"digest,omitempty"`
+	Pushed bool     `json:"pushed"`
+}
+
+func newResult() *result {
+	return &result{Images: []resultImage{}, Durations: map[string]float64{}, Warnings: []string{}}
+}
+
+func (r *result) addImage(image resultImage) {
+	r.Images = append(r.Images, image)
+	if r.Digest == "" {
+		r.Digest = image.Digest
+	}
+}
+
+func (r *result) addDurations(durations map[string]time.Duration) {
+	for phase, d := range durations {
+		r.Durations[phase] += d.Round(time.Millisecond).Seconds()
+	}
+}
+
+// warningsMu guards the warnings of results, which the credential refresh
+// adds to in the background.
+var warningsMu sync.Mutex
+
+func (r *result) addWarning(warning string) {
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	r.Warnings = append(r.Warnings, warning)
+}
+
+// phaseClock tracks the phase of Exec and the time spent in each phase.
+type phaseClock struct {
+	phase     string
+	started   time.Time
+	durations map[string]time.Duration
+}
+
+func newPhaseClock(phase string) *phaseClock {
+	return &phaseClock{phase: phase, started: time.Now(), durations: map[string]time.Duration{}}
+}
+
+// enter ends the current phase and starts the next one.
+func (c *phaseClock) enter(phase string) {
+	now := time.Now()
+	c.durations[c.phase] += now.Sub(c.started)
+	c.phase, c.started = phase, now
+}
+
+// stop ends the current phase and returns the time spent in each phase.
+func (c *phaseClock) stop() map[string]time.Duration {
+	c.enter(c.phase)
+	return c.durations
+}
+
+// warnf logs a non-fatal failure to stderr and records it in the result.
+func (p Plugin) warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprint(os.Stderr, msg)
+	if p.result != nil {
+		p.result.addWarning(strings.TrimSpace(msg))
+	}
+}
+
+// WriteResult runs the plugin step and, in the json output format, writes
+// its result to stdout as the final line of JSON, whether or not it failed.
+// Errors raised before Exec are reported in the setup phase.
+func WriteResult(format string, run func() error) error {
+	switch format {
+	case "", OutputText:
+		return run()
+	case OutputJSON:
+	default:
+		return fmt.Errorf("invalid output format %s, expected %s or %s", format, OutputText, OutputJSON)
+	}
+
+	lastResult = nil
+	err := run()
+	if werr := writeResult(os.Stdout, lastResult, err); werr != nil {
+		fmt.Fprintf(os.Stderr, "failed to write result: %s\n", werr)
+	}
+	return err
+}
+
+// writeResult writes the result of the step, which failed with err if set,
+// as a line of JSON.
+func writeResult(w io.Writer, r *result, err error) error {
+	if r == nil {
+		r = newResult()
+	}
+	switch {
+	case err != nil:
+		r.Status = StatusFailure
+		r.Phase = PhaseSetup
+		var phaseErr *PhaseError
+		if errors.As(err, &phaseErr) {
+			r.Phase = phaseErr.Phase
+		}
+		r.Error = err.Error()
+	case len(r.Images) == 0:
+		r.Status = StatusSkipped
+	default:
+		r.Status = StatusSuccess
+	}
+	return json.NewEncoder(w).Encode(r)
+}
@@ -0,0 +1,115 @@
+package kaniko
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestWriteResult_invalidFormat(t *testing.T) {
+	ran := false
+	err := WriteResult("yaml", func() error {
+		ran = true
+		return nil
+	})
+	if err == nil || ran {
+		t.Errorf("WriteResult() with invalid format = %v, ran %v", err, ran)
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	dockerfile := filepath.Join(t.TempDir(), "Dockerfile")
+	p := Plugin{Build: Build{NoPush: true, Dockerfile: dockerfile, Tags: []string{"latest"}, Repo: "octocat/app"}}
+	var err error
+	if werr := WriteResult(OutputJSON, func() error {
+		err = p.Exec()
+		return err
+	}); werr != err {
+		t.Errorf("WriteResult() error = %v, want %v", werr, err)
+	}
+
+	var out bytes.Buffer
+	if err := writeResult(&out, lastResult, err); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("expected a single line of JSON, got %q", out.String())
+	}
+	var got result
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("invalid result %q: %s", out.String(), err)
+	}
+	want := result{
+		Status:   StatusFailure,
+		Images:   []resultImage{},
+		Warnings: []string{},
+		Phase:    PhaseValidate,
+		Error:    "dockerfile does not exist at path: " + dockerfile,
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(result{}, "Durations")); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+	if _, ok := got.Durations["total"]; !ok {
+		t.Errorf("expected total duration, got %v", got.Durations)
+	}
+}
+
+func TestWriteResult_status(t *testing.T) {
+	image := resultImage{Repo: "octocat/app", Tags: []string{"latest"}, Digest: "sha256:abc", Pushed: true}
+	tests := []struct {
+		name   string
+		images []resultImage
+		err    error
+		status string
+		phase  string
+	}{
+		{name: "success", images: []resultImage{image}, status: StatusSuccess},
+		{name: "skipped", status: StatusSkipped},
+		{name: "setup failure", err: fmt.Errorf("missing credentials"), status: StatusFailure, phase: PhaseSetup},
+		{name: "build failure", err: &PhaseError{Phase: PhaseBuild, Err: fmt.Errorf("exit status 1")}, status: StatusFailure, phase: PhaseBuild},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newResult()
+			for _, image := range test.images {
+				r.addImage(image)
+			}
+			r.addWarning("failed to publish plugin artifact")
+			var out bytes.Buffer
+			if err := writeResult(&out, r, test.err); err != nil {
+				t.Fatal(err)
+			}
+			var got result
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatalf("invalid result %q: %s", out.String(), err)
+			}
+			if got.Status != test.status || got.Phase != test.phase || len(got.Warnings) != 1 {
+				t.Errorf("unexpected result %+v", got)
+			}
+			if len(test.images) != 0 && got.Digest != image.Digest {
+				t.Errorf("digest = %s, want %s", got.Digest, image.Digest)
+			}
+		})
+	}
+}
+
+func TestPhaseClock(t *testing.T) {
+	c := newPhaseClock(PhaseValidate)
+	c.enter(PhaseBuild)
+	c.enter(PhasePublish)
+	durations := c.stop()
+	for _, phase := range []string{PhaseValidate, PhaseBuild, PhasePublish} {
+		if _, ok := durations[phase]; !ok {
+			t.Errorf("missing duration of phase %s in %v", phase, durations)
+		}
+	}
+	if c.phase != PhasePublish {
+		t.Errorf("phase = %s, want %s", c.phase, PhasePublish)
+	}
+}
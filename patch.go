@@ -0,0 +1,71 @@
+package kaniko
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/patch"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// patchRules parses the manifest patch rules.
+func (b Build) patchRules() ([]patch.Rule, error) {
+	var rules []patch.Rule
+	for _, s := range b.PatchFiles {
+		rule, err := patch.ParseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// patchImage returns the pushed image the manifests are patched with. Docker
+// Hub images are written without registry host and are also matched by
+// their docker.io names.
+func patchImage(name, tag, digest string) (patch.Image, error) {
+	repo, err := registry.ParseRepository(name)
+	if err != nil {
+		return patch.Image{}, err
+	}
+	image := patch.Image{Repo: repo.String(), Names: []string{repo.String()}, Tag: tag, Digest: digest}
+	if repo.Registry == "registry-1.docker.io" {
+		short := strings.TrimPrefix(repo.Name, "library/")
+		image.Repo = short
+		image.Names = []string{"docker.io/" + repo.Name, "index.docker.io/" + repo.Name, repo.Name}
+		if short != repo.Name {
+			image.Names = append(image.Names, "docker.io/"+short, short)
+		}
+	}
+	return image, nil
+}
+
+// patchManifests updates the deployment manifests in the workspace with the
+// digest reference of the pushed image.
+func (p Plugin) patchManifests(tags []string) error {
+	rules, err := p.Build.patchRules()
+	if err != nil {
+		return err
+	}
+	digest := p.imageDigest()
+	if digest == "" {
+		return fmt.Errorf("failed to patch manifests: digest of the pushed image unknown")
+	}
+	var tag string
+	if len(tags) != 0 {
+		tag = tags[0]
+	}
+	image, err := patchImage(p.Build.Repo, tag, digest)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := patch.Apply(rule, image); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "Patched %s with %s\n", rule.File, image.Ref())
+	}
+	return nil
+}
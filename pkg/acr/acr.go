@@ -0,0 +1,119 @@
+// Package acr implements Azure Container Registry authentication with Azure
+// AD workload identity federation.
+package acr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultAuthorityHost is the Azure AD endpoint of the public cloud.
+	DefaultAuthorityHost string = "https://login.microsoftonline.com/"
+
+	// TokenUsername is the docker username that accompanies ACR refresh tokens.
+	TokenUsername string = "00000000-0000-0000-0000-000000000000"
+
+	// scope requested for the Azure AD token exchanged with ACR.
+	scope string = "https://management.azure.com/.default"
+
+	clientAssertionType string = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+// Client exchanges federated tokens for Azure AD and ACR tokens.
+type Client struct {
+	HTTPClient    *http.Client
+	AuthorityHost string // Azure AD authority host, defaults to DefaultAuthorityHost
+	Scheme        string // URL scheme of the registry, defaults to https
+	UserAgent     string // User-Agent of token requests
+}
+
+// AADToken exchanges the federated token issued to the workload, e.g. a
+// projected Kubernetes service account token, for an Azure AD access token
+// of the application clientID in tenantID.
+func (c *Client) AADToken(ctx context.Context, tenantID, clientID, federatedToken string) (string, error) {
+	authority := c.AuthorityHost
+	if authority == "" {
+		authority = DefaultAuthorityHost
+	}
+	endpoint := strings.TrimSuffix(authority, "/") + "/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token"
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := c.postForm(ctx, endpoint, url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {clientID},
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {strings.TrimSpace(federatedToken)},
+		"scope":                 {scope},
+	}, &token)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to exchange federated token for an Azure AD token")
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("Azure AD token response contains no access token")
+	}
+	return token.AccessToken, nil
+}
+
+// RefreshToken exchanges an Azure AD access token for an ACR refresh token,
+// which docker clients use as password together with TokenUsername.
+func (c *Client) RefreshToken(ctx context.Context, registry, tenantID, aadToken string) (string, error) {
+	scheme := c.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	var token struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := c.postForm(ctx, fmt.Sprintf("%s://%s/oauth2/exchange", scheme, registry), url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"tenant":       {tenantID},
+		"access_token": {aadToken},
+	}, &token)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to exchange Azure AD token for an ACR refresh token of %s", registry))
+	}
+	if token.RefreshToken == "" {
+		return "", fmt.Errorf("ACR token response contains no refresh token")
+	}
+	return token.RefreshToken, nil
+}
+
+func (c *Client) postForm(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
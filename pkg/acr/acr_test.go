@@ -0,0 +1,56 @@
+package acr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClient_exchange(t *testing.T) {
+	var registry string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		switch r.URL.Path {
+		case "/tenant/oauth2/v2.0/token":
+			if r.Form.Get("client_assertion") != "federated" || r.Form.Get("client_id") != "client" ||
+				r.Form.Get("client_assertion_type") != clientAssertionType {
+				http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"access_token":"aad","token_type":"Bearer"}`))
+		case "/oauth2/exchange":
+			if r.Form.Get("access_token") != "aad" || r.Form.Get("service") != registry || r.Form.Get("tenant") != "tenant" {
+				http.Error(w, `{"errors":[{"code":"UNAUTHORIZED"}]}`, http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"refresh_token":"refresh"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	registry = strings.TrimPrefix(srv.URL, "http://")
+
+	c := &Client{AuthorityHost: srv.URL + "/", Scheme: "http"}
+	ctx := context.Background()
+
+	aad, err := c.AADToken(ctx, "tenant", "client", "federated\n")
+	if err != nil {
+		t.Fatalf("AADToken failed: %s", err)
+	}
+	refresh, err := c.RefreshToken(ctx, registry, "tenant", aad)
+	if err != nil {
+		t.Fatalf("RefreshToken failed: %s", err)
+	}
+	if refresh != "refresh" {
+		t.Errorf("refresh token = %q, want %q", refresh, "refresh")
+	}
+
+	if _, err := c.AADToken(ctx, "tenant", "client", "forged"); err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("expected invalid_client error, got %v", err)
+	}
+}
@@ -1,13 +1,15 @@
 package artifact
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -20,25 +22,65 @@ const (
 	Docker RegistryTypeEnum = "Docker"
 	ECR    RegistryTypeEnum = "ECR"
 	GCR    RegistryTypeEnum = "GCR"
+	ACR    RegistryTypeEnum = "ACR"
 )
 
+type FormatEnum string
+
+const (
+	JSON FormatEnum = "json"
+	YAML FormatEnum = "yaml"
+	Env  FormatEnum = "env"
+)
+
+// ParseFormat parses the artifact file format, which defaults to JSON.
+func ParseFormat(s string) (FormatEnum, error) {
+	switch format := FormatEnum(strings.ToLower(s)); format {
+	case "":
+		return JSON, nil
+	case JSON, YAML, Env:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported artifact format %s, must be one of %s, %s or %s", s, JSON, YAML, Env)
+}
+
+// ContentType returns the media type of artifacts in the format.
+func (f FormatEnum) ContentType() string {
+	switch f {
+	case YAML:
+		return "application/yaml"
+	case Env:
+		return "text/plain"
+	}
+	return "application/json"
+}
+
 type (
 	Image struct {
-		Image  string `json:"image"`
-		Digest string `json:"digest"`
+		Image  string `json:"image" yaml:"image"`
+		Digest string `json:"digest" yaml:"digest"`
 	}
 	Data struct {
-		RegistryType RegistryTypeEnum `json:"registryType"`
-		RegistryUrl  string           `json:"registryUrl"`
-		Images       []Image          `json:"images"`
+		RegistryType RegistryTypeEnum `json:"registryType" yaml:"registryType"`
+		RegistryUrl  string           `json:"registryUrl" yaml:"registryUrl"`
+		Images       []Image          `json:"images" yaml:"images"`
 	}
 	DockerArtifact struct {
-		Kind string `json:"kind"`
-		Data Data   `json:"data"`
+		Kind string `json:"kind" yaml:"kind"`
+		Data Data   `json:"data" yaml:"data"`
 	}
 )
 
-func WritePluginArtifactFile(registryType RegistryTypeEnum, artifactFilePath, registryUrl, imageName, digest string, tags []string) error {
+func WritePluginArtifactFile(registryType RegistryTypeEnum, format FormatEnum, artifactFilePath, registryUrl, imageName, digest string, tags []string) error {
+	b, err := Marshal(registryType, format, registryUrl, imageName, digest, tags)
+	if err != nil {
+		return err
+	}
+	return File(artifactFilePath).Publish(context.TODO(), b, format)
+}
+
+// Marshal returns the artifact of the image pushed with the tags in the format.
+func Marshal(registryType RegistryTypeEnum, format FormatEnum, registryUrl, imageName, digest string, tags []string) ([]byte, error) {
 	var images []Image
 	for _, tag := range tags {
 		images = append(images, Image{
@@ -46,6 +88,12 @@ func WritePluginArtifactFile(registryType RegistryTypeEnum, artifactFilePath, re
 			Digest: digest,
 		})
 	}
+	return MarshalImages(registryType, format, registryUrl, images)
+}
+
+// MarshalImages returns the artifact of several pushed images in the format,
+// e.g. the images of a release.
+func MarshalImages(registryType RegistryTypeEnum, format FormatEnum, registryUrl string, images []Image) ([]byte, error) {
 	data := Data{
 		RegistryType: registryType,
 		RegistryUrl:  registryUrl,
@@ -57,20 +105,55 @@ func WritePluginArtifactFile(registryType RegistryTypeEnum, artifactFilePath, re
 		Data: data,
 	}
 
-	b, err := json.MarshalIndent(dockerArtifact, "", "\t")
+	b, err := marshal(dockerArtifact, format)
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("failed to marshal output %+v", dockerArtifact))
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to marshal output %+v", dockerArtifact))
 	}
+	return b, nil
+}
 
-	dir := filepath.Dir(artifactFilePath)
-	err = os.MkdirAll(dir, 0644)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("failed to create %s directory for artifact file", dir))
+func marshal(dockerArtifact DockerArtifact, format FormatEnum) ([]byte, error) {
+	switch format {
+	case YAML:
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(dockerArtifact); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Env:
+		return marshalEnv(dockerArtifact), nil
 	}
+	return json.MarshalIndent(dockerArtifact, "", "\t")
+}
 
-	err = ioutil.WriteFile(artifactFilePath, b, 0644)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("failed to write artifact to artifact file %s", artifactFilePath))
+// marshalEnv writes the artifact as dotenv-style KEY=value lines that can be
+// sourced by shell steps. IMAGE and DIGEST refer to the first image.
+func marshalEnv(dockerArtifact DockerArtifact) []byte {
+	var buf bytes.Buffer
+	env := func(key, value string) {
+		if strings.ContainsAny(value, " \t\n\"'$`\\#") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&buf, "%s=%s\n", key, value)
+	}
+
+	data := dockerArtifact.Data
+	env("KIND", dockerArtifact.Kind)
+	env("REGISTRY_TYPE", string(data.RegistryType))
+	env("REGISTRY_URL", data.RegistryUrl)
+	var images []string
+	for _, image := range data.Images {
+		images = append(images, image.Image)
+	}
+	env("IMAGES", strings.Join(images, " "))
+	if len(data.Images) != 0 {
+		env("IMAGE", data.Images[0].Image)
+		env("DIGEST", data.Images[0].Digest)
+	}
+	for i, image := range data.Images {
+		env(fmt.Sprintf("IMAGE_%d", i), image.Image)
 	}
-	return nil
+	return buf.Bytes()
 }
@@ -1,7 +1,12 @@
 package artifact
 
 import (
+	"context"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -9,7 +14,7 @@ func TestWritePluginArtifactFile(t *testing.T) {
 
 	testFile := t.TempDir() + "got.json"
 
-	err := WritePluginArtifactFile(Docker, testFile, "https://index.docker.io/", "image", "sha256:22332233", []string{"a1", "latest"})
+	err := WritePluginArtifactFile(Docker, JSON, testFile, "https://index.docker.io/", "image", "sha256:22332233", []string{"a1", "latest"})
 	if err != nil {
 		t.Error(err)
 		t.FailNow()
@@ -36,3 +41,92 @@ func TestWritePluginArtifactFile(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestWritePluginArtifactFile_formats(t *testing.T) {
+	tests := []struct {
+		format FormatEnum
+		want   string
+	}{
+		{
+			format: YAML,
+			want: `kind: docker/v1
+data:
+  registryType: Docker
+  registryUrl: https://index.docker.io/
+  images:
+    - image: image:a1
+      digest: sha256:22332233
+    - image: image:latest
+      digest: sha256:22332233
+`,
+		},
+		{
+			format: Env,
+			want: `KIND=docker/v1
+REGISTRY_TYPE=Docker
+REGISTRY_URL=https://index.docker.io/
+IMAGES="image:a1 image:latest"
+IMAGE=image:a1
+DIGEST=sha256:22332233
+IMAGE_0=image:a1
+IMAGE_1=image:latest
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			testFile := t.TempDir() + "/artifact"
+			if err := WritePluginArtifactFile(Docker, test.format, testFile, "https://index.docker.io/", "image", "sha256:22332233", []string{"a1", "latest"}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(testFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for in, want := range map[string]FormatEnum{"": JSON, "json": JSON, "YAML": YAML, "env": Env} {
+		got, err := ParseFormat(in)
+		if err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %s, %v, want %s", in, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("toml"); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+}
+
+func TestHTTP_Publish(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		got = append(got, r.Method+" "+r.Header.Get("Content-Type")+" "+r.Header.Get("Authorization")+" "+string(body))
+		if r.URL.Path == "/fail" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	header, err := ParseHeaders([]string{"Authorization: Bearer token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (HTTP{URL: srv.URL + "/artifacts", Header: header}).Publish(context.Background(), []byte("IMAGE=image:a1\n"), Env); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"POST text/plain Bearer token IMAGE=image:a1\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %q, want %q", got, want)
+	}
+	if err := (HTTP{URL: srv.URL + "/fail"}).Publish(context.Background(), []byte("{}"), JSON); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected error of failed request, got %v", err)
+	}
+	if _, err := ParseHeaders([]string{"Authorization"}); err == nil {
+		t.Error("expected error for header without value")
+	}
+}
@@ -0,0 +1,96 @@
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Publisher delivers artifacts to a sink, e.g. a file or an object store.
+type Publisher interface {
+	Publish(ctx context.Context, content []byte, format FormatEnum) error
+	fmt.Stringer
+}
+
+// Opener returns the publisher of a destination URL.
+type Opener func(destination string) (Publisher, error)
+
+// File publishes artifacts to a local file.
+type File string
+
+// Publish writes the artifact to the file, creating its directory.
+func (f File) Publish(ctx context.Context, content []byte, format FormatEnum) error {
+	dir := filepath.Dir(string(f))
+	err := os.MkdirAll(dir, 0644)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to create %s directory for artifact file", dir))
+	}
+
+	err = ioutil.WriteFile(string(f), content, 0644)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to write artifact to artifact file %s", string(f)))
+	}
+	return nil
+}
+
+func (f File) String() string {
+	return string(f)
+}
+
+// HTTP publishes artifacts by POSTing them to a URL.
+type HTTP struct {
+	Client *http.Client
+	URL    string
+	Header http.Header // Additional request headers, e.g. Authorization
+}
+
+// ParseHeaders parses request headers given as Name: value.
+func ParseHeaders(headers []string) (http.Header, error) {
+	header := http.Header{}
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid header %s, expected Name: value", h)
+		}
+		header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return header, nil
+}
+
+// Publish posts the artifact with the content type of its format.
+func (h HTTP) Publish(ctx context.Context, content []byte, format FormatEnum) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	for name, values := range h.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", format.ContentType())
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to post artifact to %s", h.URL))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to post artifact to %s: %s: %s", h.URL, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+func (h HTTP) String() string {
+	return h.URL
+}
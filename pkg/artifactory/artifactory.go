@@ -0,0 +1,108 @@
+// Package artifactory implements the parts of the JFrog Artifactory REST API
+// used after pushing an image: setting the build-info properties of the
+// pushed tags.
+package artifactory
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Build-info properties linking artifacts to their Artifactory build.
+const (
+	PropertyBuildName   string = "build.name"
+	PropertyBuildNumber string = "build.number"
+)
+
+// Client calls the Artifactory REST API, authenticated with an access
+// token, an API key or, without either, a username and password.
+type Client struct {
+	HTTPClient  *http.Client
+	URL         string // Base URL of the REST API, e.g. https://example.jfrog.io/artifactory
+	AccessToken string
+	APIKey      string
+	Username    string
+	Password    string
+	UserAgent   string // User-Agent of API requests
+}
+
+// SplitRepository splits the repository path of an image in a docker
+// repository accessed with the repository path method, e.g.
+// docker-local/team/app, into the repository key and the image path. When
+// repoKey is set, e.g. with the subdomain or port methods, the whole
+// repository path is the image path.
+func SplitRepository(name, repoKey string) (string, string, error) {
+	if repoKey != "" {
+		return repoKey, name, nil
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid repository %s, expected <repo-key>/<image> or the repository key to be set", name)
+	}
+	return parts[0], parts[1], nil
+}
+
+// SetProperties sets the properties on the item at path in the repository,
+// recursively for folders such as the tags of docker images.
+func (c *Client) SetProperties(ctx context.Context, repoKey, path string, properties map[string]string) error {
+	var names []string
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var props []string
+	for _, name := range names {
+		props = append(props, escape(name)+"="+escape(properties[name]))
+	}
+	query := url.Values{"properties": {strings.Join(props, ";")}, "recursive": {"1"}}
+	endpoint := fmt.Sprintf("%s/api/storage/%s/%s?%s", strings.TrimSuffix(c.URL, "/"), repoKey, path, query.Encode())
+	if err := c.do(ctx, http.MethodPut, endpoint); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to set properties on %s/%s", repoKey, path))
+	}
+	return nil
+}
+
+// escape escapes the characters separating properties and their values.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, "|", `\|`, "=", `\=`, ";", `\;`).Replace(s)
+}
+
+func (c *Client) do(ctx context.Context, method, endpoint string) error {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.AccessToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	case c.APIKey != "":
+		req.Header.Set("X-JFrog-Art-Api", c.APIKey)
+	default:
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s returned %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
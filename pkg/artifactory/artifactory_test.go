@@ -0,0 +1,57 @@
+package artifactory
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClient_SetProperties(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization") + r.Header.Get("X-JFrog-Art-Api")
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.URL.Query().Get("properties")+" "+r.URL.Query().Get("recursive")+" "+auth)
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			http.Error(w, `{"errors":[{"status":404}]}`, http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	props := map[string]string{PropertyBuildName: "octocat/hello;world", PropertyBuildNumber: "42"}
+	c := &Client{URL: srv.URL + "/artifactory/", AccessToken: "token"}
+	if err := c.SetProperties(ctx, "docker-local", "team/app/1.0.0", props); err != nil {
+		t.Fatal(err)
+	}
+	c = &Client{URL: srv.URL + "/artifactory", APIKey: "key"}
+	if err := c.SetProperties(ctx, "docker-local", "team/app/latest", props); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`PUT /artifactory/api/storage/docker-local/team/app/1.0.0 build.name=octocat/hello\;world;build.number=42 1 Bearer token`,
+		`PUT /artifactory/api/storage/docker-local/team/app/latest build.name=octocat/hello\;world;build.number=42 1 key`,
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := c.SetProperties(ctx, "docker-local", "team/app/missing", props); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestSplitRepository(t *testing.T) {
+	if key, path, err := SplitRepository("docker-local/team/app", ""); err != nil || key != "docker-local" || path != "team/app" {
+		t.Errorf("SplitRepository() = %s, %s, %v", key, path, err)
+	}
+	if key, path, err := SplitRepository("team/app", "docker-local"); err != nil || key != "docker-local" || path != "team/app" {
+		t.Errorf("SplitRepository() with repository key = %s, %s, %v", key, path, err)
+	}
+	if _, _, err := SplitRepository("app", ""); err == nil {
+		t.Error("SplitRepository() without repository key error = nil")
+	}
+}
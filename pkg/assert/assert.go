@@ -0,0 +1,88 @@
+package assert
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// Assertions are declarative expectations on the final image configuration.
+type Assertions struct {
+	Entrypoint   []string // Expected entrypoint, not checked when empty
+	ExposedPorts []string // Ports that must be exposed, e.g. 8080 or 53/udp
+	Env          []string // Environment variables that must be set, as NAME or NAME=value
+	Labels       []string // Labels that must be set, as key or key=value
+}
+
+// ParseEntrypoint parses an entrypoint given either as a JSON array or as
+// whitespace separated words.
+func ParseEntrypoint(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") {
+		return strings.Fields(s), nil
+	}
+	var entrypoint []string
+	if err := json.Unmarshal([]byte(s), &entrypoint); err != nil {
+		return nil, fmt.Errorf("invalid entrypoint %s: %s", s, err)
+	}
+	return entrypoint, nil
+}
+
+// Empty reports whether there is nothing to check.
+func (a Assertions) Empty() bool {
+	return len(a.Entrypoint) == 0 && len(a.ExposedPorts) == 0 && len(a.Env) == 0 && len(a.Labels) == 0
+}
+
+// Check evaluates the assertions against the image configuration and
+// returns an error describing every failed assertion.
+func (a Assertions) Check(config *registry.ImageConfig) error {
+	var failures []string
+
+	if len(a.Entrypoint) > 0 && !reflect.DeepEqual(a.Entrypoint, config.Config.Entrypoint) {
+		failures = append(failures, fmt.Sprintf("entrypoint is %q, expected %q", config.Config.Entrypoint, a.Entrypoint))
+	}
+	for _, port := range a.ExposedPorts {
+		if !strings.Contains(port, "/") {
+			port += "/tcp"
+		}
+		if _, ok := config.Config.ExposedPorts[port]; !ok {
+			failures = append(failures, fmt.Sprintf("port %s is not exposed", port))
+		}
+	}
+	env := map[string]string{}
+	for _, kv := range config.Config.Env {
+		parts := strings.SplitN(kv, "=", 2)
+		env[parts[0]] = parts[len(parts)-1]
+	}
+	for _, want := range a.Env {
+		if failure := checkPair("environment variable", env, want); failure != "" {
+			failures = append(failures, failure)
+		}
+	}
+	for _, want := range a.Labels {
+		if failure := checkPair("label", config.Config.Labels, want); failure != "" {
+			failures = append(failures, failure)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("image assertions failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// checkPair checks a key or key=value expectation against values.
+func checkPair(kind string, values map[string]string, want string) string {
+	parts := strings.SplitN(want, "=", 2)
+	got, ok := values[parts[0]]
+	if !ok {
+		return fmt.Sprintf("%s %s is not set", kind, parts[0])
+	}
+	if len(parts) == 2 && got != parts[1] {
+		return fmt.Sprintf("%s %s is %q, expected %q", kind, parts[0], got, parts[1])
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package assert
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+func TestParseEntrypoint(t *testing.T) {
+	tests := map[string][]string{
+		`["/bin/app", "--serve"]`: {"/bin/app", "--serve"},
+		"/bin/app --serve":        {"/bin/app", "--serve"},
+		"":                        {},
+	}
+	for in, want := range tests {
+		got, err := ParseEntrypoint(in)
+		if err != nil {
+			t.Errorf("ParseEntrypoint(%q) returned error: %s", in, err)
+		}
+		if len(got) != 0 || len(want) != 0 {
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ParseEntrypoint(%q) = %q, want %q", in, got, want)
+			}
+		}
+	}
+	if _, err := ParseEntrypoint(`["/bin/app"`); err == nil {
+		t.Error("expected error for invalid JSON entrypoint")
+	}
+}
+
+func TestAssertions_Check(t *testing.T) {
+	config := &registry.ImageConfig{
+		Config: registry.ContainerConfig{
+			Entrypoint:   []string{"/bin/app"},
+			ExposedPorts: map[string]struct{}{"8080/tcp": {}, "53/udp": {}},
+			Env:          []string{"PATH=/usr/bin", "MODE=prod"},
+			Labels:       map[string]string{"owner": "team"},
+		},
+	}
+
+	pass := Assertions{
+		Entrypoint:   []string{"/bin/app"},
+		ExposedPorts: []string{"8080", "53/udp"},
+		Env:          []string{"PATH", "MODE=prod"},
+		Labels:       []string{"owner=team"},
+	}
+	if err := pass.Check(config); err != nil {
+		t.Errorf("unexpected failure: %s", err)
+	}
+
+	fail := Assertions{
+		Entrypoint:   []string{"/bin/other"},
+		ExposedPorts: []string{"9090"},
+		Env:          []string{"MODE=dev", "DEBUG"},
+		Labels:       []string{"cost-center"},
+	}
+	err := fail.Check(config)
+	if err == nil {
+		t.Fatal("expected assertions to fail")
+	}
+	for _, want := range []string{"entrypoint", "port 9090/tcp", "MODE", "DEBUG", "label cost-center"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %q, got %s", want, err)
+		}
+	}
+
+	if !(Assertions{}).Empty() || pass.Empty() {
+		t.Error("unexpected result from Empty")
+	}
+}
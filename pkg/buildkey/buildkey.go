@@ -0,0 +1,139 @@
+package buildkey
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/glob"
+	"github.com/pkg/errors"
+)
+
+// TagPrefix is prepended to build keys when they are stored as image tags.
+const TagPrefix string = "build-"
+
+// Inputs are the parameters that determine the result of a build.
+type Inputs struct {
+	Dockerfile string   // Path to the Dockerfile
+	Context    string   // Path to the build context directory
+	Args       []string // Build args in k=v form
+	Target     string   // Build target stage
+	Platform   string   // Target platform
+}
+
+// Compute returns a deterministic hex encoded sha256 key of the build inputs.
+// Files excluded by the context's .dockerignore do not contribute to the key,
+// so changes to them do not invalidate previously built images.
+func Compute(in Inputs) (string, error) {
+	h := sha256.New()
+
+	if err := hashFile(h, "dockerfile", in.Dockerfile); err != nil {
+		return "", err
+	}
+
+	ignore, err := readIgnore(filepath.Join(in.Context, ".dockerignore"))
+	if err != nil {
+		return "", err
+	}
+	err = filepath.Walk(in.Context, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(in.Context, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ".git" || ignored(ignore, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "link %s %s\n", rel, target)
+		case info.IsDir():
+			fmt.Fprintf(h, "dir %s %o\n", rel, info.Mode().Perm())
+		default:
+			return hashFile(h, fmt.Sprintf("file %s %o", rel, info.Mode().Perm()), path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to hash build context %s", in.Context))
+	}
+
+	args := append([]string(nil), in.Args...)
+	sort.Strings(args)
+	for _, arg := range args {
+		fmt.Fprintf(h, "arg %s\n", arg)
+	}
+	fmt.Fprintf(h, "target %s\nplatform %s\n", in.Target, in.Platform)
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// hashFile writes the header followed by the file's size and contents.
+func hashFile(h hash.Hash, header, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to open %s", path))
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "%s %d\n", header, info.Size())
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// readIgnore reads the patterns from a .dockerignore file, if present.
+func readIgnore(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// ignored applies .dockerignore semantics: the last matching pattern wins and
+// patterns prefixed with "!" re-include paths. A pattern matching a directory
+// also excludes everything below it.
+func ignored(patterns []string, rel string) bool {
+	excluded := false
+	for _, pattern := range patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		if glob.Match(pattern, rel) || glob.Match(pattern+"/**", rel) {
+			excluded = !negate
+		}
+	}
+	return excluded
+}
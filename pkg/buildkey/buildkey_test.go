@@ -0,0 +1,88 @@
+package buildkey
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Dockerfile":    "FROM scratch\nCOPY main.go /\n",
+		"main.go":       "package main\n",
+		"docs/index.md": "# docs\n",
+		".dockerignore": "docs\n*.md\n",
+	})
+	in := Inputs{
+		Dockerfile: filepath.Join(dir, "Dockerfile"),
+		Context:    dir,
+		Args:       []string{"B=2", "A=1"},
+	}
+
+	key, err := Compute(in)
+	if err != nil {
+		t.Fatalf("Compute failed: %s", err)
+	}
+
+	// Argument order does not matter.
+	in.Args = []string{"A=1", "B=2"}
+	if got, _ := Compute(in); got != key {
+		t.Errorf("key changed with reordered args: %s != %s", got, key)
+	}
+
+	// Changes to ignored files do not change the key.
+	writeFiles(t, dir, map[string]string{"docs/index.md": "# new docs\n", "README.md": "readme"})
+	if got, _ := Compute(in); got != key {
+		t.Errorf("key changed for ignored files: %s != %s", got, key)
+	}
+
+	// Changes to the context, args, target or Dockerfile do.
+	changes := []func(){
+		func() { writeFiles(t, dir, map[string]string{"main.go": "package main\n\nfunc main() {}\n"}) },
+		func() { in.Args = append(in.Args, "C=3") },
+		func() { in.Target = "release" },
+		func() { writeFiles(t, dir, map[string]string{"Dockerfile": "FROM alpine\n"}) },
+	}
+	for i, change := range changes {
+		change()
+		got, err := Compute(in)
+		if err != nil {
+			t.Fatalf("Compute failed: %s", err)
+		}
+		if got == key {
+			t.Errorf("change %d did not change the key", i)
+		}
+		key = got
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	patterns := []string{"docs", "*.md", "!README.md"}
+	tests := map[string]bool{
+		"docs":           true,
+		"docs/index.md":  true,
+		"CHANGELOG.md":   true,
+		"README.md":      false,
+		"src/main.go":    false,
+		"src/handler.md": false,
+	}
+	for rel, want := range tests {
+		if got := ignored(patterns, rel); got != want {
+			t.Errorf("ignored(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
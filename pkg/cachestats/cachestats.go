@@ -0,0 +1,231 @@
+// Package cachestats records how kaniko builds use the layers of a cache
+// repository and stores the statistics in the cache repository itself, so
+// that cache pruning and reporting can tell which layers are still used
+// across pipelines.
+package cachestats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Tag is the tag the statistics are stored under in the cache
+	// repository. Kaniko stores cached layers under sha256 hex tags only.
+	Tag string = "cache-stats"
+
+	// MediaType is the media type of the statistics blob and the artifact
+	// type of its manifest.
+	MediaType string = "application/vnd.drone.kaniko.cache-stats.v1+json"
+
+	mediaTypeEmpty string = "application/vnd.oci.empty.v1+json"
+)
+
+// emptyConfig is the content of the OCI empty descriptor.
+var emptyConfig = []byte("{}")
+
+var (
+	// checkingPattern matches the cache lookup kaniko logs before each command.
+	checkingPattern = regexp.MustCompile(`Checking for cached layer \S+:([0-9a-f]{64})`)
+	// pushingPattern matches the layers kaniko adds to the cache.
+	pushingPattern = regexp.MustCompile(`Pushing layer \S+:([0-9a-f]{64}) to cache`)
+)
+
+// Kaniko log messages of cache hits and misses.
+const (
+	hitMessage  string = "Using caching version of cmd"
+	missMessage string = "No cached layer found for cmd"
+)
+
+type (
+	// Stats are the statistics of a cache repository.
+	Stats struct {
+		LastUsed time.Time         `json:"lastUsed"`
+		Builds   int               `json:"builds"`
+		Hits     int               `json:"hits"`
+		Misses   int               `json:"misses"`
+		Layers   map[string]*Layer `json:"layers"` // keyed by cache key
+	}
+
+	// Layer are the statistics of a cached layer.
+	Layer struct {
+		Created  time.Time `json:"created,omitempty"`
+		LastUsed time.Time `json:"lastUsed"`
+		Hits     int       `json:"hits"`
+	}
+)
+
+// Run are the cache lookups of a build, collected from the kaniko output.
+type Run struct {
+	Hits   []string // Cache keys of the layers used from the cache
+	Misses []string // Cache keys of the layers missing in the cache
+	Pushed []string // Cache keys of the layers added to the cache
+}
+
+// Counter collects the Run of a build from the kaniko output written to it.
+type Counter struct {
+	mu      sync.Mutex
+	partial []byte
+	pending string // Cache key of the last lookup
+	run     Run
+}
+
+// Write scans the complete lines written so far.
+func (c *Counter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.partial = append(c.partial, p...)
+	for {
+		i := bytes.IndexByte(c.partial, '\n')
+		if i < 0 {
+			break
+		}
+		c.scan(string(c.partial[:i]))
+		c.partial = c.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (c *Counter) scan(line string) {
+	if m := checkingPattern.FindStringSubmatch(line); m != nil {
+		c.pending = m[1]
+		return
+	}
+	if m := pushingPattern.FindStringSubmatch(line); m != nil {
+		c.run.Pushed = append(c.run.Pushed, m[1])
+		return
+	}
+	if c.pending == "" {
+		return
+	}
+	switch {
+	case strings.Contains(line, hitMessage):
+		c.run.Hits = append(c.run.Hits, c.pending)
+		c.pending = ""
+	case strings.Contains(line, missMessage):
+		c.run.Misses = append(c.run.Misses, c.pending)
+		c.pending = ""
+	}
+}
+
+// Run returns the cache lookups collected so far.
+func (c *Counter) Run() Run {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.run
+}
+
+// Empty reports whether the run made no use of the cache.
+func (r Run) Empty() bool {
+	return len(r.Hits) == 0 && len(r.Misses) == 0 && len(r.Pushed) == 0
+}
+
+// Record adds the run, finished at now, to the statistics.
+func (s *Stats) Record(run Run, now time.Time) {
+	if s.Layers == nil {
+		s.Layers = map[string]*Layer{}
+	}
+	s.LastUsed = now
+	s.Builds++
+	s.Hits += len(run.Hits)
+	s.Misses += len(run.Misses)
+	for _, key := range run.Hits {
+		l := s.layer(key)
+		l.LastUsed = now
+		l.Hits++
+	}
+	for _, key := range run.Pushed {
+		l := s.layer(key)
+		if l.Created.IsZero() {
+			l.Created = now
+		}
+		l.LastUsed = now
+	}
+}
+
+func (s *Stats) layer(key string) *Layer {
+	l, ok := s.Layers[key]
+	if !ok {
+		l = &Layer{}
+		s.Layers[key] = l
+	}
+	return l
+}
+
+// Fetch reads the statistics stored in the cache repository. Repositories
+// without statistics yield empty statistics.
+func Fetch(ctx context.Context, client *registry.Client, repo registry.Repository) (*Stats, error) {
+	stats := &Stats{Layers: map[string]*Layer{}}
+	m, err := client.GetManifest(ctx, repo, Tag)
+	if e, ok := err.(*registry.Error); ok && e.StatusCode == http.StatusNotFound {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	image, err := m.Image()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode cache statistics manifest")
+	}
+	if len(image.Layers) != 1 || image.Layers[0].MediaType != MediaType {
+		return nil, fmt.Errorf("%s:%s is not a cache statistics manifest", repo, Tag)
+	}
+	blob, _, err := client.GetBlob(ctx, repo, image.Layers[0].Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	b, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, stats); err != nil {
+		return nil, errors.Wrap(err, "failed to decode cache statistics")
+	}
+	if stats.Layers == nil {
+		stats.Layers = map[string]*Layer{}
+	}
+	return stats, nil
+}
+
+// Push stores the statistics in the cache repository under Tag.
+func Push(ctx context.Context, client *registry.Client, repo registry.Repository, stats *Stats) error {
+	content, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	manifest := registry.ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		ArtifactType:  MediaType,
+		Config:        registry.Descriptor{MediaType: mediaTypeEmpty, Digest: registry.Digest(emptyConfig), Size: int64(len(emptyConfig))},
+		Layers:        []registry.Descriptor{{MediaType: MediaType, Digest: registry.Digest(content), Size: int64(len(content))}},
+	}
+	for _, blob := range []struct {
+		desc    registry.Descriptor
+		content []byte
+	}{{manifest.Config, emptyConfig}, {manifest.Layers[0], content}} {
+		if err := client.UploadBlob(ctx, repo, blob.desc.Digest, bytes.NewReader(blob.content), blob.desc.Size); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to upload blob %s", blob.desc.Digest))
+		}
+	}
+
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	m := &registry.Manifest{MediaType: registry.MediaTypeOCIManifest, Digest: registry.Digest(b), Content: b}
+	_, err = client.PutManifest(ctx, repo, Tag, m)
+	return err
+}
@@ -0,0 +1,95 @@
+package cachestats
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/gexops/drone-kaniko/pkg/registry/registrytest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCounter(t *testing.T) {
+	keyA, keyB, keyC := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+	output := strings.Join([]string{
+		`INFO[0001] Checking for cached layer gcr.io/p/app/cache:` + keyA + `...`,
+		`INFO[0001] Using caching version of cmd: RUN apt-get update`,
+		`INFO[0002] Checking for cached layer gcr.io/p/app/cache:` + keyB + `...`,
+		`INFO[0002] No cached layer found for cmd RUN make`,
+		`INFO[0009] Pushing layer gcr.io/p/app/cache:` + keyC + ` to cache now`,
+		`INFO[0010] Pushed gcr.io/p/app@sha256:` + keyA,
+		"",
+	}, "\n")
+
+	c := &Counter{}
+	// Lines may be split across writes
+	for i := 0; i < len(output); i += 7 {
+		end := i + 7
+		if end > len(output) {
+			end = len(output)
+		}
+		fmt.Fprint(c, output[i:end])
+	}
+	want := Run{Hits: []string{keyA}, Misses: []string{keyB}, Pushed: []string{keyC}}
+	if diff := cmp.Diff(want, c.Run()); diff != "" {
+		t.Errorf("unexpected run (-want +got):\n%s", diff)
+	}
+	if !(&Counter{}).Run().Empty() || want.Empty() {
+		t.Error("unexpected Empty()")
+	}
+}
+
+func TestStats(t *testing.T) {
+	reg := registrytest.New(t)
+	client := registry.NewClient(registry.KeychainFunc(func(string) (registry.Credential, error) {
+		return registry.Credential{Username: registrytest.Username, Password: registrytest.Password}, nil
+	}), true)
+	repo := registry.Repository{Registry: reg.Host(), Name: "app/cache"}
+	ctx := context.Background()
+
+	stats, err := Fetch(ctx, client, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Builds != 0 || len(stats.Layers) != 0 {
+		t.Errorf("expected empty stats, got %+v", stats)
+	}
+
+	first := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	stats.Record(Run{Misses: []string{"a"}, Pushed: []string{"a"}}, first)
+	if err := Push(ctx, client, repo, stats); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = Fetch(ctx, client, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats.Record(Run{Hits: []string{"a"}}, second)
+	if err := Push(ctx, client, repo, stats); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Fetch(ctx, client, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Stats{
+		LastUsed: second,
+		Builds:   2,
+		Hits:     1,
+		Misses:   1,
+		Layers:   map[string]*Layer{"a": {Created: first, LastUsed: second, Hits: 1}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%s", diff)
+	}
+
+	reg.PushImage("app/other", Tag, []byte(`{"os":"linux"}`), []byte("layer"))
+	if _, err := Fetch(ctx, client, registry.Repository{Registry: reg.Host(), Name: "app/other"}); err == nil {
+		t.Error("expected error for a tag that holds no statistics")
+	}
+}
@@ -0,0 +1,71 @@
+package changes
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/glob"
+	"github.com/pkg/errors"
+)
+
+// Known reports whether a commit range can be diffed. Drone sends an empty
+// or all zero before commit for new branches and the first push.
+func Known(before, after string) bool {
+	return after != "" && strings.Trim(before, "0") != ""
+}
+
+// Files returns the paths changed between the before and after commits of
+// the git repository in dir, relative to the repository root.
+func Files(dir, before, after string) ([]string, error) {
+	out, err := runGit(dir, "diff", "--name-only", before, after)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to diff %s..%s", before, after))
+	}
+
+	var files []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// Root returns the root directory of the git repository in dir, which the
+// paths returned by Files are relative to.
+func Root(dir string) (string, error) {
+	out, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find the git repository root")
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// runGit runs git with the arguments in dir, returning its output.
+func runGit(dir string, args ...string) (string, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", fmt.Errorf("git is required but was not found")
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrap(err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// Matching returns the files that match at least one of the glob patterns.
+func Matching(patterns, files []string) []string {
+	var matched []string
+	for _, file := range files {
+		if glob.MatchAny(patterns, file) {
+			matched = append(matched, file)
+		}
+	}
+	return matched
+}
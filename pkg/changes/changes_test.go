@@ -0,0 +1,109 @@
+package changes
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %s: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func commit(t *testing.T, dir string, files map[string]string) string {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "change")
+	return git(t, dir, "rev-parse", "HEAD")
+}
+
+func TestFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git(t, dir, "init", "-q")
+	before := commit(t, dir, map[string]string{"README.md": "readme", "services/api/main.go": "package main"})
+	after := commit(t, dir, map[string]string{"docs/index.md": "docs", "services/api/main.go": "package main\n"})
+
+	got, err := Files(dir, before, after)
+	if err != nil {
+		t.Fatalf("Files failed: %s", err)
+	}
+	if want := []string{"docs/index.md", "services/api/main.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Files = %q, want %q", got, want)
+	}
+
+	if _, err := Files(dir, "0123456789abcdef0123456789abcdef01234567", after); err == nil {
+		t.Error("expected error for unknown commit")
+	}
+}
+
+func TestRoot(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git(t, dir, "init", "-q")
+	sub := filepath.Join(dir, "services", "api")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Root(sub)
+	if err != nil {
+		t.Fatalf("Root failed: %s", err)
+	}
+	if want, _ := filepath.EvalSymlinks(dir); got != want {
+		t.Errorf("Root = %s, want %s", got, want)
+	}
+	if _, err := Root(t.TempDir()); err == nil {
+		t.Error("expected error outside of a repository")
+	}
+}
+
+func TestKnown(t *testing.T) {
+	tests := []struct {
+		before, after string
+		want          bool
+	}{
+		{"abc123", "def456", true},
+		{"", "def456", false},
+		{"0000000000000000000000000000000000000000", "def456", false},
+		{"abc123", "", false},
+	}
+	for _, tt := range tests {
+		if got := Known(tt.before, tt.after); got != tt.want {
+			t.Errorf("Known(%q, %q) = %v, want %v", tt.before, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestMatching(t *testing.T) {
+	files := []string{"docs/index.md", "services/api/main.go", "services/web/main.go"}
+	got := Matching([]string{"services/api/**"}, files)
+	if want := []string{"services/api/main.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Matching = %q, want %q", got, want)
+	}
+	if got := Matching([]string{"charts/**"}, files); len(got) != 0 {
+		t.Errorf("Matching = %q, want none", got)
+	}
+}
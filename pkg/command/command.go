@@ -0,0 +1,833 @@
+// Package command holds what the plugin commands have in common: the
+// settings shared by all registries and the kaniko build they configure.
+package command
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	kaniko "github.com/gexops/drone-kaniko"
+	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/gexops/drone-kaniko/pkg/repotemplate"
+	"github.com/gexops/drone-kaniko/pkg/settings"
+	"github.com/gexops/drone-kaniko/pkg/useragent"
+	"github.com/gexops/drone-kaniko/pkg/vault"
+)
+
+// DigestFile is where kaniko writes the digest of the pushed image.
+const DigestFile string = "/kaniko/digest-file"
+
+// Flags returns the flags of the settings shared by all commands, which add
+// the flags of their registry, e.g. its credentials, repo and registry.
+func Flags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "dockerfile",
+			Usage:  "build dockerfile",
+			Value:  "Dockerfile",
+			EnvVar: "PLUGIN_DOCKERFILE",
+		},
+		cli.StringFlag{
+			Name:   "context",
+			Usage:  "build context",
+			Value:  ".",
+			EnvVar: "PLUGIN_CONTEXT",
+		},
+		cli.StringFlag{
+			Name:   "drone-commit-ref",
+			Usage:  "git commit ref passed by Drone",
+			EnvVar: "DRONE_COMMIT_REF",
+		},
+		cli.StringFlag{
+			Name:   "drone-repo-branch",
+			Usage:  "git repository default branch passed by Drone",
+			EnvVar: "DRONE_REPO_BRANCH",
+		},
+		cli.StringSliceFlag{
+			Name:     "tags",
+			Usage:    "build tags",
+			Value:    &cli.StringSlice{"latest"},
+			EnvVar:   "PLUGIN_TAGS",
+			FilePath: ".tags",
+		},
+		cli.BoolFlag{
+			Name:   "expand-tag",
+			Usage:  "enable for semver tagging",
+			EnvVar: "PLUGIN_EXPAND_TAG",
+		},
+		cli.BoolFlag{
+			Name:   "auto-tag",
+			Usage:  "enable auto generation of build tags",
+			EnvVar: "PLUGIN_AUTO_TAG",
+		},
+		cli.StringFlag{
+			Name:   "auto-tag-suffix",
+			Usage:  "the suffix of auto build tags",
+			EnvVar: "PLUGIN_AUTO_TAG_SUFFIX",
+		},
+		cli.StringSliceFlag{
+			Name:   "taggers",
+			Usage:  "Registered taggers applied after auto-tag and expand-tag",
+			EnvVar: "PLUGIN_TAGGERS",
+		},
+		cli.StringSliceFlag{
+			Name:   "args",
+			Usage:  "build args",
+			EnvVar: "PLUGIN_BUILD_ARGS",
+		},
+		cli.StringFlag{
+			Name:   "target",
+			Usage:  "build target",
+			EnvVar: "PLUGIN_TARGET",
+		},
+		cli.StringSliceFlag{
+			Name:   "custom-labels",
+			Usage:  "additional k=v labels",
+			EnvVar: "PLUGIN_CUSTOM_LABELS",
+		},
+		cli.StringSliceFlag{
+			Name:   "registry-mirrors",
+			Usage:  "docker registry mirrors",
+			EnvVar: "PLUGIN_REGISTRY_MIRRORS",
+		},
+		cli.BoolFlag{
+			Name:   "enable-cache",
+			Usage:  "Set this flag to opt into caching with kaniko",
+			EnvVar: "PLUGIN_ENABLE_CACHE",
+		},
+		cli.StringFlag{
+			Name:   "cache-dir",
+			Usage:  "Set this flag to specify a local directory cache for base images. enable-cache needs to be set to use this flag. Defaults to /cache.",
+			Value:  "/cache",
+			EnvVar: "PLUGIN_CACHE_DIR",
+		},
+		cli.BoolFlag{
+			Name:   "cache-copy-layers",
+			Usage:  "Set this flag to cache copy layers. Defaults to false",
+			EnvVar: "PLUGIN_CACHE_COPY_LAYERS",
+		},
+		cli.BoolFlag{
+			Name:   "cache-no-compress",
+			Usage:  "Set this to true in order to prevent tar compression for cached layers.",
+			EnvVar: "PLUGIN_CACHE_NO_COMPRESS",
+		},
+		cli.BoolTFlag{
+			Name:   "cache-retry",
+			Usage:  "Retry builds failing on corrupted or incompatible cached layers once with the cache disabled",
+			EnvVar: "PLUGIN_CACHE_RETRY",
+		},
+		cli.StringFlag{
+			Name:   "cache-prune-file",
+			Usage:  "File the cache repo and directory of builds retried with the cache disabled are appended to for pruning",
+			EnvVar: "PLUGIN_CACHE_PRUNE_FILE",
+		},
+		cli.IntFlag{
+			Name:   "cache-ttl",
+			Usage:  "Cache timeout in hours. Defaults to two weeks.",
+			EnvVar: "PLUGIN_CACHE_TTL",
+		},
+		cli.StringFlag{
+			Name:   "artifact-file",
+			Usage:  "Artifact file location that will be generated by the plugin. This file will include information of docker images that are uploaded by the plugin.",
+			EnvVar: "PLUGIN_ARTIFACT_FILE",
+		},
+		cli.BoolFlag{
+			Name:   "no-push",
+			Usage:  "Set this flag if you only want to build the image, without pushing to a registry",
+			EnvVar: "PLUGIN_NO_PUSH",
+		},
+		cli.StringFlag{
+			Name:   "verbosity",
+			Usage:  "Set this flag with value as oneof <panic|fatal|error|warn|info|debug|trace> to set the logging level for kaniko. Defaults to info.",
+			EnvVar: "PLUGIN_VERBOSITY",
+		},
+		cli.BoolFlag{
+			Name:   "use-new-run",
+			Usage:  "Set this flag if experimental run implementation for detecting changes without requiring file system snapshots. In some cases, this may improve build performance by 75%",
+			EnvVar: "PLUGIN_USE_NEW_RUN",
+		},
+		cli.StringFlag{
+			Name:   "platform",
+			Usage:  "Allows to build with another default platform than the host, similarly to docker build --platform",
+			EnvVar: "PLUGIN_PLATFORM",
+		},
+		cli.BoolFlag{
+			Name:   "skip-identical",
+			Usage:  "Skip the build and retag the existing image when an image built from identical Dockerfile, context and build args already exists in the registry",
+			EnvVar: "PLUGIN_SKIP_IDENTICAL",
+		},
+		cli.StringFlag{
+			Name:   "drone-commit-before",
+			Usage:  "previous git commit sha of the push passed by Drone",
+			EnvVar: "DRONE_COMMIT_BEFORE",
+		},
+		cli.StringFlag{
+			Name:   "drone-commit-sha",
+			Usage:  "git commit sha passed by Drone",
+			EnvVar: "DRONE_COMMIT_SHA",
+		},
+		cli.StringSliceFlag{
+			Name:   "trigger-paths",
+			Usage:  "Only build when files matching one of these globs changed between DRONE_COMMIT_BEFORE and DRONE_COMMIT_SHA",
+			EnvVar: "PLUGIN_TRIGGER_PATHS",
+		},
+		cli.BoolFlag{
+			Name:   "discover",
+			Usage:  "Discover Dockerfiles below discover-root and build one image per directory, pushed to <repo>/<directory name>. Only directories changed between DRONE_COMMIT_BEFORE and DRONE_COMMIT_SHA are built",
+			EnvVar: "PLUGIN_DISCOVER",
+		},
+		cli.StringFlag{
+			Name:   "discover-root",
+			Usage:  "Root directory for Dockerfile discovery",
+			Value:  ".",
+			EnvVar: "PLUGIN_DISCOVER_ROOT",
+		},
+		cli.StringFlag{
+			Name:   "discover-pattern",
+			Usage:  "Glob relative to discover-root matching the Dockerfiles to build",
+			Value:  "**/Dockerfile",
+			EnvVar: "PLUGIN_DISCOVER_PATTERN",
+		},
+		cli.StringFlag{
+			Name:   "release",
+			Usage:  "Path of a release manifest listing related images built and pushed with the same tags, each to a repository named after the image below repo",
+			EnvVar: "PLUGIN_RELEASE",
+		},
+		cli.StringFlag{
+			Name:   "promote-from",
+			Usage:  "Promote this image (repo@digest or repo:tag) to the repository instead of building",
+			EnvVar: "PLUGIN_PROMOTE_FROM",
+		},
+		cli.StringFlag{
+			Name:   "promote-username",
+			Usage:  "Username for the registry of the promoted image",
+			EnvVar: "PLUGIN_PROMOTE_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "promote-password",
+			Usage:  "Password for the registry of the promoted image",
+			EnvVar: "PLUGIN_PROMOTE_PASSWORD",
+		},
+		cli.StringSliceFlag{
+			Name:   "credential-helpers",
+			Usage:  "docker credential helpers of registries as registry=helper pairs, e.g. europe-docker.pkg.dev=gcloud",
+			EnvVar: "PLUGIN_CREDENTIAL_HELPERS",
+		},
+		cli.StringFlag{
+			Name:   "cosign-verify-key",
+			Usage:  "Cosign public key the promoted image's signature must verify against",
+			EnvVar: "PLUGIN_COSIGN_VERIFY_KEY",
+		},
+		cli.StringFlag{
+			Name:   "cosign-sign-key",
+			Usage:  "Cosign private key used to re-sign the promoted image. The key password is read from COSIGN_PASSWORD",
+			EnvVar: "PLUGIN_COSIGN_SIGN_KEY",
+		},
+		cli.StringFlag{
+			Name:   "assert-entrypoint",
+			Usage:  "Fail before pushing unless the image entrypoint equals this value, given as JSON array or space separated words",
+			EnvVar: "PLUGIN_ASSERT_ENTRYPOINT",
+		},
+		cli.StringSliceFlag{
+			Name:   "assert-ports",
+			Usage:  "Fail before pushing unless the image exposes these ports, e.g. 8080 or 53/udp",
+			EnvVar: "PLUGIN_ASSERT_PORTS",
+		},
+		cli.StringSliceFlag{
+			Name:   "assert-env",
+			Usage:  "Fail before pushing unless the image sets these environment variables, as NAME or NAME=value",
+			EnvVar: "PLUGIN_ASSERT_ENV",
+		},
+		cli.StringSliceFlag{
+			Name:   "assert-labels",
+			Usage:  "Fail before pushing unless the image sets these labels, as key or key=value",
+			EnvVar: "PLUGIN_ASSERT_LABELS",
+		},
+		cli.StringFlag{
+			Name:   "secret-scan",
+			Usage:  "Scan the final image for secrets such as AWS keys and private keys before pushing. Set to warn to report findings or fail to fail the build",
+			EnvVar: "PLUGIN_SECRET_SCAN",
+		},
+		cli.StringSliceFlag{
+			Name:   "secret-scan-ignore",
+			Usage:  "Globs of image file paths excluded from the secret scan, e.g. usr/lib/python3*/**/tests/**",
+			EnvVar: "PLUGIN_SECRET_SCAN_IGNORE",
+		},
+		cli.StringFlag{
+			Name:   "drone-repo",
+			Usage:  "git repository name passed by Drone",
+			EnvVar: "DRONE_REPO",
+		},
+		cli.StringFlag{
+			Name:   "drone-build-number",
+			Usage:  "build number passed by Drone",
+			EnvVar: "DRONE_BUILD_NUMBER",
+		},
+		cli.StringFlag{
+			Name:   "user-agent-suffix",
+			Usage:  "Organization specific suffix appended to the User-Agent of requests made by the plugin",
+			EnvVar: "PLUGIN_USER_AGENT_SUFFIX",
+		},
+		cli.StringSliceFlag{
+			Name:   "dns",
+			Usage:  "DNS servers (IPv4 or IPv6 addresses listening on port 53) used by the plugin's registry clients and kaniko",
+			EnvVar: "PLUGIN_DNS",
+		},
+		cli.StringSliceFlag{
+			Name:   "host-overrides",
+			Usage:  "Static host:ip mappings used by the plugin's registry clients and kaniko, e.g. to resolve public registry names to internal VIPs",
+			EnvVar: "PLUGIN_HOST_OVERRIDES",
+		},
+		cli.BoolFlag{
+			Name:   "preflight-auth",
+			Usage:  "Authenticate against the destination and cache registries and probe push permissions before starting the build",
+			EnvVar: "PLUGIN_PREFLIGHT_AUTH",
+		},
+		cli.IntFlag{
+			Name:   "pull-retry",
+			Usage:  "Number of retries for transient base image pull failures",
+			EnvVar: "PLUGIN_PULL_RETRY",
+		},
+		cli.DurationFlag{
+			Name:   "pull-retry-backoff",
+			Usage:  "Initial delay between pull retries, doubled on every retry",
+			Value:  5 * time.Second,
+			EnvVar: "PLUGIN_PULL_RETRY_BACKOFF",
+		},
+		cli.DurationFlag{
+			Name:   "build-timeout",
+			Usage:  "Maximum duration of each kaniko executor run before it is aborted",
+			EnvVar: "PLUGIN_BUILD_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "control-address",
+			Usage:  "Local address, host:port on a loopback host or unix:<path>, of an endpoint accepting POST /cancel requests that abort the build",
+			EnvVar: "PLUGIN_CONTROL_ADDRESS",
+		},
+		cli.BoolFlag{
+			Name:   "strict-mirrors",
+			Usage:  "Fail when a base image would be pulled from a registry other than the registry mirrors or the destination registry",
+			EnvVar: "PLUGIN_STRICT_MIRRORS",
+		},
+		cli.StringSliceFlag{
+			Name:   "platforms",
+			Usage:  "Platforms to build, published under a single multi-platform index whose tags are created once all platforms are pushed",
+			EnvVar: "PLUGIN_PLATFORMS",
+		},
+		cli.StringSliceFlag{
+			Name:   "platform-fields",
+			Usage:  "Index descriptor fields of built platforms, as platform:field=value with field variant, os.version or os.features",
+			EnvVar: "PLUGIN_PLATFORM_FIELDS",
+		},
+		cli.StringSliceFlag{
+			Name:   "oci-artifacts",
+			Usage:  "Workspace files, as path or path:mediatype, pushed as an OCI artifact referring to the image in the same repository",
+			EnvVar: "PLUGIN_OCI_ARTIFACTS",
+		},
+		cli.StringFlag{
+			Name:   "oci-artifacts-tag",
+			Usage:  "Tag of the OCI artifact. Defaults to the first image tag with a -files suffix",
+			EnvVar: "PLUGIN_OCI_ARTIFACTS_TAG",
+		},
+		cli.StringFlag{
+			Name:   "oci-artifact-type",
+			Usage:  "Artifact type of the OCI artifact",
+			Value:  "application/vnd.drone.kaniko.files.v1",
+			EnvVar: "PLUGIN_OCI_ARTIFACT_TYPE",
+		},
+		cli.StringFlag{
+			Name:   "helm-chart",
+			Usage:  "Helm chart directory packaged and pushed as OCI chart to the image registry after the image is pushed",
+			EnvVar: "PLUGIN_HELM_CHART",
+		},
+		cli.StringFlag{
+			Name:   "helm-repo",
+			Usage:  "Repository path in the image registry the chart is pushed below, as with helm push oci://<registry>/<path>",
+			EnvVar: "PLUGIN_HELM_REPO",
+		},
+		cli.StringFlag{
+			Name:   "helm-chart-version",
+			Usage:  "Overrides the version of the pushed chart",
+			EnvVar: "PLUGIN_HELM_CHART_VERSION",
+		},
+		cli.StringFlag{
+			Name:   "helm-tag-key",
+			Usage:  "Chart values key set to the pushed image tag",
+			Value:  "image.tag",
+			EnvVar: "PLUGIN_HELM_TAG_KEY",
+		},
+		cli.StringFlag{
+			Name:   "helm-digest-key",
+			Usage:  "Chart values key set to the pushed image digest, e.g. image.digest",
+			EnvVar: "PLUGIN_HELM_DIGEST_KEY",
+		},
+		cli.BoolFlag{
+			Name:   "single-snapshot",
+			Usage:  "Take a single snapshot of the filesystem at the end of the build, producing a single layer. Cannot be combined with enable-cache",
+			EnvVar: "PLUGIN_SINGLE_SNAPSHOT",
+		},
+		cli.StringSliceFlag{
+			Name:   "ignore-paths",
+			Usage:  "Absolute paths excluded from snapshots",
+			EnvVar: "PLUGIN_IGNORE_PATHS",
+		},
+		cli.BoolFlag{
+			Name:   "include-var-run",
+			Usage:  "Include /var/run in snapshots, which kaniko ignores by default",
+			EnvVar: "PLUGIN_INCLUDE_VAR_RUN",
+		},
+		cli.StringSliceFlag{
+			Name:   "cache-from",
+			Usage:  "Additional cache repositories consulted in order for cached layers missing in cache-repo, e.g. the cache of the main branch. enable-cache and cache-repo need to be set to use this flag",
+			EnvVar: "PLUGIN_CACHE_FROM",
+		},
+		cli.BoolFlag{
+			Name:   "cache-stats",
+			Usage:  "Record cache hits and the last use of cached layers as metadata in the cache repo, for cache pruning and reporting",
+			EnvVar: "PLUGIN_CACHE_STATS",
+		},
+		cli.StringSliceFlag{
+			Name:   "patch-files",
+			Usage:  "Workspace manifests patched with the pushed image digest reference after push, as file[:path[=value]]",
+			EnvVar: "PLUGIN_PATCH_FILES",
+		},
+		cli.BoolFlag{
+			Name:   "pr-comment",
+			Usage:  "Comment the pushed image reference and digest on the originating GitHub pull request or GitLab merge request",
+			EnvVar: "PLUGIN_PR_COMMENT",
+		},
+		cli.BoolFlag{
+			Name:   "commit-status",
+			Usage:  "Set a commit status with the pushed image reference and digest",
+			EnvVar: "PLUGIN_COMMIT_STATUS",
+		},
+		cli.StringFlag{
+			Name:   "scm-provider",
+			Usage:  "SCM provider to report the build result to, github or gitlab. Detected from the repository link if not set",
+			EnvVar: "PLUGIN_SCM_PROVIDER",
+		},
+		cli.StringFlag{
+			Name:   "scm-url",
+			Usage:  "API endpoint of the SCM provider, e.g. https://github.example.com/api/v3",
+			EnvVar: "PLUGIN_SCM_URL",
+		},
+		cli.StringFlag{
+			Name:   "scm-token",
+			Usage:  "Token used to comment on pull requests and set commit statuses",
+			EnvVar: "PLUGIN_SCM_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "drone-repo-link",
+			Usage:  "git repository link passed by Drone",
+			EnvVar: "DRONE_REPO_LINK",
+		},
+		cli.IntFlag{
+			Name:   "drone-pull-request",
+			Usage:  "pull request number passed by Drone",
+			EnvVar: "DRONE_PULL_REQUEST",
+		},
+		cli.StringFlag{
+			Name:   "drone-build-link",
+			Usage:  "build link passed by Drone",
+			EnvVar: "DRONE_BUILD_LINK",
+		},
+		cli.StringFlag{
+			Name:   "artifact-format",
+			Usage:  "Format of the artifact file: json, yaml or env (dotenv-style KEY=value lines)",
+			Value:  "json",
+			EnvVar: "PLUGIN_ARTIFACT_FORMAT",
+		},
+		cli.StringSliceFlag{
+			Name:   "artifact-publish-headers",
+			Usage:  "Request headers of http(s) artifact publishers, as Name: value, e.g. Authorization: Bearer <token>",
+			EnvVar: "PLUGIN_ARTIFACT_PUBLISH_HEADERS",
+		},
+		cli.StringFlag{
+			Name:   "error-file",
+			Usage:  "Path of a JSON error report written on failure, with the failed phase, the failure category, the underlying registry or AWS error code and whether a retry may succeed",
+			EnvVar: "PLUGIN_ERROR_FILE",
+		},
+		cli.StringFlag{
+			Name:   "output",
+			Usage:  "Output format, text or json to end the output with a line of JSON reporting the status, images, digest, phase durations and warnings",
+			Value:  "text",
+			EnvVar: "PLUGIN_OUTPUT",
+		},
+		cli.StringFlag{
+			Name:   "executor-path",
+			Usage:  "Path of the kaniko executor binary, for custom plugin images bundling a patched or newer executor",
+			Value:  "/kaniko/executor",
+			EnvVar: "PLUGIN_EXECUTOR_PATH",
+		},
+		cli.StringFlag{
+			Name:   "executor-checksum",
+			Usage:  "Expected sha256 checksum of the kaniko executor binary, verified before the build",
+			EnvVar: "PLUGIN_EXECUTOR_CHECKSUM",
+		},
+		cli.StringFlag{
+			Name:   "executor-version",
+			Usage:  "Expected version of the kaniko executor, e.g. v1.9.1, verified before the build",
+			EnvVar: "PLUGIN_EXECUTOR_VERSION",
+		},
+		cli.StringFlag{
+			Name:   "context-sub-path",
+			Usage:  "Sub-path of the build context used as context, e.g. services/api of a git:// context",
+			EnvVar: "PLUGIN_CONTEXT_SUB_PATH",
+		},
+		cli.StringSliceFlag{
+			Name:   "add-hosts",
+			Usage:  "Static host:ip mappings written to /etc/hosts for RUN steps of the build, like docker build --add-host",
+			EnvVar: "PLUGIN_ADD_HOSTS",
+		},
+		cli.BoolFlag{
+			Name:   "rootless",
+			Usage:  "Run the kaniko executor in a user namespace when the plugin does not run as root, checking that the runner supports it before the build",
+			EnvVar: "PLUGIN_ROOTLESS",
+		},
+		cli.BoolFlag{
+			Name:   "ci-annotations",
+			Usage:  "Annotate the pushed manifest with the Drone commit, repository, build link and number, stage, step and runner",
+			EnvVar: "PLUGIN_CI_ANNOTATIONS",
+		},
+		cli.StringSliceFlag{
+			Name:   "annotations",
+			Usage:  "Annotations of the pushed manifest, as key=value",
+			EnvVar: "PLUGIN_ANNOTATIONS",
+		},
+		cli.StringFlag{
+			Name:   "drone-stage-name",
+			Usage:  "stage name passed by Drone",
+			EnvVar: "DRONE_STAGE_NAME",
+		},
+		cli.StringFlag{
+			Name:   "drone-step-name",
+			Usage:  "step name passed by Drone",
+			EnvVar: "DRONE_STEP_NAME",
+		},
+		cli.StringFlag{
+			Name:   "drone-stage-machine",
+			Usage:  "stage machine passed by Drone",
+			EnvVar: "DRONE_STAGE_MACHINE",
+		},
+		cli.StringFlag{
+			Name:   "drone-runner-hostname",
+			Usage:  "runner hostname passed by Drone",
+			EnvVar: "DRONE_RUNNER_HOSTNAME",
+		},
+		cli.StringSliceFlag{
+			Name:   "retention-labels",
+			Usage:  "Retention labels read by registry cleanup jobs, as key=value, e.g. retention=30d",
+			EnvVar: "PLUGIN_RETENTION_LABELS",
+		},
+		cli.BoolFlag{
+			Name:   "retention-presets",
+			Usage:  "Add the retention and env labels preset for the Drone event: 14d/pr for pull requests, permanent/release for tags and 30d/branch otherwise",
+			EnvVar: "PLUGIN_RETENTION_PRESETS",
+		},
+		cli.StringSliceFlag{
+			Name:   "required-labels",
+			Usage:  "Names of org-mandated labels, e.g. owner,cost-center,data-classification, read from LABEL_<NAME> or the required labels file; the build fails if any is missing",
+			EnvVar: "PLUGIN_REQUIRED_LABELS",
+		},
+		cli.StringFlag{
+			Name:   "required-label-prefix",
+			Usage:  "Prefix of the required labels, e.g. com.example.",
+			EnvVar: "PLUGIN_REQUIRED_LABEL_PREFIX",
+		},
+		cli.StringFlag{
+			Name:   "required-labels-file",
+			Usage:  "YAML file mapping required label names to values",
+			EnvVar: "PLUGIN_REQUIRED_LABELS_FILE",
+		},
+		cli.StringFlag{
+			Name:   "drone-build-event",
+			Usage:  "build event passed by Drone",
+			EnvVar: "DRONE_BUILD_EVENT",
+		},
+		cli.StringSliceFlag{
+			Name:   "digest-files",
+			Usage:  "Additional files, e.g. in the workspace, the image digest is written to besides /kaniko/digest-file",
+			EnvVar: "PLUGIN_DIGEST_FILES",
+		},
+		cli.BoolFlag{
+			Name:   "digest-stdout",
+			Usage:  "Print the image digest as a DIGEST=sha256:... line",
+			EnvVar: "PLUGIN_DIGEST_STDOUT",
+		},
+		cli.StringFlag{
+			Name:   "expected-digest",
+			Usage:  "Digest the image must have, e.g. of a previous hermetic build, failing the build otherwise",
+			EnvVar: "PLUGIN_EXPECTED_DIGEST",
+		},
+		cli.StringFlag{
+			Name:   "dockerfile-check",
+			Usage:  "Check the Dockerfile for BuildKit features kaniko does not support before the build: emulate (emulate cache mounts and --link, fail on others), strict (fail on all) or off",
+			Value:  "emulate",
+			EnvVar: "PLUGIN_DOCKERFILE_CHECK",
+		},
+		cli.StringSliceFlag{
+			Name:   "secret-files",
+			Usage:  "Secrets available to RUN --mount=type=secret steps without being stored in the image, as id=path, id=file:path or id=env:NAME, masked in the kaniko output",
+			EnvVar: "PLUGIN_SECRET_FILES,PLUGIN_SECRETS",
+		},
+		cli.StringSliceFlag{
+			Name:   "tag-args",
+			Usage:  "Build args of individual tags, as tag:NAME=value, each such tag is built in a separate kaniko run sharing the cache",
+			EnvVar: "PLUGIN_TAG_ARGS",
+		},
+		cli.StringFlag{
+			Name:   "drone-repo-owner",
+			Usage:  "repository owner passed by Drone",
+			EnvVar: "DRONE_REPO_OWNER",
+		},
+		cli.StringFlag{
+			Name:   "drone-repo-name",
+			Usage:  "repository name passed by Drone",
+			EnvVar: "DRONE_REPO_NAME",
+		},
+		cli.StringFlag{
+			Name:   "drone-commit-branch",
+			Usage:  "commit branch passed by Drone",
+			EnvVar: "DRONE_COMMIT_BRANCH",
+		},
+		cli.StringFlag{
+			Name:   "drone-tag",
+			Usage:  "git tag passed by Drone",
+			EnvVar: "DRONE_TAG",
+		},
+		cli.BoolFlag{
+			Name:   "strict-settings",
+			Usage:  "Fail on PLUGIN_ environment variables that are not a setting of the plugin, e.g. misspelled settings",
+			EnvVar: "PLUGIN_STRICT_SETTINGS",
+		},
+		cli.StringFlag{
+			Name:   "vault-addr",
+			Usage:  "Vault address the vault-path secret is read from",
+			EnvVar: "PLUGIN_VAULT_ADDR,VAULT_ADDR",
+		},
+		cli.StringFlag{
+			Name:   "vault-token",
+			Usage:  "Vault token",
+			EnvVar: "PLUGIN_VAULT_TOKEN,VAULT_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "vault-namespace",
+			Usage:  "Vault Enterprise namespace",
+			EnvVar: "PLUGIN_VAULT_NAMESPACE,VAULT_NAMESPACE",
+		},
+	}
+}
+
+// Context returns the context of the command, canceled when it is stopped
+// with SIGINT or SIGTERM, e.g. because the Drone build was canceled. The
+// commands make their registry requests and run the plugin with it.
+func Context() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// Setup prepares the settings before they are read: it rejects unknown
+// settings in strict mode, loads settings from Vault and expands the Drone
+// metadata templates of the repository settings.
+func Setup(ctx context.Context, c *cli.Context, userAgent string) error {
+	if c.Bool("strict-settings") {
+		if err := settings.CheckUnknown(c.App.Flags, os.Environ(), "PLUGIN_ENV_FILE"); err != nil {
+			return err
+		}
+	}
+	if err := vault.LoadSettings(ctx, c, userAgent); err != nil {
+		return err
+	}
+	return expandRepos(c)
+}
+
+// UserAgent identifies the plugin command and the Drone build in requests.
+func UserAgent(c *cli.Context, name, version string) string {
+	return useragent.Info{
+		Name:    name,
+		Version: version,
+		Repo:    c.String("drone-repo"),
+		Build:   c.String("drone-build-number"),
+		Suffix:  c.String("user-agent-suffix"),
+	}.String()
+}
+
+// AddAuths adds the credentials of the promotion source, of the additional
+// registries and the credential helpers to the docker config used by kaniko.
+func AddAuths(c *cli.Context) error {
+	if err := setupPromotionAuth(c.String("promote-from"), c.String("promote-username"), c.String("promote-password")); err != nil {
+		return err
+	}
+	if err := docker.AddRegistryAuths(docker.ConfigPath, c.String("registry-auths")); err != nil {
+		return err
+	}
+	return docker.AddCredHelpers(docker.ConfigPath, c.StringSlice("credential-helpers"))
+}
+
+// Build returns the build configured by the shared settings. The commands
+// set the repositories, which depend on their registry.
+func Build(c *cli.Context) kaniko.Build {
+	return kaniko.Build{
+		DroneCommitRef:      c.String("drone-commit-ref"),
+		DroneRepoBranch:     c.String("drone-repo-branch"),
+		Dockerfile:          c.String("dockerfile"),
+		Context:             c.String("context"),
+		ContextSubPath:      c.String("context-sub-path"),
+		Tags:                c.StringSlice("tags"),
+		AutoTag:             c.Bool("auto-tag"),
+		AutoTagSuffix:       c.String("auto-tag-suffix"),
+		ExpandTag:           c.Bool("expand-tag"),
+		Taggers:             c.StringSlice("taggers"),
+		Args:                c.StringSlice("args"),
+		Target:              c.String("target"),
+		Mirrors:             c.StringSlice("registry-mirrors"),
+		Labels:              c.StringSlice("custom-labels"),
+		SkipTlsVerify:       c.Bool("skip-tls-verify"),
+		SnapshotMode:        c.String("snapshot-mode"),
+		EnableCache:         c.Bool("enable-cache"),
+		CacheDir:            c.String("cache-dir"),
+		CacheCopyLayers:     c.Bool("cache-copy-layers"),
+		CacheNoCompress:     c.Bool("cache-no-compress"),
+		CacheRetry:          c.BoolT("cache-retry"),
+		CachePruneFile:      c.String("cache-prune-file"),
+		CacheTTL:            c.Int("cache-ttl"),
+		DigestFile:          DigestFile,
+		NoPush:              c.Bool("no-push"),
+		Verbosity:           c.String("verbosity"),
+		UseNewRun:           c.Bool("use-new-run"),
+		Platform:            c.String("platform"),
+		SkipIdentical:       c.Bool("skip-identical"),
+		DroneCommitBefore:   c.String("drone-commit-before"),
+		DroneCommitSha:      c.String("drone-commit-sha"),
+		TriggerPaths:        c.StringSlice("trigger-paths"),
+		Discover:            c.Bool("discover"),
+		DiscoverRoot:        c.String("discover-root"),
+		DiscoverPattern:     c.String("discover-pattern"),
+		Release:             c.String("release"),
+		AssertEntrypoint:    c.String("assert-entrypoint"),
+		AssertPorts:         c.StringSlice("assert-ports"),
+		AssertEnv:           c.StringSlice("assert-env"),
+		AssertLabels:        c.StringSlice("assert-labels"),
+		SecretScan:          c.String("secret-scan"),
+		SecretScanIgnore:    c.StringSlice("secret-scan-ignore"),
+		DNSServers:          c.StringSlice("dns"),
+		HostOverrides:       c.StringSlice("host-overrides"),
+		PreflightAuth:       c.Bool("preflight-auth"),
+		PullRetry:           c.Int("pull-retry"),
+		PullRetryBackoff:    c.Duration("pull-retry-backoff"),
+		BuildTimeout:        c.Duration("build-timeout"),
+		ControlAddress:      c.String("control-address"),
+		StrictMirrors:       c.Bool("strict-mirrors"),
+		Platforms:           c.StringSlice("platforms"),
+		PlatformFields:      c.StringSlice("platform-fields"),
+		OCIArtifacts:        c.StringSlice("oci-artifacts"),
+		OCIArtifactsTag:     c.String("oci-artifacts-tag"),
+		OCIArtifactType:     c.String("oci-artifact-type"),
+		HelmChart:           c.String("helm-chart"),
+		HelmRepo:            c.String("helm-repo"),
+		HelmChartVersion:    c.String("helm-chart-version"),
+		HelmTagKey:          c.String("helm-tag-key"),
+		HelmDigestKey:       c.String("helm-digest-key"),
+		SingleSnapshot:      c.Bool("single-snapshot"),
+		IgnorePaths:         c.StringSlice("ignore-paths"),
+		IncludeVarRun:       c.Bool("include-var-run"),
+		CacheStats:          c.Bool("cache-stats"),
+		PatchFiles:          c.StringSlice("patch-files"),
+		PRComment:           c.Bool("pr-comment"),
+		CommitStatus:        c.Bool("commit-status"),
+		SCMProvider:         c.String("scm-provider"),
+		SCMURL:              c.String("scm-url"),
+		SCMToken:            c.String("scm-token"),
+		DroneRepo:           c.String("drone-repo"),
+		DroneRepoLink:       c.String("drone-repo-link"),
+		DronePullRequest:    c.Int("drone-pull-request"),
+		DroneBuildLink:      c.String("drone-build-link"),
+		ExecutorPath:        c.String("executor-path"),
+		ExecutorChecksum:    c.String("executor-checksum"),
+		ExecutorVersion:     c.String("executor-version"),
+		AddHosts:            c.StringSlice("add-hosts"),
+		Rootless:            c.Bool("rootless"),
+		DroneBuildNumber:    c.String("drone-build-number"),
+		DroneStageName:      c.String("drone-stage-name"),
+		DroneStepName:       c.String("drone-step-name"),
+		DroneStageMachine:   c.String("drone-stage-machine"),
+		DroneRunnerHostname: c.String("drone-runner-hostname"),
+		CIAnnotations:       c.Bool("ci-annotations"),
+		Annotations:         c.StringSlice("annotations"),
+		RetentionLabels:     c.StringSlice("retention-labels"),
+		RetentionPresets:    c.Bool("retention-presets"),
+		RequiredLabels:      c.StringSlice("required-labels"),
+		RequiredLabelPrefix: c.String("required-label-prefix"),
+		RequiredLabelsFile:  c.String("required-labels-file"),
+		DroneBuildEvent:     c.String("drone-build-event"),
+		DigestFiles:         c.StringSlice("digest-files"),
+		DigestStdout:        c.Bool("digest-stdout"),
+		ExpectedDigest:      c.String("expected-digest"),
+		DockerfileCheck:     c.String("dockerfile-check"),
+		SecretFiles:         c.StringSlice("secret-files"),
+		TagArgs:             c.StringSlice("tag-args"),
+		Ledger:              c.String("ledger"),
+	}
+}
+
+// Promotion returns the image promotion configured by the shared settings.
+func Promotion(c *cli.Context) kaniko.Promotion {
+	return kaniko.Promotion{
+		Source:    c.String("promote-from"),
+		VerifyKey: c.String("cosign-verify-key"),
+		SignKey:   c.String("cosign-sign-key"),
+	}
+}
+
+// MaskValues returns the values of the named sensitive settings of the
+// command and of the shared ones, masked in the kaniko output.
+func MaskValues(c *cli.Context, names ...string) []string {
+	values := settings.Values(c, append(names, "promote-password", "scm-token", "vault-token")...)
+	return append(values, docker.RegistryAuthSecrets(c.String("registry-auths"))...)
+}
+
+// expandRepos expands the Drone metadata templates, e.g. team/{{.RepoName}},
+// of the repository flags before they are used.
+func expandRepos(c *cli.Context) error {
+	m := repotemplate.Metadata{
+		Repo:        c.String("drone-repo"),
+		RepoOwner:   c.String("drone-repo-owner"),
+		RepoName:    c.String("drone-repo-name"),
+		Branch:      c.String("drone-commit-branch"),
+		Tag:         c.String("drone-tag"),
+		CommitSha:   c.String("drone-commit-sha"),
+		BuildNumber: c.String("drone-build-number"),
+		Event:       c.String("drone-build-event"),
+	}
+	for _, name := range []string{"repo", "cache-repo"} {
+		repo, err := repotemplate.Expand(c.String(name), m)
+		if err != nil {
+			return err
+		}
+		if repo != c.String(name) {
+			if err := c.Set(name, repo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setupPromotionAuth adds the credentials of the registry of the promotion
+// source to the docker config.
+func setupPromotionAuth(source, username, password string) error {
+	if source == "" || username == "" {
+		return nil
+	}
+	repo, _, err := registry.ParseReference(source)
+	if err != nil {
+		return errors.Wrap(err, "invalid promotion source")
+	}
+	return docker.AddAuth(docker.ConfigPath, repo.Registry, username, password)
+}
@@ -0,0 +1,60 @@
+package command
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func newContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range Flags() {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(nil, set, nil)
+}
+
+func TestFlags(t *testing.T) {
+	names := map[string]bool{}
+	for _, f := range Flags() {
+		if names[f.GetName()] {
+			t.Errorf("flag %s is defined twice", f.GetName())
+		}
+		names[f.GetName()] = true
+	}
+}
+
+func TestBuild(t *testing.T) {
+	c := newContext(t, "--dockerfile", "docker/Dockerfile", "--custom-labels", "team=acme", "--custom-labels", "tier=web", "--no-push")
+	build := Build(c)
+	if build.Dockerfile != "docker/Dockerfile" {
+		t.Errorf("Dockerfile = %s, want docker/Dockerfile", build.Dockerfile)
+	}
+	if want := []string{"team=acme", "tier=web"}; !reflect.DeepEqual(build.Labels, want) {
+		t.Errorf("Labels = %v, want %v", build.Labels, want)
+	}
+	if !build.NoPush {
+		t.Error("NoPush = false, want true")
+	}
+	if build.DigestFile != DigestFile {
+		t.Errorf("DigestFile = %s, want %s", build.DigestFile, DigestFile)
+	}
+	if build.Repo != "" || build.CacheRepo != "" {
+		t.Errorf("Repo = %q, CacheRepo = %q, want them set by the command", build.Repo, build.CacheRepo)
+	}
+}
+
+func TestMaskValues(t *testing.T) {
+	c := newContext(t, "--promote-password", "promote-secret", "--scm-token", "scm-secret")
+	got := MaskValues(c)
+	want := []string{"promote-secret", "scm-secret"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MaskValues() = %v, want %v", got, want)
+	}
+}
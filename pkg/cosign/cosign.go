@@ -0,0 +1,50 @@
+package cosign
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Binary is the cosign executable, looked up in PATH.
+var Binary = "cosign"
+
+// Check returns an error when the cosign binary is not installed.
+func Check() error {
+	if _, err := exec.LookPath(Binary); err != nil {
+		return fmt.Errorf("cosign keys are set but %s was not found in PATH", Binary)
+	}
+	return nil
+}
+
+// Verify verifies the signature of the image reference against the public key.
+func Verify(key, ref string) error {
+	if err := run("verify", "--key", key, ref); err != nil {
+		return fmt.Errorf("signature verification failed for %s: %s", ref, err)
+	}
+	return nil
+}
+
+// Sign signs the image reference with the private key. The key password is
+// read by cosign from COSIGN_PASSWORD.
+func Sign(key, ref string) error {
+	if err := run("sign", "--key", key, ref); err != nil {
+		return fmt.Errorf("signing failed for %s: %s", ref, err)
+	}
+	return nil
+}
+
+// SignatureTag returns the tag cosign stores the signature of the manifest
+// with the given digest under.
+func SignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+func run(args ...string) error {
+	cmd := exec.Command(Binary, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	fmt.Fprintf(os.Stdout, "+ %s\n", strings.Join(cmd.Args, " "))
+	return cmd.Run()
+}
@@ -0,0 +1,30 @@
+package cosign
+
+import "testing"
+
+func TestSignatureTag(t *testing.T) {
+	got := SignatureTag("sha256:0123abcd")
+	if want := "sha256-0123abcd.sig"; got != want {
+		t.Errorf("SignatureTag = %q, want %q", got, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	Binary = "sh"
+	defer func() { Binary = "cosign" }()
+	if err := Check(); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	Binary = "cosign-does-not-exist"
+	if err := Check(); err == nil {
+		t.Error("expected error when cosign is not installed")
+	}
+}
+
+func TestVerify_MissingBinary(t *testing.T) {
+	Binary = "cosign-does-not-exist"
+	defer func() { Binary = "cosign" }()
+	if err := Verify("cosign.pub", "registry.example.com/app@sha256:0123abcd"); err == nil {
+		t.Error("expected error when cosign is not installed")
+	}
+}
@@ -0,0 +1,115 @@
+package discover
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/glob"
+)
+
+// DefaultPattern matches every Dockerfile below the discovery root.
+const DefaultPattern string = "**/Dockerfile"
+
+// invalidNameChars matches the characters not allowed in repository names.
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// Service is a Dockerfile found during discovery.
+type Service struct {
+	Name       string // Name derived from the directory, empty for the root directory
+	Dir        string // Directory containing the Dockerfile, used as build context
+	Dockerfile string // Path to the Dockerfile
+}
+
+// Services walks root and returns a service for each file matching the glob
+// pattern, which is relative to root. Service names are the base name of the
+// Dockerfile's directory, sanitized for use as repository name, and must be
+// unique since each service is pushed to its own repository.
+func Services(root, pattern string) ([]Service, error) {
+	if pattern == "" {
+		pattern = DefaultPattern
+	}
+	var services []Service
+	dirs := map[string]string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if !glob.Match(pattern, filepath.ToSlash(rel)) {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		service := Service{Dir: dir, Dockerfile: path}
+		if relDir := filepath.Dir(rel); relDir != "." {
+			if service.Name, err = Name(filepath.Base(relDir)); err != nil {
+				return err
+			}
+		}
+		if other, ok := dirs[service.Name]; ok {
+			return fmt.Errorf("dockerfiles in %s and %s are both pushed to the repository of %q, rename a directory or narrow the discover pattern", other, dir, service.Name)
+		}
+		dirs[service.Name] = dir
+		services = append(services, service)
+		return nil
+	})
+	return services, err
+}
+
+// Name returns the repository name of the directory name dir: lowercased,
+// with runs of characters not allowed in repository names replaced by a dash.
+func Name(dir string) (string, error) {
+	name := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(dir), "-"), "._-")
+	if name == "" {
+		return "", fmt.Errorf("directory %s has no valid repository name", dir)
+	}
+	return name, nil
+}
+
+// Changed returns the services with at least one changed file in their
+// directory. Files are slash separated paths relative to the repository
+// root, as listed by git, and service directories are made relative to it.
+func Changed(services []Service, repoRoot string, files []string) ([]Service, error) {
+	var changed []Service
+	for _, service := range services {
+		dir, err := relativeDir(repoRoot, service.Dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if dir == "." || strings.HasPrefix(file, dir+"/") {
+				changed = append(changed, service)
+				break
+			}
+		}
+	}
+	return changed, nil
+}
+
+// relativeDir returns dir relative to root as slash separated path.
+func relativeDir(root, dir string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absRoot, absDir)
+	if rel = filepath.ToSlash(rel); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("directory %s is outside of the repository %s", dir, root)
+	}
+	return rel, nil
+}
@@ -0,0 +1,116 @@
+package discover
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestServices(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"services/API/Dockerfile", "services/web/Dockerfile", "services/web/main.go", "tools/Dockerfile.dev"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	services, err := Services(root, "")
+	if err != nil {
+		t.Fatalf("Services failed: %s", err)
+	}
+	want := []Service{
+		{Name: "api", Dir: filepath.Join(root, "services/API"), Dockerfile: filepath.Join(root, "services/API/Dockerfile")},
+		{Name: "web", Dir: filepath.Join(root, "services/web"), Dockerfile: filepath.Join(root, "services/web/Dockerfile")},
+	}
+	if !reflect.DeepEqual(services, want) {
+		t.Errorf("Services = %#v, want %#v", services, want)
+	}
+
+	services, err = Services(root, "tools/Dockerfile.*")
+	if err != nil {
+		t.Fatalf("Services failed: %s", err)
+	}
+	if len(services) != 1 || services[0].Name != "tools" {
+		t.Errorf("Services with custom pattern = %#v", services)
+	}
+}
+
+func TestServices_names(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"services/My API/Dockerfile", "tools/api/Dockerfile"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	services, err := Services(root, "services/**/Dockerfile")
+	if err != nil {
+		t.Fatalf("Services failed: %s", err)
+	}
+	if len(services) != 1 || services[0].Name != "my-api" {
+		t.Errorf("Services = %#v, want my-api", services)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "services/api"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "services/api/Dockerfile"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Services(root, ""); err == nil {
+		t.Error("expected error for services/api and tools/api")
+	}
+}
+
+func TestName(t *testing.T) {
+	tests := map[string]string{
+		"api":        "api",
+		"My API":     "my-api",
+		"web_app.v2": "web_app.v2",
+		"_internal":  "internal",
+		"Ümlaut":     "mlaut",
+	}
+	for dir, want := range tests {
+		if got, err := Name(dir); err != nil || got != want {
+			t.Errorf("Name(%q) = %q, %v, want %q", dir, got, err, want)
+		}
+	}
+	if _, err := Name("@@"); err == nil {
+		t.Error("Name(@@) error = nil")
+	}
+}
+
+func TestChanged(t *testing.T) {
+	services := []Service{
+		{Name: "api", Dir: "services/api"},
+		{Name: "web", Dir: "services/web"},
+	}
+	files := []string{"README.md", "services/api/main.go", "services/webapp/main.go"}
+	got, err := Changed(services, ".", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := services[:1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Changed = %#v, want %#v", got, want)
+	}
+
+	// Absolute directories, e.g. of an absolute discover root
+	root := t.TempDir()
+	absolute := []Service{{Name: "api", Dir: filepath.Join(root, "services/api")}}
+	if got, err := Changed(absolute, root, files); err != nil || len(got) != 1 {
+		t.Errorf("Changed with absolute dirs = %#v, %v", got, err)
+	}
+	if _, err := Changed(absolute, t.TempDir(), files); err == nil {
+		t.Error("expected error for directories outside of the repository")
+	}
+}
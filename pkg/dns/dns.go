@@ -0,0 +1,195 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Paths of the resolver configuration read by kaniko.
+var (
+	ResolvConfPath = "/etc/resolv.conf"
+	HostsPath      = "/etc/hosts"
+)
+
+// Config overrides name resolution for the plugin and kaniko.
+type Config struct {
+	Servers []string          // DNS servers, as ip addresses
+	Hosts   map[string]string // Static host name to ip mappings
+}
+
+// New parses DNS servers, given as ip or ip:53, and host overrides given
+// as host:ip pairs, as accepted by docker's --add-host. IPv6 addresses are
+// supported in both. resolv.conf has no ports, so servers must listen on
+// port 53.
+func New(servers, hosts []string) (*Config, error) {
+	c := &Config{Hosts: map[string]string{}}
+	for _, server := range servers {
+		host, port := strings.Trim(server, "[]"), "53"
+		if net.ParseIP(host) == nil {
+			var err error
+			if host, port, err = net.SplitHostPort(server); err != nil || net.ParseIP(host) == nil {
+				return nil, fmt.Errorf("invalid DNS server %s, expected an ip address", server)
+			}
+		}
+		if port != "53" {
+			return nil, fmt.Errorf("DNS server %s must use port 53, resolv.conf does not support other ports", server)
+		}
+		c.Servers = append(c.Servers, net.ParseIP(host).String())
+	}
+	for _, entry := range hosts {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || net.ParseIP(strings.Trim(parts[1], "[]")) == nil {
+			return nil, fmt.Errorf("invalid host mapping %s, expected host:ip", entry)
+		}
+		c.Hosts[parts[0]] = strings.Trim(parts[1], "[]")
+	}
+	return c, nil
+}
+
+// Empty reports whether the config overrides nothing.
+func (c *Config) Empty() bool {
+	return len(c.Servers) == 0 && len(c.Hosts) == 0
+}
+
+// DialContext dials addresses using the host overrides and DNS servers.
+func (c *Config) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if len(c.Servers) > 0 {
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var err error
+				for _, server := range c.Servers {
+					var conn net.Conn
+					if conn, err = (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, network, net.JoinHostPort(server, "53")); err == nil {
+						return conn, nil
+					}
+				}
+				return nil, err
+			},
+		}
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		if ip, ok := c.Hosts[host]; ok {
+			addr = net.JoinHostPort(ip, port)
+		}
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// Apply writes the DNS servers to ResolvConfPath and appends the host
+// overrides missing from HostsPath so that the kaniko executor uses them as
+// well. The files outlive the build, e.g. on exec runners, where they are
+// those of the host, so the returned function restores them.
+func (c *Config) Apply() (restore func() error, err error) {
+	var saved []savedFile
+	restore = func() error {
+		var err error
+		for i := len(saved) - 1; i >= 0; i-- {
+			if rerr := saved[i].restore(); rerr != nil && err == nil {
+				err = rerr
+			}
+		}
+		return err
+	}
+	defer func() {
+		if err != nil {
+			restore()
+		}
+	}()
+
+	if len(c.Servers) > 0 {
+		f, err := saveFile(ResolvConfPath)
+		if err != nil {
+			return nil, err
+		}
+		saved = append(saved, f)
+		var b strings.Builder
+		for _, server := range c.Servers {
+			fmt.Fprintf(&b, "nameserver %s\n", server)
+		}
+		if err := ioutil.WriteFile(ResolvConfPath, []byte(b.String()), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %s", ResolvConfPath, err)
+		}
+	}
+	if len(c.Hosts) > 0 {
+		f, err := saveFile(HostsPath)
+		if err != nil {
+			return nil, err
+		}
+		saved = append(saved, f)
+		mapped := map[string]bool{}
+		for _, line := range strings.Split(string(f.content), "\n") {
+			if fields := strings.Fields(line); len(fields) > 1 {
+				for _, host := range fields[1:] {
+					mapped[fields[0]+" "+host] = true
+				}
+			}
+		}
+
+		var hosts []string
+		for host := range c.Hosts {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		var b strings.Builder
+		for _, host := range hosts {
+			// Sub-builds, e.g. of discovered services, apply the same overrides
+			if ip := c.Hosts[host]; !mapped[ip+" "+host] {
+				fmt.Fprintf(&b, "%s\t%s\n", ip, host)
+			}
+		}
+		if err := appendFile(HostsPath, b.String()); err != nil {
+			return nil, err
+		}
+	}
+	return restore, nil
+}
+
+// savedFile is the content of a file before Apply changed it.
+type savedFile struct {
+	path    string
+	content []byte
+	exists  bool
+}
+
+func saveFile(path string) (savedFile, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return savedFile{}, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	return savedFile{path: path, content: content, exists: err == nil}, nil
+}
+
+func (f savedFile) restore() error {
+	if !f.exists {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %s", f.path, err)
+		}
+		return nil
+	}
+	// Written in place, since /etc/resolv.conf and /etc/hosts are usually
+	// bind mounts that cannot be replaced
+	if err := ioutil.WriteFile(f.path, f.content, 0644); err != nil {
+		return fmt.Errorf("failed to restore %s: %s", f.path, err)
+	}
+	return nil
+}
+
+func appendFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	c, err := New([]string{"10.0.0.2", "[fd00::53]", "10.0.0.3:53"}, []string{"registry.internal:10.1.2.3", "v6.internal:fd00::1"})
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if got, want := strings.Join(c.Servers, ","), "10.0.0.2,fd00::53,10.0.0.3"; got != want {
+		t.Errorf("Servers = %s, want %s", got, want)
+	}
+	if c.Hosts["registry.internal"] != "10.1.2.3" || c.Hosts["v6.internal"] != "fd00::1" {
+		t.Errorf("unexpected hosts %v", c.Hosts)
+	}
+
+	for _, hosts := range [][]string{{"registry.internal"}, {"registry.internal:not-an-ip"}, {":10.0.0.1"}} {
+		if _, err := New(nil, hosts); err == nil {
+			t.Errorf("expected error for hosts %q", hosts)
+		}
+	}
+	for _, server := range []string{"dns.example.com", "dns.example.com:53", "10.0.0.3:5353", "[fd00::53]:5353"} {
+		if _, err := New([]string{server}, nil); err == nil {
+			t.Errorf("expected error for server %s", server)
+		}
+	}
+}
+
+func TestConfig_DialContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	c, _ := New(nil, []string{"registry.internal:127.0.0.1"})
+	conn, err := c.DialContext(context.Background(), "tcp", net.JoinHostPort("registry.internal", port))
+	if err != nil {
+		t.Fatalf("DialContext failed: %s", err)
+	}
+	conn.Close()
+}
+
+func TestConfig_Apply(t *testing.T) {
+	dir := t.TempDir()
+	ResolvConfPath = filepath.Join(dir, "resolv.conf")
+	HostsPath = filepath.Join(dir, "hosts")
+	defer func() { ResolvConfPath, HostsPath = "/etc/resolv.conf", "/etc/hosts" }()
+	ioutil.WriteFile(HostsPath, []byte("127.0.0.1\tlocalhost\n"), 0644)
+
+	c, _ := New([]string{"10.0.0.2"}, []string{"registry.internal:10.1.2.3"})
+	restore, err := c.Apply()
+	if err != nil {
+		t.Fatalf("Apply failed: %s", err)
+	}
+	resolv, _ := ioutil.ReadFile(ResolvConfPath)
+	if got, want := string(resolv), "nameserver 10.0.0.2\n"; got != want {
+		t.Errorf("resolv.conf = %q, want %q", got, want)
+	}
+	hosts, _ := ioutil.ReadFile(HostsPath)
+	if got, want := string(hosts), "127.0.0.1\tlocalhost\n10.1.2.3\tregistry.internal\n"; got != want {
+		t.Errorf("hosts = %q, want %q", got, want)
+	}
+
+	// Applying again, e.g. for a sub-build, adds no duplicate entries
+	c, _ = New(nil, []string{"registry.internal:10.1.2.3", "cache.internal:10.1.2.4"})
+	restoreSub, err := c.Apply()
+	if err != nil {
+		t.Fatalf("Apply failed: %s", err)
+	}
+	hosts, _ = ioutil.ReadFile(HostsPath)
+	if got, want := string(hosts), "127.0.0.1\tlocalhost\n10.1.2.3\tregistry.internal\n10.1.2.4\tcache.internal\n"; got != want {
+		t.Errorf("hosts after second apply = %q, want %q", got, want)
+	}
+
+	if err := restoreSub(); err != nil {
+		t.Fatalf("restore failed: %s", err)
+	}
+	hosts, _ = ioutil.ReadFile(HostsPath)
+	if got, want := string(hosts), "127.0.0.1\tlocalhost\n10.1.2.3\tregistry.internal\n"; got != want {
+		t.Errorf("hosts after restoring the second apply = %q, want %q", got, want)
+	}
+	if err := restore(); err != nil {
+		t.Fatalf("restore failed: %s", err)
+	}
+	hosts, _ = ioutil.ReadFile(HostsPath)
+	if got, want := string(hosts), "127.0.0.1\tlocalhost\n"; got != want {
+		t.Errorf("restored hosts = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(ResolvConfPath); !os.IsNotExist(err) {
+		t.Errorf("expected the created resolv.conf to be removed, stat %v", err)
+	}
+}
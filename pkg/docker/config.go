@@ -2,12 +2,30 @@ package docker
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
 )
 
+// ConfigPath is the location of the docker config file read by kaniko, in
+// the DOCKER_CONFIG directory when set, e.g. for images running as non-root.
+var ConfigPath = filepath.Join(configDir(), "config.json")
+
+func configDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	return DefaultConfigDir
+}
+
 type (
 	Auth struct {
-		Auth string `json:"auth"`
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken,omitempty"`
 	}
 
 	Config struct {
@@ -29,6 +47,60 @@ func (c *Config) SetAuth(registry, username, password string) {
 	c.Auths[registry] = Auth{Auth: encodedString}
 }
 
+// SetIdentityToken sets the OAuth identity token, a refresh token exchanged
+// for registry tokens, of username for registry, as stored by docker login
+// for e.g. Docker Hub accounts with two-factor authentication.
+func (c *Config) SetIdentityToken(registry, username, token string) {
+	encodedString := base64.StdEncoding.EncodeToString([]byte(username + ":"))
+	c.Auths[registry] = Auth{Auth: encodedString, IdentityToken: token}
+}
+
 func (c *Config) SetCredHelper(registry, helper string) {
 	c.CredHelpers[registry] = helper
 }
+
+// Save writes the config to path, readable by the owner only, creating its
+// directory if necessary.
+func (c *Config) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to create %s directory", filepath.Dir(path)))
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	// The config is replaced atomically since credential refreshes rewrite
+	// it while kaniko reads it
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "failed to write docker config file")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to write docker config file")
+	}
+	return nil
+}
+
+// RemoveCredentials removes the docker config file, the environments of
+// wrapping credential helpers and the given credential files, so that
+// credentials don't outlive the step on shared runners.
+func RemoveCredentials(paths ...string) {
+	helpers, _ := filepath.Glob(filepath.Join(HelperDir, helperPrefix+"*"+envHelperSuffix+".json"))
+	for _, path := range append(append([]string{ConfigPath}, helpers...), paths...) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "failed to remove credentials at %s: %s\n", path, err)
+		}
+	}
+}
+
+// AddAuth adds basic auth credentials for registry to the docker config file
+// at path, keeping any existing entries.
+func AddAuth(path, registry, username, password string) error {
+	c, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	c.SetAuth(registry, username, password)
+	return c.Save(path)
+}
@@ -2,6 +2,9 @@ package docker
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -23,3 +26,74 @@ func TestConfig(t *testing.T) {
 		t.Errorf("unexpected json output:\n  want: %s\n   got: %s", want, got)
 	}
 }
+
+func TestConfig_SetIdentityToken(t *testing.T) {
+	c := NewConfig()
+	c.SetIdentityToken(RegistryV1, "octocat", "refresh-token")
+
+	bytes, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"auths":{"https://index.docker.io/v1/":{"auth":"b2N0b2NhdDo=","identitytoken":"refresh-token"}},"credHelpers":{}}`
+	if got := string(bytes); got != want {
+		t.Errorf("unexpected json output:\n  want: %s\n   got: %s", want, got)
+	}
+	parsed, err := ParseConfig(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed.Auths[RegistryV1]; got.IdentityToken != "refresh-token" {
+		t.Errorf("parsed auth = %#v, want identity token refresh-token", got)
+	}
+}
+
+func TestSave_permissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "docker")
+	existing := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(existing, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{filepath.Join(dir, "config.json"), existing} {
+		c := NewConfig()
+		c.SetAuth(RegistryV1, "test", "password")
+		if err := c.Save(path); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("%s: unexpected mode %o", path, mode)
+		}
+	}
+}
+
+func TestRemoveCredentials(t *testing.T) {
+	dir := t.TempDir()
+	configPath, helperDir := ConfigPath, HelperDir
+	defer func() { ConfigPath, HelperDir = configPath, helperDir }()
+	ConfigPath, HelperDir = filepath.Join(dir, "config.json"), dir
+
+	key := filepath.Join(dir, "key.json")
+	helperEnv := filepath.Join(dir, "docker-credential-gcr-env.json")
+	other := filepath.Join(dir, "other.json")
+	for _, path := range []string{ConfigPath, key, helperEnv, other} {
+		if err := ioutil.WriteFile(path, []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	RemoveCredentials(key, filepath.Join(dir, "missing.json"))
+
+	for _, path := range []string{ConfigPath, key, helperEnv} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", path)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("%s was removed", other)
+	}
+}
@@ -5,3 +5,6 @@ const (
 	RegistryV2        string = "https://index.docker.io/v2/"
 	RegistryECRPublic string = "public.ecr.aws"
 )
+
+// DefaultConfigDir is the docker config directory of the kaniko images.
+const DefaultConfigDir string = "/kaniko/.docker"
@@ -0,0 +1,210 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// dockerHubHosts are the host names that refer to Docker Hub. Credentials
+// for Docker Hub are conventionally stored under RegistryV1.
+var dockerHubHosts = []string{"docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com"}
+
+// LoadConfig reads a docker config file. A missing file yields an empty config.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewConfig(), nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read docker config %s", path))
+	}
+	c, err := ParseConfig(b)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to parse docker config %s", path))
+	}
+	return c, nil
+}
+
+// ParseConfig parses the content of a docker config file. Auth entries given
+// as username and password instead of auth are converted to auth.
+func ParseConfig(content []byte) (*Config, error) {
+	var config struct {
+		Auths map[string]struct {
+			Auth
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+	c := NewConfig()
+	for registry, auth := range config.Auths {
+		if auth.Auth.Auth == "" && auth.Username != "" {
+			auth.Auth.Auth = base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		}
+		c.Auths[registry] = auth.Auth
+	}
+	for registry, helper := range config.CredHelpers {
+		c.CredHelpers[registry] = helper
+	}
+	return c, nil
+}
+
+// Merge adds the auth entries and credential helpers of other to the config,
+// replacing those of the same registries.
+func (c *Config) Merge(other *Config) {
+	for registry, auth := range other.Auths {
+		c.Auths[registry] = auth
+	}
+	for registry, helper := range other.CredHelpers {
+		c.CredHelpers[registry] = helper
+	}
+}
+
+// RegistryAuth is an entry of a list of registry credentials, with either a
+// password or an identity token.
+type RegistryAuth struct {
+	Registry      string `json:"registry" yaml:"registry"`
+	Username      string `json:"username" yaml:"username"`
+	Password      string `json:"password" yaml:"password"`
+	IdentityToken string `json:"identity_token" yaml:"identity_token"`
+}
+
+// ParseRegistryAuths parses a JSON or YAML list of registry credentials.
+func ParseRegistryAuths(content string) ([]RegistryAuth, error) {
+	var auths []RegistryAuth
+	if err := yaml.Unmarshal([]byte(content), &auths); err != nil {
+		return nil, errors.Wrap(err, "failed to parse registry credentials")
+	}
+	for _, auth := range auths {
+		if auth.Registry == "" || auth.Username == "" {
+			return nil, fmt.Errorf("registry credentials must specify registry and username")
+		}
+		if auth.Password != "" && auth.IdentityToken != "" {
+			return nil, fmt.Errorf("registry credentials of %s must specify either password or identity_token", auth.Registry)
+		}
+	}
+	return auths, nil
+}
+
+// RegistryAuthSecrets returns the passwords and identity tokens of the JSON
+// or YAML list of registry credentials, none if it is invalid.
+func RegistryAuthSecrets(content string) []string {
+	auths, err := ParseRegistryAuths(content)
+	if err != nil {
+		return nil
+	}
+	var secrets []string
+	for _, auth := range auths {
+		for _, secret := range []string{auth.Password, auth.IdentityToken} {
+			if secret != "" {
+				secrets = append(secrets, secret)
+			}
+		}
+	}
+	return secrets
+}
+
+// AddRegistryAuths adds the JSON or YAML list of registry credentials to the
+// docker config file at path, replacing the entries of the same registries.
+func AddRegistryAuths(path, content string) error {
+	if strings.TrimSpace(content) == "" {
+		return nil
+	}
+	auths, err := ParseRegistryAuths(content)
+	if err != nil {
+		return err
+	}
+	c, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	for _, auth := range auths {
+		if auth.IdentityToken != "" {
+			c.SetIdentityToken(auth.Registry, auth.Username, auth.IdentityToken)
+			continue
+		}
+		c.SetAuth(auth.Registry, auth.Username, auth.Password)
+	}
+	return c.Save(path)
+}
+
+// Credentials returns the username and password configured for the registry
+// host, either as a static auth entry or through a credential helper. Empty
+// values are returned when the registry has no credentials configured.
+func (c *Config) Credentials(host string) (username, password string, err error) {
+	for _, key := range authKeys(host) {
+		if auth, ok := c.Auths[key]; ok && auth.Auth != "" {
+			return decodeAuth(auth.Auth)
+		}
+	}
+	if helper, ok := c.CredHelpers[host]; ok {
+		return helperCredentials(helper, host)
+	}
+	return "", "", nil
+}
+
+// IdentityToken returns the identity token configured for the registry host,
+// if any.
+func (c *Config) IdentityToken(host string) string {
+	for _, key := range authKeys(host) {
+		if auth, ok := c.Auths[key]; ok && auth.Auth != "" {
+			return auth.IdentityToken
+		}
+	}
+	return ""
+}
+
+// authKeys lists the keys an auth entry for host may be stored under.
+func authKeys(host string) []string {
+	keys := []string{host, "https://" + host, "http://" + host}
+	for _, hub := range dockerHubHosts {
+		if host == hub {
+			return append(keys, RegistryV1)
+		}
+	}
+	return keys
+}
+
+func decodeAuth(auth string) (string, string, error) {
+	b, err := base64.StdEncoding.DecodeString(auth)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to decode docker auth")
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid docker auth entry")
+	}
+	return parts[0], parts[1], nil
+}
+
+// helperCredentials runs docker-credential-<helper> get for the host.
+func helperCredentials(helper, host string) (string, string, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(host)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", "", errors.Wrap(err, fmt.Sprintf("credential helper %s failed for %s: %s", helper, host, strings.TrimSpace(stderr.String())))
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", errors.Wrap(err, fmt.Sprintf("invalid output from credential helper %s", helper))
+	}
+	return creds.Username, creds.Secret, nil
+}
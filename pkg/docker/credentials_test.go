@@ -0,0 +1,171 @@
+package docker
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	c, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("unexpected error for missing config: %s", err)
+	}
+	if len(c.Auths) != 0 || len(c.CredHelpers) != 0 {
+		t.Errorf("expected empty config, got %#v", c)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"auths":{"registry.example.com":{"auth":"dGVzdDpwYXNzd29yZA=="}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err = LoadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := c.Auths["registry.example.com"]; !ok {
+		t.Errorf("expected auth entry for registry.example.com, got %#v", c.Auths)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig([]byte(`{
+		"auths": {
+			"nexus.example.com": {"username": "user", "password": "pass:word"},
+			"zot.example.com": {"auth": "dGVzdDpwYXNzd29yZA=="},
+			"acr.example.com": {"identitytoken": "token"}
+		},
+		"credHelpers": {"gcr.io": "gcr"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username, password, _ := c.Credentials("nexus.example.com"); username != "user" || password != "pass:word" {
+		t.Errorf("Credentials(nexus.example.com) = %q, %q", username, password)
+	}
+	if username, _, _ := c.Credentials("zot.example.com"); username != "test" {
+		t.Errorf("Credentials(zot.example.com) username = %q", username)
+	}
+	if c.Auths["acr.example.com"].IdentityToken != "token" || c.CredHelpers["gcr.io"] != "gcr" {
+		t.Errorf("unexpected config %#v", c)
+	}
+
+	base := NewConfig()
+	base.SetAuth("zot.example.com", "other", "password")
+	base.SetAuth("gitea.example.com", "gitea", "password")
+	base.Merge(c)
+	if username, _, _ := base.Credentials("zot.example.com"); username != "test" {
+		t.Errorf("expected merged auth to replace zot.example.com, got %q", username)
+	}
+	if _, ok := base.Auths["gitea.example.com"]; !ok || len(base.Auths) != 4 {
+		t.Errorf("unexpected merged auths %#v", base.Auths)
+	}
+
+	if _, err := ParseConfig([]byte(`{"auths": [`)); err == nil {
+		t.Error("ParseConfig() with invalid JSON error = nil")
+	}
+}
+
+func TestConfig_Credentials(t *testing.T) {
+	c := NewConfig()
+	c.SetAuth(RegistryV1, "hub-user", "hub-pass")
+	c.SetAuth("https://registry.example.com", "user", "pass:word")
+
+	tests := []struct {
+		host     string
+		username string
+		password string
+	}{
+		{"registry-1.docker.io", "hub-user", "hub-pass"},
+		{"index.docker.io", "hub-user", "hub-pass"},
+		{"registry.example.com", "user", "pass:word"},
+		{"other.example.com", "", ""},
+	}
+	for _, tt := range tests {
+		username, password, err := c.Credentials(tt.host)
+		if err != nil {
+			t.Errorf("Credentials(%q) returned error: %s", tt.host, err)
+			continue
+		}
+		if username != tt.username || password != tt.password {
+			t.Errorf("Credentials(%q) = %q, %q, want %q, %q", tt.host, username, password, tt.username, tt.password)
+		}
+	}
+}
+
+func TestAddAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".docker", "config.json")
+	c := NewConfig()
+	c.SetCredHelper("gcr.io", "gcr")
+	if err := c.Save(path); err != nil {
+		t.Fatalf("Save failed: %s", err)
+	}
+
+	if err := AddAuth(path, "staging.example.com", "user", "pass"); err != nil {
+		t.Fatalf("AddAuth failed: %s", err)
+	}
+	got, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CredHelpers["gcr.io"] != "gcr" {
+		t.Errorf("expected existing cred helper to be kept, got %#v", got.CredHelpers)
+	}
+	if username, password, _ := got.Credentials("staging.example.com"); username != "user" || password != "pass" {
+		t.Errorf("Credentials = %q, %q, want user, pass", username, password)
+	}
+}
+
+func TestAddRegistryAuths(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".docker", "config.json")
+	if err := AddAuth(path, "ghcr.io", "old", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{
+		`[{"registry": "ghcr.io", "username": "octocat", "password": "pat"}, {"registry": "quay.io", "username": "robot", "password": "0123"}]`,
+		"- registry: ghcr.io\n  username: octocat\n  password: pat\n- registry: quay.io\n  username: robot\n  password: 0123\n",
+	} {
+		if err := AddRegistryAuths(path, content); err != nil {
+			t.Fatalf("AddRegistryAuths(%q) failed: %s", content, err)
+		}
+		got, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username, password, _ := got.Credentials("ghcr.io"); username != "octocat" || password != "pat" {
+			t.Errorf("Credentials(ghcr.io) = %q, %q, want octocat, pat", username, password)
+		}
+		if username, password, _ := got.Credentials("quay.io"); username != "robot" || password != "0123" {
+			t.Errorf("Credentials(quay.io) = %q, %q, want robot, 0123", username, password)
+		}
+	}
+	if err := AddRegistryAuths(path, `[{"registry": "registry.example.com", "username": "octocat", "identity_token": "refresh-token"}]`); err != nil {
+		t.Fatalf("AddRegistryAuths() with identity token failed: %s", err)
+	}
+	if got, _ := LoadConfig(path); got.Auths["registry.example.com"].IdentityToken != "refresh-token" {
+		t.Errorf("auth of registry.example.com = %#v, want identity token", got.Auths["registry.example.com"])
+	}
+	for _, content := range []string{
+		`[{"registry": "ghcr.io"}]`,
+		`{"registry": "ghcr.io"}`,
+		`[{"registry": "ghcr.io", "username": "octocat", "password": "pat", "identity_token": "refresh-token"}]`,
+	} {
+		if err := AddRegistryAuths(path, content); err == nil {
+			t.Errorf("AddRegistryAuths(%q) = nil, want error", content)
+		}
+	}
+	if err := AddRegistryAuths(filepath.Join(t.TempDir(), "config.json"), " "); err != nil {
+		t.Errorf("AddRegistryAuths() without credentials = %v", err)
+	}
+}
+
+func TestRegistryAuthSecrets(t *testing.T) {
+	content := `[{"registry": "ghcr.io", "username": "octocat", "password": "pat"}, {"registry": "registry.example.com", "username": "octocat", "identity_token": "refresh-token"}]`
+	if got, want := RegistryAuthSecrets(content), []string{"pat", "refresh-token"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RegistryAuthSecrets() = %q, want %q", got, want)
+	}
+	if got := RegistryAuthSecrets(`[{"registry": "ghcr.io"}]`); got != nil {
+		t.Errorf("RegistryAuthSecrets() of invalid credentials = %q, want none", got)
+	}
+}
@@ -0,0 +1,183 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	helperPrefix string = "docker-credential-"
+
+	// envHelperSuffix is appended to the name of credential helpers run
+	// with a helper specific environment.
+	envHelperSuffix string = "-env"
+)
+
+// helperPattern matches credential helper names, the suffix of their
+// docker-credential-<helper> executables.
+var helperPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// HelperDir is the directory wrapping credential helpers are written to. It
+// must be in the PATH of kaniko, as /kaniko is in the kaniko images.
+var HelperDir = "/kaniko"
+
+// HelperEnv returns the environment of credential helpers from a proxy URL,
+// the hosts excluded from the proxy and further NAME=value variables, e.g.
+// AWS_CA_BUNDLE for proxies intercepting TLS.
+func HelperEnv(proxy, noProxy string, vars []string) (map[string]string, error) {
+	env := map[string]string{}
+	if proxy != "" {
+		env["HTTPS_PROXY"], env["HTTP_PROXY"] = proxy, proxy
+	}
+	if noProxy != "" {
+		env["NO_PROXY"] = noProxy
+	}
+	for _, v := range vars {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid credential helper environment variable %s, expected NAME=value", v)
+		}
+		env[parts[0]] = parts[1]
+	}
+	return env, nil
+}
+
+// ParseCredHelpers parses registry=helper pairs, e.g. ghcr.io=gcloud, into
+// the credential helpers of the registries.
+func ParseCredHelpers(pairs []string) (map[string]string, error) {
+	helpers := map[string]string{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || !helperPattern.MatchString(strings.TrimPrefix(parts[1], helperPrefix)) {
+			return nil, fmt.Errorf("invalid credential helper %s, expected registry=helper", pair)
+		}
+		helpers[parts[0]] = strings.TrimPrefix(parts[1], helperPrefix)
+	}
+	return helpers, nil
+}
+
+// AddCredHelpers adds the registry=helper pairs to the docker config file at
+// path, replacing the entries of the same registries. Helpers missing from
+// the PATH are reported, since kaniko fails only when it pulls or pushes.
+func AddCredHelpers(path string, pairs []string) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+	helpers, err := ParseCredHelpers(pairs)
+	if err != nil {
+		return err
+	}
+	c, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	for registry, helper := range helpers {
+		if _, err := exec.LookPath(helperPrefix + helper); err != nil {
+			fmt.Fprintf(os.Stderr, "credential helper %s%s of %s not found in PATH\n", helperPrefix, helper, registry)
+		}
+		c.SetCredHelper(registry, helper)
+	}
+	return c.Save(path)
+}
+
+// WrapCredHelpers makes every credential helper of the config run with env
+// on top of the environment of kaniko, so that e.g. only credential
+// requests go through a proxy. The running executable serves as wrapping
+// helper and must call RunCredHelperWrapper on startup.
+func (c *Config) WrapCredHelpers(env map[string]string) error {
+	if len(env) == 0 {
+		return nil
+	}
+	for registry, helper := range c.CredHelpers {
+		if strings.HasSuffix(helper, envHelperSuffix) {
+			continue
+		}
+		wrapped, err := wrapCredHelper(helper, env)
+		if err != nil {
+			return err
+		}
+		c.CredHelpers[registry] = wrapped
+	}
+	return nil
+}
+
+// wrapCredHelper links docker-credential-<helper>-env in HelperDir to the
+// running executable, writes env next to it and returns the name of the
+// wrapping helper.
+func wrapCredHelper(helper string, env map[string]string) (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to locate the plugin executable")
+	}
+	name := helperPrefix + helper + envHelperSuffix
+	link := filepath.Join(HelperDir, name)
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.Symlink(self, link); err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to create credential helper %s", link))
+	}
+	b, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(link+".json", b, 0600); err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to write environment of credential helper %s", name))
+	}
+	return helper + envHelperSuffix, nil
+}
+
+// RunCredHelperWrapper runs the wrapped credential helper and exits if the
+// process was started as wrapping helper, and returns otherwise.
+func RunCredHelperWrapper() {
+	name := filepath.Base(os.Args[0])
+	if !strings.HasPrefix(name, helperPrefix) || !strings.HasSuffix(name, envHelperSuffix) {
+		return
+	}
+	os.Exit(runWrappedHelper(name, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// runWrappedHelper runs the helper wrapped by the helper name with its
+// environment and returns the exit code.
+func runWrappedHelper(name string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	b, err := ioutil.ReadFile(filepath.Join(HelperDir, name+".json"))
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to read environment of credential helper %s: %s\n", name, err)
+		return 1
+	}
+	var env map[string]string
+	if err := json.Unmarshal(b, &env); err != nil {
+		fmt.Fprintf(stderr, "failed to parse environment of credential helper %s: %s\n", name, err)
+		return 1
+	}
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	cmd := exec.Command(strings.TrimSuffix(name, envHelperSuffix), args...)
+	cmd.Env = os.Environ()
+	for _, key := range keys {
+		cmd.Env = append(cmd.Env, key+"="+env[key])
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(stderr, "failed to run credential helper %s: %s\n", cmd.Path, err)
+		return 1
+	}
+	return 0
+}
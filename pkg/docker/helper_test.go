@@ -0,0 +1,86 @@
+package docker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHelperEnv(t *testing.T) {
+	got, err := HelperEnv("http://proxy:3128", "169.254.169.254", []string{"AWS_CA_BUNDLE=/kaniko/ca.pem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"HTTPS_PROXY":   "http://proxy:3128",
+		"HTTP_PROXY":    "http://proxy:3128",
+		"NO_PROXY":      "169.254.169.254",
+		"AWS_CA_BUNDLE": "/kaniko/ca.pem",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("HelperEnv() = %v, want %v", got, want)
+	}
+	if _, err := HelperEnv("", "", []string{"AWS_CA_BUNDLE"}); err == nil {
+		t.Error("expected error for variable without value")
+	}
+}
+
+func TestWrapCredHelpers(t *testing.T) {
+	HelperDir = t.TempDir()
+	defer func() { HelperDir = "/kaniko" }()
+	helper := "#!/bin/sh\necho \"$1 via $HTTPS_PROXY\"\n"
+	if err := ioutil.WriteFile(filepath.Join(HelperDir, "docker-credential-fake"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", HelperDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	c := NewConfig()
+	c.SetCredHelper("registry.example.com", "fake")
+	if err := c.WrapCredHelpers(map[string]string{"HTTPS_PROXY": "http://proxy:3128"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.CredHelpers["registry.example.com"]; got != "fake-env" {
+		t.Fatalf("helper = %s, want fake-env", got)
+	}
+	if _, err := os.Lstat(filepath.Join(HelperDir, "docker-credential-fake-env")); err != nil {
+		t.Fatalf("wrapping helper not created: %s", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runWrappedHelper("docker-credential-fake-env", []string{"get"}, strings.NewReader(""), &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	if got, want := stdout.String(), "get via http://proxy:3128\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestAddCredHelpers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".docker", "config.json")
+	if err := AddAuth(path, "ghcr.io", "octocat", "pat"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddCredHelpers(path, []string{"europe-docker.pkg.dev=gcloud", "example.azurecr.io=docker-credential-acr-env"}); err != nil {
+		t.Fatalf("AddCredHelpers failed: %s", err)
+	}
+	got, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"europe-docker.pkg.dev": "gcloud", "example.azurecr.io": "acr-env"}
+	if !reflect.DeepEqual(got.CredHelpers, want) {
+		t.Errorf("credential helpers = %v, want %v", got.CredHelpers, want)
+	}
+	if _, ok := got.Auths["ghcr.io"]; !ok {
+		t.Errorf("expected existing auth to be kept, got %#v", got.Auths)
+	}
+	for _, pair := range []string{"gcloud", "=gcloud", "ghcr.io=", "ghcr.io=../gcloud"} {
+		if err := AddCredHelpers(path, []string{pair}); err == nil {
+			t.Errorf("AddCredHelpers(%q) = nil, want error", pair)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+// Package dockerfile implements a minimal Dockerfile parser, sufficient to
+// inspect stages and base images before handing the build to kaniko.
+package dockerfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Scratch is the reserved name of the empty base image.
+const Scratch string = "scratch"
+
+// platformArgs are the ARGs defined automatically for FROM instructions.
+var platformArgs = map[string]bool{
+	"BUILDPLATFORM": true, "BUILDOS": true, "BUILDARCH": true, "BUILDVARIANT": true,
+	"TARGETPLATFORM": true, "TARGETOS": true, "TARGETARCH": true, "TARGETVARIANT": true,
+}
+
+type (
+	// Instruction is a single Dockerfile instruction.
+	Instruction struct {
+		Command string   // Upper-cased instruction, e.g. FROM
+		Flags   []string // Leading --flag arguments, e.g. --platform=linux/amd64
+		Args    []string // Remaining whitespace separated arguments
+		Line    int      // Line the instruction starts at
+		Heredoc bool     // Whether the instruction has heredoc arguments, e.g. <<EOF
+	}
+
+	// Stage is a build stage started by a FROM instruction.
+	Stage struct {
+		Name     string // Stage name given with AS, if any
+		Base     string // Base image or stage, before variable expansion
+		Platform string // Value of the --platform flag, if any
+		Line     int    // Line of the FROM instruction
+	}
+
+	// UnsetArg is a variable referenced by a FROM instruction that has no value.
+	UnsetArg struct {
+		Name string // Variable name
+		Line int    // Line of the FROM instruction
+	}
+
+	// Dockerfile is a parsed Dockerfile.
+	Dockerfile struct {
+		Instructions []Instruction
+		Stages       []Stage
+		Args         map[string]string // Global ARG defaults declared before the first FROM
+
+		noDefault map[string]bool // Global ARGs declared without a default
+	}
+)
+
+// ParseFile parses the Dockerfile at path.
+func ParseFile(path string) (*Dockerfile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse parses a Dockerfile. Line continuations and comments are handled;
+// instruction arguments are split on whitespace only.
+func Parse(r io.Reader) (*Dockerfile, error) {
+	d := &Dockerfile{Args: map[string]string{}, noDefault: map[string]bool{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var (
+		current    string
+		start      int
+		line       int
+		delimiters []string // Delimiters of the pending heredoc bodies
+	)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		// Heredoc bodies are not instructions
+		if len(delimiters) > 0 {
+			if text == delimiters[0] {
+				delimiters = delimiters[1:]
+			}
+			continue
+		}
+		if strings.HasPrefix(text, "#") || (text == "" && current == "") {
+			continue
+		}
+		if current == "" {
+			start = line
+		}
+		if strings.HasSuffix(text, "\\") {
+			current += strings.TrimSuffix(text, "\\") + " "
+			continue
+		}
+		if d.add(current+text, start) {
+			delimiters = heredocDelimiters(d.Instructions[len(d.Instructions)-1])
+		}
+		current = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != "" {
+		d.add(current, start)
+	}
+	if len(d.Stages) == 0 {
+		return nil, fmt.Errorf("no FROM instruction found")
+	}
+	return d, nil
+}
+
+// add adds the instruction in text and reports whether there was one.
+func (d *Dockerfile) add(text string, line int) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return false
+	}
+	inst := Instruction{Command: strings.ToUpper(fields[0]), Line: line}
+	rest := fields[1:]
+	for len(rest) > 0 && strings.HasPrefix(rest[0], "--") {
+		inst.Flags = append(inst.Flags, rest[0])
+		rest = rest[1:]
+	}
+	inst.Args = rest
+	inst.Heredoc = len(heredocDelimiters(inst)) > 0
+	d.Instructions = append(d.Instructions, inst)
+
+	switch inst.Command {
+	case "ARG":
+		if len(d.Stages) > 0 {
+			return true
+		}
+		for _, arg := range inst.Args {
+			parts := strings.SplitN(arg, "=", 2)
+			value := ""
+			if len(parts) == 2 {
+				value = strings.Trim(parts[1], `"'`)
+			}
+			d.Args[parts[0]] = value
+			d.noDefault[parts[0]] = len(parts) == 1
+		}
+	case "FROM":
+		stage := Stage{Line: line, Platform: inst.Flag("platform")}
+		if len(inst.Args) > 0 {
+			stage.Base = inst.Args[0]
+		}
+		if len(inst.Args) == 3 && strings.EqualFold(inst.Args[1], "AS") {
+			stage.Name = strings.ToLower(inst.Args[2])
+		}
+		d.Stages = append(d.Stages, stage)
+	}
+	return true
+}
+
+// heredocDelimiters returns the delimiters of the heredocs of RUN, COPY and
+// ADD instructions, e.g. EOF for <<EOF, <<-EOF and <<"EOF".
+func heredocDelimiters(inst Instruction) []string {
+	switch inst.Command {
+	case "RUN", "COPY", "ADD":
+	default:
+		return nil
+	}
+	var delimiters []string
+	for _, arg := range inst.Args {
+		if !strings.HasPrefix(arg, "<<") {
+			continue
+		}
+		delimiter := strings.Trim(strings.TrimPrefix(strings.TrimPrefix(arg, "<<"), "-"), `"'`)
+		if delimiter != "" {
+			delimiters = append(delimiters, delimiter)
+		}
+	}
+	return delimiters
+}
+
+// Flag returns the value of the named flag, or an empty string.
+func (i Instruction) Flag(name string) string {
+	prefix := "--" + name + "="
+	for _, flag := range i.Flags {
+		if strings.HasPrefix(flag, prefix) {
+			return strings.TrimPrefix(flag, prefix)
+		}
+	}
+	return ""
+}
+
+// Vars returns the variables available to FROM instructions: the global
+// ARG defaults, overridden by build args (KEY=VALUE) for declared names.
+func (d *Dockerfile) Vars(buildArgs []string) map[string]string {
+	vars := map[string]string{}
+	for k, v := range d.Args {
+		vars[k] = v
+	}
+	for _, arg := range buildArgs {
+		parts := strings.SplitN(arg, "=", 2)
+		if _, ok := vars[parts[0]]; !ok {
+			continue
+		}
+		if len(parts) == 2 {
+			vars[parts[0]] = parts[1]
+		} else {
+			vars[parts[0]] = os.Getenv(parts[0])
+		}
+	}
+	return vars
+}
+
+// StageNames returns the names of the named stages, in order of appearance.
+func (d *Dockerfile) StageNames() []string {
+	var names []string
+	for _, stage := range d.Stages {
+		if stage.Name != "" {
+			names = append(names, stage.Name)
+		}
+	}
+	return names
+}
+
+// HasStage reports whether a stage is named name, ignoring case.
+func (d *Dockerfile) HasStage(name string) bool {
+	for _, stage := range d.StageNames() {
+		if stage == strings.ToLower(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnsetArgs returns the variables referenced by FROM instructions that
+// have no value: global ARGs that are not declared, or declared without a
+// default and not set by the build args (KEY=VALUE). Explicitly empty
+// defaults (ARG VAR=), references with a default, e.g. ${VAR:-default},
+// and platform ARGs are not reported.
+func (d *Dockerfile) UnsetArgs(buildArgs []string) []UnsetArg {
+	vars := d.Vars(buildArgs)
+	var unset []UnsetArg
+	for _, stage := range d.Stages {
+		seen := map[string]bool{}
+		check := func(name string) string {
+			if strings.Contains(name, ":-") || strings.Contains(name, ":+") {
+				return ""
+			}
+			_, declared := vars[name]
+			empty := !declared || vars[name] == "" && d.noDefault[name]
+			if empty && !platformArgs[name] && !seen[name] {
+				seen[name] = true
+				unset = append(unset, UnsetArg{Name: name, Line: stage.Line})
+			}
+			return ""
+		}
+		os.Expand(stage.Base, check)
+		os.Expand(stage.Platform, check)
+	}
+	return unset
+}
+
+// FinalBaseImage returns the expanded base image of the target stage, or of
+// the last stage without target, following references to earlier stages.
+// It returns an empty string for scratch.
+func (d *Dockerfile) FinalBaseImage(target string, buildArgs []string) string {
+	vars := d.Vars(buildArgs)
+	last := len(d.Stages) - 1
+	if target != "" {
+		for i, stage := range d.Stages {
+			if stage.Name == strings.ToLower(target) {
+				last = i
+				break
+			}
+		}
+	}
+	for i := last; i >= 0; {
+		base := Expand(d.Stages[i].Base, vars)
+		earlier := -1
+		for j := 0; j < i; j++ {
+			if d.Stages[j].Name != "" && d.Stages[j].Name == strings.ToLower(base) {
+				earlier = j
+			}
+		}
+		if earlier < 0 {
+			if base == Scratch {
+				return ""
+			}
+			return base
+		}
+		i = earlier
+	}
+	return ""
+}
+
+// BaseImages returns the expanded base images of all stages, excluding
+// references to earlier stages and scratch, in order of appearance.
+func (d *Dockerfile) BaseImages(buildArgs []string) []string {
+	vars := d.Vars(buildArgs)
+	stages := map[string]bool{}
+	seen := map[string]bool{}
+	var images []string
+	for _, stage := range d.Stages {
+		base := Expand(stage.Base, vars)
+		if !stages[strings.ToLower(base)] && base != Scratch && base != "" && !seen[base] {
+			seen[base] = true
+			images = append(images, base)
+		}
+		if stage.Name != "" {
+			stages[stage.Name] = true
+		}
+	}
+	return images
+}
+
+// Expand substitutes $VAR, ${VAR}, ${VAR:-default} and ${VAR:+alternate}
+// references in s. Unknown variables expand to an empty string.
+func Expand(s string, vars map[string]string) string {
+	return os.Expand(s, func(name string) string {
+		if i := strings.Index(name, ":-"); i >= 0 {
+			if v := vars[name[:i]]; v != "" {
+				return v
+			}
+			return name[i+2:]
+		}
+		if i := strings.Index(name, ":+"); i >= 0 {
+			if vars[name[:i]] != "" {
+				return name[i+2:]
+			}
+			return ""
+		}
+		return vars[name]
+	})
+}
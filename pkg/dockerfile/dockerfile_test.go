@@ -0,0 +1,171 @@
+package dockerfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const multiStage = `# syntax=docker/dockerfile:1
+ARG GO_VERSION=1.17
+ARG REGISTRY
+FROM --platform=$BUILDPLATFORM golang:${GO_VERSION} AS Build
+RUN go build \
+    -o /app .
+
+FROM ${REGISTRY:-docker.io}/library/alpine:3.14
+COPY --from=build /app /app
+FROM build AS test
+FROM scratch
+`
+
+func TestParse(t *testing.T) {
+	d, err := Parse(strings.NewReader(multiStage))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	wantStages := []Stage{
+		{Name: "build", Base: "golang:${GO_VERSION}", Platform: "$BUILDPLATFORM", Line: 4},
+		{Base: "${REGISTRY:-docker.io}/library/alpine:3.14", Line: 8},
+		{Name: "test", Base: "build", Line: 10},
+		{Base: "scratch", Line: 11},
+	}
+	if diff := cmp.Diff(wantStages, d.Stages); diff != "" {
+		t.Errorf("stages mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]string{"GO_VERSION": "1.17", "REGISTRY": ""}, d.Args); diff != "" {
+		t.Errorf("args mismatch (-want +got):\n%s", diff)
+	}
+
+	run := d.Instructions[3]
+	if got, want := run.Args, []string{"go", "build", "-o", "/app", "."}; !cmp.Equal(got, want) {
+		t.Errorf("continued RUN args = %q, want %q", got, want)
+	}
+	if got, want := d.Instructions[5].Flag("from"), "build"; got != want {
+		t.Errorf("COPY --from = %q, want %q", got, want)
+	}
+}
+
+func TestBaseImages(t *testing.T) {
+	d, err := Parse(strings.NewReader(multiStage))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"golang:1.17", "docker.io/library/alpine:3.14"},
+		},
+		{
+			name: "build args",
+			args: []string{"GO_VERSION=1.16", "REGISTRY=mirror.example.com", "UNDECLARED=x"},
+			want: []string{"golang:1.16", "mirror.example.com/library/alpine:3.14"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, d.BaseImages(test.args)); diff != "" {
+				t.Errorf("base images mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFinalBaseImage(t *testing.T) {
+	d, err := Parse(strings.NewReader(multiStage))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tests := map[string]string{
+		"":      "",
+		"test":  "golang:1.17",
+		"Build": "golang:1.17",
+	}
+	for target, want := range tests {
+		if got := d.FinalBaseImage(target, nil); got != want {
+			t.Errorf("FinalBaseImage(%q) = %q, want %q", target, got, want)
+		}
+	}
+
+	d, err = Parse(strings.NewReader("FROM golang:1.17 AS build\nFROM alpine:3.14 AS base\nFROM base\nCOPY --from=build /app /app\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := d.FinalBaseImage("", nil); got != "alpine:3.14" {
+		t.Errorf("FinalBaseImage() = %q, want alpine:3.14", got)
+	}
+}
+
+func TestParse_noFrom(t *testing.T) {
+	if _, err := Parse(strings.NewReader("RUN true\n")); err == nil {
+		t.Error("expected error for Dockerfile without FROM")
+	}
+}
+
+func TestParse_heredoc(t *testing.T) {
+	d, err := Parse(strings.NewReader(`FROM alpine
+RUN <<EOF
+# not a comment
+apk add curl
+EOF
+COPY <<-"CONFIG" /etc/app.conf
+FROM scratch
+CONFIG
+USER app
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var commands []string
+	for _, inst := range d.Instructions {
+		commands = append(commands, inst.Command)
+	}
+	if diff := cmp.Diff([]string{"FROM", "RUN", "COPY", "USER"}, commands); diff != "" {
+		t.Errorf("instructions mismatch (-want +got):\n%s", diff)
+	}
+	if !d.Instructions[1].Heredoc || !d.Instructions[2].Heredoc || d.Instructions[3].Heredoc {
+		t.Errorf("unexpected heredoc instructions %+v", d.Instructions)
+	}
+	if len(d.Stages) != 1 {
+		t.Errorf("stages = %d, want 1", len(d.Stages))
+	}
+}
+
+func TestUnsetArgs(t *testing.T) {
+	d, err := Parse(strings.NewReader(`ARG BASE
+ARG TAG=3.14
+ARG PREFIX=
+FROM --platform=$BUILDPLATFORM ${PREFIX}${BASE}:${TAG} AS build
+FROM ${REGISTRY:-docker.io}/alpine:$VERSION
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []UnsetArg
+	}{
+		{name: "defaults", want: []UnsetArg{{Name: "BASE", Line: 4}, {Name: "VERSION", Line: 5}}},
+		{name: "provided", args: []string{"BASE=alpine", "TAG=", "VERSION=1"}, want: []UnsetArg{{Name: "VERSION", Line: 5}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, d.UnsetArgs(test.args)); diff != "" {
+				t.Errorf("UnsetArgs() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if !d.HasStage("BUILD") || d.HasStage("test") {
+		t.Errorf("HasStage() mismatch for stages %q", d.StageNames())
+	}
+}
@@ -0,0 +1,236 @@
+// Package dockerhub checks the scopes of Docker Hub access tokens against a
+// repository, so that tokens which can't push fail before the build, and
+// reports the pull rate limit of credentials.
+package dockerhub
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// AuthURL is the token endpoint of Docker Hub.
+	AuthURL string = "https://auth.docker.io/token"
+	// RegistryURL is the registry API endpoint of Docker Hub.
+	RegistryURL string = "https://registry-1.docker.io"
+
+	service string = "registry.docker.io"
+
+	organizationTokenPrefix string = "dckr_oat_"
+	personalTokenPrefix     string = "dckr_pat_"
+
+	// rateLimitRepo is the repository Docker documents for checking the rate
+	// limit, with manifest HEAD requests that don't count against it.
+	rateLimitRepo string = "ratelimitpreview/test"
+)
+
+// IsOrganizationToken reports whether the password is an organization
+// access token, which is used with the organization name as username.
+func IsOrganizationToken(password string) bool {
+	return strings.HasPrefix(password, organizationTokenPrefix)
+}
+
+// IsAccessToken reports whether the password is a personal or organization
+// access token, whose scopes may not allow pushing.
+func IsAccessToken(password string) bool {
+	return IsOrganizationToken(password) || strings.HasPrefix(password, personalTokenPrefix)
+}
+
+// Client requests registry tokens from Docker Hub.
+type Client struct {
+	HTTPClient  *http.Client
+	AuthURL     string // Token endpoint, defaults to AuthURL
+	RegistryURL string // Registry API endpoint, defaults to RegistryURL
+	UserAgent   string // User-Agent of API requests
+}
+
+// Actions returns the actions, e.g. pull and push, that the credentials are
+// granted on the repository, given as namespace/name.
+func (c *Client) Actions(ctx context.Context, username, password, repo string) ([]string, error) {
+	token, err := c.token(ctx, username, password, "repository:"+repo+":pull,push")
+	if err != nil {
+		return nil, err
+	}
+	return tokenActions(token, repo)
+}
+
+// RateLimit is the pull rate limit of Docker Hub credentials, or of the IP
+// address for anonymous pulls.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Window    time.Duration
+}
+
+func (r RateLimit) String() string {
+	return fmt.Sprintf("%d of %d pulls remaining per %s", r.Remaining, r.Limit, r.Window)
+}
+
+// RateLimit returns the pull rate limit of the credentials, anonymous if
+// username is empty, or nil if Docker Hub reports no limit, e.g. for paid
+// subscriptions. It doesn't count against the limit.
+func (c *Client) RateLimit(ctx context.Context, username, password string) (*RateLimit, error) {
+	token, err := c.token(ctx, username, password, "repository:"+rateLimitRepo+":pull")
+	if err != nil {
+		return nil, err
+	}
+	endpoint := c.RegistryURL
+	if endpoint == "" {
+		endpoint = RegistryURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint+"/v2/"+rateLimitRepo+"/manifests/latest", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request Docker Hub rate limit")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Docker Hub rate limit request returned %s", resp.Status)
+	}
+	if resp.Header.Get("RateLimit-Limit") == "" {
+		return nil, nil
+	}
+	limit, window, err := parseRateLimit(resp.Header.Get("RateLimit-Limit"))
+	if err != nil {
+		return nil, err
+	}
+	remaining, _, err := parseRateLimit(resp.Header.Get("RateLimit-Remaining"))
+	if err != nil {
+		return nil, err
+	}
+	return &RateLimit{Limit: limit, Remaining: remaining, Window: window}, nil
+}
+
+// parseRateLimit parses rate limit headers such as 100;w=21600, a number of
+// pulls in a window of seconds.
+func parseRateLimit(s string) (int, time.Duration, error) {
+	parts := strings.Split(s, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Docker Hub rate limit %q", s)
+	}
+	var window time.Duration
+	for _, part := range parts[1:] {
+		if seconds := strings.TrimPrefix(strings.TrimSpace(part), "w="); seconds != strings.TrimSpace(part) {
+			w, err := strconv.Atoi(seconds)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid Docker Hub rate limit %q", s)
+			}
+			window = time.Duration(w) * time.Second
+		}
+	}
+	return n, window, nil
+}
+
+// token requests a registry token with the scope, authenticated with the
+// credentials unless username is empty.
+func (c *Client) token(ctx context.Context, username, password, scope string) (string, error) {
+	endpoint := c.AuthURL
+	if endpoint == "" {
+		endpoint = AuthURL
+	}
+	query := url.Values{"service": {service}, "scope": {scope}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to request Docker Hub token")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", fmt.Errorf("Docker Hub rejected the credentials of %s, check the username and the token", username)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Docker Hub token request returned %s", resp.Status)
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", errors.Wrap(err, "failed to decode Docker Hub token")
+	}
+	return body.Token, nil
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// tokenActions returns the actions granted on the repository by the access
+// claim of the JWT registry token.
+func tokenActions(token, repo string) ([]string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid Docker Hub token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid Docker Hub token")
+	}
+	var claims struct {
+		Access []struct {
+			Type    string   `json:"type"`
+			Name    string   `json:"name"`
+			Actions []string `json:"actions"`
+		} `json:"access"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Wrap(err, "invalid Docker Hub token")
+	}
+	var actions []string
+	for _, access := range claims.Access {
+		if access.Type == "repository" && access.Name == repo {
+			actions = append(actions, access.Actions...)
+		}
+	}
+	return actions, nil
+}
+
+// CheckScopes checks that the access token of username can pull from and,
+// if push is set, push to the repository.
+func (c *Client) CheckScopes(ctx context.Context, username, password, repo string, push bool) error {
+	actions, err := c.Actions(ctx, username, password, repo)
+	if err != nil {
+		return err
+	}
+	granted := map[string]bool{}
+	for _, action := range actions {
+		granted[action] = true
+	}
+	kind := "personal access token"
+	if IsOrganizationToken(password) {
+		kind = "organization access token"
+	}
+	switch {
+	case push && granted["pull"] && !granted["push"]:
+		return fmt.Errorf("the %s of %s is read-only for %s, pushing requires a token with write (image push) scope", kind, username, repo)
+	case !granted["pull"]:
+		return fmt.Errorf("the %s of %s grants no access to %s, check the token's scopes and repositories", kind, username, repo)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package dockerhub
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_CheckScopes(t *testing.T) {
+	grants := map[string]string{
+		"dckr_oat_write": `["pull","push"]`,
+		"dckr_oat_read":  `["pull"]`,
+		"dckr_pat_none":  `[]`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, password, _ := r.BasicAuth()
+		actions, ok := grants[password]
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if got, want := r.URL.Query().Get("scope"), "repository:acme/app:pull,push"; got != want {
+			t.Errorf("scope = %q, want %q", got, want)
+		}
+		claims := `{"access":[{"type":"repository","name":"acme/app","actions":` + actions + `}]}`
+		token := "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+		w.Write([]byte(`{"token":"` + token + `"}`))
+	}))
+	defer srv.Close()
+
+	c := &Client{AuthURL: srv.URL}
+	ctx := context.Background()
+	if err := c.CheckScopes(ctx, "acme", "dckr_oat_write", "acme/app", true); err != nil {
+		t.Errorf("CheckScopes() error = %v", err)
+	}
+	if err := c.CheckScopes(ctx, "acme", "dckr_oat_read", "acme/app", false); err != nil {
+		t.Errorf("CheckScopes() without push error = %v", err)
+	}
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{password: "dckr_oat_read", want: "organization access token of acme is read-only"},
+		{password: "dckr_pat_none", want: "personal access token of acme grants no access"},
+		{password: "dckr_pat_invalid", want: "rejected the credentials"},
+	}
+	for _, test := range tests {
+		if err := c.CheckScopes(ctx, "acme", test.password, "acme/app", true); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("CheckScopes(%s) error = %v, want %q", test.password, err, test.want)
+		}
+	}
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if got, want := r.URL.Query().Get("scope"), "repository:ratelimitpreview/test:pull"; got != want {
+				t.Errorf("scope = %q, want %q", got, want)
+			}
+			token := "anonymous"
+			if username, _, ok := r.BasicAuth(); ok {
+				token = username
+			}
+			w.Write([]byte(`{"token":"` + token + `"}`))
+		case "/v2/ratelimitpreview/test/manifests/latest":
+			if r.Method != http.MethodHead {
+				t.Errorf("method = %s, want HEAD", r.Method)
+			}
+			if r.Header.Get("Authorization") == "Bearer anonymous" {
+				w.Header().Set("RateLimit-Limit", "100;w=21600")
+				w.Header().Set("RateLimit-Remaining", "76;w=21600")
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{AuthURL: srv.URL + "/token", RegistryURL: srv.URL}
+	limit, err := c.RateLimit(context.Background(), "", "")
+	if err != nil || limit == nil {
+		t.Fatalf("RateLimit() anonymous = %v, %v", limit, err)
+	}
+	if got, want := limit.String(), "76 of 100 pulls remaining per 6h0m0s"; got != want {
+		t.Errorf("RateLimit() = %s, want %s", got, want)
+	}
+	if limit, err := c.RateLimit(context.Background(), "acme", "dckr_pat_abc"); err != nil || limit != nil {
+		t.Errorf("RateLimit() without limit = %v, %v", limit, err)
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	if n, window, err := parseRateLimit("200;w=21600"); n != 200 || window != 6*time.Hour || err != nil {
+		t.Errorf("parseRateLimit() = %d, %s, %v", n, window, err)
+	}
+	for _, s := range []string{"", "abc;w=60", "100;w=abc"} {
+		if _, _, err := parseRateLimit(s); err == nil {
+			t.Errorf("parseRateLimit(%q) = nil, want error", s)
+		}
+	}
+}
+
+func TestIsAccessToken(t *testing.T) {
+	if !IsOrganizationToken("dckr_oat_abc") || IsOrganizationToken("dckr_pat_abc") {
+		t.Error("IsOrganizationToken() mismatch")
+	}
+	if !IsAccessToken("dckr_pat_abc") || IsAccessToken("hunter2") {
+		t.Error("IsAccessToken() mismatch")
+	}
+}
@@ -0,0 +1,154 @@
+// Package errreport writes machine-readable reports of plugin failures, so
+// that pipeline orchestration can branch on the type of failure.
+package errreport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/smithy-go"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// Failure categories
+const (
+	CategoryAuth      string = "auth"       // Missing or invalid credentials or permissions
+	CategoryRateLimit string = "rate-limit" // Requests throttled by the registry or AWS
+	CategoryNotFound  string = "not-found"  // Missing repository, image or manifest
+	CategoryRegistry  string = "registry"   // Registry or AWS server errors
+	CategoryNetwork   string = "network"    // Connection and DNS failures
+	CategoryTimeout   string = "timeout"    // Deadline exceeded
+	CategoryPull      string = "pull"       // Base image pull failures
+	CategoryPush      string = "push"       // Image push failures
+	CategoryConfig    string = "config"     // Invalid plugin settings
+	CategoryBuild     string = "build"      // Dockerfile build failures
+	CategoryInternal  string = "internal"   // Anything else
+)
+
+// Report describes a plugin failure.
+type Report struct {
+	Phase     string `json:"phase"`
+	Category  string `json:"category"`
+	Code      string `json:"code,omitempty"` // HTTP status or AWS error code of the underlying error
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+}
+
+var (
+	statusPattern  = regexp.MustCompile(`\bstatus:? (\d{3})\b|\b(\d{3}) (?:Unauthorized|Forbidden|Not Found|Too Many Requests|Internal Server Error|Bad Gateway|Service Unavailable|Gateway Timeout)\b`)
+	awsCodePattern = regexp.MustCompile(`\b([A-Z][A-Za-z]+Exception)\b`)
+)
+
+var (
+	authMarkers      = []string{"UNAUTHORIZED", "DENIED", "authentication required", "no basic auth credentials", "NoCredentialProviders"}
+	rateLimitMarkers = []string{"TOOMANYREQUESTS", "rate limit"}
+	notFoundMarkers  = []string{"MANIFEST_UNKNOWN", "NAME_UNKNOWN", "BLOB_UNKNOWN"}
+	networkMarkers   = []string{"connection reset by peer", "connection refused", "no such host", "i/o timeout", "TLS handshake timeout", "unexpected EOF"}
+	timeoutMarkers   = []string{"context deadline exceeded", "timed out"}
+)
+
+// Classify classifies err, raised in phase, by the HTTP status or AWS error
+// code it carries, falling back to well-known messages in the error and in
+// output, e.g. the output of a failed command. Unknown failures are
+// internal.
+func Classify(phase string, err error, output string) Report {
+	report := Report{Phase: phase, Message: err.Error()}
+	text := err.Error() + "\n" + output
+
+	var registryErr *registry.Error
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &registryErr):
+		report.Code = strconv.Itoa(registryErr.StatusCode)
+	case errors.As(err, &apiErr):
+		report.Code = apiErr.ErrorCode()
+	default:
+		if m := statusPattern.FindStringSubmatch(text); m != nil && m[1]+m[2] >= "400" {
+			report.Code = m[1] + m[2]
+		} else if m := awsCodePattern.FindStringSubmatch(text); m != nil {
+			report.Code = m[1]
+		}
+	}
+	report.Category, report.Retryable = classifyCode(report.Code)
+
+	var netErr net.Error
+	if report.Category == "" {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded) || containsAny(text, timeoutMarkers):
+			report.Category, report.Retryable = CategoryTimeout, true
+		case containsAny(text, authMarkers):
+			report.Category = CategoryAuth
+		case containsAny(text, rateLimitMarkers):
+			report.Category, report.Retryable = CategoryRateLimit, true
+		case containsAny(text, notFoundMarkers):
+			report.Category = CategoryNotFound
+		case errors.As(err, &netErr) || containsAny(text, networkMarkers):
+			report.Category, report.Retryable = CategoryNetwork, true
+		default:
+			report.Category = CategoryInternal
+		}
+	}
+	return report
+}
+
+// classifyCode returns the category of an HTTP status or AWS error code, or
+// an empty category for unknown codes.
+func classifyCode(code string) (string, bool) {
+	if status, err := strconv.Atoi(code); err == nil {
+		switch {
+		case status == 401 || status == 403:
+			return CategoryAuth, false
+		case status == 404:
+			return CategoryNotFound, false
+		case status == 429:
+			return CategoryRateLimit, true
+		case status >= 500:
+			return CategoryRegistry, true
+		}
+		return "", false
+	}
+	switch {
+	case code == "":
+		return "", false
+	case strings.Contains(code, "AccessDenied"), strings.Contains(code, "UnrecognizedClient"),
+		strings.Contains(code, "ExpiredToken"), strings.Contains(code, "InvalidSignature"), strings.Contains(code, "Unauthorized"):
+		return CategoryAuth, false
+	case strings.Contains(code, "Throttl"), strings.Contains(code, "TooManyRequests"), strings.Contains(code, "LimitExceeded"):
+		return CategoryRateLimit, true
+	case strings.Contains(code, "NotFound"):
+		return CategoryNotFound, false
+	case strings.Contains(code, "ServiceUnavailable"), strings.Contains(code, "InternalServer"), strings.Contains(code, "ServerException"):
+		return CategoryRegistry, true
+	}
+	return "", false
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the report as JSON to path.
+func Write(path string, report Report) error {
+	b, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for error report %s: %s", path, err)
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
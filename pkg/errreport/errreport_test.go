@@ -0,0 +1,78 @@
+package errreport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		output string
+		want   Report
+	}{
+		{
+			name: "registry error",
+			err:  fmt.Errorf("push failed: %w", &registry.Error{StatusCode: 503, Message: "unavailable"}),
+			want: Report{Phase: "build", Category: CategoryRegistry, Code: "503", Message: "push failed: unavailable", Retryable: true},
+		},
+		{
+			name: "aws error",
+			err:  fmt.Errorf("create failed: %w", &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "denied"}),
+			want: Report{Phase: "build", Category: CategoryAuth, Code: "AccessDeniedException", Message: "create failed: api error AccessDeniedException: denied"},
+		},
+		{
+			name: "status in message",
+			err:  errors.New("registry auth preflight failed for gcr.io/project/app: check access failed with status 403"),
+			want: Report{Phase: "build", Category: CategoryAuth, Code: "403", Message: "registry auth preflight failed for gcr.io/project/app: check access failed with status 403"},
+		},
+		{
+			name:   "output",
+			err:    errors.New("exit status 1"),
+			output: "error building image: retrieving image: GET https://index.docker.io/v2/: TOOMANYREQUESTS: rate limit",
+			want:   Report{Phase: "build", Category: CategoryRateLimit, Message: "exit status 1", Retryable: true},
+		},
+		{
+			name: "deadline",
+			err:  fmt.Errorf("scan failed: %w", context.DeadlineExceeded),
+			want: Report{Phase: "build", Category: CategoryTimeout, Message: "scan failed: context deadline exceeded", Retryable: true},
+		},
+		{
+			name: "unknown",
+			err:  errors.New("dockerfile does not exist"),
+			want: Report{Phase: "build", Category: CategoryInternal, Message: "dockerfile does not exist"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Classify("build", test.err, test.output)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Classify() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports", "error.json")
+	if err := Write(path, Report{Phase: "build", Category: CategoryBuild, Message: "exit status 1"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n\t\"phase\": \"build\",\n\t\"category\": \"build\",\n\t\"message\": \"exit status 1\",\n\t\"retryable\": false\n}"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("Write() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,208 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// artifactRegistryHostSuffix is the suffix of the Docker hosts of
+	// Artifact Registry locations, e.g. us-docker.pkg.dev.
+	artifactRegistryHostSuffix string = "-docker.pkg.dev"
+
+	// operationTimeout bounds the wait for repository creation.
+	operationTimeout time.Duration = 2 * time.Minute
+)
+
+// operationPollInterval is the delay between polls of long-running operations.
+var operationPollInterval = 2 * time.Second
+
+// ArtifactRepository identifies an Artifact Registry repository.
+type ArtifactRepository struct {
+	Project    string
+	Location   string // e.g. us or europe-west1
+	Repository string
+}
+
+// ParseArtifactRepository returns the Artifact Registry repository of the
+// image repo, given as <project>/<repository>/<image>, or the images below
+// <project>/<repository>, in the registry <location>-docker.pkg.dev.
+func ParseArtifactRepository(registry, repo string) (ArtifactRepository, error) {
+	location := strings.TrimSuffix(registry, artifactRegistryHostSuffix)
+	parts := strings.SplitN(repo, "/", 3)
+	if location == registry || location == "" || len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return ArtifactRepository{}, fmt.Errorf("invalid artifact registry image %s/%s, expected <location>-docker.pkg.dev/<project>/<repository>/<image>", registry, repo)
+	}
+	return ArtifactRepository{Project: parts[0], Location: location, Repository: parts[1]}, nil
+}
+
+// Name returns the resource name of the repository.
+func (r ArtifactRepository) Name() string {
+	return fmt.Sprintf("projects/%s/locations/%s/repositories/%s", r.Project, r.Location, r.Repository)
+}
+
+// RepositorySettings are the settings applied to Artifact Registry
+// repositories.
+type RepositorySettings struct {
+	Labels          map[string]string
+	CleanupPolicies json.RawMessage // Cleanup policies keyed by policy id, as in the API
+}
+
+// Empty reports whether no settings are given.
+func (s RepositorySettings) Empty() bool {
+	return len(s.Labels) == 0 && len(s.CleanupPolicies) == 0
+}
+
+// artifactRepository is the part of an Artifact Registry repository
+// resource used by the plugin.
+type artifactRepository struct {
+	Format          string            `json:"format,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	CleanupPolicies json.RawMessage   `json:"cleanupPolicies,omitempty"`
+}
+
+// operation is a long-running operation.
+type operation struct {
+	Name  string `json:"name"`
+	Done  bool   `json:"done"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// EnsureRepository creates the Docker repository with the settings unless
+// it exists, in which case the settings are applied as by UpdateRepository.
+// It reports whether the repository was created.
+func (c *Client) EnsureRepository(ctx context.Context, token string, repo ArtifactRepository, settings RepositorySettings) (bool, error) {
+	err := c.UpdateRepository(ctx, token, repo, settings)
+	if HasStatus(err, http.StatusNotFound) {
+		return true, c.createRepository(ctx, token, repo, settings)
+	}
+	return false, err
+}
+
+// UpdateRepository adds the labels to the labels of the repository and
+// replaces its cleanup policies, if given. A *StatusError with status 404 is
+// returned if the repository does not exist.
+func (c *Client) UpdateRepository(ctx context.Context, token string, repo ArtifactRepository, settings RepositorySettings) error {
+	endpoint := fmt.Sprintf("%s/v1/%s", or(c.ArtifactRegistryURL, DefaultArtifactRegistryURL), repo.Name())
+	var existing artifactRepository
+	if err := c.artifactRegistry(ctx, http.MethodGet, endpoint, token, nil, &existing); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to get repository %s", repo.Name()))
+	}
+	if settings.Empty() {
+		return nil
+	}
+
+	var mask []string
+	update := artifactRepository{}
+	if len(settings.Labels) != 0 {
+		update.Labels = map[string]string{}
+		for k, v := range existing.Labels {
+			update.Labels[k] = v
+		}
+		for k, v := range settings.Labels {
+			update.Labels[k] = v
+		}
+		mask = append(mask, "labels")
+	}
+	if len(settings.CleanupPolicies) != 0 {
+		update.CleanupPolicies = settings.CleanupPolicies
+		mask = append(mask, "cleanup_policies")
+	}
+	endpoint += "?updateMask=" + url.QueryEscape(strings.Join(mask, ","))
+	if err := c.artifactRegistry(ctx, http.MethodPatch, endpoint, token, update, nil); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to update repository %s", repo.Name()))
+	}
+	return nil
+}
+
+// createRepository creates the repository and waits for the operation.
+func (c *Client) createRepository(ctx context.Context, token string, repo ArtifactRepository, settings RepositorySettings) error {
+	base := or(c.ArtifactRegistryURL, DefaultArtifactRegistryURL)
+	endpoint := fmt.Sprintf("%s/v1/projects/%s/locations/%s/repositories?repositoryId=%s",
+		base, repo.Project, repo.Location, url.QueryEscape(repo.Repository))
+	create := artifactRepository{Format: "DOCKER", Labels: settings.Labels, CleanupPolicies: settings.CleanupPolicies}
+	var op operation
+	if err := c.artifactRegistry(ctx, http.MethodPost, endpoint, token, create, &op); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to create repository %s", repo.Name()))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+	for !op.Done {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for the creation of repository %s", repo.Name())
+		case <-time.After(operationPollInterval):
+		}
+		if err := c.artifactRegistry(ctx, http.MethodGet, base+"/v1/"+op.Name, token, nil, &op); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to wait for the creation of repository %s", repo.Name()))
+		}
+	}
+	if op.Error != nil {
+		return fmt.Errorf("failed to create repository %s: %s", repo.Name(), op.Error.Message)
+	}
+	return nil
+}
+
+func (c *Client) artifactRegistry(ctx context.Context, method, endpoint, token string, body, v interface{}) error {
+	var content []byte
+	if body != nil {
+		var err error
+		if content, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := c.newRequest(ctx, method, endpoint, token, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(req, v)
+}
+
+// ParseLabels parses repository labels given as key=value.
+func ParseLabels(labels []string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, label := range labels {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid label %s, expected key=value", label)
+		}
+		out[parts[0]] = parts[1]
+	}
+	return out, nil
+}
+
+// ParseCleanupPolicies validates cleanup policies given as a JSON object
+// keyed by policy id, as the cleanupPolicies of the API.
+func ParseCleanupPolicies(content []byte) (json.RawMessage, error) {
+	var policies map[string]struct {
+		ID     string `json:"id"`
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(content, &policies); err != nil {
+		return nil, errors.Wrap(err, "invalid cleanup policies, expected a JSON object keyed by policy id")
+	}
+	for id, policy := range policies {
+		if policy.ID != "" && policy.ID != id {
+			return nil, fmt.Errorf("invalid cleanup policy %s, its id is %s", id, policy.ID)
+		}
+		if policy.Action != "DELETE" && policy.Action != "KEEP" {
+			return nil, fmt.Errorf("invalid cleanup policy %s, action must be DELETE or KEEP", id)
+		}
+	}
+	return json.RawMessage(content), nil
+}
@@ -0,0 +1,97 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseArtifactRepository(t *testing.T) {
+	got, err := ParseArtifactRepository("europe-west1-docker.pkg.dev", "project/images/team/app")
+	want := ArtifactRepository{Project: "project", Location: "europe-west1", Repository: "images"}
+	if err != nil || got != want {
+		t.Errorf("ParseArtifactRepository() = %+v, %v", got, err)
+	}
+	if name := got.Name(); name != "projects/project/locations/europe-west1/repositories/images" {
+		t.Errorf("Name() = %s", name)
+	}
+	for _, test := range [][2]string{{"gcr.io", "project/images/app"}, {"us-docker.pkg.dev", "project"}, {"us-docker.pkg.dev", "project//app"}} {
+		if _, err := ParseArtifactRepository(test[0], test[1]); err == nil {
+			t.Errorf("ParseArtifactRepository(%q, %q) = nil, want error", test[0], test[1])
+		}
+	}
+}
+
+func TestClient_EnsureRepository(t *testing.T) {
+	defer func(interval time.Duration) { operationPollInterval = interval }(operationPollInterval)
+	operationPollInterval = time.Millisecond
+
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v1/projects/p/locations/us/repositories/images":
+			w.Write([]byte(`{"name":"projects/p/locations/us/repositories/images","format":"DOCKER","labels":{"team":"a"}}`))
+		case "PATCH /v1/projects/p/locations/us/repositories/images", "GET /v1/projects/p/locations/us/operations/op":
+			w.Write([]byte(`{"name":"projects/p/locations/us/operations/op","done":true}`))
+		case "POST /v1/projects/p/locations/us/repositories":
+			w.Write([]byte(`{"name":"projects/p/locations/us/operations/op"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{ArtifactRegistryURL: srv.URL}
+	ctx := context.Background()
+	policies := json.RawMessage(`{"old":{"id":"old","action":"DELETE","condition":{"olderThan":"2592000s"}}}`)
+	settings := RepositorySettings{Labels: map[string]string{"env": "prod"}, CleanupPolicies: policies}
+
+	created, err := c.EnsureRepository(ctx, "token", ArtifactRepository{Project: "p", Location: "us", Repository: "images"}, settings)
+	if err != nil || created {
+		t.Errorf("EnsureRepository() of existing repository = %v, %v", created, err)
+	}
+	created, err = c.EnsureRepository(ctx, "token", ArtifactRepository{Project: "p", Location: "us", Repository: "new"}, settings)
+	if err != nil || !created {
+		t.Errorf("EnsureRepository() of missing repository = %v, %v", created, err)
+	}
+	want := []string{
+		"GET /v1/projects/p/locations/us/repositories/images ",
+		`PATCH /v1/projects/p/locations/us/repositories/images?updateMask=labels%2Ccleanup_policies {"labels":{"env":"prod","team":"a"},"cleanupPolicies":` + string(policies) + `}`,
+		"GET /v1/projects/p/locations/us/repositories/new ",
+		`POST /v1/projects/p/locations/us/repositories?repositoryId=new {"format":"DOCKER","labels":{"env":"prod"},"cleanupPolicies":` + string(policies) + `}`,
+		"GET /v1/projects/p/locations/us/operations/op ",
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("unexpected requests (-want +got):\n%s", diff)
+	}
+
+	if err := c.UpdateRepository(ctx, "token", ArtifactRepository{Project: "p", Location: "us", Repository: "missing"}, settings); !HasStatus(err, http.StatusNotFound) {
+		t.Errorf("expected not found error updating a missing repository, got %v", err)
+	}
+	if _, err := c.EnsureRepository(ctx, "invalid", ArtifactRepository{Project: "p", Location: "us", Repository: "images"}, settings); err == nil {
+		t.Error("expected error with invalid token")
+	}
+}
+
+func TestParseCleanupPolicies(t *testing.T) {
+	if _, err := ParseCleanupPolicies([]byte(`{"keep":{"id":"keep","action":"KEEP","mostRecentVersions":{"keepCount":5}}}`)); err != nil {
+		t.Error(err)
+	}
+	for _, content := range []string{`[{"name":"keep"}]`, `{"keep":{"id":"other","action":"KEEP"}}`, `{"keep":{"action":"Delete"}}`} {
+		if _, err := ParseCleanupPolicies([]byte(content)); err == nil {
+			t.Errorf("ParseCleanupPolicies(%s) = nil, want error", content)
+		}
+	}
+}
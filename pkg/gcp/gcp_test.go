@@ -0,0 +1,258 @@
+package gcp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	uploads := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/token":
+			form, _ := url.ParseQuery(string(body))
+			parts := strings.Split(form.Get("assertion")+"..", ".")
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			if form.Get("grant_type") != jwtBearerGrant || rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], signature) != nil {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token":"key-token"}`))
+		case "/metadata/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				http.Error(w, "missing header", http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"access_token":"metadata-token"}`))
+		case "/upload/storage/v1/b/bucket/o", "/v1/projects/p/secrets/s:addVersion":
+			if r.Header.Get("Authorization") != "Bearer key-token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			uploads[r.URL.Path+"?"+r.URL.Query().Get("name")] = string(body)
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{TokenURL: srv.URL + "/token", MetadataURL: srv.URL + "/metadata", StorageURL: srv.URL, SecretManagerURL: srv.URL}
+	ctx := context.Background()
+
+	jsonKey, _ := json.Marshal(serviceAccountKey{
+		Type:        "service_account",
+		ClientEmail: "builder@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	token, err := c.Token(ctx, string(jsonKey))
+	if err != nil || token != "key-token" {
+		t.Fatalf("Token() = %q, %v", token, err)
+	}
+	if token, err := c.Token(ctx, ""); err != nil || token != "metadata-token" {
+		t.Errorf("Token() without key = %q, %v", token, err)
+	}
+
+	if err := c.UploadObject(ctx, token, "bucket", "app/image", "text/plain", []byte("gcr.io/p/app@sha256:abc")); err != nil {
+		t.Fatalf("UploadObject failed: %s", err)
+	}
+	if got := uploads["/upload/storage/v1/b/bucket/o?app/image"]; got != "gcr.io/p/app@sha256:abc" {
+		t.Errorf("uploaded object = %q", got)
+	}
+	if err := c.AddSecretVersion(ctx, token, "projects/p/secrets/s", []byte("ref")); err != nil {
+		t.Fatalf("AddSecretVersion failed: %s", err)
+	}
+	if got, want := uploads["/v1/projects/p/secrets/s:addVersion?"], `{"payload":{"data":"cmVm"}}`; got != want {
+		t.Errorf("secret version = %s, want %s", got, want)
+	}
+	if err := c.UploadObject(ctx, "forged", "bucket", "app/image", "text/plain", nil); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+}
+
+func TestClient_impersonate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata/instance/service-accounts/default/token":
+			w.Write([]byte(`{"access_token":"metadata-token"}`))
+		case "/v1/projects/-/serviceAccounts/pusher@p.iam.gserviceaccount.com:generateAccessToken":
+			var req struct {
+				Scope []string `json:"scope"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if r.Header.Get("Authorization") != "Bearer metadata-token" || len(req.Scope) != 1 {
+				http.Error(w, `{"error":{"status":"PERMISSION_DENIED"}}`, http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"accessToken":"pusher-token","expireTime":"2014-10-02T15:01:23Z"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{MetadataURL: srv.URL + "/metadata", IAMCredentialsURL: srv.URL, Impersonate: "pusher@p.iam.gserviceaccount.com"}
+	if token, err := c.Token(context.Background(), ""); err != nil || token != "pusher-token" {
+		t.Errorf("Token() impersonating = %q, %v", token, err)
+	}
+	c.AccessToken = "metadata-token"
+	c.MetadataURL = ""
+	if token, err := c.Token(context.Background(), ""); err != nil || token != "pusher-token" {
+		t.Errorf("Token() impersonating with access token = %q, %v", token, err)
+	}
+	c.Impersonate = "other@p.iam.gserviceaccount.com"
+	if _, err := c.Token(context.Background(), ""); !HasStatus(err, http.StatusNotFound) {
+		t.Errorf("Token() impersonating unknown service account = %v, want 404", err)
+	}
+}
+
+func TestClient_objectGeneration(t *testing.T) {
+	content, generation := "", int64(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/bucket/o/builds/ledger.jsonl":
+			if generation == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("X-Goog-Generation", strconv.FormatInt(generation, 10))
+			w.Write([]byte(content))
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/bucket/o":
+			if r.URL.Query().Get("ifGenerationMatch") != strconv.FormatInt(generation, 10) {
+				http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+				return
+			}
+			content, generation = string(body), generation+1
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{StorageURL: srv.URL}
+	ctx := context.Background()
+	if _, _, err := c.DownloadObject(ctx, "token", "bucket", "builds/ledger.jsonl"); !HasStatus(err, http.StatusNotFound) {
+		t.Fatalf("DownloadObject() of missing object error = %v", err)
+	}
+	if err := c.UploadObjectIfGeneration(ctx, "token", "bucket", "builds/ledger.jsonl", "application/x-ndjson", []byte("first\n"), 0); err != nil {
+		t.Fatalf("UploadObjectIfGeneration() error = %v", err)
+	}
+	got, gen, err := c.DownloadObject(ctx, "token", "bucket", "builds/ledger.jsonl")
+	if err != nil || string(got) != "first\n" || gen != 1 {
+		t.Fatalf("DownloadObject() = %q, %d, %v", got, gen, err)
+	}
+	if err := c.UploadObjectIfGeneration(ctx, "token", "bucket", "builds/ledger.jsonl", "application/x-ndjson", nil, 0); !HasStatus(err, http.StatusPreconditionFailed) {
+		t.Errorf("UploadObjectIfGeneration() of stale generation error = %v", err)
+	}
+}
+
+func TestParseObjectURL(t *testing.T) {
+	tests := []struct {
+		url, bucket, object string
+		ok                  bool
+	}{
+		{url: "gs://bucket/app/image", bucket: "bucket", object: "app/image", ok: true},
+		{url: "gs://bucket"},
+		{url: "gs:///image"},
+		{url: "bucket/image"},
+	}
+	for _, test := range tests {
+		bucket, object, err := ParseObjectURL(test.url)
+		if bucket != test.bucket || object != test.object || (err == nil) != test.ok {
+			t.Errorf("ParseObjectURL(%q) = %q, %q, %v", test.url, bucket, object, err)
+		}
+	}
+}
+
+func TestKeyProject(t *testing.T) {
+	block := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}))
+	tests := []struct {
+		key     serviceAccountKey
+		project string
+	}{
+		{key: serviceAccountKey{Type: "service_account", ProjectID: "p", ClientEmail: "sa@p.iam.gserviceaccount.com", PrivateKey: block}, project: "p"},
+		{key: serviceAccountKey{Type: "authorized_user", ProjectID: "p", ClientEmail: "sa@p.iam.gserviceaccount.com", PrivateKey: block}},
+		{key: serviceAccountKey{Type: "service_account", ClientEmail: "sa@p.iam.gserviceaccount.com", PrivateKey: block}},
+		{key: serviceAccountKey{Type: "service_account", ProjectID: "p", ClientEmail: "sa@p.iam.gserviceaccount.com"}},
+	}
+	for _, test := range tests {
+		b, _ := json.Marshal(test.key)
+		project, _, err := KeyProject(string(b))
+		if project != test.project || (err == nil) != (test.project != "") {
+			t.Errorf("KeyProject(%s) = %q, %v", b, project, err)
+		}
+	}
+	if _, _, err := KeyProject("{"); err == nil {
+		t.Error("KeyProject() of invalid JSON = nil, want error")
+	}
+}
+
+func TestKeySecrets(t *testing.T) {
+	block := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("private key bytes")}))
+	b, _ := json.Marshal(serviceAccountKey{Type: "service_account", ClientEmail: "sa@p.iam.gserviceaccount.com", PrivateKeyID: "0123abcd", PrivateKey: block})
+	want := []string{"0123abcd", base64.StdEncoding.EncodeToString([]byte("private key bytes"))}
+	if got := KeySecrets(string(b)); !reflect.DeepEqual(got, want) {
+		t.Errorf("KeySecrets() = %q, want %q", got, want)
+	}
+	if got := KeySecrets("{"); got != nil {
+		t.Errorf("KeySecrets() of invalid JSON = %q, want none", got)
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	key := `{"type": "service_account", "client_email": "sa@p.iam.gserviceaccount.com"}`
+	encoded := base64.StdEncoding.EncodeToString([]byte(key))
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{key: key, ok: true},
+		{key: "\n" + key + "\n", ok: true},
+		{key: encoded, ok: true},
+		{key: encoded[:20] + "\n" + encoded[20:] + "\n", ok: true},
+		{key: "not a key"},
+		{key: base64.StdEncoding.EncodeToString([]byte("not json"))},
+	}
+	for _, test := range tests {
+		got, err := DecodeKey(test.key)
+		if (err == nil) != test.ok || (test.ok && got != key) {
+			t.Errorf("DecodeKey(%q) = %q, %v", test.key, got, err)
+		}
+	}
+}
+
+func TestValidateSecret(t *testing.T) {
+	if err := ValidateSecret("projects/p/secrets/s"); err != nil {
+		t.Error(err)
+	}
+	for _, secret := range []string{"s", "projects/p/secrets", "projects/p/secrets/s/versions/1", "projects//secrets/s"} {
+		if err := ValidateSecret(secret); err == nil {
+			t.Errorf("ValidateSecret(%q) = nil, want error", secret)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package glob
+
+import (
+	"path"
+	"strings"
+)
+
+// Match reports whether name matches the slash separated shell pattern.
+//
+// In addition to the syntax supported by path.Match, a "**" path segment
+// matches zero or more directories, so "services/**/Dockerfile" matches both
+// "services/Dockerfile" and "services/api/v1/Dockerfile".
+func Match(pattern, name string) bool {
+	return matchSegments(split(pattern), split(name))
+}
+
+// MatchAny reports whether name matches at least one of the patterns.
+func MatchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func split(s string) []string {
+	s = strings.Trim(path.Clean("/"+s), "/")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "/")
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive "**" segments, then try every possible split.
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range name {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
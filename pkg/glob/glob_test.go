@@ -0,0 +1,41 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"Dockerfile", "Dockerfile", true},
+		{"*.md", "README.md", true},
+		{"*.md", "docs/README.md", false},
+		{"docs/*", "docs/index.md", true},
+		{"docs/*", "docs/api/index.md", false},
+		{"docs/**", "docs/api/index.md", true},
+		{"**/*.md", "README.md", true},
+		{"**/*.md", "docs/api/index.md", true},
+		{"services/**/Dockerfile", "services/Dockerfile", true},
+		{"services/**/Dockerfile", "services/api/v1/Dockerfile", true},
+		{"services/**/Dockerfile", "services/api/main.go", false},
+		{"**", "anything/at/all", true},
+		{"./src/*.go", "src/main.go", true},
+		{"[", "[", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	patterns := []string{"docs/**", "*.md"}
+	if !MatchAny(patterns, "docs/index.html") {
+		t.Errorf("expected docs/index.html to match %q", patterns)
+	}
+	if MatchAny(patterns, "src/main.go") {
+		t.Errorf("expected src/main.go not to match %q", patterns)
+	}
+}
@@ -0,0 +1,183 @@
+// Package helm packages Helm charts and pushes them to OCI registries.
+package helm
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/glob"
+	"github.com/gexops/drone-kaniko/pkg/yamlpath"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	chartFile  string = "Chart.yaml"
+	valuesFile string = "values.yaml"
+	ignoreFile string = ".helmignore"
+)
+
+// Chart is a chart directory together with the metadata of its Chart.yaml.
+type Chart struct {
+	Dir      string
+	Metadata map[string]interface{}
+
+	// Values overrides the content of values.yaml when packaging.
+	Values []byte
+}
+
+// Load reads the chart in dir.
+func Load(dir string) (*Chart, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, chartFile))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read %s", chartFile))
+	}
+	c := &Chart{Dir: dir}
+	if err := yaml.Unmarshal(b, &c.Metadata); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to parse %s", chartFile))
+	}
+	if c.Name() == "" || c.Version() == "" {
+		return nil, fmt.Errorf("%s must set name and version", chartFile)
+	}
+	return c, nil
+}
+
+// Name returns the chart name.
+func (c *Chart) Name() string {
+	s, _ := c.Metadata["name"].(string)
+	return s
+}
+
+// Version returns the chart version.
+func (c *Chart) Version() string {
+	s, _ := c.Metadata["version"].(string)
+	return s
+}
+
+// SetVersion overrides the chart version.
+func (c *Chart) SetVersion(version string) {
+	c.Metadata["version"] = version
+}
+
+// SetValues sets the paths, e.g. image.tag, of the chart values to the given
+// values. Comments and the order of keys in values.yaml are kept.
+func (c *Chart) SetValues(values map[string]string) error {
+	b := c.Values
+	if b == nil {
+		var err error
+		if b, err = ioutil.ReadFile(filepath.Join(c.Dir, valuesFile)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	b, err := yamlpath.Update(b, values)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to update %s", valuesFile))
+	}
+	c.Values = b
+	return nil
+}
+
+// Package returns the chart archive, a gzipped tarball with the chart
+// files below a directory named after the chart, as created by helm
+// package. Files matching .helmignore patterns are skipped.
+func (c *Chart) Package() ([]byte, error) {
+	ignore, err := readIgnore(filepath.Join(c.Dir, ignoreFile))
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := yaml.Marshal(c.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	err = filepath.Walk(c.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(c.Dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.Name() == ".git" || glob.MatchAny(ignore, rel) || glob.MatchAny(ignore, info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		var content []byte
+		switch {
+		case rel == chartFile:
+			content = metadata
+		case rel == valuesFile && c.Values != nil:
+			content = c.Values
+		default:
+			if content, err = ioutil.ReadFile(path); err != nil {
+				return err
+			}
+		}
+		return writeFile(tw, c.Name()+"/"+rel, content)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.Values != nil {
+		if _, err := os.Stat(filepath.Join(c.Dir, valuesFile)); os.IsNotExist(err) {
+			if err := writeFile(tw, c.Name()+"/"+valuesFile, c.Values); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeFile(tw *tar.Writer, name string, content []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+// readIgnore reads the patterns of a .helmignore file.
+func readIgnore(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, strings.Trim(line, "/"))
+	}
+	return patterns, scanner.Err()
+}
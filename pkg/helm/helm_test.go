@@ -0,0 +1,142 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/gexops/drone-kaniko/pkg/registry/registrytest"
+	"github.com/google/go-cmp/cmp"
+)
+
+const values = `# Image settings
+image:
+  repository: registry.example.com/team/app
+  # overwritten by CI
+  tag: latest
+replicas: 2
+`
+
+func writeChart(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml":            "apiVersion: v2\nname: app\nversion: 1.2.0+build.1\n",
+		"values.yaml":           values,
+		"templates/deploy.yaml": "kind: Deployment\n",
+		"README.tmp":            "ignored\n",
+		".helmignore":           "# temporary files\n*.tmp\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestChart_SetValues(t *testing.T) {
+	c, err := Load(writeChart(t))
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+	if c.Name() != "app" || c.Version() != "1.2.0+build.1" {
+		t.Errorf("unexpected chart %s %s", c.Name(), c.Version())
+	}
+
+	if err := c.SetValues(map[string]string{"image.tag": "v1.0.0", "image.digest": "sha256:abcd"}); err != nil {
+		t.Fatalf("SetValues failed: %s", err)
+	}
+	want := `# Image settings
+image:
+  repository: registry.example.com/team/app
+  # overwritten by CI
+  tag: v1.0.0
+  digest: sha256:abcd
+replicas: 2
+`
+	if diff := cmp.Diff(want, string(c.Values)); diff != "" {
+		t.Errorf("values mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := c.SetValues(map[string]string{"replicas.count": "3"}); err == nil {
+		t.Error("expected error setting a key below a scalar")
+	}
+}
+
+func TestChart_Push(t *testing.T) {
+	reg := registrytest.New(t)
+	client := registry.NewClient(registry.KeychainFunc(func(string) (registry.Credential, error) {
+		return registry.Credential{Username: registrytest.Username, Password: registrytest.Password}, nil
+	}), true)
+	repo := registry.Repository{Registry: reg.Host(), Name: "charts/app"}
+
+	c, err := Load(writeChart(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetValues(map[string]string{"image.tag": "v1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := c.Push(context.Background(), client, repo)
+	if err != nil {
+		t.Fatalf("Push failed: %s", err)
+	}
+
+	_, content, ok := reg.Manifest("charts/app", "1.2.0_build.1")
+	if !ok || registry.Digest(content) != digest {
+		t.Fatalf("expected chart to be tagged with its version")
+	}
+	var manifest registry.ImageManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Config.MediaType != MediaTypeConfig || len(manifest.Layers) != 1 || manifest.Layers[0].MediaType != MediaTypeChart {
+		t.Fatalf("unexpected manifest %s", content)
+	}
+
+	archive, _ := reg.Blob("charts/app", manifest.Layers[0].Digest)
+	files := untar(t, archive)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if diff := cmp.Diff([]string{"app/.helmignore", "app/Chart.yaml", "app/templates/deploy.yaml", "app/values.yaml"}, names); diff != "" {
+		t.Errorf("archive mismatch (-want +got):\n%s", diff)
+	}
+	if !bytes.Contains(files["app/values.yaml"], []byte("tag: v1.0.0")) {
+		t.Errorf("expected packaged values to contain the image tag, got:\n%s", files["app/values.yaml"])
+	}
+}
+
+func untar(t *testing.T, archive []byte) map[string][]byte {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name], _ = ioutil.ReadAll(tr)
+	}
+}
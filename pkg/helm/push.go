@@ -0,0 +1,57 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+// Media types of Helm charts stored in OCI registries.
+const (
+	MediaTypeConfig string = "application/vnd.cncf.helm.config.v1+json"
+	MediaTypeChart  string = "application/vnd.cncf.helm.chart.content.v1.tar.gz"
+)
+
+// Push packages the chart and pushes it to repo, tagged with the chart
+// version as helm push does. It returns the digest of the chart manifest.
+func (c *Chart) Push(ctx context.Context, client *registry.Client, repo registry.Repository) (string, error) {
+	archive, err := c.Package()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to package chart")
+	}
+	config, err := json.Marshal(c.Metadata)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode chart metadata")
+	}
+
+	manifest := registry.ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		Config:        registry.Descriptor{MediaType: MediaTypeConfig, Digest: registry.Digest(config), Size: int64(len(config))},
+		Layers:        []registry.Descriptor{{MediaType: MediaTypeChart, Digest: registry.Digest(archive), Size: int64(len(archive))}},
+	}
+	for _, blob := range []struct {
+		desc    registry.Descriptor
+		content []byte
+	}{{manifest.Config, config}, {manifest.Layers[0], archive}} {
+		if err := client.UploadBlob(ctx, repo, blob.desc.Digest, bytes.NewReader(blob.content), blob.desc.Size); err != nil {
+			return "", errors.Wrap(err, fmt.Sprintf("failed to upload blob %s", blob.desc.Digest))
+		}
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	m := &registry.Manifest{MediaType: registry.MediaTypeOCIManifest, Digest: registry.Digest(content), Content: content}
+	// OCI tags cannot contain +, helm replaces it with _
+	if _, err := client.PutManifest(ctx, repo, strings.Replace(c.Version(), "+", "_", -1), m); err != nil {
+		return "", err
+	}
+	return m.Digest, nil
+}
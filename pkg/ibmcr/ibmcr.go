@@ -0,0 +1,114 @@
+// Package ibmcr derives the regional endpoints of the IBM Cloud Container
+// Registry and exchanges IBM Cloud IAM API keys for registry tokens.
+package ibmcr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultIAMEndpoint is the public IBM Cloud IAM endpoint.
+	DefaultIAMEndpoint string = "https://iam.cloud.ibm.com"
+
+	// TokenUsername is the docker username that accompanies IAM access tokens.
+	TokenUsername string = "iambearer"
+
+	grantType string = "urn:ibm:params:oauth:grant-type:apikey"
+)
+
+// registries are the registry endpoints of the regions.
+var registries = map[string]string{
+	"global":   "icr.io",
+	"us-south": "us.icr.io",
+	"us-east":  "us.icr.io",
+	"eu-gb":    "uk.icr.io",
+	"eu-de":    "de.icr.io",
+	"eu-es":    "es.icr.io",
+	"eu-fr2":   "fr2.icr.io",
+	"au-syd":   "au.icr.io",
+	"jp-tok":   "jp.icr.io",
+	"jp-osa":   "jp2.icr.io",
+	"br-sao":   "br.icr.io",
+	"ca-tor":   "ca.icr.io",
+}
+
+// Registry returns the registry endpoint of the region, e.g. us.icr.io for
+// us-south, or icr.io for global.
+func Registry(region string) (string, error) {
+	region = strings.ToLower(strings.TrimSpace(region))
+	if registry, ok := registries[region]; ok {
+		return registry, nil
+	}
+	var regions []string
+	for region := range registries {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return "", fmt.Errorf("invalid region %q, must be one of %s", region, strings.Join(regions, ", "))
+}
+
+// Client exchanges IAM API keys for IAM access tokens.
+type Client struct {
+	HTTPClient  *http.Client
+	IAMEndpoint string // IAM endpoint, defaults to DefaultIAMEndpoint
+	UserAgent   string // User-Agent of token requests
+}
+
+// Token exchanges the API key of a user or service ID for an IAM access
+// token, which docker clients use as password together with TokenUsername.
+func (c *Client) Token(ctx context.Context, apiKey string) (string, error) {
+	endpoint := c.IAMEndpoint
+	if endpoint == "" {
+		endpoint = DefaultIAMEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/") + "/identity/token"
+	form := url.Values{
+		"grant_type": {grantType},
+		"apikey":     {strings.TrimSpace(apiKey)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to exchange API key for an IAM token")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to exchange API key for an IAM token: %s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", errors.Wrap(err, "failed to decode IAM token response")
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("IAM token response contains no access token")
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,53 @@
+package ibmcr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	tests := []struct {
+		region  string
+		want    string
+		wantErr bool
+	}{
+		{region: "us-south", want: "us.icr.io"},
+		{region: "EU-GB", want: "uk.icr.io"},
+		{region: "jp-osa", want: "jp2.icr.io"},
+		{region: "global", want: "icr.io"},
+		{region: "", wantErr: true},
+		{region: "us-ashburn-1", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := Registry(test.region)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("Registry(%q) = %q, %v, want %q", test.region, got, err, test.want)
+		}
+	}
+}
+
+func TestClient_Token(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/identity/token" || r.FormValue("grant_type") != grantType {
+			http.NotFound(w, r)
+			return
+		}
+		if r.FormValue("apikey") != "key" {
+			http.Error(w, `{"errorCode":"BXNIM0415E"}`, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token":"token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer srv.Close()
+
+	c := &Client{IAMEndpoint: srv.URL + "/"}
+	if token, err := c.Token(context.Background(), "key\n"); err != nil || token != "token" {
+		t.Errorf("Token() = %q, %v", token, err)
+	}
+	if _, err := c.Token(context.Background(), "invalid"); err == nil || !strings.Contains(err.Error(), "BXNIM0415E") {
+		t.Errorf("expected IAM error, got %v", err)
+	}
+}
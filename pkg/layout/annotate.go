@@ -0,0 +1,78 @@
+package layout
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+// ociMediaTypes maps docker media types to their OCI equivalents.
+var ociMediaTypes = map[string]string{
+	registry.MediaTypeDockerManifest:                            registry.MediaTypeOCIManifest,
+	"application/vnd.docker.container.image.v1+json":            "application/vnd.oci.image.config.v1+json",
+	"application/vnd.docker.image.rootfs.diff.tar.gzip":         "application/vnd.oci.image.layer.v1.tar+gzip",
+	"application/vnd.docker.image.rootfs.foreign.diff.tar.gzip": "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip",
+}
+
+// Annotate adds the annotations to the manifest of the image in the layout.
+// Docker manifests, which don't support annotations, are converted to OCI
+// manifests; the config and layer blobs are unchanged.
+func (l *Layout) Annotate(annotations map[string]string) error {
+	if len(annotations) == 0 {
+		return nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(l.Path, "index.json"))
+	if err != nil {
+		return err
+	}
+	var index registry.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return errors.Wrap(err, "failed to decode OCI layout index")
+	}
+	m, err := l.Manifest()
+	if err != nil {
+		return err
+	}
+	image, err := m.Image()
+	if err != nil {
+		return errors.Wrap(err, "failed to decode manifest")
+	}
+
+	image.MediaType = ociMediaType(m.MediaType)
+	image.Config.MediaType = ociMediaType(image.Config.MediaType)
+	for i := range image.Layers {
+		image.Layers[i].MediaType = ociMediaType(image.Layers[i].MediaType)
+	}
+	if image.Annotations == nil {
+		image.Annotations = map[string]string{}
+	}
+	for key, value := range annotations {
+		image.Annotations[key] = value
+	}
+
+	content, err := json.Marshal(image)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode manifest")
+	}
+	digest := registry.Digest(content)
+	if err := ioutil.WriteFile(l.BlobPath(digest), content, 0644); err != nil {
+		return errors.Wrap(err, "failed to write annotated manifest")
+	}
+	index.Manifests[0].MediaType = image.MediaType
+	index.Manifests[0].Digest = digest
+	index.Manifests[0].Size = int64(len(content))
+	if b, err = json.Marshal(index); err != nil {
+		return errors.Wrap(err, "failed to encode OCI layout index")
+	}
+	return ioutil.WriteFile(filepath.Join(l.Path, "index.json"), b, 0644)
+}
+
+func ociMediaType(mediaType string) string {
+	if oci, ok := ociMediaTypes[mediaType]; ok {
+		return oci
+	}
+	return mediaType
+}
@@ -0,0 +1,87 @@
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+// PushIndex uploads the images of the layouts, one per platform, together
+// with an index referencing them and tags the index with each tag. Images
+// and the index are pushed by digest only and the tags are created last,
+// so that consumers never observe a tag referencing a partial index. It
+// returns the digest of the index. Annotations make the index an OCI index.
+func PushIndex(ctx context.Context, client *registry.Client, repo registry.Repository, layouts []*Layout, tags []string, annotations map[string]string) (string, error) {
+	index := registry.Index{SchemaVersion: 2, MediaType: registry.MediaTypeDockerManifestList}
+	if len(annotations) != 0 {
+		index.MediaType, index.Annotations = registry.MediaTypeOCIIndex, annotations
+	}
+	for _, l := range layouts {
+		m, err := l.Manifest()
+		if err != nil {
+			return "", err
+		}
+		config, err := l.Config()
+		if err != nil {
+			return "", err
+		}
+		if err := l.PushBlobs(ctx, client, repo, m); err != nil {
+			return "", err
+		}
+		if _, err := client.PutManifest(ctx, repo, m.Digest, m); err != nil {
+			return "", err
+		}
+
+		if m.MediaType != registry.MediaTypeDockerManifest {
+			index.MediaType = registry.MediaTypeOCIIndex
+		}
+		index.Manifests = append(index.Manifests, registry.Descriptor{
+			MediaType: m.MediaType,
+			Digest:    m.Digest,
+			Size:      int64(len(m.Content)),
+			Platform:  l.platform(config),
+		})
+	}
+
+	content, err := json.Marshal(index)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode index")
+	}
+	m := &registry.Manifest{MediaType: index.MediaType, Digest: registry.Digest(content), Content: content}
+	if _, err := client.PutManifest(ctx, repo, m.Digest, m); err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to push index %s", m.Digest))
+	}
+	for _, tag := range tags {
+		if _, err := client.PutManifest(ctx, repo, tag, m); err != nil {
+			return "", err
+		}
+	}
+	return m.Digest, nil
+}
+
+// platform returns the platform descriptor of the image with the config,
+// with the fields set by the Platform of the layout overridden.
+func (l *Layout) platform(config *registry.ImageConfig) *registry.Platform {
+	platform := &registry.Platform{
+		OS:           config.OS,
+		Architecture: config.Architecture,
+		OSVersion:    config.OSVersion,
+		Variant:      config.Variant,
+	}
+	if l.Platform == nil {
+		return platform
+	}
+	if l.Platform.OSVersion != "" {
+		platform.OSVersion = l.Platform.OSVersion
+	}
+	if len(l.Platform.OSFeatures) != 0 {
+		platform.OSFeatures = l.Platform.OSFeatures
+	}
+	if l.Platform.Variant != "" {
+		platform.Variant = l.Platform.Variant
+	}
+	return platform
+}
@@ -0,0 +1,144 @@
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+// Layout is an OCI image layout directory as written by kaniko's
+// --oci-layout-path flag.
+type Layout struct {
+	Path string
+	// Platform overrides the non-empty fields of the platform descriptor
+	// of the image in indexes, which defaults to the platform of its config.
+	Platform *registry.Platform
+}
+
+// Open validates and opens the OCI image layout at path.
+func Open(path string) (*Layout, error) {
+	if _, err := os.Stat(filepath.Join(path, "index.json")); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("invalid OCI layout at %s", path))
+	}
+	return &Layout{Path: path}, nil
+}
+
+// BlobPath returns the file path of the blob with the given digest.
+func (l *Layout) BlobPath(digest string) string {
+	return filepath.Join(l.Path, "blobs", strings.Replace(digest, ":", string(filepath.Separator), 1))
+}
+
+// ReadBlob reads the blob with the given digest.
+func (l *Layout) ReadBlob(digest string) ([]byte, error) {
+	b, err := ioutil.ReadFile(l.BlobPath(digest))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read blob %s", digest))
+	}
+	return b, nil
+}
+
+// Manifest returns the manifest of the image in the layout. Layouts written
+// by kaniko contain exactly one image.
+func (l *Layout) Manifest() (*registry.Manifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(l.Path, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	var index registry.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, errors.Wrap(err, "failed to decode OCI layout index")
+	}
+	if len(index.Manifests) != 1 {
+		return nil, fmt.Errorf("expected one image in OCI layout, found %d", len(index.Manifests))
+	}
+	return l.manifest(index.Manifests[0])
+}
+
+func (l *Layout) manifest(desc registry.Descriptor) (*registry.Manifest, error) {
+	content, err := l.ReadBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	mediaType := desc.MediaType
+	if mediaType == "" {
+		var m struct {
+			MediaType string `json:"mediaType"`
+		}
+		json.Unmarshal(content, &m)
+		mediaType = m.MediaType
+	}
+	return &registry.Manifest{MediaType: mediaType, Digest: desc.Digest, Content: content}, nil
+}
+
+// Config returns the image configuration of the image in the layout.
+func (l *Layout) Config() (*registry.ImageConfig, error) {
+	m, err := l.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	image, err := m.Image()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode manifest")
+	}
+	b, err := l.ReadBlob(image.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var config registry.ImageConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, errors.Wrap(err, "failed to decode image config")
+	}
+	return &config, nil
+}
+
+// Push uploads the image in the layout to repo and tags it with each tag.
+// It returns the digest of the pushed manifest.
+func (l *Layout) Push(ctx context.Context, client *registry.Client, repo registry.Repository, tags []string) (string, error) {
+	m, err := l.Manifest()
+	if err != nil {
+		return "", err
+	}
+	if err := l.PushBlobs(ctx, client, repo, m); err != nil {
+		return "", err
+	}
+	for _, tag := range tags {
+		if _, err := client.PutManifest(ctx, repo, tag, m); err != nil {
+			return "", err
+		}
+	}
+	return m.Digest, nil
+}
+
+// PushBlobs uploads the blobs referenced by the manifest that are missing in repo.
+func (l *Layout) PushBlobs(ctx context.Context, client *registry.Client, repo registry.Repository, m *registry.Manifest) error {
+	image, err := m.Image()
+	if err != nil {
+		return errors.Wrap(err, "failed to decode manifest")
+	}
+	for _, blob := range append([]registry.Descriptor{image.Config}, image.Layers...) {
+		exists, err := client.BlobExists(ctx, repo, blob.Digest)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		f, err := os.Open(l.BlobPath(blob.Digest))
+		if err != nil {
+			return err
+		}
+		err = client.UploadBlob(ctx, repo, blob.Digest, f, blob.Size)
+		f.Close()
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to upload blob %s", blob.Digest))
+		}
+	}
+	return nil
+}
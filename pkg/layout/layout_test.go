@@ -0,0 +1,182 @@
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/gexops/drone-kaniko/pkg/registry/registrytest"
+)
+
+// writeLayout writes an OCI layout containing a single image.
+func writeLayout(t *testing.T, config []byte, layers ...[]byte) string {
+	dir := t.TempDir()
+	writeBlob := func(content []byte) registry.Descriptor {
+		digest := registry.Digest(content)
+		path := filepath.Join(dir, "blobs", "sha256", digest[len("sha256:"):])
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return registry.Descriptor{Digest: digest, Size: int64(len(content))}
+	}
+
+	image := registry.ImageManifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIManifest}
+	image.Config = writeBlob(config)
+	image.Config.MediaType = "application/vnd.oci.image.config.v1+json"
+	for _, layer := range layers {
+		desc := writeBlob(layer)
+		desc.MediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+		image.Layers = append(image.Layers, desc)
+	}
+	content, _ := json.Marshal(image)
+	desc := writeBlob(content)
+	desc.MediaType = registry.MediaTypeOCIManifest
+
+	index, _ := json.Marshal(registry.Index{SchemaVersion: 2, Manifests: []registry.Descriptor{desc}})
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLayout_Config(t *testing.T) {
+	dir := writeLayout(t, []byte(`{"architecture":"amd64","os":"linux","config":{"Entrypoint":["/app"],"Labels":{"owner":"team"}}}`), []byte("layer"))
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err)
+	}
+	config, err := l.Config()
+	if err != nil {
+		t.Fatalf("Config failed: %s", err)
+	}
+	if config.OS != "linux" || len(config.Config.Entrypoint) != 1 || config.Config.Labels["owner"] != "team" {
+		t.Errorf("unexpected config %#v", config)
+	}
+
+	if _, err := Open(t.TempDir()); err == nil {
+		t.Error("expected error opening a directory without index.json")
+	}
+}
+
+func TestLayout_Push(t *testing.T) {
+	reg := registrytest.New(t)
+	client := registry.NewClient(registry.KeychainFunc(func(string) (registry.Credential, error) {
+		return registry.Credential{Username: registrytest.Username, Password: registrytest.Password}, nil
+	}), true)
+	repo := registry.Repository{Registry: reg.Host(), Name: "team/app"}
+
+	l, err := Open(writeLayout(t, []byte(`{"os":"linux"}`), []byte("layer1"), []byte("layer2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := l.Push(context.Background(), client, repo, []string{"latest", "v1"})
+	if err != nil {
+		t.Fatalf("Push failed: %s", err)
+	}
+	for _, tag := range []string{"latest", "v1"} {
+		if _, content, ok := reg.Manifest("team/app", tag); !ok || registry.Digest(content) != digest {
+			t.Errorf("expected tag %s to reference %s", tag, digest)
+		}
+	}
+	if _, ok := reg.Blob("team/app", registry.Digest([]byte("layer2"))); !ok {
+		t.Error("expected layer2 to be uploaded")
+	}
+}
+
+func TestPushIndex(t *testing.T) {
+	reg := registrytest.New(t)
+	client := registry.NewClient(registry.KeychainFunc(func(string) (registry.Credential, error) {
+		return registry.Credential{Username: registrytest.Username, Password: registrytest.Password}, nil
+	}), true)
+	repo := registry.Repository{Registry: reg.Host(), Name: "team/app"}
+
+	var layouts []*Layout
+	for _, arch := range []string{"amd64", "arm64"} {
+		l, err := Open(writeLayout(t, []byte(`{"os":"linux","architecture":"`+arch+`"}`), []byte("layer-"+arch)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		layouts = append(layouts, l)
+	}
+	layouts[1].Platform = &registry.Platform{Variant: "v8"}
+	digest, err := PushIndex(context.Background(), client, repo, layouts, []string{"latest"}, map[string]string{"org.opencontainers.image.revision": "abc"})
+	if err != nil {
+		t.Fatalf("PushIndex failed: %s", err)
+	}
+
+	mediaType, content, ok := reg.Manifest("team/app", "latest")
+	if !ok || registry.Digest(content) != digest {
+		t.Fatalf("expected latest to reference %s", digest)
+	}
+	if mediaType != registry.MediaTypeOCIIndex {
+		t.Errorf("media type = %s, want %s", mediaType, registry.MediaTypeOCIIndex)
+	}
+	var index registry.Index
+	if err := json.Unmarshal(content, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 2 || index.Manifests[1].Platform.Architecture != "arm64" || index.Manifests[1].Platform.Variant != "v8" || index.Manifests[0].Platform.Variant != "" || index.Annotations["org.opencontainers.image.revision"] != "abc" {
+		t.Fatalf("unexpected index %s", content)
+	}
+	for _, desc := range index.Manifests {
+		if _, _, ok := reg.Manifest("team/app", desc.Digest); !ok {
+			t.Errorf("expected platform manifest %s to be pushed", desc.Digest)
+		}
+	}
+}
+
+func TestLayout_Annotate(t *testing.T) {
+	config := []byte(`{"os":"linux"}`)
+	l, err := Open(writeLayout(t, config, []byte("layer")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Convert the image to a docker image as written by kaniko
+	m, err := l.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, _ := m.Image()
+	image.MediaType = registry.MediaTypeDockerManifest
+	image.Config.MediaType = "application/vnd.docker.container.image.v1+json"
+	image.Layers[0].MediaType = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	content, _ := json.Marshal(image)
+	if err := ioutil.WriteFile(l.BlobPath(registry.Digest(content)), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	index, _ := json.Marshal(registry.Index{SchemaVersion: 2, Manifests: []registry.Descriptor{{
+		MediaType: registry.MediaTypeDockerManifest, Digest: registry.Digest(content), Size: int64(len(content)),
+	}}})
+	if err := ioutil.WriteFile(filepath.Join(l.Path, "index.json"), index, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Annotate(map[string]string{"io.drone.build.number": "42"}); err != nil {
+		t.Fatalf("Annotate failed: %s", err)
+	}
+	m, err = l.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.MediaType != registry.MediaTypeOCIManifest {
+		t.Errorf("media type = %s, want %s", m.MediaType, registry.MediaTypeOCIManifest)
+	}
+	annotated, _ := m.Image()
+	if annotated.Annotations["io.drone.build.number"] != "42" {
+		t.Errorf("annotations = %v", annotated.Annotations)
+	}
+	if annotated.Config.Digest != registry.Digest(config) || annotated.Config.MediaType != "application/vnd.oci.image.config.v1+json" {
+		t.Errorf("unexpected config %+v", annotated.Config)
+	}
+	if annotated.Layers[0].MediaType != "application/vnd.oci.image.layer.v1.tar+gzip" {
+		t.Errorf("layer media type = %s", annotated.Layers[0].MediaType)
+	}
+}
@@ -0,0 +1,121 @@
+// Package ledger appends build records to a JSON Lines ledger, kept in a
+// file or in an object of a storage service that cannot append to objects.
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Results of builds
+const (
+	ResultSuccess string = "success"
+	ResultFailure string = "failure"
+	ResultSkipped string = "skipped" // Not built, since no trigger paths changed
+)
+
+// maxAttempts is how often an object is rewritten after conflicting writes.
+const maxAttempts int = 5
+
+// ErrConflict is returned by Object.Write when the object changed since it
+// was read.
+var ErrConflict = errors.New("object changed since it was read")
+
+// Entry is the record of a build.
+type Entry struct {
+	Time        time.Time `json:"time"`
+	Repo        string    `json:"repo,omitempty"` // Drone repository, e.g. octocat/hello-world
+	Commit      string    `json:"commit,omitempty"`
+	Ref         string    `json:"ref,omitempty"`
+	Event       string    `json:"event,omitempty"`
+	BuildNumber string    `json:"build_number,omitempty"`
+	BuildLink   string    `json:"build_link,omitempty"`
+	Stage       string    `json:"stage,omitempty"`
+	Step        string    `json:"step,omitempty"`
+	Image       string    `json:"image,omitempty"` // Image repository
+	Tags        []string  `json:"tags,omitempty"`
+	Digest      string    `json:"digest,omitempty"`
+	Pushed      bool      `json:"pushed"`
+	Duration    float64   `json:"duration_seconds"`
+	Result      string    `json:"result"`
+	Phase       string    `json:"phase,omitempty"` // Phase the build failed in
+	Error       string    `json:"error,omitempty"`
+}
+
+// Store appends lines to a ledger.
+type Store interface {
+	Append(ctx context.Context, line []byte) error
+}
+
+// Append appends the entry to the ledger, as a line of JSON.
+func Append(ctx context.Context, store Store, entry Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return store.Append(ctx, append(b, '\n'))
+}
+
+// File is a ledger file, e.g. on a volume shared by the builds.
+type File string
+
+// Append appends the line to the file, creating it and its directory. The
+// line is written at once, so that concurrent builds don't interleave.
+func (f File) Append(ctx context.Context, line []byte) error {
+	if err := os.MkdirAll(filepath.Dir(string(f)), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(string(f), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(line)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Object is an object of a storage service.
+type Object interface {
+	// Read returns the content of the object and its version, or nil and
+	// an empty version if it does not exist.
+	Read(ctx context.Context) (content []byte, version string, err error)
+	// Write replaces the object, unless it no longer has the version read,
+	// in which case ErrConflict is returned. Services without conditional
+	// writes replace the object regardless.
+	Write(ctx context.Context, content []byte, version string) error
+	fmt.Stringer
+}
+
+// ObjectStore appends lines to an object by rewriting it, retrying when
+// concurrent builds wrote the object in between.
+type ObjectStore struct {
+	Object Object
+}
+
+// Append appends the line to the object.
+func (s ObjectStore) Append(ctx context.Context, line []byte) error {
+	for attempt := 1; ; attempt++ {
+		content, version, err := s.Object.Read(ctx)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to read %s", s.Object))
+		}
+		if len(content) != 0 && content[len(content)-1] != '\n' {
+			content = append(content, '\n')
+		}
+		err = s.Object.Write(ctx, append(content, line...), version)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrConflict) || attempt == maxAttempts {
+			return errors.Wrap(err, fmt.Sprintf("failed to write %s", s.Object))
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFile_Append(t *testing.T) {
+	f := File(filepath.Join(t.TempDir(), "ledger", "builds.jsonl"))
+	entries := []Entry{
+		{Time: time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC), Repo: "octocat/app", Image: "docker.io/octocat/app", Tags: []string{"latest"}, Digest: "sha256:abc", Pushed: true, Duration: 42.5, Result: ResultSuccess},
+		{Time: time.Date(2021, 8, 1, 13, 0, 0, 0, time.UTC), Repo: "octocat/app", Result: ResultFailure, Phase: "build", Error: "exit status 1"},
+	}
+	for _, entry := range entries {
+		if err := Append(context.TODO(), f, entry); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Entry
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		var entry Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid line %q: %s", line, err)
+		}
+		got = append(got, entry)
+	}
+	if diff := cmp.Diff(entries, got); diff != "" {
+		t.Errorf("ledger mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// fakeObject is an object whose version changes on every write, and that
+// is written concurrently the first conflicts times it is written.
+type fakeObject struct {
+	content   []byte
+	version   int
+	conflicts int
+}
+
+func (o *fakeObject) Read(ctx context.Context) ([]byte, string, error) {
+	return append([]byte{}, o.content...), strconv.Itoa(o.version), nil
+}
+
+func (o *fakeObject) Write(ctx context.Context, content []byte, version string) error {
+	if o.conflicts > 0 {
+		o.conflicts--
+		o.content = append(o.content, "concurrent\n"...)
+		o.version++
+	}
+	if version != strconv.Itoa(o.version) {
+		return ErrConflict
+	}
+	o.content = content
+	o.version++
+	return nil
+}
+
+func (o *fakeObject) String() string {
+	return "fake"
+}
+
+func TestObjectStore_Append(t *testing.T) {
+	o := &fakeObject{content: []byte("first\n"), conflicts: 2}
+	if err := (ObjectStore{Object: o}).Append(context.TODO(), []byte("line\n")); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if want := "first\nconcurrent\nconcurrent\nline\n"; string(o.content) != want {
+		t.Errorf("content = %q, want %q", o.content, want)
+	}
+
+	o = &fakeObject{conflicts: maxAttempts}
+	if err := (ObjectStore{Object: o}).Append(context.TODO(), []byte("line\n")); err == nil {
+		t.Error("Append() with persistent conflicts error = nil")
+	}
+}
@@ -0,0 +1,126 @@
+// Package ociartifact pushes arbitrary files to a registry as OCI artifacts,
+// compatible with ORAS.
+package ociartifact
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultArtifactType is the artifact type of pushed file bundles.
+	DefaultArtifactType string = "application/vnd.drone.kaniko.files.v1"
+
+	// DefaultMediaType is the media type of files without an explicit one.
+	DefaultMediaType string = "application/vnd.oci.image.layer.v1.tar"
+
+	// AnnotationTitle holds the file name of a layer, as used by ORAS.
+	AnnotationTitle string = "org.opencontainers.image.title"
+
+	mediaTypeEmpty string = "application/vnd.oci.empty.v1+json"
+)
+
+// emptyConfig is the content of the OCI empty descriptor.
+var emptyConfig = []byte("{}")
+
+// File is a file pushed as a layer of an artifact.
+type File struct {
+	Path      string
+	MediaType string
+}
+
+// ParseFile parses a file given as path or path:mediatype, as in ORAS.
+func ParseFile(s string) (File, error) {
+	f := File{Path: s, MediaType: DefaultMediaType}
+	if i := strings.LastIndex(s, ":"); i >= 0 && strings.Contains(s[i+1:], "/") {
+		f.Path, f.MediaType = s[:i], s[i+1:]
+	}
+	if f.Path == "" {
+		return File{}, fmt.Errorf("invalid artifact file %q", s)
+	}
+	return f, nil
+}
+
+// Push uploads the files as the layers of an artifact manifest of the given
+// artifact type and tags it in repo. The subject, if not nil, is set as the
+// manifest subject so that the artifact is listed as a referrer of it. It
+// returns the digest of the artifact manifest.
+func Push(ctx context.Context, client *registry.Client, repo registry.Repository, tag, artifactType string, files []File, subject *registry.Descriptor) (string, error) {
+	manifest := registry.ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		ArtifactType:  artifactType,
+		Config: registry.Descriptor{
+			MediaType: mediaTypeEmpty,
+			Digest:    registry.Digest(emptyConfig),
+			Size:      int64(len(emptyConfig)),
+		},
+		Subject: subject,
+	}
+	if err := client.UploadBlob(ctx, repo, manifest.Config.Digest, bytes.NewReader(emptyConfig), manifest.Config.Size); err != nil {
+		return "", errors.Wrap(err, "failed to upload artifact config")
+	}
+
+	for _, file := range files {
+		desc, err := pushFile(ctx, client, repo, file)
+		if err != nil {
+			return "", errors.Wrap(err, fmt.Sprintf("failed to upload %s", file.Path))
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	m := &registry.Manifest{MediaType: registry.MediaTypeOCIManifest, Digest: registry.Digest(content), Content: content}
+	if _, err := client.PutManifest(ctx, repo, tag, m); err != nil {
+		return "", err
+	}
+	return m.Digest, nil
+}
+
+func pushFile(ctx context.Context, client *registry.Client, repo registry.Repository, file File) (registry.Descriptor, error) {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	if info.IsDir() {
+		return registry.Descriptor{}, fmt.Errorf("%s is a directory", file.Path)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return registry.Descriptor{}, err
+	}
+	desc := registry.Descriptor{
+		MediaType:   file.MediaType,
+		Digest:      fmt.Sprintf("sha256:%x", h.Sum(nil)),
+		Size:        info.Size(),
+		Annotations: map[string]string{AnnotationTitle: filepath.Base(file.Path)},
+	}
+
+	exists, err := client.BlobExists(ctx, repo, desc.Digest)
+	if err != nil || exists {
+		return desc, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return registry.Descriptor{}, err
+	}
+	return desc, client.UploadBlob(ctx, repo, desc.Digest, f, desc.Size)
+}
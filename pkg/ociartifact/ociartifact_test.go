@@ -0,0 +1,70 @@
+package ociartifact
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/gexops/drone-kaniko/pkg/registry/registrytest"
+)
+
+func TestParseFile(t *testing.T) {
+	tests := []struct {
+		in   string
+		want File
+	}{
+		{"values.yaml", File{Path: "values.yaml", MediaType: DefaultMediaType}},
+		{"report.xml:application/vnd.junit+xml", File{Path: "report.xml", MediaType: "application/vnd.junit+xml"}},
+		{"c:dir", File{Path: "c:dir", MediaType: DefaultMediaType}},
+	}
+	for _, test := range tests {
+		got, err := ParseFile(test.in)
+		if err != nil || got != test.want {
+			t.Errorf("ParseFile(%q) = %v, %v, want %v", test.in, got, err, test.want)
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	reg := registrytest.New(t)
+	client := registry.NewClient(registry.KeychainFunc(func(string) (registry.Credential, error) {
+		return registry.Credential{Username: registrytest.Username, Password: registrytest.Password}, nil
+	}), true)
+	repo := registry.Repository{Registry: reg.Host(), Name: "team/app"}
+
+	path := filepath.Join(t.TempDir(), "values.yaml")
+	if err := ioutil.WriteFile(path, []byte("replicas: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	subject := &registry.Descriptor{MediaType: registry.MediaTypeOCIManifest, Digest: "sha256:abcd", Size: 10}
+
+	digest, err := Push(context.Background(), client, repo, "v1-files", DefaultArtifactType, []File{{Path: path, MediaType: "application/yaml"}}, subject)
+	if err != nil {
+		t.Fatalf("Push failed: %s", err)
+	}
+	_, content, ok := reg.Manifest("team/app", "v1-files")
+	if !ok || registry.Digest(content) != digest {
+		t.Fatalf("expected v1-files to reference %s", digest)
+	}
+
+	var manifest registry.ImageManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ArtifactType != DefaultArtifactType || manifest.Subject == nil || manifest.Subject.Digest != subject.Digest {
+		t.Errorf("unexpected manifest %s", content)
+	}
+	if len(manifest.Layers) != 1 || manifest.Layers[0].Annotations[AnnotationTitle] != "values.yaml" {
+		t.Fatalf("unexpected layers %s", content)
+	}
+	if blob, ok := reg.Blob("team/app", manifest.Layers[0].Digest); !ok || string(blob) != "replicas: 2\n" {
+		t.Error("expected file to be uploaded")
+	}
+
+	if _, err := Push(context.Background(), client, repo, "dir", DefaultArtifactType, []File{{Path: t.TempDir()}}, nil); err == nil {
+		t.Error("expected error pushing a directory")
+	}
+}
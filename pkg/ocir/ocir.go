@@ -0,0 +1,56 @@
+// Package ocir derives the registry endpoint, usernames and repositories of
+// the Oracle Cloud Infrastructure Registry from the tenancy settings.
+package ocir
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// regionIdentifierPattern matches region identifiers, e.g. us-ashburn-1.
+	regionIdentifierPattern = regexp.MustCompile(`^[a-z]+(-[a-z]+)+-[0-9]+$`)
+	// regionKeyPattern matches region keys, e.g. iad.
+	regionKeyPattern = regexp.MustCompile(`^[a-z]{3}$`)
+)
+
+// Registry returns the registry endpoint of the region, given as region
+// identifier, e.g. us-ashburn-1, or region key, e.g. iad.
+func Registry(region string) (string, error) {
+	region = strings.ToLower(strings.TrimSpace(region))
+	switch {
+	case regionIdentifierPattern.MatchString(region):
+		return fmt.Sprintf("ocir.%s.oci.oraclecloud.com", region), nil
+	case regionKeyPattern.MatchString(region):
+		return region + ".ocir.io", nil
+	}
+	return "", fmt.Errorf("invalid region %s, expected a region identifier such as us-ashburn-1 or a region key such as iad", region)
+}
+
+// Username returns the registry username of the user in the tenancy
+// namespace: <namespace>/<username>, or <namespace>/<domain>/<username> for
+// users of an identity domain or a federated identity provider, e.g.
+// oracleidentitycloudservice. Usernames already qualified with the
+// namespace are returned as is.
+func Username(namespace, domain, username string) (string, error) {
+	if username == "" {
+		return "", fmt.Errorf("username must be specified")
+	}
+	if namespace == "" || strings.HasPrefix(username, namespace+"/") {
+		return username, nil
+	}
+	if domain != "" {
+		return namespace + "/" + domain + "/" + username, nil
+	}
+	return namespace + "/" + username, nil
+}
+
+// Repository returns the repository path of repo in the tenancy namespace,
+// i.e. <namespace>/<repo>, unless repo is already prefixed with it.
+func Repository(namespace, repo string) string {
+	if repo == "" || namespace == "" || strings.HasPrefix(repo, namespace+"/") {
+		return repo
+	}
+	return namespace + "/" + repo
+}
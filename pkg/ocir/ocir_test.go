@@ -0,0 +1,55 @@
+package ocir
+
+import "testing"
+
+func TestRegistry(t *testing.T) {
+	tests := []struct {
+		region  string
+		want    string
+		wantErr bool
+	}{
+		{region: "us-ashburn-1", want: "ocir.us-ashburn-1.oci.oraclecloud.com"},
+		{region: "eu-frankfurt-1", want: "ocir.eu-frankfurt-1.oci.oraclecloud.com"},
+		{region: "IAD", want: "iad.ocir.io"},
+		{region: "", wantErr: true},
+		{region: "ashburn", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := Registry(test.region)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("Registry(%q) = %q, %v, want %q, wantErr %v", test.region, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestUsername(t *testing.T) {
+	tests := []struct {
+		namespace, domain, username string
+		want                        string
+	}{
+		{namespace: "axaxnpcrorw5", username: "ci@example.com", want: "axaxnpcrorw5/ci@example.com"},
+		{namespace: "axaxnpcrorw5", domain: "oracleidentitycloudservice", username: "ci@example.com", want: "axaxnpcrorw5/oracleidentitycloudservice/ci@example.com"},
+		{namespace: "axaxnpcrorw5", username: "axaxnpcrorw5/ci@example.com", want: "axaxnpcrorw5/ci@example.com"},
+		{username: "axaxnpcrorw5/ci@example.com", want: "axaxnpcrorw5/ci@example.com"},
+	}
+	for _, test := range tests {
+		if got, err := Username(test.namespace, test.domain, test.username); err != nil || got != test.want {
+			t.Errorf("Username(%q, %q, %q) = %q, %v, want %q", test.namespace, test.domain, test.username, got, err, test.want)
+		}
+	}
+	if _, err := Username("axaxnpcrorw5", "", ""); err == nil {
+		t.Error("Username() without username error = nil")
+	}
+}
+
+func TestRepository(t *testing.T) {
+	for repo, want := range map[string]string{
+		"team/app":              "axaxnpcrorw5/team/app",
+		"axaxnpcrorw5/team/app": "axaxnpcrorw5/team/app",
+		"":                      "",
+	} {
+		if got := Repository("axaxnpcrorw5", repo); got != want {
+			t.Errorf("Repository(%q) = %q, want %q", repo, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package patch updates deployment manifests in the workspace with the
+// reference of a pushed image.
+package patch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/yamlpath"
+	"github.com/pkg/errors"
+)
+
+// DefaultValue is the value set by rules without an explicit value.
+const DefaultValue string = "${REF}"
+
+// Rule patches a single file. Without a path every reference to the image
+// in the file is replaced; with a path the YAML value at the path is set.
+type Rule struct {
+	File  string
+	Path  string
+	Value string
+}
+
+// ParseRule parses a rule of the form file[:path[=value]], e.g.
+// kustomization.yaml:images[name=app].digest=${DIGEST}.
+func ParseRule(s string) (Rule, error) {
+	rule := Rule{File: s}
+	if i := strings.Index(s, ":"); i >= 0 {
+		rule.File, rule.Path = s[:i], s[i+1:]
+		rule.Value = DefaultValue
+		// The value follows the first = outside of a selector
+		depth := 0
+		for j, c := range rule.Path {
+			if c == '[' {
+				depth++
+			} else if c == ']' {
+				depth--
+			} else if c == '=' && depth == 0 {
+				rule.Path, rule.Value = rule.Path[:j], rule.Path[j+1:]
+				break
+			}
+		}
+		if rule.Path == "" {
+			return Rule{}, fmt.Errorf("invalid patch rule %s: empty path", s)
+		}
+	}
+	if rule.File == "" {
+		return Rule{}, fmt.Errorf("invalid patch rule %s: empty file", s)
+	}
+	return rule, nil
+}
+
+// Image is the pushed image the manifests are patched with.
+type Image struct {
+	Names  []string // Names the image may be referred to by, e.g. docker.io/octocat/app
+	Repo   string   // Name written to the manifests
+	Tag    string
+	Digest string
+}
+
+// Ref returns the digest reference of the image.
+func (i Image) Ref() string {
+	return i.Repo + "@" + i.Digest
+}
+
+// Expand replaces ${REF}, ${REPO}, ${TAG} and ${DIGEST} in s with the
+// corresponding values of the image.
+func (i Image) Expand(s string) string {
+	return os.Expand(s, func(name string) string {
+		switch name {
+		case "REF":
+			return i.Ref()
+		case "REPO":
+			return i.Repo
+		case "TAG":
+			return i.Tag
+		case "DIGEST":
+			return i.Digest
+		}
+		return "${" + name + "}"
+	})
+}
+
+// Apply patches the file of the rule with the image.
+func Apply(rule Rule, image Image) error {
+	b, err := ioutil.ReadFile(rule.File)
+	if err != nil {
+		return err
+	}
+	if rule.Path != "" {
+		if b, err = yamlpath.Update(b, map[string]string{rule.Path: image.Expand(rule.Value)}); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to patch %s", rule.File))
+		}
+	} else {
+		var n int
+		if b, n = ReplaceReferences(b, image); n == 0 {
+			return fmt.Errorf("failed to patch %s: no reference to %s found", rule.File, image.Repo)
+		}
+	}
+	return ioutil.WriteFile(rule.File, b, 0644)
+}
+
+// ReplaceReferences replaces every reference to one of the image names,
+// with or without tag or digest, by the digest reference of the image and
+// returns the result along with the number of references replaced.
+func ReplaceReferences(b []byte, image Image) ([]byte, int) {
+	names := make([]string, len(image.Names))
+	for i, name := range image.Names {
+		names[i] = regexp.QuoteMeta(name)
+	}
+	re := regexp.MustCompile(`(?:` + strings.Join(names, "|") + `)(?::[\w][\w.-]*)?(?:@sha256:[a-f0-9]{64})?`)
+
+	var out []byte
+	var last, n int
+	for _, loc := range re.FindAllIndex(b, -1) {
+		// Skip matches within other names, e.g. app in octocat/app-cache
+		if loc[0] > 0 && isNameChar(b[loc[0]-1]) || loc[1] < len(b) && (isNameChar(b[loc[1]]) || b[loc[1]] == ':') {
+			continue
+		}
+		out = append(out, b[last:loc[0]]...)
+		out = append(out, image.Ref()...)
+		last = loc[1]
+		n++
+	}
+	return append(out, b[last:]...), n
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("_./-", c) >= 0
+}
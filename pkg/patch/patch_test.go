@@ -0,0 +1,84 @@
+package patch
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rule
+		wantErr bool
+	}{
+		{in: "deploy.yaml", want: Rule{File: "deploy.yaml"}},
+		{in: "values.yaml:image.repository", want: Rule{File: "values.yaml", Path: "image.repository", Value: DefaultValue}},
+		{in: "kustomization.yaml:images[name=app].digest=${DIGEST}", want: Rule{File: "kustomization.yaml", Path: "images[name=app].digest", Value: "${DIGEST}"}},
+		{in: "values.yaml:", wantErr: true},
+		{in: ":image.tag", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseRule(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseRule(%q) error = %v, wantErr %v", test.in, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseRule(%q) = %+v, want %+v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestReplaceReferences(t *testing.T) {
+	image := Image{Names: []string{"gcr.io/project/app"}, Repo: "gcr.io/project/app", Digest: digest}
+	in := "image: gcr.io/project/app:v1\n" +
+		"sidecar: gcr.io/project/app-cache:v1\n" +
+		"pinned: gcr.io/project/app@" + digest[:len(digest)-1] + "0\n" +
+		"other: mirror.gcr.io/project/app:v1\n"
+	want := "image: gcr.io/project/app@" + digest + "\n" +
+		"sidecar: gcr.io/project/app-cache:v1\n" +
+		"pinned: gcr.io/project/app@" + digest + "\n" +
+		"other: mirror.gcr.io/project/app:v1\n"
+
+	got, n := ReplaceReferences([]byte(in), image)
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("ReplaceReferences() mismatch (-want +got):\n%s", diff)
+	}
+	if n != 2 {
+		t.Errorf("ReplaceReferences() replaced %d references, want 2", n)
+	}
+}
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "kustomization.yaml")
+	if err := ioutil.WriteFile(file, []byte("images:\n  - name: app\n    newTag: v1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	image := Image{Names: []string{"gcr.io/project/app"}, Repo: "gcr.io/project/app", Tag: "v2", Digest: digest}
+
+	if err := Apply(Rule{File: file, Path: "images[name=app].newTag", Value: "${TAG}"}, image); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(Rule{File: file, Path: "images[name=app].digest", Value: "${DIGEST}"}, image); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "images:\n  - name: app\n    newTag: v2\n    digest: " + digest + "\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("Apply() mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := Apply(Rule{File: file}, image); err == nil {
+		t.Error("Apply() without references should fail")
+	}
+}
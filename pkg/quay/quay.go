@@ -0,0 +1,153 @@
+// Package quay implements the parts of the Quay API used after pushing an
+// image: repository visibility and team permissions, and validates robot
+// account names.
+package quay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRegistry is the registry host of Quay.io.
+const DefaultRegistry string = "quay.io"
+
+// Repository visibilities
+const (
+	VisibilityPublic  string = "public"
+	VisibilityPrivate string = "private"
+)
+
+// roles are the repository roles of teams.
+var roles = map[string]bool{"read": true, "write": true, "admin": true}
+
+// Client calls the Quay API of a registry with an OAuth access token, which
+// robot accounts cannot obtain.
+type Client struct {
+	HTTPClient *http.Client
+	Registry   string // Registry host, defaults to DefaultRegistry
+	Scheme     string // URL scheme of the API, defaults to https
+	Token      string // OAuth access token with the repo:admin scope
+	UserAgent  string // User-Agent of API requests
+}
+
+// TeamPermission is the role of a team on a repository.
+type TeamPermission struct {
+	Team string
+	Role string // read, write or admin
+}
+
+// ParseTeamPermission parses a team permission given as team:role.
+func ParseTeamPermission(s string) (TeamPermission, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || !roles[parts[1]] {
+		return TeamPermission{}, fmt.Errorf("invalid team permission %s, expected team:role with role read, write or admin", s)
+	}
+	return TeamPermission{Team: parts[0], Role: parts[1]}, nil
+}
+
+// ValidateRobotAccount checks that username is a robot account, given as
+// <namespace>+<name>, of the namespace of the repository.
+func ValidateRobotAccount(username, repo string) error {
+	parts := strings.SplitN(username, "+", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid robot account %s, expected <namespace>+<name>", username)
+	}
+	if namespace := strings.SplitN(repo, "/", 2)[0]; parts[0] != namespace {
+		return fmt.Errorf("robot account %s belongs to namespace %s, not to %s of the repository %s", username, parts[0], namespace, repo)
+	}
+	return nil
+}
+
+// Exists reports whether the repository, given as namespace/name, exists.
+func (c *Client) Exists(ctx context.Context, repo string) (bool, error) {
+	status, err := c.do(ctx, http.MethodGet, "/repository/"+repo, nil, http.StatusNotFound)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to get repository %s", repo))
+	}
+	return status != http.StatusNotFound, nil
+}
+
+// SetVisibility makes the repository public or private.
+func (c *Client) SetVisibility(ctx context.Context, repo, visibility string) error {
+	if visibility != VisibilityPublic && visibility != VisibilityPrivate {
+		return fmt.Errorf("invalid visibility %s, must be %s or %s", visibility, VisibilityPublic, VisibilityPrivate)
+	}
+	_, err := c.do(ctx, http.MethodPost, "/repository/"+repo+"/changevisibility", map[string]string{"visibility": visibility})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to make repository %s %s", repo, visibility))
+	}
+	return nil
+}
+
+// SetTeamPermission grants the team its role on the repository.
+func (c *Client) SetTeamPermission(ctx context.Context, repo string, permission TeamPermission) error {
+	_, err := c.do(ctx, http.MethodPut, "/repository/"+repo+"/permissions/team/"+url.PathEscape(permission.Team), map[string]string{"role": permission.Role})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to grant team %s %s on repository %s", permission.Team, permission.Role, repo))
+	}
+	return nil
+}
+
+// do sends the request with the JSON body, if any, and returns the status
+// of successful responses and responses with one of the accepted statuses.
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, accepted ...int) (int, error) {
+	scheme, registry := c.Scheme, c.Registry
+	if scheme == "" {
+		scheme = "https"
+	}
+	if registry == "" {
+		registry = DefaultRegistry
+	}
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	endpoint := fmt.Sprintf("%s://%s/api/v1%s", scheme, registry, path)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	for _, status := range accepted {
+		if resp.StatusCode == status {
+			return status, nil
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("%s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,93 @@
+package quay
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClient(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		switch r.URL.Path {
+		case "/api/v1/repository/acme/app":
+			w.Write([]byte(`{"namespace":"acme","name":"app"}`))
+		case "/api/v1/repository/acme/app/changevisibility", "/api/v1/repository/acme/app/permissions/team/devs":
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{Registry: strings.TrimPrefix(srv.URL, "http://"), Scheme: "http", Token: "token"}
+	ctx := context.Background()
+	if exists, err := c.Exists(ctx, "acme/app"); err != nil || !exists {
+		t.Errorf("Exists() = %v, %v", exists, err)
+	}
+	if exists, err := c.Exists(ctx, "acme/new"); err != nil || exists {
+		t.Errorf("Exists() of missing repository = %v, %v", exists, err)
+	}
+	if err := c.SetVisibility(ctx, "acme/app", VisibilityPublic); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetTeamPermission(ctx, "acme/app", TeamPermission{Team: "devs", Role: "write"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"GET /api/v1/repository/acme/app ",
+		"GET /api/v1/repository/acme/new ",
+		`POST /api/v1/repository/acme/app/changevisibility {"visibility":"public"}`,
+		`PUT /api/v1/repository/acme/app/permissions/team/devs {"role":"write"}`,
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := c.SetVisibility(ctx, "acme/app", "internal"); err == nil {
+		t.Error("SetVisibility() with invalid visibility error = nil")
+	}
+	c.Token = "forged"
+	if err := c.SetVisibility(ctx, "acme/app", VisibilityPrivate); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+}
+
+func TestParseTeamPermission(t *testing.T) {
+	if got, err := ParseTeamPermission("devs:read"); err != nil || got != (TeamPermission{Team: "devs", Role: "read"}) {
+		t.Errorf("ParseTeamPermission() = %v, %v", got, err)
+	}
+	for _, s := range []string{"devs", ":read", "devs:owner"} {
+		if _, err := ParseTeamPermission(s); err == nil {
+			t.Errorf("ParseTeamPermission(%q) error = nil", s)
+		}
+	}
+}
+
+func TestValidateRobotAccount(t *testing.T) {
+	tests := []struct {
+		username string
+		wantErr  bool
+	}{
+		{username: "acme+ci"},
+		{username: "acme", wantErr: true},
+		{username: "acme+", wantErr: true},
+		{username: "other+ci", wantErr: true},
+	}
+	for _, test := range tests {
+		if err := ValidateRobotAccount(test.username, "acme/app"); (err != nil) != test.wantErr {
+			t.Errorf("ValidateRobotAccount(%s) error = %v, wantErr %v", test.username, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// emptyDigest is the digest of zero bytes, used to probe blob access.
+const emptyDigest string = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// CheckAccess verifies that the client can authenticate against the
+// repository and pull from it and, if push is set, start blob uploads. It
+// allows credential problems to be detected before a long build.
+func (c *Client) CheckAccess(ctx context.Context, repo Repository, push bool) error {
+	scope := "pull"
+	if push {
+		scope = "pull,push"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.url(repo, "blobs/%s", emptyDigest), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, repo, scope)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotFound:
+	default:
+		return newError(resp, fmt.Sprintf("pull access check for %s", repo))
+	}
+	if !push {
+		return nil
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, c.url(repo, "blobs/uploads/"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err = c.do(req, repo, scope)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	if resp.StatusCode != http.StatusAccepted {
+		return newError(resp, fmt.Sprintf("push access check for %s", repo))
+	}
+
+	// Cancel the upload session again, ignoring registries that do not support it.
+	if location, err := resp.Request.URL.Parse(resp.Header.Get("Location")); err == nil {
+		if req, err := http.NewRequestWithContext(ctx, http.MethodDelete, location.String(), nil); err == nil {
+			if resp, err := c.do(req, repo, scope); err == nil {
+				drain(resp)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// BlobExists reports whether the blob exists in the repository.
+func (c *Client) BlobExists(ctx context.Context, repo Repository, digest string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.url(repo, "blobs/%s", digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req, repo, "pull")
+	if err != nil {
+		return false, err
+	}
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, newError(resp, fmt.Sprintf("blob lookup for %s@%s", repo, digest))
+}
+
+// GetBlob opens the blob for reading. The caller must close the returned reader.
+func (c *Client) GetBlob(ctx context.Context, repo Repository, digest string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(repo, "blobs/%s", digest), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.do(req, repo, "pull")
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer drain(resp)
+		return nil, 0, newError(resp, fmt.Sprintf("blob fetch for %s@%s", repo, digest))
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// UploadBlob uploads size bytes read from content as a blob with the given
+// digest using a monolithic upload.
+func (c *Client) UploadBlob(ctx context.Context, repo Repository, digest string, content io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(repo, "blobs/uploads/"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, repo, "pull,push")
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	if resp.StatusCode != http.StatusAccepted {
+		return newError(resp, fmt.Sprintf("blob upload for %s@%s", repo, digest))
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPut, location.String(), content)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = c.do(req, repo, "pull,push")
+	if err != nil {
+		return err
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusCreated {
+		return newError(resp, fmt.Sprintf("blob upload for %s@%s", repo, digest))
+	}
+	return nil
+}
+
+// mountBlob attempts to mount a blob from another repository of the same
+// registry and reports whether the registry mounted it.
+func (c *Client) mountBlob(ctx context.Context, repo Repository, digest string, from Repository) (bool, error) {
+	query := url.Values{"mount": {digest}, "from": {from.Name}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(repo, "blobs/uploads/?%s", query.Encode()), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req, repo, "pull,push")
+	if err != nil {
+		return false, err
+	}
+	drain(resp)
+	return resp.StatusCode == http.StatusCreated, nil
+}
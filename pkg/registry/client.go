@@ -0,0 +1,250 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Credential holds basic auth credentials for a registry, or an OAuth
+	// identity token exchanged for registry tokens.
+	Credential struct {
+		Username      string
+		Password      string
+		IdentityToken string
+	}
+
+	// Keychain resolves the credential for a registry host.
+	Keychain interface {
+		Resolve(registry string) (Credential, error)
+	}
+
+	// KeychainFunc adapts a function to the Keychain interface.
+	KeychainFunc func(registry string) (Credential, error)
+
+	// Client talks to registries implementing the OCI distribution API.
+	Client struct {
+		UserAgent string // User-Agent sent with every request
+
+		keychain Keychain
+		client   *http.Client
+
+		mu     sync.Mutex
+		tokens map[string]string // bearer tokens keyed by registry and scope
+	}
+)
+
+// Resolve calls f(registry).
+func (f KeychainFunc) Resolve(registry string) (Credential, error) {
+	return f(registry)
+}
+
+// Anonymous is a keychain without any credentials.
+var Anonymous Keychain = KeychainFunc(func(string) (Credential, error) {
+	return Credential{}, nil
+})
+
+// DockerKeychain resolves credentials from the docker config file at path,
+// including any configured credential helpers.
+func DockerKeychain(path string) Keychain {
+	return KeychainFunc(func(registry string) (Credential, error) {
+		config, err := docker.LoadConfig(path)
+		if err != nil {
+			return Credential{}, err
+		}
+		username, password, err := config.Credentials(registry)
+		return Credential{Username: username, Password: password, IdentityToken: config.IdentityToken(registry)}, err
+	})
+}
+
+// NewClient returns a registry client using the keychain for authentication.
+func NewClient(keychain Keychain, skipTLSVerify bool) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if skipTLSVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &Client{
+		keychain: keychain,
+		client:   &http.Client{Transport: transport},
+		tokens:   map[string]string{},
+	}
+}
+
+// do sends the request, negotiating authentication with the registry when it
+// responds with a challenge. scope is the list of actions (e.g. "pull,push")
+// requested for the repository.
+func (c *Client) do(req *http.Request, repo Repository, scope string) (*http.Response, error) {
+	key := repo.Registry + " repository:" + repo.Name + ":" + scope
+	c.mu.Lock()
+	token := c.tokens[key]
+	c.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	c.setUserAgent(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// Streamed request bodies cannot be replayed after a challenge.
+	if resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	drain(resp)
+
+	token, err = c.authorize(repo, scope, challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.tokens[key] = token
+	c.mu.Unlock()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", token)
+	return c.client.Do(retry)
+}
+
+// authorize answers a WWW-Authenticate challenge and returns the value of
+// the Authorization header to use.
+func (c *Client) authorize(repo Repository, scope, challenge string) (string, error) {
+	cred, err := c.keychain.Resolve(repo.Registry)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to resolve credentials for %s", repo.Registry))
+	}
+
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if cred.Username == "" {
+			return "", fmt.Errorf("registry %s requires credentials", repo.Registry)
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(cred.Username, cred.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+		realm := params["realm"]
+		if realm == "" {
+			return "", fmt.Errorf("registry %s sent a bearer challenge without realm", repo.Registry)
+		}
+		query := url.Values{}
+		if service := params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		query.Set("scope", "repository:"+repo.Name+":"+scope)
+		var req *http.Request
+		if cred.IdentityToken != "" {
+			// OAuth token request, exchanging the identity token as refresh token
+			query.Set("grant_type", "refresh_token")
+			query.Set("refresh_token", cred.IdentityToken)
+			query.Set("client_id", "drone-kaniko")
+			req, err = http.NewRequest(http.MethodPost, realm, strings.NewReader(query.Encode()))
+		} else {
+			req, err = http.NewRequest(http.MethodGet, realm+"?"+query.Encode(), nil)
+		}
+		if err != nil {
+			return "", err
+		}
+		if cred.IdentityToken != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else if cred.Username != "" {
+			req.SetBasicAuth(cred.Username, cred.Password)
+		}
+		c.setUserAgent(req)
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return "", errors.Wrap(err, fmt.Sprintf("failed to request token from %s", realm))
+		}
+		defer drain(resp)
+		if resp.StatusCode != http.StatusOK {
+			return "", newError(resp, fmt.Sprintf("token request for %s", repo))
+		}
+		var body struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return "", errors.Wrap(err, "failed to decode registry token")
+		}
+		if body.Token == "" {
+			body.Token = body.AccessToken
+		}
+		return "Bearer " + body.Token, nil
+	}
+	return "", fmt.Errorf("registry %s sent an unsupported challenge: %q", repo.Registry, challenge)
+}
+
+// parseChallenge parses a WWW-Authenticate header of the form
+// `Bearer realm="https://auth",service="registry"`.
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	scheme := strings.ToLower(parts[0])
+	if len(parts) == 2 {
+		for _, param := range strings.Split(parts[1], ",") {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 {
+				params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+			}
+		}
+	}
+	return scheme, params
+}
+
+// SetDialer sets the function used to dial registry connections.
+func (c *Client) SetDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	c.client.Transport.(*http.Transport).DialContext = dial
+}
+
+func (c *Client) setUserAgent(req *http.Request) {
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+}
+
+func (c *Client) url(repo Repository, format string, args ...interface{}) string {
+	return fmt.Sprintf("https://%s/v2/%s/", repo.Registry, repo.Name) + fmt.Sprintf(format, args...)
+}
+
+// Error is returned when the registry responds with an unexpected status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(resp *http.Response, action string) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	msg := fmt.Sprintf("%s failed with status %d", action, resp.StatusCode)
+	if s := strings.TrimSpace(string(body)); s != "" {
+		msg += ": " + s
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: msg}
+}
+
+func drain(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
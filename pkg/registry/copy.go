@@ -0,0 +1,67 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Copy copies the manifest referenced by srcRef, including all blobs and,
+// for indexes, all child manifests, from src to dst and tags it as dstRef.
+// It returns the digest of the copied manifest.
+func (c *Client) Copy(ctx context.Context, src Repository, srcRef string, dst Repository, dstRef string) (string, error) {
+	m, err := c.GetManifest(ctx, src, srcRef)
+	if err != nil {
+		return "", err
+	}
+
+	if m.IsIndex() {
+		index, err := m.Index()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to decode index")
+		}
+		for _, child := range index.Manifests {
+			if _, err := c.Copy(ctx, src, child.Digest, dst, child.Digest); err != nil {
+				return "", err
+			}
+		}
+	} else {
+		image, err := m.Image()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to decode manifest")
+		}
+		for _, blob := range append([]Descriptor{image.Config}, image.Layers...) {
+			if err := c.copyBlob(ctx, src, dst, blob); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return c.PutManifest(ctx, dst, dstRef, m)
+}
+
+func (c *Client) copyBlob(ctx context.Context, src, dst Repository, blob Descriptor) error {
+	exists, err := c.BlobExists(ctx, dst, blob.Digest)
+	if err != nil || exists {
+		return err
+	}
+	if src.Registry == dst.Registry {
+		if mounted, err := c.mountBlob(ctx, dst, blob.Digest, src); err == nil && mounted {
+			return nil
+		}
+	}
+
+	content, size, err := c.GetBlob(ctx, src, blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	if size < 0 {
+		size = blob.Size
+	}
+	if err := c.UploadBlob(ctx, dst, blob.Digest, content, size); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to copy blob %s", blob.Digest))
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Media types of the manifests understood by the client.
+const (
+	MediaTypeDockerManifest     string = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList string = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        string = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           string = "application/vnd.oci.image.index.v1+json"
+)
+
+var manifestMediaTypes = strings.Join([]string{
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeOCIIndex,
+}, ", ")
+
+// Manifest is a raw image manifest together with its media type and digest.
+type Manifest struct {
+	MediaType string
+	Digest    string
+	Content   []byte
+}
+
+// Digest returns the sha256 digest of content.
+func Digest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// HeadManifest reports whether the reference (a tag or digest) exists in the
+// repository, and returns its digest when it does.
+func (c *Client) HeadManifest(ctx context.Context, repo Repository, reference string) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.url(repo, "manifests/%s", reference), nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Accept", manifestMediaTypes)
+	resp, err := c.do(req, repo, "pull")
+	if err != nil {
+		return "", false, err
+	}
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("Docker-Content-Digest"), true, nil
+	case http.StatusNotFound:
+		return "", false, nil
+	}
+	return "", false, newError(resp, fmt.Sprintf("manifest lookup for %s:%s", repo, reference))
+}
+
+// GetManifest fetches the manifest for the reference (a tag or digest).
+func (c *Client) GetManifest(ctx context.Context, repo Repository, reference string) (*Manifest, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(repo, "manifests/%s", reference), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", manifestMediaTypes)
+	resp, err := c.do(req, repo, "pull")
+	if err != nil {
+		return nil, err
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, newError(resp, fmt.Sprintf("manifest fetch for %s:%s", repo, reference))
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    Digest(content),
+		Content:   content,
+	}, nil
+}
+
+// PutManifest uploads the manifest under the reference and returns the
+// digest reported by the registry.
+func (c *Client) PutManifest(ctx context.Context, repo Repository, reference string, m *Manifest) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.url(repo, "manifests/%s", reference), bytes.NewReader(m.Content))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", m.MediaType)
+	resp, err := c.do(req, repo, "pull,push")
+	if err != nil {
+		return "", err
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", newError(resp, fmt.Sprintf("manifest upload for %s:%s", repo, reference))
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	return Digest(m.Content), nil
+}
@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParsePlatform parses a platform of the form os/arch or os/arch/variant.
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// String returns the platform in os/arch[/variant] form.
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Matches reports whether the platform satisfies want. The variant is only
+// compared when want specifies one.
+func (p Platform) Matches(want Platform) bool {
+	return p.OS == want.OS && p.Architecture == want.Architecture && (want.Variant == "" || p.Variant == want.Variant)
+}
+
+// LayerCount returns the number of layers of the image referenced by
+// reference, using the image for the platform when it is an index.
+func (c *Client) LayerCount(ctx context.Context, repo Repository, reference string, platform Platform) (int, error) {
+	m, err := c.GetManifest(ctx, repo, reference)
+	if err != nil {
+		return 0, err
+	}
+	if m.IsIndex() {
+		index, err := m.Index()
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to decode index")
+		}
+		var digest string
+		for _, desc := range index.Manifests {
+			if desc.Platform != nil && desc.Platform.Matches(platform) {
+				digest = desc.Digest
+				break
+			}
+		}
+		if digest == "" {
+			return 0, fmt.Errorf("%s:%s does not provide platform %s", repo, reference, platform)
+		}
+		if m, err = c.GetManifest(ctx, repo, digest); err != nil {
+			return 0, err
+		}
+	}
+	image, err := m.Image()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to decode manifest")
+	}
+	return len(image.Layers), nil
+}
+
+// Platforms returns the platforms provided by the image referenced by
+// reference: the platforms listed in an index, or the platform recorded in
+// the configuration of a single image.
+func (c *Client) Platforms(ctx context.Context, repo Repository, reference string) ([]Platform, error) {
+	m, err := c.GetManifest(ctx, repo, reference)
+	if err != nil {
+		return nil, err
+	}
+	if m.IsIndex() {
+		index, err := m.Index()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode index")
+		}
+		var platforms []Platform
+		for _, desc := range index.Manifests {
+			if desc.Platform != nil {
+				platforms = append(platforms, *desc.Platform)
+			}
+		}
+		return platforms, nil
+	}
+
+	image, err := m.Image()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode manifest")
+	}
+	blob, _, err := c.GetBlob(ctx, repo, image.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	b, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, errors.Wrap(err, "failed to decode image config")
+	}
+	return []Platform{{OS: config.OS, Architecture: config.Architecture, OSVersion: config.OSVersion, Variant: config.Variant}}, nil
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	dockerHubRegistry string = "registry-1.docker.io"
+	dockerHubLibrary  string = "library/"
+)
+
+// Repository identifies an image repository within a registry.
+type Repository struct {
+	Registry string // Registry host, e.g. gcr.io
+	Name     string // Repository path within the registry, e.g. project/image
+}
+
+// ParseRepository parses an image repository such as "gcr.io/project/image"
+// into its registry host and repository name. Repositories without a
+// registry host refer to Docker Hub. Any scheme or Docker Hub API version
+// path (as used by the docker plugin's default registry) is ignored.
+func ParseRepository(s string) (Repository, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+	for _, prefix := range []string{"index.docker.io/v1/", "index.docker.io/v2/", "registry.hub.docker.com/v2/"} {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	s = strings.Trim(s, "/")
+	if s == "" {
+		return Repository{}, fmt.Errorf("repository must be specified")
+	}
+
+	repo := Repository{Registry: dockerHubRegistry, Name: s}
+	if parts := strings.SplitN(s, "/", 2); len(parts) == 2 && isRegistryHost(parts[0]) {
+		repo.Registry, repo.Name = parts[0], parts[1]
+	}
+	switch repo.Registry {
+	case "docker.io", "index.docker.io":
+		repo.Registry = dockerHubRegistry
+	}
+	if repo.Registry == dockerHubRegistry && !strings.Contains(repo.Name, "/") {
+		repo.Name = dockerHubLibrary + repo.Name
+	}
+	if repo.Name != strings.ToLower(repo.Name) {
+		return Repository{}, fmt.Errorf("repository name must be lowercase: %s", repo.Name)
+	}
+	return repo, nil
+}
+
+// isRegistryHost follows the docker reference convention: the first path
+// component is a registry host if it contains a dot or a port, or is localhost.
+func isRegistryHost(s string) bool {
+	return strings.ContainsAny(s, ".:") || s == "localhost"
+}
+
+// String returns the repository in host/name form.
+func (r Repository) String() string {
+	return r.Registry + "/" + r.Name
+}
+
+// IsDockerHub reports whether the repository is on Docker Hub.
+func (r Repository) IsDockerHub() bool {
+	return r.Registry == dockerHubRegistry
+}
+
+// ParseReference parses an image reference such as "gcr.io/project/image:tag"
+// or "gcr.io/project/image@sha256:..." into its repository and the tag or
+// digest. A reference without tag or digest refers to the latest tag.
+func ParseReference(s string) (Repository, string, error) {
+	name, reference := s, "latest"
+	if i := strings.Index(s, "@"); i >= 0 {
+		name, reference = s[:i], s[i+1:]
+		// A tag alongside the digest is ignored
+		if j := strings.LastIndex(name, ":"); j > strings.LastIndex(name, "/") {
+			name = name[:j]
+		}
+	} else if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+		name, reference = s[:i], s[i+1:]
+	}
+	repo, err := ParseRepository(name)
+	return repo, reference, err
+}
@@ -0,0 +1,295 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gexops/drone-kaniko/pkg/docker"
+	"github.com/gexops/drone-kaniko/pkg/registry/registrytest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func testKeychain() Keychain {
+	return KeychainFunc(func(string) (Credential, error) {
+		return Credential{Username: registrytest.Username, Password: registrytest.Password}, nil
+	})
+}
+
+func testRepo(reg *registrytest.Registry, name string) Repository {
+	return Repository{Registry: reg.Host(), Name: name}
+}
+
+func TestParseRepository(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Repository
+	}{
+		{"golang", Repository{"registry-1.docker.io", "library/golang"}},
+		{"foo/bar", Repository{"registry-1.docker.io", "foo/bar"}},
+		{"https://index.docker.io/v1/foo/bar", Repository{"registry-1.docker.io", "foo/bar"}},
+		{"docker.io/foo/bar", Repository{"registry-1.docker.io", "foo/bar"}},
+		{"gcr.io/project/image", Repository{"gcr.io", "project/image"}},
+		{"localhost:5000/image", Repository{"localhost:5000", "image"}},
+		{"123456789.dkr.ecr.us-east-1.amazonaws.com/app", Repository{"123456789.dkr.ecr.us-east-1.amazonaws.com", "app"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRepository(tt.in)
+		if err != nil {
+			t.Errorf("ParseRepository(%q) returned error: %s", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRepository(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "gcr.io/Project/Image"} {
+		if _, err := ParseRepository(in); err == nil {
+			t.Errorf("ParseRepository(%q) expected error", in)
+		}
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		in        string
+		repo      Repository
+		reference string
+	}{
+		{"golang", Repository{"registry-1.docker.io", "library/golang"}, "latest"},
+		{"gcr.io/project/image:v1", Repository{"gcr.io", "project/image"}, "v1"},
+		{"localhost:5000/image", Repository{"localhost:5000", "image"}, "latest"},
+		{"localhost:5000/image@sha256:abcd", Repository{"localhost:5000", "image"}, "sha256:abcd"},
+		{"gcr.io/project/image:v1@sha256:abcd", Repository{"gcr.io", "project/image"}, "sha256:abcd"},
+	}
+	for _, tt := range tests {
+		repo, reference, err := ParseReference(tt.in)
+		if err != nil {
+			t.Errorf("ParseReference(%q) returned error: %s", tt.in, err)
+			continue
+		}
+		if repo != tt.repo || reference != tt.reference {
+			t.Errorf("ParseReference(%q) = %#v, %q, want %#v, %q", tt.in, repo, reference, tt.repo, tt.reference)
+		}
+	}
+}
+
+func TestClient_Manifests(t *testing.T) {
+	reg := registrytest.New(t)
+	client := NewClient(testKeychain(), true)
+	repo := testRepo(reg, "team/app")
+	ctx := context.Background()
+
+	if _, found, err := client.HeadManifest(ctx, repo, "v1"); err != nil || found {
+		t.Fatalf("HeadManifest before push = %v, %v, want not found", found, err)
+	}
+
+	m := &Manifest{MediaType: MediaTypeOCIManifest, Content: []byte(`{"schemaVersion":2}`)}
+	digest, err := client.PutManifest(ctx, repo, "v1", m)
+	if err != nil {
+		t.Fatalf("PutManifest failed: %s", err)
+	}
+	if want := Digest(m.Content); digest != want {
+		t.Errorf("PutManifest digest = %s, want %s", digest, want)
+	}
+
+	got, found, err := client.HeadManifest(ctx, repo, "v1")
+	if err != nil || !found || got != digest {
+		t.Errorf("HeadManifest after push = %q, %v, %v, want %q", got, found, err, digest)
+	}
+
+	fetched, err := client.GetManifest(ctx, repo, digest)
+	if err != nil {
+		t.Fatalf("GetManifest failed: %s", err)
+	}
+	if string(fetched.Content) != string(m.Content) || fetched.MediaType != m.MediaType {
+		t.Errorf("GetManifest = %#v, want %#v", fetched, m)
+	}
+}
+
+func TestClient_Unauthorized(t *testing.T) {
+	reg := registrytest.New(t)
+	client := NewClient(Anonymous, true)
+	_, _, err := client.HeadManifest(context.Background(), testRepo(reg, "team/app"), "v1")
+	if err == nil {
+		t.Fatal("expected error for anonymous access")
+	}
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized registry error, got %v", err)
+	}
+}
+
+func TestClient_IdentityToken(t *testing.T) {
+	reg := registrytest.New(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := docker.NewConfig()
+	config.SetIdentityToken(reg.Host(), registrytest.Username, registrytest.IdentityToken)
+	if err := config.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(DockerKeychain(path), true)
+	if _, found, err := client.HeadManifest(context.Background(), testRepo(reg, "team/app"), "v1"); err != nil || found {
+		t.Errorf("HeadManifest with identity token = %v, %v, want not found", found, err)
+	}
+}
+
+func TestClient_Copy(t *testing.T) {
+	staging := registrytest.New(t)
+	production := registrytest.New(t)
+	client := NewClient(testKeychain(), true)
+	ctx := context.Background()
+
+	digest := staging.PushImage("team/app", "rc", []byte(`{"os":"linux"}`), []byte("layer"))
+
+	// Copy between registries streams the blobs.
+	got, err := client.Copy(ctx, testRepo(staging, "team/app"), digest, testRepo(production, "prod/app"), "v1")
+	if err != nil {
+		t.Fatalf("Copy failed: %s", err)
+	}
+	if got != digest {
+		t.Errorf("Copy digest = %s, want %s", got, digest)
+	}
+	if _, ok := production.Blob("prod/app", Digest([]byte("layer"))); !ok {
+		t.Errorf("expected layer to be copied to production")
+	}
+	if got, found, _ := client.HeadManifest(ctx, testRepo(production, "prod/app"), "v1"); !found || got != digest {
+		t.Errorf("expected production tag v1 to reference %s, got %q", digest, got)
+	}
+
+	// Copy within a registry mounts the blobs.
+	if _, err := client.Copy(ctx, testRepo(staging, "team/app"), "rc", testRepo(staging, "team/other"), "rc"); err != nil {
+		t.Fatalf("Copy within registry failed: %s", err)
+	}
+	if _, ok := staging.Blob("team/other", Digest([]byte("layer"))); !ok {
+		t.Errorf("expected layer to be mounted into team/other")
+	}
+}
+
+func TestClient_UserAgent(t *testing.T) {
+	var got string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.Header.Get("User-Agent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewClient(Anonymous, true)
+	client.UserAgent = "drone-kaniko-docker/1.0.0"
+	repo := Repository{Registry: strings.TrimPrefix(server.URL, "https://"), Name: "app"}
+	if _, _, err := client.HeadManifest(context.Background(), repo, "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if got != client.UserAgent {
+		t.Errorf("User-Agent = %q, want %q", got, client.UserAgent)
+	}
+}
+
+func TestClient_CheckAccess(t *testing.T) {
+	reg := registrytest.New(t)
+	ctx := context.Background()
+
+	if err := NewClient(testKeychain(), true).CheckAccess(ctx, testRepo(reg, "team/app"), true); err != nil {
+		t.Errorf("CheckAccess with valid credentials failed: %s", err)
+	}
+
+	bad := KeychainFunc(func(string) (Credential, error) {
+		return Credential{Username: "user", Password: "wrong"}, nil
+	})
+	err := NewClient(bad, true).CheckAccess(ctx, testRepo(reg, "team/app"), false)
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized error for invalid credentials, got %v", err)
+	}
+}
+
+func TestClient_Platforms(t *testing.T) {
+	reg := registrytest.New(t)
+	client := NewClient(testKeychain(), true)
+	repo := testRepo(reg, "windows/servercore")
+	ctx := context.Background()
+
+	reg.PushImage("windows/servercore", "ltsc2019", []byte(`{"os":"windows","architecture":"amd64","os.version":"10.0.17763.2803"}`), []byte("layer"))
+	platforms, err := client.Platforms(ctx, repo, "ltsc2019")
+	if err != nil {
+		t.Fatalf("Platforms failed: %s", err)
+	}
+	want := []Platform{{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.2803"}}
+	if diff := cmp.Diff(want, platforms); diff != "" {
+		t.Errorf("platforms mismatch (-want +got):\n%s", diff)
+	}
+
+	index := &Manifest{MediaType: MediaTypeOCIIndex, Content: []byte(`{"schemaVersion":2,"manifests":[` +
+		`{"digest":"sha256:a","platform":{"os":"linux","architecture":"arm","variant":"v7"}},` +
+		`{"digest":"sha256:b","platform":{"os":"windows","architecture":"amd64","os.version":"10.0.20348.643"}}]}`)}
+	if _, err := client.PutManifest(ctx, repo, "multi", index); err != nil {
+		t.Fatal(err)
+	}
+	platforms, err = client.Platforms(ctx, repo, "multi")
+	if err != nil {
+		t.Fatalf("Platforms failed: %s", err)
+	}
+	if len(platforms) != 2 || !platforms[1].Matches(Platform{OS: "windows", Architecture: "amd64"}) {
+		t.Errorf("unexpected index platforms %v", platforms)
+	}
+	if platforms[0].Matches(Platform{OS: "linux", Architecture: "arm", Variant: "v6"}) {
+		t.Error("expected variant mismatch")
+	}
+}
+
+func TestClient_LayerCount(t *testing.T) {
+	reg := registrytest.New(t)
+	client := NewClient(testKeychain(), true)
+	repo := testRepo(reg, "library/alpine")
+	ctx := context.Background()
+
+	digest := reg.PushImage("library/alpine", "3.14", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("layer"))
+	count, err := client.LayerCount(ctx, repo, "3.14", Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil || count != 1 {
+		t.Errorf("LayerCount() = %d, %v, want 1", count, err)
+	}
+
+	index := &Manifest{MediaType: MediaTypeOCIIndex, Content: []byte(`{"schemaVersion":2,"manifests":[` +
+		`{"digest":"` + digest + `","platform":{"os":"linux","architecture":"amd64"}}]}`)}
+	if _, err := client.PutManifest(ctx, repo, "multi", index); err != nil {
+		t.Fatal(err)
+	}
+	count, err = client.LayerCount(ctx, repo, "multi", Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil || count != 1 {
+		t.Errorf("LayerCount() of index = %d, %v, want 1", count, err)
+	}
+	if _, err := client.LayerCount(ctx, repo, "multi", Platform{OS: "linux", Architecture: "arm64"}); err == nil {
+		t.Error("expected error for a platform missing from the index")
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	p, err := ParsePlatform("linux/arm/v7")
+	if err != nil || p.String() != "linux/arm/v7" || p.Variant != "v7" {
+		t.Errorf("ParsePlatform(linux/arm/v7) = %v, %v", p, err)
+	}
+	for _, s := range []string{"amd64", "linux/", "linux/arm/v7/x"} {
+		if _, err := ParsePlatform(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestClient_Tags(t *testing.T) {
+	reg := registrytest.New(t)
+	client := NewClient(testKeychain(), true)
+	for _, tag := range []string{"v1", "v2", "latest"} {
+		reg.PushImage("team/app", tag, []byte(`{"os":"linux"}`), []byte("layer"))
+	}
+	reg.PushImage("team/app/cache", "key", []byte(`{"os":"linux"}`), []byte("layer"))
+
+	tags, err := client.Tags(context.Background(), testRepo(reg, "team/app"))
+	if err != nil {
+		t.Fatalf("Tags failed: %s", err)
+	}
+	if diff := cmp.Diff([]string{"latest", "v1", "v2"}, tags); diff != "" {
+		t.Errorf("tags mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,207 @@
+// Package registrytest provides an in-memory registry for tests.
+package registrytest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Credentials accepted by the registry.
+const (
+	Username      string = "user"
+	Password      string = "pass"
+	IdentityToken string = "refresh" // OAuth refresh token of Username
+)
+
+type manifest struct {
+	mediaType string
+	content   []byte
+}
+
+// Registry is a minimal TLS registry requiring bearer tokens, which are
+// issued for the basic auth credentials Username and Password, or for the
+// refresh token IdentityToken.
+type Registry struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	manifests map[string]manifest // keyed by name:reference
+	blobs     map[string][]byte   // keyed by name@digest
+}
+
+// New starts a registry that is shut down when the test completes.
+func New(t testing.TB) *Registry {
+	r := &Registry{manifests: map[string]manifest{}, blobs: map[string][]byte{}}
+	r.server = httptest.NewTLSServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// Host returns the host:port of the registry.
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.server.URL, "https://")
+}
+
+// Digest returns the sha256 digest of content.
+func Digest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// PushImage stores an OCI image with the config and a single layer under
+// the tag and returns the manifest digest.
+func (r *Registry) PushImage(name, tag string, config, layer []byte) string {
+	content, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config":        map[string]interface{}{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": Digest(config), "size": len(config)},
+		"layers":        []interface{}{map[string]interface{}{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": Digest(layer), "size": len(layer)}},
+	})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[name+"@"+Digest(config)] = config
+	r.blobs[name+"@"+Digest(layer)] = layer
+	m := manifest{mediaType: "application/vnd.oci.image.manifest.v1+json", content: content}
+	r.manifests[name+":"+tag] = m
+	r.manifests[name+":"+Digest(content)] = m
+	return Digest(content)
+}
+
+// Manifest returns the media type and content stored under the reference.
+func (r *Registry) Manifest(name, reference string) (string, []byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.manifests[name+":"+reference]
+	return m.mediaType, m.content, ok
+}
+
+// Blob returns the blob stored in the repository.
+func (r *Registry) Blob(name, digest string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.blobs[name+"@"+digest]
+	return b, ok
+}
+
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" && req.Method == http.MethodPost {
+		if req.PostFormValue("grant_type") != "refresh_token" || req.PostFormValue("refresh_token") != IdentityToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"access_token":"secret"}`))
+		return
+	}
+	if req.URL.Path == "/token" {
+		if user, pass, ok := req.BasicAuth(); !ok || user != Username || pass != Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"token":"secret"}`))
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer secret" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.server.URL+`/token",service="registrytest"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(path, "/tags/list"):
+		r.serveTags(w, req, strings.TrimSuffix(path, "/tags/list"))
+	case strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		key := parts[0] + ":" + parts[1]
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			m, ok := r.manifests[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", m.mediaType)
+			w.Header().Set("Docker-Content-Digest", Digest(m.content))
+			if req.Method == http.MethodGet {
+				w.Write(m.content)
+			}
+		case http.MethodPut:
+			content, _ := ioutil.ReadAll(req.Body)
+			m := manifest{mediaType: req.Header.Get("Content-Type"), content: content}
+			r.manifests[key] = m
+			r.manifests[parts[0]+":"+Digest(content)] = m
+			w.Header().Set("Docker-Content-Digest", Digest(content))
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			delete(r.manifests, key)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	case strings.HasSuffix(path, "/blobs/uploads/"):
+		name := strings.TrimSuffix(path, "/blobs/uploads/")
+		if digest := req.URL.Query().Get("mount"); digest != "" {
+			from := req.URL.Query().Get("from")
+			if b, ok := r.blobs[from+"@"+digest]; ok {
+				r.blobs[name+"@"+digest] = b
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		w.Header().Set("Location", "/v2/"+name+"/blobs/uploads/session")
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasSuffix(path, "/blobs/uploads/session"):
+		name := strings.TrimSuffix(path, "/blobs/uploads/session")
+		content, _ := ioutil.ReadAll(req.Body)
+		digest := req.URL.Query().Get("digest")
+		if Digest(content) != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[name+"@"+digest] = content
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		parts := strings.SplitN(path, "/blobs/", 2)
+		content, ok := r.blobs[parts[0]+"@"+parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if req.Method == http.MethodGet {
+			w.Write(content)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// serveTags lists the tags of the repository, one per page to exercise
+// pagination.
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, name string) {
+	var tags []string
+	for key := range r.manifests {
+		if strings.HasPrefix(key, name+":") && !strings.Contains(key, "sha256:") {
+			tags = append(tags, strings.TrimPrefix(key, name+":"))
+		}
+	}
+	sort.Strings(tags)
+
+	last := req.URL.Query().Get("last")
+	i := sort.SearchStrings(tags, last)
+	if last != "" && i < len(tags) && tags[i] == last {
+		i++
+	}
+	page := tags[i:]
+	if len(page) > 1 {
+		page = page[:1]
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=1&last=%s>; rel="next"`, name, page[0]))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "tags": page})
+}
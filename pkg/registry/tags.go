@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Tags lists the tags of repo, following pagination links.
+func (c *Client) Tags(ctx context.Context, repo Repository) ([]string, error) {
+	var tags []string
+	next := c.url(repo, "tags/list")
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req, repo, "pull")
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer drain(resp)
+			return nil, newError(resp, fmt.Sprintf("tag list of %s", repo))
+		}
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		drain(resp)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode tag list")
+		}
+		tags = append(tags, list.Tags...)
+
+		next = ""
+		if link := nextLink(resp.Header.Get("Link")); link != "" {
+			u, err := resp.Request.URL.Parse(link)
+			if err != nil {
+				return nil, err
+			}
+			next = u.String()
+		}
+	}
+	return tags, nil
+}
+
+// nextLink returns the target of a Link header with rel="next", e.g.
+// </v2/name/tags/list?n=100&last=v1>; rel="next".
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 || !strings.Contains(parts[1], `rel="next"`) {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package registry
+
+import "encoding/json"
+
+type (
+	// Platform describes the platform of an image in an index.
+	Platform struct {
+		Architecture string   `json:"architecture"`
+		OS           string   `json:"os"`
+		OSVersion    string   `json:"os.version,omitempty"`
+		OSFeatures   []string `json:"os.features,omitempty"`
+		Variant      string   `json:"variant,omitempty"`
+	}
+
+	// Descriptor references content by media type, digest and size.
+	Descriptor struct {
+		MediaType    string            `json:"mediaType"`
+		Digest       string            `json:"digest"`
+		Size         int64             `json:"size"`
+		ArtifactType string            `json:"artifactType,omitempty"`
+		Platform     *Platform         `json:"platform,omitempty"`
+		Annotations  map[string]string `json:"annotations,omitempty"`
+	}
+
+	// ImageManifest is a docker v2 schema 2 or OCI image manifest.
+	ImageManifest struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType,omitempty"`
+		ArtifactType  string            `json:"artifactType,omitempty"`
+		Config        Descriptor        `json:"config"`
+		Layers        []Descriptor      `json:"layers"`
+		Subject       *Descriptor       `json:"subject,omitempty"`
+		Annotations   map[string]string `json:"annotations,omitempty"`
+	}
+
+	// Index is a docker manifest list or OCI image index.
+	Index struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType,omitempty"`
+		Manifests     []Descriptor      `json:"manifests"`
+		Annotations   map[string]string `json:"annotations,omitempty"`
+	}
+)
+
+// IsIndex reports whether the manifest is a manifest list or image index.
+func (m *Manifest) IsIndex() bool {
+	return m.MediaType == MediaTypeDockerManifestList || m.MediaType == MediaTypeOCIIndex
+}
+
+// Image decodes the manifest as an image manifest.
+func (m *Manifest) Image() (*ImageManifest, error) {
+	var image ImageManifest
+	err := json.Unmarshal(m.Content, &image)
+	return &image, err
+}
+
+// Index decodes the manifest as an index.
+func (m *Manifest) Index() (*Index, error) {
+	var index Index
+	err := json.Unmarshal(m.Content, &index)
+	return &index, err
+}
+
+type (
+	// ImageConfig is the subset of the image configuration blob used by the plugin.
+	ImageConfig struct {
+		Architecture string          `json:"architecture"`
+		OS           string          `json:"os"`
+		OSVersion    string          `json:"os.version,omitempty"`
+		Variant      string          `json:"variant,omitempty"`
+		Config       ContainerConfig `json:"config"`
+	}
+
+	// ContainerConfig holds the execution parameters of an image.
+	ContainerConfig struct {
+		User         string              `json:"User,omitempty"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+		Env          []string            `json:"Env,omitempty"`
+		Entrypoint   []string            `json:"Entrypoint,omitempty"`
+		Cmd          []string            `json:"Cmd,omitempty"`
+		WorkingDir   string              `json:"WorkingDir,omitempty"`
+		Labels       map[string]string   `json:"Labels,omitempty"`
+	}
+)
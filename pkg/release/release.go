@@ -0,0 +1,90 @@
+// Package release reads release manifests, which define related images,
+// e.g. an application, its migrations and a sidecar, built and pushed
+// together as one release.
+package release
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// namePattern matches image names, which are used as repository path
+// components.
+var namePattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*$`)
+
+// Image is an image of the release.
+type Image struct {
+	Name       string   `yaml:"name"`       // Name of the image, appended to the repository of the plugin
+	Context    string   `yaml:"context"`    // Build context, the workspace by default
+	Dockerfile string   `yaml:"dockerfile"` // Dockerfile, Dockerfile in the build context by default
+	Target     string   `yaml:"target"`     // Build stage to build
+	Args       []string `yaml:"args"`       // Build args added to those of the plugin, as NAME=value
+}
+
+// Manifest is a release manifest.
+type Manifest struct {
+	Images []Image `yaml:"images"`
+}
+
+// Load reads and validates the release manifest at path, filling in the
+// default build context and Dockerfile of the images.
+func Load(path string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read release manifest")
+	}
+	return Parse(b)
+}
+
+// Parse parses and validates a release manifest.
+func Parse(content []byte) (*Manifest, error) {
+	var m Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, errors.Wrap(err, "invalid release manifest")
+	}
+	if len(m.Images) == 0 {
+		return nil, fmt.Errorf("invalid release manifest: no images")
+	}
+	seen := map[string]bool{}
+	for i := range m.Images {
+		image := &m.Images[i]
+		if !namePattern.MatchString(image.Name) {
+			return nil, fmt.Errorf("invalid release manifest: invalid image name %q, expected lowercase letters and digits separated by periods, dashes or underscores", image.Name)
+		}
+		if seen[image.Name] {
+			return nil, fmt.Errorf("invalid release manifest: duplicate image %s", image.Name)
+		}
+		seen[image.Name] = true
+		if image.Context == "" {
+			image.Context = "."
+		}
+		if image.Dockerfile == "" {
+			image.Dockerfile = filepath.Join(image.Context, "Dockerfile")
+		}
+	}
+	return &m, nil
+}
+
+// Repository returns the repository of the image, named after the image
+// below repo.
+func (i Image) Repository(repo string) string {
+	return strings.TrimSuffix(repo, "/") + "/" + i.Name
+}
+
+// Repositories returns the repositories of the images of the manifest.
+func (m *Manifest) Repositories(repo string) []string {
+	var repos []string
+	for _, image := range m.Images {
+		repos = append(repos, image.Repository(repo))
+	}
+	return repos
+}
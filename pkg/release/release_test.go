@@ -0,0 +1,49 @@
+package release
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	m, err := Parse([]byte(`
+images:
+  - name: app
+  - name: migrations
+    context: db
+    args: [TOOL=flyway]
+  - name: sidecar
+    context: sidecar
+    dockerfile: sidecar/Dockerfile.prod
+    target: prod
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Image{
+		{Name: "app", Context: ".", Dockerfile: "Dockerfile"},
+		{Name: "migrations", Context: "db", Dockerfile: "db/Dockerfile", Args: []string{"TOOL=flyway"}},
+		{Name: "sidecar", Context: "sidecar", Dockerfile: "sidecar/Dockerfile.prod", Target: "prod"},
+	}
+	if diff := cmp.Diff(want, m.Images); diff != "" {
+		t.Errorf("images mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"gcr.io/p/app", "gcr.io/p/migrations", "gcr.io/p/sidecar"}, m.Repositories("gcr.io/p/")); diff != "" {
+		t.Errorf("repositories mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	for _, content := range []string{
+		`images: []`,
+		`images: [{name: App}]`,
+		`images: [{name: app}, {name: app}]`,
+		`images: [{name: app, dockerfil: Dockerfile}]`,
+		`images: [{}]`,
+	} {
+		if _, err := Parse([]byte(content)); err == nil {
+			t.Errorf("Parse(%q) error = nil", content)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Package repotemplate expands repository name templates, e.g.
+// team/{{.RepoName}}, with the Drone metadata of the build, so that a single
+// pipeline template can be shared across repositories.
+package repotemplate
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Metadata is the Drone metadata available to templates.
+type Metadata struct {
+	Repo        string // e.g. octocat/hello-world
+	RepoOwner   string // e.g. octocat
+	RepoName    string // e.g. hello-world
+	Branch      string
+	Tag         string
+	CommitSha   string
+	BuildNumber string
+	Event       string
+}
+
+var funcs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"trunc": func(n int, s string) string {
+		if len(s) > n {
+			return s[:n]
+		}
+		return s
+	},
+}
+
+// Expand expands the template in repo with the metadata. Repositories
+// without template are returned unchanged.
+func Expand(repo string, m Metadata) (string, error) {
+	if !strings.Contains(repo, "{{") {
+		return repo, nil
+	}
+	t, err := template.New("repo").Funcs(funcs).Option("missingkey=error").Parse(repo)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("invalid repository template %s", repo))
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, m); err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to expand repository template %s", repo))
+	}
+	expanded := b.String()
+	for _, segment := range strings.Split(expanded, "/") {
+		if segment == "" {
+			return "", fmt.Errorf("repository template %s expands to %s, which has an empty path segment", repo, expanded)
+		}
+	}
+	return expanded, nil
+}
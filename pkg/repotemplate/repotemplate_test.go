@@ -0,0 +1,30 @@
+package repotemplate
+
+import "testing"
+
+func TestExpand(t *testing.T) {
+	m := Metadata{Repo: "Octocat/Hello-World", RepoOwner: "Octocat", RepoName: "Hello-World", Branch: "feature/login"}
+	tests := []struct {
+		repo    string
+		want    string
+		wantErr bool
+	}{
+		{repo: "team/app", want: "team/app"},
+		{repo: "team/{{.RepoName | lower}}", want: "team/hello-world"},
+		{repo: "{{lower .Repo}}", want: "octocat/hello-world"},
+		{repo: "{{lower .RepoOwner}}/{{lower .RepoName}}-{{replace \"/\" \"-\" .Branch}}", want: "octocat/hello-world-feature-login"},
+		{repo: "team/{{.Tag}}", wantErr: true},
+		{repo: "team/{{.Unknown}}", wantErr: true},
+		{repo: "team/{{.RepoName", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := Expand(test.repo, m)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Expand(%q) error = %v, wantErr %v", test.repo, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Expand(%q) = %q, want %q", test.repo, got, test.want)
+		}
+	}
+}
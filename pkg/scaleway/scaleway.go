@@ -0,0 +1,153 @@
+// Package scaleway derives the regional endpoints of the Scaleway Container
+// Registry and creates registry namespaces through the Scaleway API.
+package scaleway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultEndpoint is the Scaleway API endpoint.
+	DefaultEndpoint string = "https://api.scaleway.com"
+
+	// DefaultRegion is the region of the registry when none is set.
+	DefaultRegion string = "fr-par"
+
+	// TokenUsername is the docker username that accompanies secret keys.
+	TokenUsername string = "nologin"
+)
+
+// regions are the regions offering the Container Registry.
+var regions = map[string]bool{"fr-par": true, "nl-ams": true, "pl-waw": true}
+
+// Registry returns the registry endpoint of the region, rg.<region>.scw.cloud.
+func Registry(region string) (string, error) {
+	region = strings.ToLower(strings.TrimSpace(region))
+	if !regions[region] {
+		var names []string
+		for name := range regions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("invalid region %q, must be one of %s", region, strings.Join(names, ", "))
+	}
+	return "rg." + region + ".scw.cloud", nil
+}
+
+// Namespace returns the registry namespace of repo, given as
+// <namespace>/<image>.
+func Namespace(repo string) (string, error) {
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid repository %s, expected <namespace>/<image>", repo)
+	}
+	return parts[0], nil
+}
+
+// Client calls the Container Registry API with a secret key.
+type Client struct {
+	HTTPClient *http.Client
+	Endpoint   string // API endpoint, defaults to DefaultEndpoint
+	SecretKey  string // Secret key of an API key with registry permissions
+	UserAgent  string // User-Agent of API requests
+}
+
+// namespace is a registry namespace as returned by the API.
+type namespace struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// EnsureNamespace creates the private namespace in the project of the region
+// unless it exists. It reports whether the namespace was created.
+func (c *Client) EnsureNamespace(ctx context.Context, region, name, projectID string) (bool, error) {
+	path := fmt.Sprintf("/registry/v1/regions/%s/namespaces", url.PathEscape(region))
+	query := url.Values{"name": {name}}
+	if projectID != "" {
+		query.Set("project_id", projectID)
+	}
+	var list struct {
+		Namespaces []namespace `json:"namespaces"`
+	}
+	if err := c.do(ctx, http.MethodGet, path+"?"+query.Encode(), nil, &list); err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to list namespaces %s", name))
+	}
+	// The name filter matches names containing the name
+	for _, ns := range list.Namespaces {
+		if ns.Name == name {
+			return false, nil
+		}
+	}
+
+	if projectID == "" {
+		return false, fmt.Errorf("project id must be specified to create namespace %s", name)
+	}
+	body := map[string]interface{}{"name": name, "project_id": projectID, "is_public": false}
+	if err := c.do(ctx, http.MethodPost, path, body, nil); err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to create namespace %s", name))
+	}
+	return true, nil
+}
+
+// do sends the request with the JSON body, if any, and decodes the response
+// into out, if set.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/") + path
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", c.SecretKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(b)))
+	}
+	if out != nil {
+		if err := json.Unmarshal(b, out); err != nil {
+			return errors.Wrap(err, "failed to decode response")
+		}
+	}
+	return nil
+}
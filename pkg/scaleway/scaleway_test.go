@@ -0,0 +1,83 @@
+package scaleway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	tests := []struct {
+		region  string
+		want    string
+		wantErr bool
+	}{
+		{region: "fr-par", want: "rg.fr-par.scw.cloud"},
+		{region: "NL-AMS", want: "rg.nl-ams.scw.cloud"},
+		{region: "", wantErr: true},
+		{region: "us-east-1", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := Registry(test.region)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("Registry(%q) = %q, %v, want %q", test.region, got, err, test.want)
+		}
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	if got, err := Namespace("team/app/api"); err != nil || got != "team" {
+		t.Errorf("Namespace() = %q, %v", got, err)
+	}
+	if _, err := Namespace("app"); err == nil {
+		t.Error("expected error for repository without namespace")
+	}
+}
+
+func TestClient_EnsureNamespace(t *testing.T) {
+	var created map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Auth-Token") != "secret" {
+			http.Error(w, `{"message":"authentication is denied"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/registry/v1/regions/fr-par/namespaces" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			namespaces := `[{"id":"1","name":"team-old"}]`
+			if r.URL.Query().Get("name") == "team" {
+				namespaces = `[{"id":"1","name":"team-old"},{"id":"2","name":"team"}]`
+			}
+			w.Write([]byte(`{"namespaces":` + namespaces + `}`))
+		case http.MethodPost:
+			json.NewDecoder(r.Body).Decode(&created)
+			w.Write([]byte(`{"id":"3","name":"new"}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{Endpoint: srv.URL, SecretKey: "secret"}
+	ctx := context.Background()
+	if ok, err := c.EnsureNamespace(ctx, "fr-par", "team", "project"); err != nil || ok {
+		t.Errorf("EnsureNamespace() of existing namespace = %v, %v", ok, err)
+	}
+	if ok, err := c.EnsureNamespace(ctx, "fr-par", "team-o", "project"); err != nil || !ok {
+		t.Errorf("EnsureNamespace() of missing namespace = %v, %v", ok, err)
+	}
+	if created["name"] != "team-o" || created["project_id"] != "project" || created["is_public"] != false {
+		t.Errorf("unexpected namespace created: %v", created)
+	}
+	if _, err := c.EnsureNamespace(ctx, "fr-par", "other", ""); err == nil {
+		t.Error("expected error creating a namespace without project id")
+	}
+
+	c.SecretKey = "invalid"
+	if _, err := c.EnsureNamespace(ctx, "fr-par", "team", "project"); err == nil {
+		t.Error("expected error with invalid secret key")
+	}
+}
@@ -0,0 +1,143 @@
+// Package scm reports build results back to GitHub and GitLab pull
+// requests and commits.
+package scm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Supported providers
+	GitHub string = "github"
+	GitLab string = "gitlab"
+
+	// DefaultGitHubURL is the API endpoint of github.com.
+	DefaultGitHubURL string = "https://api.github.com"
+	// DefaultGitLabURL is the API endpoint of gitlab.com.
+	DefaultGitLabURL string = "https://gitlab.com/api/v4"
+)
+
+// Client posts comments and commit statuses with a personal or project
+// access token.
+type Client struct {
+	HTTPClient *http.Client
+	Provider   string // GitHub or GitLab
+	URL        string // API endpoint, defaults to the one of the provider's public instance
+	Token      string
+	UserAgent  string
+}
+
+// NewClient returns a client for the provider, which must be GitHub or
+// GitLab.
+func NewClient(provider, apiURL, token string) (*Client, error) {
+	switch provider {
+	case GitHub, GitLab:
+	default:
+		return nil, fmt.Errorf("unsupported scm provider %s, must be %s or %s", provider, GitHub, GitLab)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("%s token must be specified", provider)
+	}
+	return &Client{Provider: provider, URL: apiURL, Token: token}, nil
+}
+
+// DetectProvider guesses the provider from the repository link.
+func DetectProvider(repoLink string) string {
+	u, err := url.Parse(repoLink)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case strings.Contains(u.Host, "github"):
+		return GitHub
+	case strings.Contains(u.Host, "gitlab"):
+		return GitLab
+	}
+	return ""
+}
+
+// Status is a commit status.
+type Status struct {
+	State       string // success, failure or pending
+	Context     string // Name of the status
+	Description string
+	TargetURL   string
+}
+
+// Comment comments on pull request, or merge request, number pr of the
+// repository, e.g. octocat/hello-world.
+func (c *Client) Comment(ctx context.Context, repo string, pr int, body string) error {
+	if c.Provider == GitLab {
+		return c.post(ctx, fmt.Sprintf("/projects/%s/merge_requests/%d/notes", url.PathEscape(repo), pr), map[string]string{"body": body})
+	}
+	return c.post(ctx, fmt.Sprintf("/repos/%s/issues/%d/comments", repo, pr), map[string]string{"body": body})
+}
+
+// SetStatus sets the status of commit sha of the repository.
+func (c *Client) SetStatus(ctx context.Context, repo, sha string, status Status) error {
+	if c.Provider == GitLab {
+		return c.post(ctx, fmt.Sprintf("/projects/%s/statuses/%s", url.PathEscape(repo), sha), map[string]string{
+			"state":       status.State,
+			"name":        status.Context,
+			"description": status.Description,
+			"target_url":  status.TargetURL,
+		})
+	}
+	return c.post(ctx, fmt.Sprintf("/repos/%s/statuses/%s", repo, sha), map[string]string{
+		"state":       status.State,
+		"context":     status.Context,
+		"description": status.Description,
+		"target_url":  status.TargetURL,
+	})
+}
+
+func (c *Client) post(ctx context.Context, path string, v interface{}) error {
+	base := c.URL
+	if base == "" {
+		base = DefaultGitHubURL
+		if c.Provider == GitLab {
+			base = DefaultGitLabURL
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimSuffix(base, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Provider == GitLab {
+		req.Header.Set("PRIVATE-TOKEN", c.Token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+		req.Header.Set("Accept", "application/vnd.github+json")
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package scm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type request struct {
+	Path string
+	Auth string
+	Body map[string]string
+}
+
+func serve(t *testing.T, requests *[]request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := request{Path: r.URL.EscapedPath(), Auth: r.Header.Get("Authorization") + r.Header.Get("PRIVATE-TOKEN")}
+		if err := json.NewDecoder(r.Body).Decode(&req.Body); err != nil {
+			t.Fatal(err)
+		}
+		*requests = append(*requests, req)
+		w.WriteHeader(http.StatusCreated)
+	}))
+}
+
+func TestClient(t *testing.T) {
+	status := Status{State: "success", Context: "kaniko", Description: "pushed", TargetURL: "https://drone.example.com/1"}
+	tests := []struct {
+		provider string
+		want     []request
+	}{
+		{
+			provider: GitHub,
+			want: []request{
+				{Path: "/repos/octocat/app/issues/7/comments", Auth: "Bearer token", Body: map[string]string{"body": "pushed"}},
+				{Path: "/repos/octocat/app/statuses/abc", Auth: "Bearer token", Body: map[string]string{
+					"state": "success", "context": "kaniko", "description": "pushed", "target_url": "https://drone.example.com/1",
+				}},
+			},
+		},
+		{
+			provider: GitLab,
+			want: []request{
+				{Path: "/projects/octocat%2Fapp/merge_requests/7/notes", Auth: "token", Body: map[string]string{"body": "pushed"}},
+				{Path: "/projects/octocat%2Fapp/statuses/abc", Auth: "token", Body: map[string]string{
+					"state": "success", "name": "kaniko", "description": "pushed", "target_url": "https://drone.example.com/1",
+				}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.provider, func(t *testing.T) {
+			var got []request
+			srv := serve(t, &got)
+			defer srv.Close()
+
+			c, err := NewClient(test.provider, srv.URL, "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Comment(context.Background(), "octocat/app", 7, "pushed"); err != nil {
+				t.Fatalf("Comment failed: %s", err)
+			}
+			if err := c.SetStatus(context.Background(), "octocat/app", "abc", status); err != nil {
+				t.Fatalf("SetStatus failed: %s", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("requests mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	if _, err := NewClient("bitbucket", "", "token"); err == nil {
+		t.Error("expected error for unsupported provider")
+	}
+	if _, err := NewClient(GitHub, "", ""); err == nil {
+		t.Error("expected error without token")
+	}
+}
+
+func TestDetectProvider(t *testing.T) {
+	for link, want := range map[string]string{
+		"https://github.com/octocat/app":      GitHub,
+		"https://gitlab.example.com/team/app": GitLab,
+		"https://bitbucket.org/team/app":      "",
+	} {
+		if got := DetectProvider(link); got != want {
+			t.Errorf("DetectProvider(%s) = %q, want %q", link, got, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package secretscan
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/glob"
+)
+
+// maxFileSize limits how much of each file is scanned.
+const maxFileSize int64 = 10 << 20
+
+type (
+	// Rule is a high-confidence secret pattern.
+	Rule struct {
+		Name    string
+		Pattern *regexp.Regexp
+	}
+
+	// Finding is a secret found in a file of an image layer. The secret
+	// itself is never reported.
+	Finding struct {
+		Layer string // Digest of the layer
+		Path  string // Path of the file within the layer
+		Rule  string // Name of the matching rule
+	}
+
+	// Scanner scans image layers for secrets.
+	Scanner struct {
+		Rules  []Rule
+		Ignore []string // Globs of file paths that are not scanned
+	}
+)
+
+// DefaultRules match well known credential formats with few false positives.
+var DefaultRules = []Rule{
+	{"aws-access-key-id", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|ENCRYPTED|PGP) )?PRIVATE KEY( BLOCK)?-----`)},
+	{"github-token", regexp.MustCompile(`\b(ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{36}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[baprs]-[0-9A-Za-z-]{10,}\b`)},
+	{"gcp-service-account-key", regexp.MustCompile(`"type":\s*"service_account"`)},
+}
+
+// New returns a scanner using the default rules.
+func New(ignore []string) *Scanner {
+	return &Scanner{Rules: DefaultRules, Ignore: ignore}
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s in /%s (layer %s)", f.Rule, f.Path, f.Layer)
+}
+
+// ScanLayer scans a tar, optionally gzip compressed, image layer.
+func (s *Scanner) ScanLayer(digest string, r io.Reader) ([]Finding, error) {
+	br := bufio.NewReader(r)
+	var layer io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		layer = gz
+	}
+
+	var findings []Finding
+	tr := tar.NewReader(layer)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return findings, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer %s: %s", digest, err)
+		}
+		path := strings.TrimPrefix(strings.TrimPrefix(hdr.Name, "./"), "/")
+		if hdr.Typeflag != tar.TypeReg || glob.MatchAny(s.Ignore, path) {
+			continue
+		}
+		content, err := ioutil.ReadAll(io.LimitReader(tr, maxFileSize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s in layer %s: %s", path, digest, err)
+		}
+		for _, rule := range s.Rules {
+			if rule.Pattern.Match(content) {
+				findings = append(findings, Finding{Layer: digest, Path: path, Rule: rule.Name})
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Package settings validates the plugin settings passed by Drone as PLUGIN_
+// environment variables.
+package settings
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// Prefix is the prefix of the environment variables of plugin settings.
+const Prefix string = "PLUGIN_"
+
+// Known returns the PLUGIN_ environment variables read by the flags.
+func Known(flags []cli.Flag) map[string]bool {
+	known := map[string]bool{}
+	for _, flag := range flags {
+		for _, name := range envVars(flag) {
+			if strings.HasPrefix(name, Prefix) {
+				known[name] = true
+			}
+		}
+	}
+	return known
+}
+
+// envVars returns the environment variables read by the flag.
+func envVars(flag cli.Flag) []string {
+	v := reflect.Indirect(reflect.ValueOf(flag))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	field := v.FieldByName("EnvVar")
+	if !field.IsValid() || field.Kind() != reflect.String {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(field.String(), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Set sets the flags of the plugin settings named by the keys of values,
+// e.g. password for PLUGIN_PASSWORD, unless they are set already. Keys that
+// no flag reads are an error.
+func Set(c *cli.Context, values map[string]string) error {
+	flags := map[string]string{}
+	for _, flag := range c.App.Flags {
+		for _, name := range envVars(flag) {
+			if strings.HasPrefix(name, Prefix) {
+				flags[name] = strings.TrimSpace(strings.Split(flag.GetName(), ",")[0])
+			}
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name, ok := flags[Prefix+strings.ToUpper(key)]
+		if !ok {
+			return fmt.Errorf("unknown setting %s", key)
+		}
+		if c.IsSet(name) {
+			continue
+		}
+		if err := c.Set(name, values[key]); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("invalid setting %s", key))
+		}
+	}
+	return nil
+}
+
+// Values returns the values of the named flags that are set, e.g. the
+// sensitive settings masked in the build output.
+func Values(c *cli.Context, names ...string) []string {
+	var values []string
+	for _, name := range names {
+		if value := c.String(name); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// CheckUnknown fails when environ, as returned by os.Environ, contains
+// PLUGIN_ variables that neither the flags nor the extra names read, such as
+// misspelled settings, suggesting the closest known setting.
+func CheckUnknown(flags []cli.Flag, environ []string, extra ...string) error {
+	known := Known(flags)
+	for _, name := range extra {
+		known[name] = true
+	}
+	var unknown []string
+	for _, kv := range environ {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if !strings.HasPrefix(name, Prefix) || known[name] {
+			continue
+		}
+		if suggestion := closest(name, known); suggestion != "" {
+			name = fmt.Sprintf("%s (did you mean %s?)", name, suggestion)
+		}
+		unknown = append(unknown, name)
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown settings: %s", strings.Join(unknown, ", "))
+}
+
+// closest returns the known name most similar to name, if it is similar
+// enough to be a likely typo.
+func closest(name string, known map[string]bool) string {
+	normalized := strings.Replace(name, "_", "", -1)
+	best, bestDistance := "", 0
+	for candidate := range known {
+		d := distance(normalized, strings.Replace(candidate, "_", "", -1))
+		if best == "" || d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	if bestDistance > 2 {
+		return ""
+	}
+	return best
+}
+
+// distance returns the Levenshtein distance of a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,77 @@
+package settings
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+var flags = []cli.Flag{
+	cli.StringFlag{Name: "repo", EnvVar: "PLUGIN_REPO"},
+	cli.StringFlag{Name: "cache-repo", EnvVar: "PLUGIN_CACHE_REPO"},
+	cli.StringSliceFlag{Name: "tags", EnvVar: "PLUGIN_TAGS,PLUGIN_TAG"},
+	cli.BoolFlag{Name: "enable-cache", EnvVar: "PLUGIN_ENABLE_CACHE"},
+	cli.StringFlag{Name: "drone-repo", EnvVar: "DRONE_REPO"},
+}
+
+func TestCheckUnknown(t *testing.T) {
+	environ := []string{"PLUGIN_REPO=app", "PLUGIN_TAG=latest", "PLUGIN_ENV_FILE=.env", "DRONE_REPO=octocat/app", "HOME=/root"}
+	if err := CheckUnknown(flags, environ, "PLUGIN_ENV_FILE"); err != nil {
+		t.Errorf("CheckUnknown() error = %v", err)
+	}
+
+	err := CheckUnknown(flags, append(environ, "PLUGIN_CACHEREPO=app/cache", "PLUGIN_PUSH_RETRIES=3"), "PLUGIN_ENV_FILE")
+	if err == nil {
+		t.Fatal("CheckUnknown() with unknown settings error = nil")
+	}
+	want := "unknown settings: PLUGIN_CACHEREPO (did you mean PLUGIN_CACHE_REPO?), PLUGIN_PUSH_RETRIES"
+	if err.Error() != want {
+		t.Errorf("CheckUnknown() error = %q, want %q", err, want)
+	}
+}
+
+func TestKnown(t *testing.T) {
+	known := Known(flags)
+	for _, name := range []string{"PLUGIN_REPO", "PLUGIN_TAGS", "PLUGIN_TAG", "PLUGIN_ENABLE_CACHE"} {
+		if !known[name] {
+			t.Errorf("Known() misses %s", name)
+		}
+	}
+	if known["DRONE_REPO"] || len(known) != 5 {
+		t.Errorf("Known() = %v", known)
+	}
+	if strings.Contains(closest("PLUGIN_COMPLETELY_DIFFERENT", known), "PLUGIN") {
+		t.Error("closest() suggested an unrelated setting")
+	}
+}
+
+func TestSet(t *testing.T) {
+	t.Setenv("PLUGIN_REPO", "octocat/app")
+	app := cli.NewApp()
+	app.Flags = flags
+	app.Action = func(c *cli.Context) error {
+		if err := Set(c, map[string]string{"repo": "octocat/other", "cache_repo": "octocat/cache", "enable_cache": "true"}); err != nil {
+			return err
+		}
+		if got := c.String("repo"); got != "octocat/app" {
+			t.Errorf("repo = %s, want the setting octocat/app", got)
+		}
+		if got := c.String("cache-repo"); got != "octocat/cache" {
+			t.Errorf("cache-repo = %s, want octocat/cache", got)
+		}
+		if !c.Bool("enable-cache") {
+			t.Error("enable-cache = false, want true")
+		}
+		if err := Set(c, map[string]string{"cachrepo": "octocat/cache"}); err == nil {
+			t.Error("Set() with unknown setting error = nil")
+		}
+		if err := Set(c, map[string]string{"drone_repo": "octocat/app"}); err == nil {
+			t.Error("Set() of a non-plugin setting error = nil")
+		}
+		return nil
+	}
+	if err := app.Run([]string{"plugin"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,178 @@
+package tagger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/semver"
+)
+
+// Metadata is the build information tags are computed from.
+type Metadata struct {
+	Tags          []string // Tags computed so far, initially the configured tags
+	CommitRef     string   // Git commit reference, e.g. refs/tags/v1.2.3
+	CommitSha     string   // Git commit sha
+	DefaultBranch string   // Default branch of the repository
+	BuildNumber   string   // Drone build number
+	BuildEvent    string   // Drone build event, e.g. push, pull_request or tag
+}
+
+// Tagger computes the tags an image is pushed with from the build metadata.
+//
+// The plugins apply the auto-tag and expand-tag taggers, followed by the
+// taggers selected with the taggers setting. Custom tagging schemes are
+// compiled into the plugins by adding a file to the plugin's main package
+// that registers them in an init function:
+//
+//	func init() {
+//		tagger.Register("sha", tagger.Func(func(m tagger.Metadata) ([]string, error) {
+//			return append(m.Tags, m.CommitSha[:8]), nil
+//		}))
+//	}
+type Tagger interface {
+	Tags(m Metadata) ([]string, error)
+}
+
+// Func adapts a function to the Tagger interface.
+type Func func(m Metadata) ([]string, error)
+
+// Tags calls f.
+func (f Func) Tags(m Metadata) ([]string, error) {
+	return f(m)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Tagger{}
+)
+
+// Register makes a tagger available under name. It panics if a tagger is
+// already registered under the name, as it is meant to be called from init
+// functions.
+func Register(name string, t Tagger) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("tagger %s is already registered", name))
+	}
+	registry[name] = t
+}
+
+// Lookup returns the tagger registered under name.
+func Lookup(name string) (Tagger, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	t, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tagger %s, registered taggers: %s", name, strings.Join(names(), ", "))
+	}
+	return t, nil
+}
+
+func names() []string {
+	var out []string
+	for name := range registry {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Chain returns a tagger applying the taggers in order, each one receiving
+// the tags computed by the previous one.
+func Chain(taggers ...Tagger) Tagger {
+	return Func(func(m Metadata) ([]string, error) {
+		for _, t := range taggers {
+			tags, err := t.Tags(m)
+			if err != nil {
+				return nil, err
+			}
+			m.Tags = tags
+		}
+		return m.Tags, nil
+	})
+}
+
+// Auto replaces the tags with the tags detected from the commit reference,
+// see AutoTagsSuffix.
+type Auto struct {
+	Suffix string // Suffix appended to the detected tags
+}
+
+// Tags returns the auto detected tags. See the AutoTag section of
+// https://plugins.drone.io/drone-plugins/drone-docker/ for more info.
+func (a Auto) Tags(m Metadata) ([]string, error) {
+	if len(m.Tags) > 1 || len(m.Tags) == 1 && m.Tags[0] != "latest" {
+		return nil, fmt.Errorf("The auto-tag flag does not work with user provided tags %s", m.Tags)
+	}
+	// We have tried the best to prevent enabling auto-tag and passing in
+	// user specified at the same time. Starts to auto detect tags.
+	// Note: passing in a "latest" tag with auto-tag enabled won't trigger the
+	// early returns above, because we cannot tell if the tag is provided by
+	// the default value or by the users.
+	if !UseAutoTag(m.CommitRef, m.DefaultBranch) {
+		return nil, fmt.Errorf("Could not auto detect the tag. Skipping automated docker build for commit %s", m.CommitRef)
+	}
+	tags, err := AutoTagsSuffix(m.CommitRef, a.Suffix)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid semantic version when auto detecting the tag. Skipping automated docker build for %s.", m.CommitRef)
+	}
+	return tags, nil
+}
+
+// Expand expands semantic version tags into major, major.minor and full
+// version tags, see ExpandTag.
+type Expand struct{}
+
+// Tags returns the expanded tags.
+func (Expand) Tags(m Metadata) ([]string, error) {
+	var tags []string
+	for _, tag := range m.Tags {
+		tags = append(tags, ExpandTag(tag)...)
+	}
+	return tags, nil
+}
+
+// ExpandTag returns the tags of a semantic version tag: major, major.minor
+// and the full version. Other tags are passed through.
+//
+// Build information (e.g. +linux_amd64) is carried through to all labels.
+// Pre-release information (e.g. -rc1) suppresses major and major+minor auto-labels.
+func ExpandTag(tag string) []string {
+	// We strip "v" off of the beginning of semantic versions, as they are not used in docker tags
+	const VersionPrefix = "v"
+
+	// Semantic Versions don't allow underscores, so replace them with dashes.
+	//   https://semver.org/
+	semverTag := strings.ReplaceAll(tag, "_", "-")
+
+	// Allow tags of the form "1.2.3" as well as "v1.2.3" to avoid confusion.
+	if withV := VersionPrefix + semverTag; !semver.IsValid(semverTag) && semver.IsValid(withV) {
+		semverTag = withV
+	}
+
+	// Pass through tags that are not semantic versions
+	if !semver.IsValid(semverTag) {
+		return []string{tag}
+	}
+	tag = semverTag
+
+	// If the version is pre-release, only the full release should be tagged, not the major/minor versions.
+	if semver.Prerelease(tag) != "" {
+		return []string{
+			strings.TrimPrefix(tag, VersionPrefix),
+		}
+	}
+
+	// tagFor carries any build information from the semantic version through to major and minor tags.
+	labelFor := func(base string) string {
+		return strings.TrimPrefix(base, VersionPrefix) + semver.Build(tag)
+	}
+	return []string{
+		labelFor(semver.Major(tag)),
+		labelFor(semver.MajorMinor(tag)),
+		labelFor(semver.Canonical(tag)),
+	}
+}
@@ -0,0 +1,68 @@
+package tagger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChain(t *testing.T) {
+	suffix := Func(func(m Metadata) ([]string, error) {
+		var tags []string
+		for _, tag := range m.Tags {
+			tags = append(tags, tag+"-"+m.BuildNumber)
+		}
+		return tags, nil
+	})
+	fail := Func(func(m Metadata) ([]string, error) {
+		return nil, fmt.Errorf("failed")
+	})
+
+	tests := []struct {
+		name    string
+		tagger  Tagger
+		meta    Metadata
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", tagger: Chain(), meta: Metadata{Tags: []string{"latest"}}, want: []string{"latest"}},
+		{name: "auto then suffix", tagger: Chain(Auto{}, suffix), meta: Metadata{Tags: []string{"latest"}, CommitRef: "refs/tags/v1.2.3", BuildNumber: "7"}, want: []string{"1-7", "1.2-7", "1.2.3-7"}},
+		{name: "expand", tagger: Chain(Expand{}), meta: Metadata{Tags: []string{"v1.2.3", "edge"}}, want: []string{"1", "1.2", "1.2.3", "edge"}},
+		{name: "auto with user tags", tagger: Chain(Auto{}), meta: Metadata{Tags: []string{"1.0"}, CommitRef: "refs/tags/v1.2.3"}, wantErr: true},
+		{name: "failing tagger", tagger: Chain(suffix, fail), meta: Metadata{Tags: []string{"latest"}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.tagger.Tags(test.meta)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Tags() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Tags() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	latest := Func(func(m Metadata) ([]string, error) {
+		return []string{"latest"}, nil
+	})
+	Register("test-latest", latest)
+	defer delete(registry, "test-latest")
+
+	if _, err := Lookup("test-latest"); err != nil {
+		t.Errorf("Lookup() error = %v", err)
+	}
+	if _, err := Lookup("test-unknown"); err == nil {
+		t.Errorf("Lookup() of an unregistered tagger did not fail")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Register() of a registered name did not panic")
+		}
+	}()
+	Register("test-latest", latest)
+}
@@ -0,0 +1,41 @@
+package useragent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Info identifies the plugin and the Drone build in User-Agent headers so
+// registry operators can trace CI traffic.
+type Info struct {
+	Name    string // Plugin name, e.g. drone-kaniko-ecr
+	Version string // Plugin version
+	Repo    string // Drone repository, e.g. octocat/hello-world
+	Build   string // Drone build number
+	Suffix  string // Organization specific suffix
+}
+
+// String formats the info as a User-Agent value such as
+// "drone-kaniko-ecr/1.0.0 (repo=octocat/hello-world; build=42) acme-ci".
+func (i Info) String() string {
+	version := i.Version
+	if version == "" {
+		version = "unknown"
+	}
+	ua := fmt.Sprintf("%s/%s", i.Name, version)
+
+	var details []string
+	if i.Repo != "" {
+		details = append(details, "repo="+i.Repo)
+	}
+	if i.Build != "" {
+		details = append(details, "build="+i.Build)
+	}
+	if len(details) > 0 {
+		ua += " (" + strings.Join(details, "; ") + ")"
+	}
+	if suffix := strings.TrimSpace(i.Suffix); suffix != "" {
+		ua += " " + suffix
+	}
+	return ua
+}
@@ -0,0 +1,19 @@
+package useragent
+
+import "testing"
+
+func TestInfo_String(t *testing.T) {
+	tests := []struct {
+		info Info
+		want string
+	}{
+		{Info{Name: "drone-kaniko-docker"}, "drone-kaniko-docker/unknown"},
+		{Info{Name: "drone-kaniko-ecr", Version: "1.0.0", Repo: "octocat/hello-world", Build: "42"}, "drone-kaniko-ecr/1.0.0 (repo=octocat/hello-world; build=42)"},
+		{Info{Name: "drone-kaniko-gcr", Version: "1.0.0", Build: "7", Suffix: " acme-ci "}, "drone-kaniko-gcr/1.0.0 (build=7) acme-ci"},
+	}
+	for _, tt := range tests {
+		if got := tt.info.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Package vault reads plugin settings, e.g. registry credentials or cloud
+// keys, from HashiCorp Vault secrets, so that they don't have to be stored
+// as Drone secrets.
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/settings"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// Client reads secrets from Vault with a token.
+type Client struct {
+	HTTPClient *http.Client
+	Address    string // Vault address, e.g. https://vault.example.com:8200
+	Token      string
+	Namespace  string // Enterprise namespace, if any
+	UserAgent  string // User-Agent of API requests
+}
+
+// Read returns the data of the secret at path, e.g. secret/data/drone/registry
+// of a KV version 2 engine mounted at secret, or kv/drone/registry of a KV
+// version 1 engine. Values other than strings are returned as JSON.
+func (c *Client) Read(ctx context.Context, path string) (map[string]string, error) {
+	if c.Address == "" || c.Token == "" {
+		return nil, fmt.Errorf("vault address and token must be specified to read %s", path)
+	}
+	endpoint := strings.TrimSuffix(c.Address, "/") + "/v1/" + strings.Trim(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", c.Token)
+	if c.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read vault secret %s", path))
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read vault secret %s: %s: %s", path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &secret); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to decode vault secret %s", path))
+	}
+	data := secret.Data
+	// KV version 2 nests the data next to its metadata
+	if _, ok := data["metadata"]; ok && data["data"] != nil {
+		data = nil
+		if err := json.Unmarshal(secret.Data["data"], &data); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to decode vault secret %s", path))
+		}
+	}
+	values := map[string]string{}
+	for key, raw := range data {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			s = string(raw)
+		}
+		values[key] = s
+	}
+	return values, nil
+}
+
+// LoadSettings sets the plugin settings named by the keys of the vault-path
+// secret, e.g. username and password, or access_key and secret_key, unless
+// they are set otherwise.
+func LoadSettings(ctx context.Context, c *cli.Context, userAgent string) error {
+	path := c.String("vault-path")
+	if path == "" {
+		return nil
+	}
+	client := &Client{
+		Address:   c.String("vault-addr"),
+		Token:     c.String("vault-token"),
+		Namespace: c.String("vault-namespace"),
+		UserAgent: userAgent,
+	}
+	values, err := client.Read(ctx, path)
+	if err != nil {
+		return err
+	}
+	if err := settings.Set(c, values); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("invalid vault secret %s", path))
+	}
+	fmt.Printf("Loaded settings from vault secret %s\n", path)
+	return nil
+}
@@ -0,0 +1,56 @@
+package vault
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClient_Read(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" || r.Header.Get("X-Vault-Namespace") != "ci" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/drone/registry":
+			w.Write([]byte(`{"data":{"data":{"username":"robot","password":"s3cret","json_key":{"type":"service_account"}},"metadata":{"version":3}}}`))
+		case "/v1/kv/drone/registry":
+			w.Write([]byte(`{"data":{"username":"robot","pull_retry":3}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{Address: srv.URL + "/", Token: "s.token", Namespace: "ci"}
+	ctx := context.Background()
+	got, err := c.Read(ctx, "secret/data/drone/registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"username": "robot", "password": "s3cret", "json_key": `{"type":"service_account"}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() of KV v2 secret = %v, want %v", got, want)
+	}
+	got, err = c.Read(ctx, "/kv/drone/registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"username": "robot", "pull_retry": "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() of KV v1 secret = %v, want %v", got, want)
+	}
+
+	if _, err := c.Read(ctx, "secret/data/missing"); err == nil {
+		t.Error("Read() of missing secret error = nil")
+	}
+	c.Token = "s.other"
+	if _, err := c.Read(ctx, "kv/drone/registry"); err == nil {
+		t.Error("Read() with invalid token error = nil")
+	}
+	if _, err := (&Client{Address: srv.URL}).Read(ctx, "kv/drone/registry"); err == nil {
+		t.Error("Read() without token error = nil")
+	}
+}
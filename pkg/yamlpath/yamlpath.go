@@ -0,0 +1,148 @@
+// Package yamlpath sets values in YAML documents addressed by simple paths,
+// keeping comments and the order of keys.
+//
+// A path is a dot separated list of map keys. A key may be followed by a
+// selector picking an element of a list, either by index, e.g.
+// containers[0], or by the value of a field, e.g. images[name=app].
+package yamlpath
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type segment struct {
+	key   string
+	index int    // List index, -1 if the segment selects no list element
+	field string // Field selecting a list element by value
+	value string
+}
+
+func parse(path string) ([]segment, error) {
+	var segments []segment
+	for _, part := range strings.Split(path, ".") {
+		seg := segment{key: part, index: -1}
+		if i := strings.Index(part, "["); i >= 0 {
+			if !strings.HasSuffix(part, "]") {
+				return nil, fmt.Errorf("invalid path %s: unterminated selector", path)
+			}
+			seg.key = part[:i]
+			selector := part[i+1 : len(part)-1]
+			if kv := strings.SplitN(selector, "=", 2); len(kv) == 2 {
+				seg.field, seg.value = kv[0], kv[1]
+			} else if n, err := strconv.Atoi(selector); err == nil && n >= 0 {
+				seg.index = n
+			} else {
+				return nil, fmt.Errorf("invalid path %s: invalid selector %s", path, selector)
+			}
+		}
+		if seg.key == "" {
+			return nil, fmt.Errorf("invalid path %s: empty key", path)
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// Update sets each path of the document to its value and returns the
+// re-encoded document. Missing map keys are created; list selectors must
+// match an existing element.
+func Update(content []byte, values map[string]string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		segments, err := parse(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := set(doc.Content[0], segments, values[path]); err != nil {
+			return nil, fmt.Errorf("failed to set %s: %s", path, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func set(node *yaml.Node, segments []segment, value string) error {
+	seg := segments[0]
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not below a map", seg.key)
+	}
+
+	var child *yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == seg.key {
+			child = node.Content[i+1]
+			break
+		}
+	}
+	if child == nil {
+		if seg.index >= 0 || seg.field != "" {
+			return fmt.Errorf("list %s not found", seg.key)
+		}
+		child = &yaml.Node{Kind: yaml.MappingNode}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: seg.key}, child)
+	}
+
+	if seg.index >= 0 || seg.field != "" {
+		element, err := selectElement(child, seg)
+		if err != nil {
+			return err
+		}
+		if len(segments) == 1 {
+			*element = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+			return nil
+		}
+		return set(element, segments[1:], value)
+	}
+	if len(segments) == 1 {
+		*child = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, LineComment: child.LineComment}
+		return nil
+	}
+	return set(child, segments[1:], value)
+}
+
+func selectElement(list *yaml.Node, seg segment) (*yaml.Node, error) {
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s is not a list", seg.key)
+	}
+	if seg.index >= 0 {
+		if seg.index >= len(list.Content) {
+			return nil, fmt.Errorf("%s has no element %d", seg.key, seg.index)
+		}
+		return list.Content[seg.index], nil
+	}
+	for _, element := range list.Content {
+		if element.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(element.Content); i += 2 {
+			if element.Content[i].Value == seg.field && element.Content[i+1].Value == seg.value {
+				return element, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s has no element with %s=%s", seg.key, seg.field, seg.value)
+}
@@ -0,0 +1,65 @@
+package yamlpath
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		values  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "nested key",
+			in:     "# image\nimage:\n  tag: latest # set by CI\n",
+			values: map[string]string{"image.tag": "v1"},
+			want:   "# image\nimage:\n  tag: v1 # set by CI\n",
+		},
+		{
+			name:   "missing keys",
+			in:     "replicas: 1\n",
+			values: map[string]string{"image.digest": "sha256:abcd"},
+			want:   "replicas: 1\nimage:\n  digest: sha256:abcd\n",
+		},
+		{
+			name:   "select by field",
+			in:     "images:\n  - name: other\n    newTag: v0\n  - name: app\n    newTag: v0\n",
+			values: map[string]string{"images[name=app].digest": "sha256:abcd"},
+			want:   "images:\n  - name: other\n    newTag: v0\n  - name: app\n    newTag: v0\n    digest: sha256:abcd\n",
+		},
+		{
+			name:   "select by index",
+			in:     "spec:\n  containers:\n    - image: app:v0\n",
+			values: map[string]string{"spec.containers[0].image": "app@sha256:abcd"},
+			want:   "spec:\n  containers:\n    - image: app@sha256:abcd\n",
+		},
+		{
+			name:    "missing element",
+			in:      "images:\n  - name: other\n",
+			values:  map[string]string{"images[name=app].digest": "sha256:abcd"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid selector",
+			in:      "images: []\n",
+			values:  map[string]string{"images[x].digest": "sha256:abcd"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Update([]byte(test.in), test.values)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Update() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.want, string(got)); err == nil && diff != "" {
+				t.Errorf("Update() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
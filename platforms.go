@@ -0,0 +1,173 @@
+package kaniko
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/layout"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/pkg/errors"
+)
+
+// validatePlatforms checks that every platform is of the form os/arch or
+// os/arch/variant and that no platform is repeated.
+func (b Build) validatePlatforms() error {
+	seen := map[string]bool{}
+	for _, platform := range b.Platforms {
+		if _, err := registry.ParsePlatform(platform); err != nil {
+			return err
+		}
+		if seen[platform] {
+			return fmt.Errorf("platform %s is specified more than once", platform)
+		}
+		seen[platform] = true
+	}
+	return nil
+}
+
+// platformFields returns the index descriptor fields of the platforms,
+// given as platform:field=value with field variant, os.version or
+// os.features, which can be repeated. Platforms must be built.
+func (b Build) platformFields() (map[string]*registry.Platform, error) {
+	if len(b.PlatformFields) == 0 {
+		return nil, nil
+	}
+	built := map[string]bool{}
+	for _, platform := range b.Platforms {
+		built[platform] = true
+	}
+	fields := map[string]*registry.Platform{}
+	for _, s := range b.PlatformFields {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid platform field %s, expected platform:field=value", s)
+		}
+		platform := parts[0]
+		field := strings.SplitN(parts[1], "=", 2)
+		if len(field) != 2 || field[1] == "" {
+			return nil, fmt.Errorf("invalid platform field %s, expected platform:field=value", s)
+		}
+		if !built[platform] {
+			return nil, fmt.Errorf("platform field %s: platform %s is not built", s, platform)
+		}
+		if fields[platform] == nil {
+			fields[platform] = &registry.Platform{}
+		}
+		switch field[0] {
+		case "variant":
+			fields[platform].Variant = field[1]
+		case "os.version":
+			fields[platform].OSVersion = field[1]
+		case "os.features":
+			fields[platform].OSFeatures = append(fields[platform].OSFeatures, field[1])
+		default:
+			return nil, fmt.Errorf("invalid platform field %s, field must be variant, os.version or os.features", s)
+		}
+	}
+	return fields, nil
+}
+
+// targetPlatformArgs returns the TARGETPLATFORM, TARGETOS, TARGETARCH and
+// TARGETVARIANT build args buildx defines for the platform, so that
+// Dockerfiles written for buildx work unmodified. Explicit build args win.
+func (b Build) targetPlatformArgs(platform string) ([]string, error) {
+	parsed, err := registry.ParsePlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+	values := [][2]string{
+		{"TARGETPLATFORM", parsed.String()},
+		{"TARGETOS", parsed.OS},
+		{"TARGETARCH", parsed.Architecture},
+	}
+	if parsed.Variant != "" {
+		values = append(values, [2]string{"TARGETVARIANT", parsed.Variant})
+	}
+
+	var args []string
+	for _, value := range values {
+		if b.hasArg(value[0]) {
+			continue
+		}
+		args = append(args, fmt.Sprintf("--build-arg=%s=%s", value[0], value[1]))
+	}
+	return args, nil
+}
+
+// hasArg reports whether the build arg name is set explicitly.
+func (b Build) hasArg(name string) bool {
+	for _, arg := range b.Args {
+		if arg == name || strings.HasPrefix(arg, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// buildPlatforms builds the image once per platform, each into its own OCI
+// layout, and then publishes all of them under a single index. The tags are
+// only created once every platform image and the index have been pushed.
+func (p Plugin) buildPlatforms(args []string, tags []string) error {
+	fields, err := p.Build.platformFields()
+	if err != nil {
+		return err
+	}
+	var layouts []*layout.Layout
+	for _, platform := range p.Build.Platforms {
+		path := filepath.Join(layoutPath, strings.Replace(platform, "/", "-", -1))
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+
+		targetArgs, err := p.Build.targetPlatformArgs(platform)
+		if err != nil {
+			return err
+		}
+		platformArgs := append(append(append([]string{}, args...), targetArgs...),
+			fmt.Sprintf("--customPlatform=%s", platform),
+			fmt.Sprintf("--oci-layout-path=%s", path),
+		)
+		fmt.Fprintf(os.Stdout, "Building for platform %s\n", platform)
+		if err := p.runExecutor(platformArgs); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to build for platform %s", platform))
+		}
+
+		l, err := layout.Open(path)
+		if err != nil {
+			return err
+		}
+		if err := p.checkLayout(l); err != nil {
+			return fmt.Errorf("platform %s: %s", platform, err)
+		}
+		l.Platform = fields[platform]
+		layouts = append(layouts, l)
+	}
+
+	if p.Build.NoPush {
+		return nil
+	}
+	annotations, err := p.Build.manifestAnnotations()
+	if err != nil {
+		return err
+	}
+	for _, l := range layouts {
+		if err := p.annotateLayout(l); err != nil {
+			return err
+		}
+	}
+	repo, err := registry.ParseRepository(p.Build.Repo)
+	if err != nil {
+		return err
+	}
+	digest, err := layout.PushIndex(p.stepContext(), p.registryClient(), repo, layouts, tags, annotations)
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %s", repo, err)
+	}
+	for _, tag := range tags {
+		fmt.Fprintf(os.Stdout, "Pushed %s:%s@%s for %s\n", repo, tag, digest, strings.Join(p.Build.Platforms, ", "))
+	}
+	p.writeDigestFile(digest)
+	return nil
+}
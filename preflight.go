@@ -0,0 +1,34 @@
+package kaniko
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// preflightAuth checks that the destination repository can be pushed to and
+// the cache repository, if any, can be pushed to and pulled from, so that
+// credential errors surface before a long build.
+func (p Plugin) preflightAuth() error {
+	var repos []string
+	if !p.Build.NoPush {
+		repos = append(repos, p.Build.Repo)
+	}
+	if p.Build.EnableCache && p.Build.CacheRepo != "" {
+		repos = append(repos, p.Build.CacheRepo)
+	}
+
+	client := p.registryClient()
+	for _, name := range repos {
+		repo, err := registry.ParseRepository(name)
+		if err != nil {
+			return fmt.Errorf("invalid repository %s: %s", name, err)
+		}
+		if err := client.CheckAccess(p.stepContext(), repo, true); err != nil {
+			return fmt.Errorf("registry auth preflight failed for %s: %s", repo, err)
+		}
+		fmt.Fprintf(os.Stdout, "Registry auth preflight succeeded for %s\n", repo)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package kaniko
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/gexops/drone-kaniko/pkg/cosign"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// Copier copies an image between repositories of a registry through the
+// registry's own API, without transferring its blobs. Copy reports false when
+// it cannot copy the image, in which case the image is copied through the
+// registry API.
+type Copier interface {
+	Copy(ctx context.Context, src registry.Repository, srcRef string, dst registry.Repository, tag string) (bool, error)
+}
+
+// promote copies the source image to the destination repository under the
+// destination tags, verifying its signature first when a verify key is set.
+// The promoted image is re-signed when a sign key is set, otherwise the
+// source signature is copied along with the image.
+func (p Plugin) promote() error {
+	if p.Build.NoPush {
+		return fmt.Errorf("the no-push flag conflicts with image promotion")
+	}
+	labels, err := p.Build.DestinationTags()
+	if err != nil {
+		return err
+	}
+	if len(labels) == 0 {
+		return fmt.Errorf("at least one tag must be specified to promote an image")
+	}
+	src, ref, err := registry.ParseReference(p.Promotion.Source)
+	if err != nil {
+		return fmt.Errorf("invalid promotion source %s: %s", p.Promotion.Source, err)
+	}
+	dst, err := registry.ParseRepository(p.Build.Repo)
+	if err != nil {
+		return fmt.Errorf("invalid repository %s: %s", p.Build.Repo, err)
+	}
+
+	ctx := p.stepContext()
+	client := p.registryClient()
+
+	// Resolve tags to a digest so the verified image is the one copied.
+	digest, found, err := client.HeadManifest(ctx, src, ref)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("promotion source %s does not exist", p.Promotion.Source)
+	}
+	if digest == "" {
+		m, err := client.GetManifest(ctx, src, ref)
+		if err != nil {
+			return err
+		}
+		digest = m.Digest
+	}
+	if p.Build.ExpectedDigest != "" && digest != p.Build.ExpectedDigest {
+		return fmt.Errorf("promotion source digest %s does not match the expected digest %s", digest, p.Build.ExpectedDigest)
+	}
+
+	if p.Promotion.VerifyKey != "" {
+		if err := cosign.Verify(p.Promotion.VerifyKey, src.String()+"@"+digest); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "Promoting %s@%s to %s\n", src, digest, dst)
+	for _, label := range labels {
+		if err := p.copyImage(ctx, client, src, digest, dst, label); err != nil {
+			return fmt.Errorf("failed to promote %s@%s to %s:%s: %s", src, digest, dst, label, err)
+		}
+		fmt.Fprintf(os.Stdout, "Tagged %s:%s\n", dst, label)
+	}
+
+	if p.Promotion.SignKey != "" {
+		if err := cosign.Sign(p.Promotion.SignKey, dst.String()+"@"+digest); err != nil {
+			return err
+		}
+	} else if p.Promotion.VerifyKey != "" {
+		sig := cosign.SignatureTag(digest)
+		if err := p.copyImage(ctx, client, src, sig, dst, sig); err != nil {
+			return fmt.Errorf("failed to copy signature %s: %s", sig, err)
+		}
+	}
+
+	if p.Build.DigestFile != "" {
+		if err := ioutil.WriteFile(p.Build.DigestFile, []byte(digest), 0644); err != nil {
+			p.warnf("failed to write digest file at path: %s with error: %s\n", p.Build.DigestFile, err)
+		}
+	}
+	p.exportDigest()
+	p.publishArtifact()
+	return nil
+}
+
+// copyImage copies the image with the Copier of the promotion, falling back
+// to the registry API when there is none or it cannot copy the image.
+func (p Plugin) copyImage(ctx context.Context, client *registry.Client, src registry.Repository, srcRef string, dst registry.Repository, tag string) error {
+	if p.Promotion.Copier != nil {
+		copied, err := p.Promotion.Copier.Copy(ctx, src, srcRef, dst, tag)
+		if err != nil || copied {
+			return err
+		}
+	}
+	_, err := client.Copy(ctx, src, srcRef, dst, tag)
+	return err
+}
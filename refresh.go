@@ -0,0 +1,38 @@
+package kaniko
+
+import (
+	"sync"
+	"time"
+)
+
+// startCredentialRefresh calls CredentialRefresh every
+// CredentialRefreshInterval in the background, so that the registry
+// credentials kaniko pushes with don't expire during long builds, until the
+// returned function is called.
+func (p Plugin) startCredentialRefresh() (stop func()) {
+	if p.CredentialRefresh == nil || p.CredentialRefreshInterval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(p.CredentialRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := p.CredentialRefresh(p.stepContext()); err != nil {
+					p.warnf("failed to refresh registry credentials: %s\n", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
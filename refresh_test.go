@@ -0,0 +1,39 @@
+package kaniko
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartCredentialRefresh(t *testing.T) {
+	var calls int32
+	p := Plugin{
+		CredentialRefresh: func(context.Context) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return fmt.Errorf("expired")
+			}
+			return nil
+		},
+		CredentialRefreshInterval: time.Millisecond,
+		result:                    newResult(),
+	}
+	stop := p.startCredentialRefresh()
+	for atomic.LoadInt32(&calls) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	n := atomic.LoadInt32(&calls)
+	time.Sleep(5 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != n {
+		t.Errorf("refreshed %d times after stop", got-n)
+	}
+	if len(p.result.Warnings) != 1 {
+		t.Errorf("warnings = %q, want the failed refresh", p.result.Warnings)
+	}
+
+	// Without refresh function, stop is a no-op
+	Plugin{CredentialRefreshInterval: time.Millisecond}.startCredentialRefresh()()
+}
@@ -0,0 +1,73 @@
+package kaniko
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gexops/drone-kaniko/pkg/artifact"
+	"github.com/gexops/drone-kaniko/pkg/release"
+)
+
+// execRelease builds the images of the release manifest in order, each
+// pushed with the tags of the plugin to a repository named after the image
+// below the configured repository. A single artifact listing the digests of
+// all images is published once every image is pushed, so deployment tooling
+// can roll out the release as a whole.
+func (p Plugin) execRelease() error {
+	if p.Build.Discover || len(p.Build.TagArgs) != 0 || p.Promotion.Source != "" {
+		return fmt.Errorf("release manifests are not supported with discover, tag build args or image promotion")
+	}
+	manifest, err := release.Load(p.Build.Release)
+	if err != nil {
+		return err
+	}
+
+	var images []artifact.Image
+	for _, image := range manifest.Images {
+		fmt.Fprintf(os.Stdout, "Building release image %s from %s\n", image.Name, image.Dockerfile)
+		sub := p.forReleaseImage(image)
+		if err := sub.Exec(); err != nil {
+			return fmt.Errorf("failed to build release image %s: %s", image.Name, err)
+		}
+		tags, _ := sub.Build.DestinationTags()
+		digest := sub.imageDigest()
+		for _, tag := range tags {
+			images = append(images, artifact.Image{Image: fmt.Sprintf("%s:%s", sub.Artifact.Repo, tag), Digest: digest})
+		}
+	}
+	p.publishReleaseArtifact(images)
+	return nil
+}
+
+// forReleaseImage returns a copy of the plugin building a single image of
+// the release, without an artifact of its own.
+func (p Plugin) forReleaseImage(image release.Image) Plugin {
+	sub := p
+	sub.Build.Release = ""
+	sub.Build.TriggerPaths = nil
+	sub.Build.AddHosts = nil // Already written to /etc/hosts
+	sub.Build.Cleanup = true // The next image is built in the same container
+	sub.Build.Dockerfile = image.Dockerfile
+	sub.Build.Context = image.Context
+	if image.Target != "" {
+		sub.Build.Target = image.Target
+	}
+	sub.Build.Args = append(append([]string{}, p.Build.Args...), image.Args...)
+	sub.Build.Repo = image.Repository(p.Build.Repo)
+	sub.Artifact.Repo = image.Repository(p.Artifact.Repo)
+	sub.Artifact.ArtifactFile = ""
+	sub.Artifact.Publishers = nil
+	return sub
+}
+
+// publishReleaseArtifact publishes the artifact of the release images.
+// Failures are only logged, like those of the artifact of single images.
+func (p Plugin) publishReleaseArtifact(images []artifact.Image) {
+	format, _ := artifact.ParseFormat(p.Artifact.Format)
+	b, err := artifact.MarshalImages(p.Artifact.RegistryType, format, p.Artifact.Registry, images)
+	if err != nil {
+		p.warnf("failed to marshal release artifact: %s\n", err)
+		return
+	}
+	p.publishArtifactContent(p.stepContext(), b, format)
+}
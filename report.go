@@ -0,0 +1,77 @@
+package kaniko
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gexops/drone-kaniko/pkg/scm"
+)
+
+// maxStatusDescription is the longest commit status description accepted by GitHub.
+const maxStatusDescription int = 140
+
+// scmClient returns the client reporting the build result to the
+// originating pull request and commit.
+func (b Build) scmClient() (*scm.Client, error) {
+	provider := b.SCMProvider
+	if provider == "" {
+		provider = scm.DetectProvider(b.DroneRepoLink)
+	}
+	if provider == "" {
+		return nil, fmt.Errorf("scm provider must be specified to report the build result of %s", b.DroneRepo)
+	}
+	return scm.NewClient(provider, b.SCMURL, b.SCMToken)
+}
+
+// reportResult comments the pushed image reference on the pull request and
+// sets a commit status. Failures are only logged, since the image is
+// already pushed.
+func (p Plugin) reportResult(tags []string) {
+	client, err := p.Build.scmClient()
+	if err != nil {
+		p.warnf("failed to report build result: %s\n", err)
+		return
+	}
+	client.UserAgent = p.UserAgent
+
+	var tag string
+	if len(tags) != 0 {
+		tag = tags[0]
+	}
+	image, err := patchImage(p.Build.Repo, tag, p.imageDigest())
+	if err != nil || image.Digest == "" {
+		p.warnf("failed to report build result: digest of the pushed image unknown\n")
+		return
+	}
+
+	if p.Build.PRComment {
+		if p.Build.DronePullRequest == 0 {
+			fmt.Fprintf(os.Stdout, "Not a pull request build, skipping pull request comment\n")
+		} else {
+			body := fmt.Sprintf("Pushed `%s:%s`\n\n```\ndocker pull %s\n```\n", image.Repo, tag, image.Ref())
+			if err := client.Comment(p.stepContext(), p.Build.DroneRepo, p.Build.DronePullRequest, body); err != nil {
+				p.warnf("failed to comment on pull request %d: %s\n", p.Build.DronePullRequest, err)
+			} else {
+				fmt.Fprintf(os.Stdout, "Commented pushed image on pull request %d\n", p.Build.DronePullRequest)
+			}
+		}
+	}
+
+	if p.Build.CommitStatus {
+		description := "Pushed " + image.Ref()
+		if len(description) > maxStatusDescription {
+			description = description[:maxStatusDescription]
+		}
+		status := scm.Status{
+			State:       "success",
+			Context:     "kaniko/" + image.Repo,
+			Description: description,
+			TargetURL:   p.Build.DroneBuildLink,
+		}
+		if err := client.SetStatus(p.stepContext(), p.Build.DroneRepo, p.Build.DroneCommitSha, status); err != nil {
+			p.warnf("failed to set commit status of %s: %s\n", p.Build.DroneCommitSha, err)
+		} else {
+			fmt.Fprintf(os.Stdout, "Set commit status of %s\n", p.Build.DroneCommitSha)
+		}
+	}
+}
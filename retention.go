@@ -0,0 +1,45 @@
+package kaniko
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// retentionPresets are the retention labels of builds by Drone event, read
+// by registry cleanup jobs. Events without preset use the default preset.
+var retentionPresets = map[string]map[string]string{
+	"pull_request": {"retention": "14d", "env": "pr"},
+	"tag":          {"retention": "permanent", "env": "release"},
+	"":             {"retention": "30d", "env": "branch"},
+}
+
+// retentionLabels returns the retention labels of the build: the preset of
+// the Drone event when retention presets are enabled, overridden by the
+// retention labels given as key=value.
+func (b Build) retentionLabels() ([]string, error) {
+	labels := map[string]string{}
+	if b.RetentionPresets {
+		preset, ok := retentionPresets[b.DroneBuildEvent]
+		if !ok {
+			preset = retentionPresets[""]
+		}
+		for key, value := range preset {
+			labels[key] = value
+		}
+	}
+	for _, label := range b.RetentionLabels {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid retention label %s, expected key=value", label)
+		}
+		labels[parts[0]] = parts[1]
+	}
+
+	var out []string
+	for key, value := range labels {
+		out = append(out, key+"="+value)
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -0,0 +1,72 @@
+package kaniko
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+var (
+	geteuid = os.Geteuid
+
+	// userNamespaceSysctls disable unprivileged user namespaces when set to 0.
+	userNamespaceSysctls = []string{
+		"/proc/sys/kernel/unprivileged_userns_clone",
+		"/proc/sys/user/max_user_namespaces",
+	}
+
+	// rootlessWritableDirs must be writable for kaniko to unpack base images.
+	rootlessWritableDirs = []string{"/", "/etc", "/usr"}
+)
+
+// rootless reports whether the executor runs in a user namespace.
+func (b Build) rootless() bool {
+	return b.Rootless && geteuid() != 0
+}
+
+// checkRootless verifies that the executor can run as a non-root user. In
+// rootless mode it runs in a user namespace in which the user is root,
+// which requires user namespaces and a root filesystem owned by the user.
+func (b Build) checkRootless() error {
+	if geteuid() == 0 {
+		if b.Rootless {
+			fmt.Fprintf(os.Stdout, "Running as root, rootless mode is not needed\n")
+		}
+		return nil
+	}
+	if !b.Rootless {
+		fmt.Fprintf(os.Stderr, "warning: the plugin runs as uid %d, but kaniko needs root to unpack base images, set rootless to run it in a user namespace\n", geteuid())
+		return nil
+	}
+
+	if !userNamespacesSupported {
+		return fmt.Errorf("rootless mode requires user namespaces, which are not supported on this platform")
+	}
+	for _, sysctl := range userNamespaceSysctls {
+		if b, err := ioutil.ReadFile(sysctl); err == nil && strings.TrimSpace(string(b)) == "0" {
+			return fmt.Errorf("rootless mode requires unprivileged user namespaces, which are disabled by %s=0 on this host", sysctl)
+		}
+	}
+
+	cmd := exec.Command(b.executorPath(), "version")
+	cmd.SysProcAttr = userNamespaceAttr()
+	if out, err := cmd.CombinedOutput(); errors.Is(err, syscall.EPERM) {
+		return fmt.Errorf("rootless mode: creating a user namespace is not permitted, the seccomp or AppArmor profile of the runner likely forbids it: %s", err)
+	} else if err != nil {
+		return fmt.Errorf("rootless mode: failed to run kaniko in a user namespace: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	for _, dir := range rootlessWritableDirs {
+		f, err := ioutil.TempFile(dir, ".kaniko-rootless-")
+		if err != nil {
+			return fmt.Errorf("rootless mode: %s is not writable by uid %d, but kaniko unpacks base images into the root filesystem, run the plugin image with its filesystem owned by that user", dir, geteuid())
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package kaniko
+
+import (
+	"os"
+	"syscall"
+)
+
+const userNamespacesSupported = true
+
+// userNamespaceAttr runs a process in a new user namespace in which the
+// current user and group are root.
+func userNamespaceAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+}
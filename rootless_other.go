@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package kaniko
+
+import "syscall"
+
+const userNamespacesSupported = false
+
+func userNamespaceAttr() *syscall.SysProcAttr {
+	return nil
+}
@@ -13,11 +13,32 @@ set -x
 GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/kaniko-gcr    ./cmd/kaniko-gcr
 GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/kaniko-ecr    ./cmd/kaniko-ecr
 GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/kaniko-docker ./cmd/kaniko-docker
+GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/kaniko-acr    ./cmd/kaniko-acr
+GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/kaniko-quay   ./cmd/kaniko-quay
+GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/kaniko-artifactory ./cmd/kaniko-artifactory
+GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/kaniko-ocir ./cmd/kaniko-ocir
+GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/kaniko-ibmcr ./cmd/kaniko-ibmcr
+GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/kaniko-oci ./cmd/kaniko-oci
+GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/kaniko-scaleway ./cmd/kaniko-scaleway
 
 GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/kaniko-gcr    ./cmd/kaniko-gcr
 GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/kaniko-ecr    ./cmd/kaniko-ecr
 GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/kaniko-docker ./cmd/kaniko-docker
+GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/kaniko-acr    ./cmd/kaniko-acr
+GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/kaniko-quay   ./cmd/kaniko-quay
+GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/kaniko-artifactory ./cmd/kaniko-artifactory
+GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/kaniko-ocir ./cmd/kaniko-ocir
+GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/kaniko-ibmcr ./cmd/kaniko-ibmcr
+GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/kaniko-oci ./cmd/kaniko-oci
+GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/kaniko-scaleway ./cmd/kaniko-scaleway
 
 GOOS=linux GOARCH=arm   go build -o release/linux/arm/kaniko-gcr      ./cmd/kaniko-gcr
 GOOS=linux GOARCH=arm   go build -o release/linux/arm/kaniko-ecr      ./cmd/kaniko-ecr
 GOOS=linux GOARCH=arm   go build -o release/linux/arm/kaniko-docker   ./cmd/kaniko-docker
+GOOS=linux GOARCH=arm   go build -o release/linux/arm/kaniko-acr      ./cmd/kaniko-acr
+GOOS=linux GOARCH=arm   go build -o release/linux/arm/kaniko-quay     ./cmd/kaniko-quay
+GOOS=linux GOARCH=arm   go build -o release/linux/arm/kaniko-artifactory ./cmd/kaniko-artifactory
+GOOS=linux GOARCH=arm   go build -o release/linux/arm/kaniko-ocir ./cmd/kaniko-ocir
+GOOS=linux GOARCH=arm   go build -o release/linux/arm/kaniko-ibmcr ./cmd/kaniko-ibmcr
+GOOS=linux GOARCH=arm   go build -o release/linux/arm/kaniko-oci ./cmd/kaniko-oci
+GOOS=linux GOARCH=arm   go build -o release/linux/arm/kaniko-scaleway ./cmd/kaniko-scaleway
@@ -0,0 +1,222 @@
+package kaniko
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// secretsDir is where BuildKit mounts secrets by default.
+	secretsDir string = "/run/secrets"
+
+	// secretMask replaces secret values in the executor output.
+	secretMask string = "********"
+
+	// minMaskedLength is the length below which secret lines are not masked,
+	// since masking them would garble unrelated output.
+	minMaskedLength int = 4
+
+	// maskBufferSize is how much output without a newline is buffered
+	// before it is masked and written.
+	maskBufferSize int = 64 << 10
+)
+
+// mountTmpfs mounts a tmpfs at the directory and unmountTmpfs unmounts it,
+// variables for tests.
+var (
+	mountTmpfs   = mountSecretsTmpfs
+	unmountTmpfs = unmountSecretsTmpfs
+)
+
+// secretFiles returns the contents of the secret files by id. Secrets are
+// given as id=path or id=file:path, or as id=env:NAME to read the
+// environment variable NAME.
+func (b Build) secretFiles() (map[string][]byte, error) {
+	secrets := map[string][]byte{}
+	for _, spec := range b.SecretFiles {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid secret file %s, expected id=path or id=env:NAME", spec)
+		}
+		id, source := parts[0], parts[1]
+		if strings.HasPrefix(source, "env:") {
+			value, ok := os.LookupEnv(strings.TrimPrefix(source, "env:"))
+			if !ok {
+				return nil, fmt.Errorf("environment variable %s of secret %s is not set", strings.TrimPrefix(source, "env:"), id)
+			}
+			secrets[id] = []byte(value)
+			continue
+		}
+		content, err := ioutil.ReadFile(strings.TrimPrefix(source, "file:"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret %s: %s", id, err)
+		}
+		secrets[id] = content
+	}
+	return secrets, nil
+}
+
+// secretValues returns the contents of the secret files, masked in the
+// executor output.
+func (b Build) secretValues() ([]string, error) {
+	secrets, err := b.secretFiles()
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, secret := range secrets {
+		values = append(values, string(secret))
+	}
+	return maskValues(values), nil
+}
+
+// maskValues returns the strings masked for the sensitive values, their
+// lines, longest first.
+func maskValues(secrets []string) []string {
+	seen := map[string]bool{}
+	var values []string
+	for _, secret := range secrets {
+		for _, line := range strings.Split(secret, "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) >= minMaskedLength && !seen[line] {
+				seen[line] = true
+				values = append(values, line)
+			}
+		}
+	}
+	// Longer values first, so that values containing others are masked whole
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	return values
+}
+
+// writeSecrets writes the secret files to the paths RUN steps mount them at,
+// which are excluded from snapshots, and returns a function removing them.
+// Secrets in the default secrets directory are written to a tmpfs mounted
+// there if possible, so that they never reach the disk.
+func (b Build) writeSecrets(paths map[string]string) (func(), error) {
+	var written []string
+	var unmount func()
+	cleanup := func() {
+		for _, path := range written {
+			os.Remove(path)
+		}
+		if unmount != nil {
+			unmount()
+		}
+	}
+	if len(paths) == 0 {
+		return cleanup, nil
+	}
+	secrets, err := b.secretFiles()
+	if err != nil {
+		return cleanup, err
+	}
+	for path := range paths {
+		if filepath.Dir(path) == secretsDir {
+			unmount = b.mountSecretsDir(secretsDir)
+			break
+		}
+	}
+	for path, id := range paths {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			cleanup()
+			return func() {}, err
+		}
+		if err := ioutil.WriteFile(path, secrets[id], 0400); err != nil {
+			cleanup()
+			return func() {}, fmt.Errorf("failed to write secret %s: %s", id, err)
+		}
+		written = append(written, path)
+	}
+	return cleanup, nil
+}
+
+// mountSecretsDir mounts a tmpfs at the secrets directory dir and returns a
+// function unmounting it, or nil if the tmpfs can't be mounted, e.g. without
+// CAP_SYS_ADMIN. Directories with contents, such as Kubernetes service
+// account tokens, are not hidden by a tmpfs.
+func (b Build) mountSecretsDir(dir string) func() {
+	if b.rootless() {
+		return nil
+	}
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) != 0 {
+		fmt.Fprintf(os.Stdout, "%s is not empty, writing secrets to disk\n", dir)
+		return nil
+	}
+	_, err := os.Stat(dir)
+	created := os.IsNotExist(err)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil
+	}
+	if err := mountTmpfs(dir); err != nil {
+		fmt.Fprintf(os.Stdout, "Failed to mount a tmpfs at %s, writing secrets to disk: %s\n", dir, err)
+		if created {
+			os.Remove(dir)
+		}
+		return nil
+	}
+	return func() {
+		if err := unmountTmpfs(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to unmount the tmpfs at %s: %s\n", dir, err)
+			return
+		}
+		if created {
+			os.Remove(dir)
+		}
+	}
+}
+
+// maskWriter replaces secret values in the output written to it, line by
+// line, so that values split across writes are masked as well.
+type maskWriter struct {
+	w        io.Writer
+	replacer *strings.Replacer
+	buf      []byte
+}
+
+// newMaskWriter returns a writer masking the values in the output written
+// to w, which is flushed by Flush.
+func newMaskWriter(w io.Writer, values []string) *maskWriter {
+	var oldnew []string
+	for _, value := range values {
+		oldnew = append(oldnew, value, secretMask)
+	}
+	return &maskWriter{w: w, replacer: strings.NewReplacer(oldnew...)}
+}
+
+func (m *maskWriter) Write(p []byte) (int, error) {
+	m.buf = append(m.buf, p...)
+	n := bytes.LastIndexByte(m.buf, '\n') + 1
+	if n == 0 && len(m.buf) < maskBufferSize {
+		return len(p), nil
+	}
+	if n == 0 {
+		n = len(m.buf)
+	}
+	if _, err := io.WriteString(m.w, m.replacer.Replace(string(m.buf[:n]))); err != nil {
+		return 0, err
+	}
+	m.buf = append(m.buf[:0], m.buf[n:]...)
+	return len(p), nil
+}
+
+// Flush masks and writes the buffered output without trailing newline.
+func (m *maskWriter) Flush() error {
+	if len(m.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(m.w, m.replacer.Replace(string(m.buf)))
+	m.buf = m.buf[:0]
+	return err
+}
@@ -0,0 +1,12 @@
+package kaniko
+
+import "syscall"
+
+// mountSecretsTmpfs mounts a tmpfs at dir.
+func mountSecretsTmpfs(dir string) error {
+	return syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=0755")
+}
+
+func unmountSecretsTmpfs(dir string) error {
+	return syscall.Unmount(dir, 0)
+}
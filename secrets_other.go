@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package kaniko
+
+import "fmt"
+
+func mountSecretsTmpfs(dir string) error {
+	return fmt.Errorf("tmpfs mounts are not supported on this platform")
+}
+
+func unmountSecretsTmpfs(dir string) error {
+	return nil
+}
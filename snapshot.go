@@ -0,0 +1,33 @@
+package kaniko
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// snapshotModes are the snapshot modes supported by kaniko.
+var snapshotModes = []string{"full", "redo", "time"}
+
+// validateSnapshot checks the snapshot options before they are passed to
+// kaniko, which would otherwise only fail once the build has started.
+func (b Build) validateSnapshot() error {
+	if b.SnapshotMode != "" {
+		valid := false
+		for _, mode := range snapshotModes {
+			valid = valid || b.SnapshotMode == mode
+		}
+		if !valid {
+			return fmt.Errorf("invalid snapshot mode %s, must be one of %s", b.SnapshotMode, strings.Join(snapshotModes, ", "))
+		}
+	}
+	if b.SingleSnapshot && b.EnableCache {
+		return fmt.Errorf("single-snapshot cannot be combined with enable-cache, layers are only cached with a snapshot per instruction")
+	}
+	for _, path := range b.IgnorePaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("ignore path %s must be absolute", path)
+		}
+	}
+	return nil
+}
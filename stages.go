@@ -0,0 +1,39 @@
+package kaniko
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/dockerfile"
+)
+
+// validateStages checks that the target names a stage of the Dockerfile
+// and that the variables used in FROM instructions have values, so that
+// mistakes fail before the context is uploaded and base images are pulled.
+func (b Build) validateStages() error {
+	d, err := dockerfile.ParseFile(b.Dockerfile)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %s", b.Dockerfile, err)
+	}
+
+	if b.Target != "" && !d.HasStage(b.Target) {
+		stages := d.StageNames()
+		if len(stages) == 0 {
+			return fmt.Errorf("target stage %s not found in %s, which has no named stages", b.Target, b.Dockerfile)
+		}
+		return fmt.Errorf("target stage %s not found in %s, stages: %s", b.Target, b.Dockerfile, strings.Join(stages, ", "))
+	}
+
+	var unset []string
+	for _, arg := range d.UnsetArgs(b.Args) {
+		if _, declared := d.Args[arg.Name]; !declared {
+			unset = append(unset, fmt.Sprintf("%s:%d: FROM uses %s, which must be declared with ARG before the first FROM", b.Dockerfile, arg.Line, arg.Name))
+			continue
+		}
+		unset = append(unset, fmt.Sprintf("%s:%d: FROM uses build arg %s, which has no default and is not provided", b.Dockerfile, arg.Line, arg.Name))
+	}
+	if len(unset) != 0 {
+		return fmt.Errorf("%s", strings.Join(unset, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package kaniko
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// kanikoDestinations returns the destinations kaniko pushes to. Kaniko
+// pushes the image to every destination separately, so only the first
+// destination is passed and the other tags are created by tagPushed.
+func kanikoDestinations(destinations []string) []string {
+	if len(destinations) > 1 {
+		return destinations[:1]
+	}
+	return destinations
+}
+
+// tagPushed tags the image kaniko pushed to the first destination with the
+// remaining destinations, putting only the manifest once the blobs have
+// been uploaded.
+func (p Plugin) tagPushed(destinations []string) error {
+	if p.Build.NoPush || len(destinations) < 2 {
+		return nil
+	}
+	repo, err := registry.ParseRepository(p.Build.Repo)
+	if err != nil {
+		return err
+	}
+	return tagManifest(p.stepContext(), p.registryClient(), repo, destinations[0], destinations[1:])
+}
+
+// tagManifest puts the manifest tagged src in repo with each tag.
+func tagManifest(ctx context.Context, client *registry.Client, repo registry.Repository, src string, tags []string) error {
+	m, err := client.GetManifest(ctx, repo, src)
+	if err != nil {
+		return fmt.Errorf("failed to fetch pushed image %s:%s: %s", repo, src, err)
+	}
+	for _, tag := range tags {
+		if _, err := client.PutManifest(ctx, repo, tag, m); err != nil {
+			return fmt.Errorf("failed to tag %s:%s: %s", repo, tag, err)
+		}
+		fmt.Fprintf(os.Stdout, "Tagged %s:%s@%s\n", repo, tag, m.Digest)
+	}
+	return nil
+}
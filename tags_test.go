@@ -0,0 +1,41 @@
+package kaniko
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gexops/drone-kaniko/pkg/registry"
+	"github.com/gexops/drone-kaniko/pkg/registry/registrytest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTagManifest(t *testing.T) {
+	reg := registrytest.New(t)
+	client := registry.NewClient(registry.KeychainFunc(func(string) (registry.Credential, error) {
+		return registry.Credential{Username: registrytest.Username, Password: registrytest.Password}, nil
+	}), true)
+	digest := reg.PushImage("team/app", "1.2.3", []byte(`{"os":"linux"}`), []byte("layer"))
+
+	repo := registry.Repository{Registry: reg.Host(), Name: "team/app"}
+	if err := tagManifest(context.Background(), client, repo, "1.2.3", []string{"1.2", "latest"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"1.2", "latest"} {
+		if _, content, ok := reg.Manifest("team/app", tag); !ok || registry.Digest(content) != digest {
+			t.Errorf("expected %s to reference %s", tag, digest)
+		}
+	}
+
+	if err := tagManifest(context.Background(), client, repo, "missing", []string{"latest"}); err == nil {
+		t.Error("expected error for missing source tag")
+	}
+}
+
+func TestKanikoDestinations(t *testing.T) {
+	if diff := cmp.Diff([]string{"1.2.3"}, kanikoDestinations([]string{"1.2.3", "1.2", "latest"})); diff != "" {
+		t.Errorf("unexpected destinations (-want +got):\n%s", diff)
+	}
+	if got := kanikoDestinations(nil); len(got) != 0 {
+		t.Errorf("expected no destinations, got %v", got)
+	}
+}
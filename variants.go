@@ -0,0 +1,76 @@
+package kaniko
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tagArgs returns the build args of the tags with build arg overrides, in
+// order of appearance. Overrides are given as tag:NAME=value.
+func (b Build) tagArgs() ([]string, map[string][]string, error) {
+	var tags []string
+	args := map[string][]string{}
+	for _, spec := range b.TagArgs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || !strings.Contains(parts[1], "=") || strings.HasPrefix(parts[1], "=") {
+			return nil, nil, fmt.Errorf("invalid tag build arg %s, expected tag:NAME=value", spec)
+		}
+		if _, ok := args[parts[0]]; !ok {
+			tags = append(tags, parts[0])
+		}
+		args[parts[0]] = append(args[parts[0]], parts[1])
+	}
+	return tags, args, nil
+}
+
+// execTagVariants builds the tags with build arg overrides in separate
+// kaniko runs, after building the other tags with the common build args.
+// The runs share the cache, so layers that don't depend on the overridden
+// args are only built once.
+func (p Plugin) execTagVariants() error {
+	if p.Build.AutoTag || p.Build.ExpandTag || len(p.Build.Taggers) != 0 {
+		return fmt.Errorf("tag build args are not supported with auto-tag, expand-tag or taggers")
+	}
+	tags, args, err := p.Build.tagArgs()
+	if err != nil {
+		return err
+	}
+
+	var common []string
+	for _, tag := range p.Build.Tags {
+		if _, ok := args[tag]; !ok {
+			common = append(common, tag)
+		}
+	}
+	if len(common) != 0 {
+		fmt.Fprintf(os.Stdout, "Building %s\n", strings.Join(common, ", "))
+		if err := p.forTags(common, nil).Exec(); err != nil {
+			return fmt.Errorf("failed to build %s: %s", strings.Join(common, ", "), err)
+		}
+	}
+	for _, tag := range tags {
+		fmt.Fprintf(os.Stdout, "Building %s with %s\n", tag, strings.Join(args[tag], ", "))
+		if err := p.forTags([]string{tag}, args[tag]).Exec(); err != nil {
+			return fmt.Errorf("failed to build %s: %s", tag, err)
+		}
+	}
+	return nil
+}
+
+// forTags returns a copy of the plugin building the tags with the extra
+// build args, which override the common build args by name.
+func (p Plugin) forTags(tags []string, args []string) Plugin {
+	sub := p
+	sub.Build.TagArgs = nil
+	sub.Build.TriggerPaths = nil
+	sub.Build.AddHosts = nil // Already written to /etc/hosts
+	sub.Build.Cleanup = true // The next tags are built in the same container
+	sub.Build.Tags = tags
+	sub.Build.Args = append(append([]string{}, p.Build.Args...), args...)
+	sub.Artifact.Tags = tags
+	if len(args) != 0 {
+		sub.Artifact = sub.Artifact.withSuffix(tags[0])
+	}
+	return sub
+}
@@ -0,0 +1,145 @@
+package kaniko
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/gexops/drone-kaniko/pkg/dockerfile"
+	"github.com/gexops/drone-kaniko/pkg/registry"
+)
+
+// windowsOS is the operating system of Windows container images.
+const windowsOS string = "windows"
+
+// targetPlatforms returns the platforms the build targets, if any.
+func (b Build) targetPlatforms() []string {
+	if len(b.Platforms) > 0 {
+		return b.Platforms
+	}
+	if b.Platform != "" {
+		return []string{b.Platform}
+	}
+	return nil
+}
+
+// checkWindowsPlatforms validates builds targeting Windows. RUN
+// instructions cannot be executed for Windows on a Linux runner, and every
+// base image must provide each targeted Windows platform. Stages with an
+// other --platform, e.g. Linux stages cross-compiling the application, and
+// the Linux platforms of mixed builds are not checked.
+func (p Plugin) checkWindowsPlatforms() error {
+	var targets []registry.Platform
+	for _, s := range p.Build.targetPlatforms() {
+		platform, err := registry.ParsePlatform(s)
+		if err != nil {
+			return err
+		}
+		if platform.OS == windowsOS {
+			targets = append(targets, platform)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	d, err := dockerfile.ParseFile(p.Build.Dockerfile)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %s", p.Build.Dockerfile, err)
+	}
+	client := p.registryClient()
+	vars := d.Vars(p.Build.Args)
+	checked := map[string]bool{}
+	for _, target := range targets {
+		windowsStages, err := p.windowsStages(d, target)
+		if err != nil {
+			return err
+		}
+		stage := -1
+		for _, inst := range d.Instructions {
+			if inst.Command == "FROM" {
+				stage++
+			}
+			if inst.Command == "RUN" && stage >= 0 && windowsStages[stage] {
+				return fmt.Errorf("%s:%d: RUN instructions are not supported for windows targets", p.Build.Dockerfile, inst.Line)
+			}
+		}
+
+		names := map[string]bool{}
+		for i, stage := range d.Stages {
+			base := dockerfile.Expand(stage.Base, vars)
+			if windowsStages[i] && !names[strings.ToLower(base)] && base != dockerfile.Scratch && !checked[base+" "+target.String()] {
+				checked[base+" "+target.String()] = true
+				if err := checkBasePlatform(p.stepContext(), client, base, target); err != nil {
+					return err
+				}
+			}
+			if stage.Name != "" {
+				names[stage.Name] = true
+			}
+		}
+	}
+	return nil
+}
+
+// windowsStages reports for each stage whether it is built for the windows
+// target: stages without --platform, or whose platform is a windows one. The
+// final stage, which the image is made of, must be built for the target.
+func (p Plugin) windowsStages(d *dockerfile.Dockerfile, target registry.Platform) (map[int]bool, error) {
+	vars := d.Vars(p.Build.Args)
+	vars["TARGETPLATFORM"], vars["TARGETOS"], vars["TARGETARCH"], vars["TARGETVARIANT"] = target.String(), target.OS, target.Architecture, target.Variant
+	vars["BUILDPLATFORM"], vars["BUILDOS"], vars["BUILDARCH"] = "linux/"+runtime.GOARCH, "linux", runtime.GOARCH
+
+	final := len(d.Stages) - 1
+	for i, stage := range d.Stages {
+		if p.Build.Target != "" && stage.Name == strings.ToLower(p.Build.Target) {
+			final = i
+		}
+	}
+	stages := map[int]bool{}
+	for i, stage := range d.Stages {
+		platform := dockerfile.Expand(stage.Platform, vars)
+		if platform == "" {
+			stages[i] = true
+			continue
+		}
+		parsed, err := registry.ParsePlatform(platform)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", p.Build.Dockerfile, stage.Line, err)
+		}
+		stages[i] = parsed.OS == windowsOS
+		if i == final && !stages[i] {
+			return nil, fmt.Errorf("%s:%d: stage platform %s is inconsistent with the windows target", p.Build.Dockerfile, stage.Line, stage.Platform)
+		}
+	}
+	return stages, nil
+}
+
+// checkBasePlatform fails when the base image does not provide the target.
+func checkBasePlatform(ctx context.Context, client *registry.Client, image string, target registry.Platform) error {
+	repo, reference, err := registry.ParseReference(image)
+	if err != nil {
+		return fmt.Errorf("invalid base image %s: %s", image, err)
+	}
+	provided, err := client.Platforms(ctx, repo, reference)
+	if err != nil {
+		return fmt.Errorf("failed to inspect base image %s: %s", image, err)
+	}
+	base, ok := matchPlatform(provided, target)
+	if !ok {
+		return fmt.Errorf("base image %s does not provide platform %s", image, target)
+	}
+	fmt.Fprintf(os.Stdout, "Base image %s provides %s (os.version %s)\n", image, target, base.OSVersion)
+	return nil
+}
+
+func matchPlatform(platforms []registry.Platform, want registry.Platform) (registry.Platform, bool) {
+	for _, platform := range platforms {
+		if platform.Matches(want) {
+			return platform, true
+		}
+	}
+	return registry.Platform{}, false
+}